@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// newGroupCmd builds the commands managing the groups or roles, as given by kind, and their members
+func newGroupCmd(kind string) *cobra.Command {
+	groupCmd := &cobra.Command{
+		Use:   kind,
+		Short: fmt.Sprintf("Manages the %vs of the users without a running server", kind),
+		Long: fmt.Sprintf(`Manages the %vs of the users without a running server.
+The %vs are released to the clients in the tokens by the scopes declaring a %vs claim.
+When the hydra admin url is set, users removed from a %v are asked for consent again.`, kind, kind, kind, kind),
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create <name>",
+		Short: fmt.Sprintf("Creates a %v", kind),
+		Args:  cobra.ExactArgs(1),
+		RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+			payload := types.AdminAddGroupRequestPayload{Name: args[0], Description: viper.GetString("description")}
+			if err := payload.Check(); err != nil {
+				return err
+			}
+
+			group, err := store.groups.CreateGroup(kind, payload.Name, payload.Description)
+			if err != nil {
+				return err
+			}
+
+			return printGroups([]db.Group{group})
+		}),
+	}
+	createCmd.Flags().StringP("description", "", "", fmt.Sprintf("[optional] Sets what the %v is for", kind))
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: fmt.Sprintf("Lists the %vs", kind),
+		Args:  cobra.NoArgs,
+		RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+			groups, err := store.groups.ListGroups(kind)
+			if err != nil {
+				return err
+			}
+
+			return printGroups(groups)
+		}),
+	}
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete <name>",
+		Short: fmt.Sprintf("Deletes a %v along with its memberships", kind),
+		Args:  cobra.ExactArgs(1),
+		RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+			group, err := store.groups.GetGroup(kind, args[0])
+			if err != nil {
+				return err
+			}
+
+			members, err := store.groups.ListGroupMembers(group.ID)
+			if err != nil {
+				return err
+			}
+
+			if err := store.groups.DeleteGroup(kind, group.Name); err != nil {
+				return err
+			}
+
+			for _, member := range members {
+				store.revokeConsentSessions(member.Username)
+			}
+
+			fmt.Printf("The %v '%v' was deleted\n", kind, group.Name)
+			return nil
+		}),
+	}
+
+	membersCmd := &cobra.Command{
+		Use:   "members <name>",
+		Short: fmt.Sprintf("Lists the members of a %v", kind),
+		Args:  cobra.ExactArgs(1),
+		RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+			group, err := store.groups.GetGroup(kind, args[0])
+			if err != nil {
+				return err
+			}
+
+			members, err := store.groups.ListGroupMembers(group.ID)
+			if err != nil {
+				return err
+			}
+
+			users := make([]types.AdminUserResponsePayload, 0, len(members))
+			for _, member := range members {
+				users = append(users, types.GetAdminUserResponsePayload(member))
+			}
+
+			if viper.GetString("output") == outputJSON {
+				return printJSON(types.AdminGroupMembersResponsePayload{Users: users})
+			}
+
+			printUsersTable(users)
+			return nil
+		}),
+	}
+
+	addMemberCmd := &cobra.Command{
+		Use:   "add-member <name> <username>",
+		Short: fmt.Sprintf("Makes a user a member of a %v", kind),
+		Args:  cobra.ExactArgs(2),
+		RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+			group, userCredential, err := store.getMembership(kind, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if err := store.groups.AddGroupMember(group.ID, userCredential.ID); err != nil {
+				return err
+			}
+
+			fmt.Printf("User '%v' added to the %v '%v'\n", userCredential.Username, kind, group.Name)
+			return nil
+		}),
+	}
+
+	removeMemberCmd := &cobra.Command{
+		Use:   "remove-member <name> <username>",
+		Short: fmt.Sprintf("Removes a user from a %v", kind),
+		Args:  cobra.ExactArgs(2),
+		RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+			group, userCredential, err := store.getMembership(kind, args[0], args[1])
+			if err != nil {
+				return err
+			}
+
+			if err := store.groups.RemoveGroupMember(group.ID, userCredential.ID); err != nil {
+				return err
+			}
+			store.revokeConsentSessions(userCredential.Username)
+
+			fmt.Printf("User '%v' removed from the %v '%v'\n", userCredential.Username, kind, group.Name)
+			return nil
+		}),
+	}
+
+	groupCmd.AddCommand(createCmd, listCmd, deleteCmd, membersCmd, addMemberCmd, removeMemberCmd)
+
+	config.AddStoreFlags(groupCmd.PersistentFlags())
+	config.AddHydraAdminFlags(groupCmd.PersistentFlags())
+	config.AddIdentityStoreFlags(groupCmd.PersistentFlags())
+	config.AddRealmFlags(groupCmd.PersistentFlags())
+	groupCmd.PersistentFlags().StringP("output", "", outputTable, "[optional] Sets the output format, one of table or json. Defaults to table")
+
+	return groupCmd
+}
+
+// getMembership gets the group or role and the user of a membership by their names
+func (store *userStore) getMembership(kind, name, username string) (db.Group, db.UserCredential, error) {
+	group, err := store.groups.GetGroup(kind, name)
+	if err != nil {
+		return db.Group{}, db.UserCredential{}, fmt.Errorf("unable to find the %v '%v': %v", kind, name, err)
+	}
+
+	userCredential, err := store.dao.GetUserCredential(username)
+	if err != nil {
+		return db.Group{}, db.UserCredential{}, fmt.Errorf("unable to find the user '%v': %v", username, err)
+	}
+
+	return group, userCredential, nil
+}
+
+// revokeConsentSessions removes the consents of a user, when hydra can be reached
+func (store *userStore) revokeConsentSessions(username string) {
+	if store.HydraHelper == nil {
+		fmt.Fprintf(os.Stderr, "Hydra admin url not set, the consents of '%v' were kept until they expire\n", username)
+		return
+	}
+
+	store.HydraHelper.RevokeConsentSessions(store.GetSubject(username), "")
+}
+
+func printGroups(groups []db.Group) error {
+	if viper.GetString("output") == outputJSON {
+		return printJSON(types.AdminGroupListResponsePayload{Groups: types.GetAdminGroupListResponsePayload(groups)})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tDESCRIPTION\tCREATED")
+	for _, group := range groups {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", group.ID, group.Name, group.Description, group.CreatedAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(newGroupCmd(db.GroupKindGroup), newGroupCmd(db.GroupKindRole))
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manages the versions of the database schema",
+	Long: `Manages the versions of the database schema.
+The serve command refuses to start on a schema other than the one it expects, unless --auto-migrate is set.`,
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Applies the pending migrations",
+	Args:  cobra.NoArgs,
+	RunE: runMigrateCommand(func(builder *config.WebBuilder) error {
+		applied, err := db.MigrateUp(builder.DB, viper.GetInt("to"))
+		printMigrations("Applied", applied)
+		return err
+	}),
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Reverts the last applied migrations",
+	Args:  cobra.NoArgs,
+	RunE: runMigrateCommand(func(builder *config.WebBuilder) error {
+		steps := viper.GetInt("steps")
+		if steps < 1 {
+			return fmt.Errorf("steps should be positive")
+		}
+
+		reverted, err := db.MigrateDown(builder.DB, steps)
+		printMigrations("Reverted", reverted)
+		return err
+	}),
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Lists the migrations and whether they were applied",
+	Args:  cobra.NoArgs,
+	RunE: runMigrateCommand(func(builder *config.WebBuilder) error {
+		statuses, err := db.GetMigrationStatus(builder.DB)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%v\t%v\t%v\n", status.Version, applied, status.Description)
+		}
+		w.Flush()
+
+		if err := db.CheckSchemaVersion(builder.DB); err != nil {
+			fmt.Printf("\n%v\n", err)
+		}
+		return nil
+	}),
+}
+
+// runMigrateCommand opens the database around a migrate command, turning the panics of the builder into errors
+func runMigrateCommand(run func(builder *config.WebBuilder) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if e, ok := r.(gohtypes.Error); ok {
+					err = fmt.Errorf("%v: %v", e.Message, e.Err)
+					return
+				}
+				panic(r)
+			}
+		}()
+
+		// bound here rather than on init since the serve command binds the same keys
+		if err := viper.GetViper().BindPFlags(cmd.Flags()); err != nil {
+			return err
+		}
+
+		builder := new(config.WebBuilder).InitDatabase(viper.GetViper())
+		defer builder.DB.Close()
+
+		return run(builder)
+	}
+}
+
+func printMigrations(action string, migrations []db.Migration) {
+	for _, migration := range migrations {
+		fmt.Printf("%v migration %v: %v\n", action, migration.Version, migration.Description)
+	}
+
+	fmt.Printf("%v %v migrations\n", action, len(migrations))
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateUpCmd, migrateDownCmd, migrateStatusCmd)
+
+	config.AddDatabaseFlags(migrateCmd.PersistentFlags())
+	migrateUpCmd.Flags().StringP("to", "", "0", "[optional] Sets the version to migrate up to. Defaults to the latest")
+	migrateDownCmd.Flags().StringP("steps", "", "1", "[optional] Sets how many migrations are reverted. Defaults to 1")
+}
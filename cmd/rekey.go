@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// rekeyCmd represents the rekey command
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Moves the stored password hashes and secrets to the active secret key",
+	Long: `Moves the stored password hashes and secrets to the active secret key, so the retired keys can be removed.
+Legacy hmac-sha512 password hashes can only be moved with the password and are reported as pending until their users sign in again.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// bound here rather than on init since the serve command binds the same keys
+		if err := viper.GetViper().BindPFlags(cmd.Flags()); err != nil {
+			return err
+		}
+
+		builder := new(config.WebBuilder).InitStore(viper.GetViper())
+		defer builder.DB.Close()
+
+		userCredentialsDAO := new(db.DefaultUserCredentialsDAO).Init(builder.Keyring, builder.Hasher, false, "", "", "", nil, builder.IdentityStore, builder.DB)
+		rekeyed, pending, err := userCredentialsDAO.RekeyUserCredentials()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("User credentials: %v rekeyed, %v pending\n", rekeyed, pending)
+
+		recoveryCodesDAO := new(db.DefaultRecoveryCodesDAO).Init(builder.Keyring, builder.Hasher, builder.DB)
+		rekeyed, pending, err = recoveryCodesDAO.RekeyRecoveryCodes()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Recovery codes: %v rekeyed, %v pending\n", rekeyed, pending)
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rekeyCmd)
+
+	config.AddStoreFlags(rekeyCmd.Flags())
+}
@@ -22,6 +22,16 @@ var serveCmd = &cobra.Command{
 		mailHandler := new(mail.DefaultHandler).Init(builder.MailUser, builder.MailPassword, builder.MailHost, builder.MailPort, mailChannel)
 		mailHandler.Run()
 
+		for _, realm := range builder.Realms {
+			if realm.Outbox != nil {
+				continue
+			}
+
+			realmMailChannel := make(chan mail.Mail)
+			new(mail.DefaultHandler).Init(realm.MailUser, realm.MailPassword, realm.MailHost, realm.MailPort, realmMailChannel).Run()
+			realm.Outbox = realmMailChannel
+		}
+
 		server := new(web.Server).InitFromWebBuilder(builder)
 
 		_, err := server.Self.CheckCredentials()
@@ -0,0 +1,343 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Output formats of the user commands
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// userCmd represents the user command
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manages the stored user credentials without a running server",
+	Long: `Manages the stored user credentials without a running server.
+When the hydra admin url is set, users that are suspended or deleted are also signed out of their Hydra sessions.
+With a read only identity store, such as ldap, users can not be created nor have their passwords or emails set here.`,
+}
+
+var userCreateCmd = &cobra.Command{
+	Use:   "create <username> <email>",
+	Short: "Creates a user",
+	Args:  cobra.ExactArgs(2),
+	RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+
+		if err := store.PasswordPolicy.Validate(password, args[0], args[1]); err != nil {
+			return err
+		}
+
+		if err := misc.VerifyEmail(args[1]); err != nil {
+			return err
+		}
+
+		if _, err := store.dao.CreateUserCredential(args[0], password, args[1]); err != nil {
+			return err
+		}
+
+		if viper.GetBool("verified") {
+			if err := store.dao.ValidateUserCredentialEmail(args[0]); err != nil {
+				return err
+			}
+		}
+
+		return store.printUser(args[0])
+	}),
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the users, optionally filtered by a search over their usernames and emails",
+	Args:  cobra.NoArgs,
+	RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+		search := viper.GetString("search")
+		page := viper.GetInt("page")
+		perPage := viper.GetInt("per-page")
+		if page < 1 || perPage < 1 {
+			return fmt.Errorf("page and per-page should be positive")
+		}
+
+		userCredentials, total, err := store.dao.ListUserCredentials(search, (page-1)*perPage, perPage)
+		if err != nil {
+			return err
+		}
+
+		users := make([]types.AdminUserResponsePayload, 0, len(userCredentials))
+		for _, userCredential := range userCredentials {
+			users = append(users, types.GetAdminUserResponsePayload(userCredential))
+		}
+
+		if viper.GetString("output") == outputJSON {
+			return printJSON(types.AdminUserListResponsePayload{Users: users, Total: total, Page: page, PerPage: perPage})
+		}
+
+		printUsersTable(users)
+		fmt.Printf("\nShowing %v of %v users (page %v)\n", len(users), total, page)
+		return nil
+	}),
+}
+
+var userShowCmd = &cobra.Command{
+	Use:   "show <username>",
+	Short: "Shows a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+		return store.printUser(args[0])
+	}),
+}
+
+var userDisableCmd = &cobra.Command{
+	Use:   "disable <username>",
+	Short: "Suspends a user, who can no longer sign in",
+	Args:  cobra.ExactArgs(1),
+	RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+		return store.setStatus(args[0], db.UserStatusSuspended, viper.GetString("reason"))
+	}),
+}
+
+var userEnableCmd = &cobra.Command{
+	Use:   "enable <username>",
+	Short: "Makes a user active again",
+	Args:  cobra.ExactArgs(1),
+	RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+		return store.setStatus(args[0], db.UserStatusActive, viper.GetString("reason"))
+	}),
+}
+
+var userDeleteCmd = &cobra.Command{
+	Use:   "delete <username>",
+	Short: "Deletes a user along with its second factors",
+	Args:  cobra.ExactArgs(1),
+	RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+		userCredential, err := store.dao.GetUserCredential(args[0])
+		if err != nil {
+			return err
+		}
+
+		if err := store.dao.DeleteUserCredential(userCredential.ID); err != nil {
+			return err
+		}
+		store.revokeSessions(userCredential.Username)
+
+		fmt.Printf("User '%v' deleted\n", userCredential.Username)
+		return nil
+	}),
+}
+
+var userSetPasswordCmd = &cobra.Command{
+	Use:   "set-password <username>",
+	Short: "Sets the password of a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+		userCredential, err := store.dao.GetUserCredential(args[0])
+		if err != nil {
+			return err
+		}
+
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+
+		if err := store.PasswordPolicy.Validate(password, userCredential.Username, userCredential.Email); err != nil {
+			return err
+		}
+
+		if err := store.dao.UpdateUserCredential(userCredential.Username, userCredential.Email, password); err != nil {
+			return err
+		}
+
+		fmt.Printf("Password of '%v' set\n", userCredential.Username)
+		return nil
+	}),
+}
+
+var userVerifyEmailCmd = &cobra.Command{
+	Use:   "verify-email <username>",
+	Short: "Marks the email of a user as verified",
+	Args:  cobra.ExactArgs(1),
+	RunE: runUserCommand(func(cmd *cobra.Command, args []string, store *userStore) error {
+		if err := store.dao.ValidateUserCredentialEmail(args[0]); err != nil {
+			return err
+		}
+
+		return store.printUser(args[0])
+	}),
+}
+
+// userStore holds what the user commands need to reach the stored user credentials
+type userStore struct {
+	*config.WebBuilder
+	dao    db.UserCredentialsDAO
+	groups db.GroupsDAO
+}
+
+// runUserCommand opens the user store around a user, group or role command, turning the panics of the DAOs into errors
+func runUserCommand(run func(cmd *cobra.Command, args []string, store *userStore) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if e, ok := r.(gohtypes.Error); ok {
+					err = fmt.Errorf("%v", e.Message)
+					return
+				}
+				panic(r)
+			}
+		}()
+
+		// bound here rather than on init since the serve command binds the same keys
+		if err := viper.GetViper().BindPFlags(cmd.Flags()); err != nil {
+			return err
+		}
+
+		if output := viper.GetString("output"); output != outputTable && output != outputJSON {
+			return fmt.Errorf("unknown output '%v', it should be %v or %v", output, outputTable, outputJSON)
+		}
+
+		builder := new(config.WebBuilder).InitStore(viper.GetViper()).InitHydraAdmin(viper.GetViper()).InitIdentityStore(viper.GetViper()).InitRealm(viper.GetViper())
+		defer builder.DB.Close()
+
+		store := &userStore{
+			WebBuilder: builder,
+			dao:        new(db.DefaultUserCredentialsDAO).Init(builder.Keyring, builder.Hasher, false, "", "", builder.Realm, nil, builder.IdentityStore, builder.DB),
+			groups:     new(db.DefaultGroupsDAO).Init(builder.Realm, builder.DB),
+		}
+
+		return run(cmd, args, store)
+	}
+}
+
+// setStatus changes the account status of a user, signing it out of its sessions when it is no longer active
+func (store *userStore) setStatus(username, status, reason string) error {
+	userCredential, err := store.dao.GetUserCredential(username)
+	if err != nil {
+		return err
+	}
+
+	if err := store.dao.SetUserCredentialStatus(userCredential.ID, status, reason); err != nil {
+		return err
+	}
+
+	if status != db.UserStatusActive {
+		store.revokeSessions(userCredential.Username)
+	}
+
+	return store.printUser(username)
+}
+
+// revokeSessions signs a user out of every hydra session, when hydra can be reached. The change of the user being
+// already stored, a failure of hydra is only warned about
+func (store *userStore) revokeSessions(username string) {
+	if store.HydraHelper == nil {
+		fmt.Fprintf(os.Stderr, "Hydra admin url not set, the sessions of '%v' were kept until they expire\n", username)
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(gohtypes.Error)
+			if !ok {
+				panic(r)
+			}
+			fmt.Fprintf(os.Stderr, "Unable to revoke the sessions of '%v', they were kept until they expire: %v\n", username, e.Message)
+		}
+	}()
+
+	store.HydraHelper.RevokeLoginSessions(store.GetSubject(username))
+	store.HydraHelper.RevokeConsentSessions(store.GetSubject(username), "")
+}
+
+func (store *userStore) printUser(username string) error {
+	userCredential, err := store.dao.GetUserCredential(username)
+	if err != nil {
+		return err
+	}
+
+	user := types.GetAdminUserResponsePayload(userCredential)
+	if viper.GetString("output") == outputJSON {
+		return printJSON(user)
+	}
+
+	printUsersTable([]types.AdminUserResponsePayload{user})
+	return nil
+}
+
+func printUsersTable(users []types.AdminUserResponsePayload) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tVERIFIED\tTOTP\tSTATUS\tCREATED")
+	for _, user := range users {
+		status := user.Status
+		if user.StatusReason != "" {
+			status += " (" + user.StatusReason + ")"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", user.ID, user.Username, user.Email, user.EmailValidated, user.TOTPEnabled, status, user.CreatedAt.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// readPassword reads the password flag, or a line of the standard input when it is '-'
+func readPassword() (string, error) {
+	password := viper.GetString("password")
+	if password != "-" {
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("unable to read the password from the standard input: %v", err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+	userCmd.AddCommand(userCreateCmd, userListCmd, userShowCmd, userDisableCmd, userEnableCmd, userDeleteCmd, userSetPasswordCmd, userVerifyEmailCmd)
+
+	config.AddStoreFlags(userCmd.PersistentFlags())
+	config.AddHydraAdminFlags(userCmd.PersistentFlags())
+	config.AddIdentityStoreFlags(userCmd.PersistentFlags())
+	config.AddRealmFlags(userCmd.PersistentFlags())
+	userCmd.PersistentFlags().StringP("output", "", outputTable, "[optional] Sets the output format, one of table or json. Defaults to table")
+
+	for _, cmd := range []*cobra.Command{userCreateCmd, userSetPasswordCmd} {
+		cmd.Flags().StringP("password", "", "", "Sets the password, read from the standard input when '-'")
+		_ = cmd.MarkFlagRequired("password")
+	}
+	userCreateCmd.Flags().StringP("verified", "", "false", "[optional] Marks the email as verified, so no confirmation is needed. Defaults to false")
+
+	userListCmd.Flags().StringP("search", "", "", "[optional] Lists only the users whose username or email contain the search")
+	userListCmd.Flags().StringP("page", "", "1", "[optional] Sets the page to list. Defaults to 1")
+	userListCmd.Flags().StringP("per-page", "", "20", "[optional] Sets how many users are listed per page. Defaults to 20")
+
+	for _, cmd := range []*cobra.Command{userDisableCmd, userEnableCmd} {
+		cmd.Flags().StringP("reason", "", "", "[optional] Sets why the status was changed")
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labbsr0x/whisper/db"
+)
+
+// newTestStoreFlags migrates a sqlite database, returning the flags of the user commands reaching it
+func newTestStoreFlags(t *testing.T) []string {
+	databaseURL := "sqlite://" + filepath.Join(t.TempDir(), "whisper.db")
+
+	database, err := db.Open(databaseURL)
+	if err != nil { // e.g. built without cgo
+		t.Skipf("sqlite unavailable: %v", err)
+	}
+	defer database.Close()
+
+	if _, err := db.MigrateUp(database, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	return []string{"--database-url", databaseURL, "--secret-key", "secret", "--password-hasher", "bcrypt", "--bcrypt-cost", "4"}
+}
+
+// getTestUser reads a user as stored by the user commands
+func getTestUser(t *testing.T, flags []string, username string) (db.UserCredential, bool) {
+	database, err := db.Open(flags[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	var userCredential db.UserCredential
+	found := database.Where("username = ?", username).First(&userCredential).Error == nil
+
+	return userCredential, found
+}
+
+func runTestCommand(args ...string) error {
+	rootCmd.SetArgs(args)
+	return rootCmd.Execute()
+}
+
+func TestUserCommands(t *testing.T) {
+	flags := newTestStoreFlags(t)
+
+	var status int32 = http.StatusInternalServerError
+	var revocations int32
+	hydra := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&revocations, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer hydra.Close()
+	flags = append(flags, "--hydra-admin-url", hydra.URL)
+
+	create := append([]string{"user", "create", "alice", "alice@example.com", "--password", "correct horse battery staple", "--verified", "true"}, flags...)
+	if err := runTestCommand(create...); err != nil {
+		t.Fatal(err)
+	}
+
+	if alice, found := getTestUser(t, flags, "alice"); !found || !alice.EmailValidated || alice.Status != db.UserStatusActive {
+		t.Fatalf("expected alice created with a verified email, got %+v", alice)
+	}
+
+	if err := runTestCommand(create...); err == nil {
+		t.Error("expected the user created once")
+	}
+
+	// the status is stored even though hydra fails to revoke the sessions
+	if err := runTestCommand(append([]string{"user", "disable", "alice", "--reason", "testing"}, flags...)...); err != nil {
+		t.Errorf("expected alice suspended despite hydra failing, got %v", err)
+	}
+
+	if alice, _ := getTestUser(t, flags, "alice"); alice.Status != db.UserStatusSuspended || alice.StatusReason != "testing" {
+		t.Errorf("expected alice suspended, got %+v", alice)
+	}
+
+	if atomic.LoadInt32(&revocations) == 0 {
+		t.Error("expected the sessions of alice revoked")
+	}
+
+	if err := runTestCommand(append([]string{"user", "enable", "alice", "--reason", ""}, flags...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	if alice, _ := getTestUser(t, flags, "alice"); alice.Status != db.UserStatusActive {
+		t.Errorf("expected alice active again, got %+v", alice)
+	}
+
+	atomic.StoreInt32(&status, http.StatusNoContent)
+	atomic.StoreInt32(&revocations, 0)
+	if err := runTestCommand(append([]string{"user", "delete", "alice"}, flags...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := getTestUser(t, flags, "alice"); found {
+		t.Error("expected alice deleted")
+	}
+
+	if atomic.LoadInt32(&revocations) != 2 {
+		t.Errorf("expected the login and consent sessions of alice revoked, got %v revocations", revocations)
+	}
+
+	if err := runTestCommand(append([]string{"user", "show", "alice"}, flags...)...); err == nil {
+		t.Error("expected alice no longer found")
+	}
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"    // mysql:// urls
+	_ "github.com/jinzhu/gorm/dialects/postgres" // postgres:// urls
+	_ "github.com/jinzhu/gorm/dialects/sqlite"   // sqlite:// urls
+)
+
+// Dialects selected by the scheme of the database url
+const (
+	MySQL    = "mysql"
+	Postgres = "postgres"
+	SQLite   = "sqlite3"
+)
+
+// Open connects to the database of the url, whose scheme selects the dialect: mysql://, postgres:// or sqlite://.
+// Urls without a scheme are taken as mysql data source names
+func Open(databaseURL string) (*gorm.DB, error) {
+	dialect, source, err := GetDialect(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(dialect, source)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect == SQLite { // sqlite serializes writes, and every connection to :memory: would be a database of its own
+		db.DB().SetMaxOpenConns(1)
+	} else {
+		db.DB().SetMaxIdleConns(4)
+		db.DB().SetMaxOpenConns(20)
+	}
+
+	return db, nil
+}
+
+// GetDialect gets the gorm dialect and data source of a database url
+func GetDialect(databaseURL string) (dialect, source string, err error) {
+	i := strings.Index(databaseURL, "://")
+	if i < 0 {
+		return MySQL, databaseURL, nil
+	}
+
+	switch scheme := databaseURL[:i]; scheme {
+	case "mysql":
+		return MySQL, databaseURL[i+3:], nil
+	case "postgres", "postgresql":
+		return Postgres, databaseURL, nil
+	case "sqlite", "sqlite3":
+		return SQLite, databaseURL[i+3:], nil
+	default:
+		return "", "", fmt.Errorf("unsupported database '%v', the url should start with mysql://, postgres:// or sqlite://", scheme)
+	}
+}
+
+// escapeLike escapes the wildcards of a value searched with LIKE ... ESCAPE '!', the escape character understood alike by every dialect
+func escapeLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
+
+// equalFold gets the condition of a column equal to a lower cased value regardless of case, the way the unique indexes
+// of the usernames and emails compare them: mysql does with its case insensitive collations, through the indexes, the
+// other dialects with their indexes over the lower cased columns
+func equalFold(db *gorm.DB, column string) string {
+	if db.Dialect().GetName() == MySQL {
+		return column + " = ?"
+	}
+
+	return "LOWER(" + column + ") = ?"
+}
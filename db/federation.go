@@ -0,0 +1,89 @@
+package db
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/sirupsen/logrus"
+)
+
+// unsafeUsernameChars are left out of the usernames picked for the users provisioned from an upstream provider
+var unsafeUsernameChars = regexp.MustCompile("[^a-zA-Z0-9._-]+")
+
+// CreateFederatedUserCredential provisions a user without a password for a subject of an upstream provider, linking
+// the subject to it. The email, verified by the provider, must not belong to another user
+func (dao *DefaultUserCredentialsDAO) CreateFederatedUserCredential(provider, subject, email, preferredUsername string) (UserCredential, error) {
+	RefuseIfReadOnly(dao)
+
+	if _, err := dao.GetUserCredentialByEmail(email); err == nil {
+		gohtypes.Panic("An account with this email already exists", http.StatusConflict)
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return UserCredential{}, err
+	}
+
+	username, err := dao.getFreeUsername(getFederatedUsername(email, preferredUsername))
+	if err != nil {
+		return UserCredential{}, err
+	}
+
+	tx := dao.db.Begin()
+	if tx.Error != nil {
+		return UserCredential{}, tx.Error
+	}
+
+	userCredential := UserCredential{Realm: dao.realm, Username: username, Email: email, EmailValidated: true}
+	if err := tx.Create(&userCredential).Error; err != nil {
+		tx.Rollback()
+		return UserCredential{}, err
+	}
+
+	identity := UserIdentity{Realm: dao.realm, UserCredentialID: userCredential.ID, Provider: provider, Subject: subject, Email: email}
+	if err := tx.Create(&identity).Error; err != nil {
+		tx.Rollback()
+		return UserCredential{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return UserCredential{}, err
+	}
+
+	logrus.Infof("User '%v' provisioned from the upstream provider '%v'", username, provider)
+	return userCredential, nil
+}
+
+// getFreeUsername finds a username nobody has yet, numbering the given one on conflicts
+func (dao *DefaultUserCredentialsDAO) getFreeUsername(base string) (string, error) {
+	for i := 1; ; i++ {
+		username := base
+		if i > 1 && i < 10 {
+			username = fmt.Sprintf("%v-%v", base, i)
+		} else if i >= 10 {
+			username = base + "-" + uuid.New().String()[:8]
+		}
+
+		if _, err := dao.GetUserCredential(username); gorm.IsRecordNotFoundError(err) {
+			return username, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// getFederatedUsername picks the username of a provisioned user from its preferred username or, lacking one, its email
+func getFederatedUsername(email, preferredUsername string) string {
+	username := unsafeUsernameChars.ReplaceAllString(preferredUsername, "")
+	if username == "" {
+		username = unsafeUsernameChars.ReplaceAllString(strings.Split(email, "@")[0], "")
+	}
+
+	if username == "" {
+		username = "user"
+	}
+
+	return username
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/labbsr0x/goh/gohtypes"
+)
+
+// Kinds of the groups users are members of. Roles are the groups meant for authorization
+const (
+	GroupKindGroup = "group"
+	GroupKindRole  = "role"
+)
+
+// Group is a named set of users of a realm, released to the clients in the groups or roles claim of the tokens
+type Group struct {
+	ID          string `gorm:"primary_key;not null;"`
+	Realm       string `gorm:"unique_index:idx_user_groups_realm_kind_name;not null;default:''"`
+	Kind        string `gorm:"unique_index:idx_user_groups_realm_kind_name;not null;"`
+	Name        string `gorm:"unique_index:idx_user_groups_realm_kind_name;not null;"`
+	Description string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TableName keeps the table clear of groups, a reserved word of mysql
+func (Group) TableName() string { return "user_groups" }
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (group *Group) BeforeCreate(scope *gorm.Scope) error {
+	return scope.SetColumn("ID", uuid.New().String())
+}
+
+// GroupMember records a user as a member of a group
+type GroupMember struct {
+	GroupID          string `gorm:"primary_key;not null;"`
+	UserCredentialID string `gorm:"primary_key;not null;index;"`
+	CreatedAt        time.Time
+}
+
+// TableName names the table after the one of the groups
+func (GroupMember) TableName() string { return "user_group_members" }
+
+// GroupsDAO defines the methods that can be performed over the groups and roles of the users
+type GroupsDAO interface {
+	Init(realm string, db *gorm.DB) GroupsDAO
+	CreateGroup(kind, name, description string) (Group, error)
+	GetGroup(kind, name string) (Group, error)
+	ListGroups(kind string) ([]Group, error)
+	DeleteGroup(kind, name string) error
+	AddGroupMember(groupID, userCredentialID string) error
+	RemoveGroupMember(groupID, userCredentialID string) error
+	ListGroupMembers(groupID string) ([]UserCredential, error)
+	ListUserGroups(userCredentialID, kind string) ([]Group, error)
+}
+
+// DefaultGroupsDAO a default GroupsDAO interface implementation, only reaching the groups and roles of its realm
+type DefaultGroupsDAO struct {
+	db    *gorm.DB
+	realm string
+}
+
+// Init initializes a default groups DAO
+func (dao *DefaultGroupsDAO) Init(realm string, db *gorm.DB) GroupsDAO {
+	dao.db = db
+	dao.realm = realm
+
+	return dao
+}
+
+// CreateGroup creates a group or role. Its name must not be taken by another one of the same kind
+func (dao *DefaultGroupsDAO) CreateGroup(kind, name, description string) (Group, error) {
+	if _, err := dao.GetGroup(kind, name); err == nil {
+		gohtypes.Panic("A "+kind+" with this name already exists", http.StatusConflict)
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return Group{}, err
+	}
+
+	group := Group{Realm: dao.realm, Kind: kind, Name: name, Description: description}
+	if err := dao.db.Create(&group).Error; err != nil {
+		return Group{}, err
+	}
+
+	return group, nil
+}
+
+// GetGroup gets a group or role by its name
+func (dao *DefaultGroupsDAO) GetGroup(kind, name string) (group Group, err error) {
+	err = dao.db.Where("realm = ? AND kind = ? AND name = ?", dao.realm, kind, name).First(&group).Error
+	return
+}
+
+// ListGroups lists the groups or roles by their names
+func (dao *DefaultGroupsDAO) ListGroups(kind string) (groups []Group, err error) {
+	err = dao.db.Where("realm = ? AND kind = ?", dao.realm, kind).Order("name").Find(&groups).Error
+	return
+}
+
+// DeleteGroup deletes a group or role along with its memberships
+func (dao *DefaultGroupsDAO) DeleteGroup(kind, name string) error {
+	group, err := dao.GetGroup(kind, name)
+	if err != nil {
+		return err
+	}
+
+	tx := dao.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := tx.Where("group_id = ?", group.ID).Delete(&GroupMember{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("id = ?", group.ID).Delete(&Group{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// AddGroupMember makes a user a member of a group. Adding it again does nothing
+func (dao *DefaultGroupsDAO) AddGroupMember(groupID, userCredentialID string) error {
+	var count int
+	if err := dao.db.Model(&GroupMember{}).Where("group_id = ? AND user_credential_id = ?", groupID, userCredentialID).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+
+	return dao.db.Create(&GroupMember{GroupID: groupID, UserCredentialID: userCredentialID}).Error
+}
+
+// RemoveGroupMember removes a user from a group
+func (dao *DefaultGroupsDAO) RemoveGroupMember(groupID, userCredentialID string) error {
+	res := dao.db.Where("group_id = ? AND user_credential_id = ?", groupID, userCredentialID).Delete(&GroupMember{})
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return res.Error
+}
+
+// ListGroupMembers lists the members of a group by their usernames
+func (dao *DefaultGroupsDAO) ListGroupMembers(groupID string) (userCredentials []UserCredential, err error) {
+	err = dao.db.Joins("JOIN user_group_members ON user_group_members.user_credential_id = user_credentials.id").
+		Where("user_group_members.group_id = ? AND user_credentials.realm = ?", groupID, dao.realm).Order("user_credentials.username").Find(&userCredentials).Error
+	return
+}
+
+// ListUserGroups lists the groups or roles a user is a member of by their names
+func (dao *DefaultGroupsDAO) ListUserGroups(userCredentialID, kind string) (groups []Group, err error) {
+	err = dao.db.Joins("JOIN user_group_members ON user_group_members.group_id = user_groups.id").
+		Where("user_group_members.user_credential_id = ? AND user_groups.realm = ? AND user_groups.kind = ?", userCredentialID, dao.realm, kind).
+		Order("user_groups.name").Find(&groups).Error
+	return
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/sirupsen/logrus"
+)
+
+// Identity stores, selected with the identity-store flag
+const (
+	IdentityStoreDatabase = "database"
+	IdentityStoreLDAP     = "ldap"
+)
+
+// Errors of the identity stores
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	ErrInvalidPassword  = errors.New("invalid password")
+)
+
+// Identity holds who a user is according to an identity store
+type Identity struct {
+	Username string
+	Email    string
+}
+
+// IdentityStore defines where the users and their passwords live when it is not the database.
+// The users it authenticates are mirrored in the database, which still keeps their second factors and account status
+type IdentityStore interface {
+	// Authenticate checks the password of a user, returning ErrIdentityNotFound or ErrInvalidPassword when refused
+	Authenticate(username, password string) (Identity, error)
+	// GetIdentity looks a user up, returning ErrIdentityNotFound when there is none
+	GetIdentity(username string) (Identity, error)
+	// IsReadOnly tells whether users can not be registered nor have their passwords changed through whisper
+	IsReadOnly() bool
+}
+
+// IsReadOnly tells whether users can not be registered nor have their passwords changed through whisper
+func (dao *DefaultUserCredentialsDAO) IsReadOnly() bool {
+	return dao.identityStore != nil && dao.identityStore.IsReadOnly()
+}
+
+// CheckIdentity verifies the user is still known by the identity store, for the logins that do not take a password.
+// Always passes when the database is the identity store
+func (dao *DefaultUserCredentialsDAO) CheckIdentity(username string) error {
+	if dao.identityStore == nil {
+		return nil
+	}
+
+	_, err := dao.identityStore.GetIdentity(username)
+	return err
+}
+
+// RefuseIfReadOnly refuses the registrations and the password and email changes the identity store of the users does
+// not take, with a 403
+func RefuseIfReadOnly(dao UserCredentialsDAO) {
+	if dao.IsReadOnly() {
+		gohtypes.Panic("Accounts are managed by the identity store, register and change passwords there", http.StatusForbidden)
+	}
+}
+
+// checkStoreCredentials verifies the credentials against the identity store, mirroring the user in the database
+func (dao *DefaultUserCredentialsDAO) checkStoreCredentials(username, password string) UserCredential {
+	identity, err := dao.identityStore.Authenticate(username, password)
+	if err == ErrIdentityNotFound || err == ErrInvalidPassword {
+		gohtypes.Panic("Incorrect username or password", http.StatusUnauthorized)
+	}
+	gohtypes.PanicIfError("Unable to reach the identity store", http.StatusBadGateway, err)
+
+	userCredential, err := dao.mirrorIdentity(identity)
+	gohtypes.PanicIfError("Unable to store the user", http.StatusInternalServerError, err)
+
+	if !userCredential.IsActive() {
+		gohtypes.Panic(GetUserStatusMessage(userCredential.Status), http.StatusForbidden)
+	}
+
+	return userCredential
+}
+
+// mirrorIdentity creates or updates the user standing in the database for an identity of the store.
+// Users created here have no password, so they can only sign in through the store
+func (dao *DefaultUserCredentialsDAO) mirrorIdentity(identity Identity) (UserCredential, error) {
+	userCredential, err := dao.GetUserCredential(identity.Username)
+	if gorm.IsRecordNotFoundError(err) {
+		userCredential = UserCredential{Realm: dao.realm, Username: identity.Username, Email: identity.Email, EmailValidated: true}
+		if err := dao.db.Create(&userCredential).Error; err != nil {
+			return UserCredential{}, err
+		}
+
+		logrus.Infof("User '%v' mirrored from the identity store", userCredential.Username)
+		return userCredential, nil
+	}
+
+	if err != nil || (userCredential.Email == identity.Email && userCredential.EmailValidated) {
+		return userCredential, err
+	}
+
+	userCredential.Email = identity.Email
+	userCredential.EmailValidated = true // the store is trusted with the emails
+	err = dao.db.Model(&UserCredential{}).Where("id = ?", userCredential.ID).
+		Updates(map[string]interface{}{"email": identity.Email, "email_validated": true}).Error
+
+	return userCredential, err
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig holds how to reach an LDAP or Active Directory server and find the users in it
+type LDAPConfig struct {
+	URL               string
+	StartTLS          bool
+	BindDN            string
+	BindPassword      string
+	BaseDN            string
+	UserFilter        string // with a %s where the escaped username goes
+	UsernameAttribute string
+	EmailAttribute    string
+	Timeout           time.Duration
+}
+
+// LDAPIdentityStore an IdentityStore interface implementation that binds against an LDAP or Active Directory server.
+// The directory is only read, so users are registered and change their passwords there
+type LDAPIdentityStore struct {
+	config LDAPConfig
+}
+
+// Init initializes an LDAP identity store
+func (store *LDAPIdentityStore) Init(config LDAPConfig) (IdentityStore, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+		return nil, fmt.Errorf("the ldap url '%v' should start with ldap:// or ldaps://", config.URL)
+	}
+
+	if config.BaseDN == "" {
+		return nil, fmt.Errorf("the ldap base dn should be set")
+	}
+
+	if strings.Count(config.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("the ldap user filter '%v' should hold a single %%s for the username", config.UserFilter)
+	}
+
+	if config.UsernameAttribute == "" || config.EmailAttribute == "" {
+		return nil, fmt.Errorf("the ldap username and email attributes should be set")
+	}
+
+	store.config = config
+	return store, nil
+}
+
+// Authenticate looks the user up with the service account, then binds as the entry found to check the password
+func (store *LDAPIdentityStore) Authenticate(username, password string) (Identity, error) {
+	if password == "" { // taken as an unauthenticated bind, which most servers accept
+		return Identity{}, ErrInvalidPassword
+	}
+
+	conn, err := store.connect()
+	if err != nil {
+		return Identity{}, err
+	}
+	defer conn.Close()
+
+	entry, err := store.findEntry(conn, username)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Identity{}, ErrInvalidPassword
+		}
+		return Identity{}, err
+	}
+
+	return store.getIdentity(entry)
+}
+
+// GetIdentity looks the user up with the service account
+func (store *LDAPIdentityStore) GetIdentity(username string) (Identity, error) {
+	conn, err := store.connect()
+	if err != nil {
+		return Identity{}, err
+	}
+	defer conn.Close()
+
+	entry, err := store.findEntry(conn, username)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	return store.getIdentity(entry)
+}
+
+// IsReadOnly tells the directory is only read
+func (store *LDAPIdentityStore) IsReadOnly() bool {
+	return true
+}
+
+// connect opens a connection bound to the service account, or anonymous when there is none
+func (store *LDAPIdentityStore) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(store.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: store.config.Timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(store.config.Timeout)
+
+	if store.config.StartTLS {
+		u, _ := url.Parse(store.config.URL)
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if store.config.BindDN != "" {
+		err = conn.Bind(store.config.BindDN, store.config.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to bind the ldap service account: %v", err)
+	}
+
+	return conn, nil
+}
+
+// findEntry searches the single entry of a user
+func (store *LDAPIdentityStore) findEntry(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		store.config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // enough to tell an ambiguous filter apart
+		int(store.config.Timeout.Seconds()),
+		false,
+		fmt.Sprintf(store.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{store.config.UsernameAttribute, store.config.EmailAttribute},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || (err == nil && len(result.Entries) > 1) {
+		return nil, fmt.Errorf("the ldap user filter matches more than one entry for '%v'", username)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Entries) == 0 {
+		return nil, ErrIdentityNotFound
+	}
+
+	return result.Entries[0], nil
+}
+
+// getIdentity maps the attributes of an entry to an identity
+func (store *LDAPIdentityStore) getIdentity(entry *ldap.Entry) (Identity, error) {
+	identity := Identity{
+		Username: entry.GetAttributeValue(store.config.UsernameAttribute),
+		Email:    entry.GetAttributeValue(store.config.EmailAttribute),
+	}
+
+	if identity.Username == "" || identity.Email == "" {
+		return Identity{}, fmt.Errorf("the ldap entry '%v' lacks the %v or %v attributes", entry.DN, store.config.UsernameAttribute, store.config.EmailAttribute)
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testLDAPServer is an in-process stand-in of an LDAP server that only takes simple binds and searches
+type testLDAPServer struct {
+	listener  net.Listener
+	mutex     sync.Mutex
+	passwords map[string]string            // by dn
+	entries   map[string]map[string]string // attributes by dn
+}
+
+func newTestLDAPServer(t *testing.T) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testLDAPServer{
+		listener: listener,
+		passwords: map[string]string{
+			"cn=whisper,dc=example,dc=com":          "service",
+			"uid=alice,ou=people,dc=example,dc=com": "alice-password",
+			"uid=bob,ou=people,dc=example,dc=com":   "bob-password",
+		},
+		entries: map[string]map[string]string{
+			"uid=alice,ou=people,dc=example,dc=com": {"objectClass": "person", "uid": "alice", "mail": "alice@example.com"},
+			"uid=bob,ou=people,dc=example,dc=com":   {"objectClass": "person", "uid": "bob", "mail": "bob@example.com"},
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (server *testLDAPServer) URL() string {
+	return "ldap://" + server.listener.Addr().String()
+}
+
+func (server *testLDAPServer) Close() {
+	server.listener.Close()
+}
+
+func (server *testLDAPServer) setMail(dn, mail string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.entries[dn]["mail"] = mail
+}
+
+func (server *testLDAPServer) remove(dn string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	delete(server.entries, dn)
+	delete(server.passwords, dn)
+}
+
+func (server *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		server.mutex.Lock()
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultInvalidCredentials
+			dn, password := request.Children[1].Value.(string), request.Children[2].Data.String()
+			if expected, ok := server.passwords[dn]; (ok && expected == password) || (dn == "" && password == "") {
+				code = ldap.LDAPResultSuccess
+			}
+			writeTestLDAPResponse(conn, messageID, newTestLDAPResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			for dn, attributes := range server.entries {
+				if strings.HasSuffix(dn, request.Children[0].Value.(string)) && matchTestLDAPFilter(request.Children[6], attributes) {
+					writeTestLDAPResponse(conn, messageID, newTestLDAPEntry(dn, attributes))
+				}
+			}
+			writeTestLDAPResponse(conn, messageID, newTestLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		default: // unbind
+			server.mutex.Unlock()
+			return
+		}
+		server.mutex.Unlock()
+	}
+}
+
+// matchTestLDAPFilter matches the and, or, equality and presence filters, ignoring the case as most attributes do
+func matchTestLDAPFilter(filter *ber.Packet, attributes map[string]string) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchTestLDAPFilter(child, attributes) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchTestLDAPFilter(child, attributes) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterEqualityMatch:
+		return strings.EqualFold(attributes[filter.Children[0].Value.(string)], filter.Children[1].Value.(string))
+	case ldap.FilterPresent:
+		_, ok := attributes[filter.Data.String()]
+		return ok
+	}
+
+	return false
+}
+
+func newTestLDAPResult(tag ber.Tag, code int) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+func newTestLDAPEntry(dn string, attributes map[string]string) *ber.Packet {
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, value := range attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		attribute.AppendChild(values)
+		list.AppendChild(attribute)
+	}
+	entry.AppendChild(list)
+
+	return entry
+}
+
+func writeTestLDAPResponse(w io.Writer, messageID int64, response *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	packet.AppendChild(response)
+	_, _ = w.Write(packet.Bytes())
+}
+
+func newTestLDAPIdentityStore(t *testing.T, server *testLDAPServer) IdentityStore {
+	store, err := new(LDAPIdentityStore).Init(LDAPConfig{
+		URL:               server.URL(),
+		BindDN:            "cn=whisper,dc=example,dc=com",
+		BindPassword:      "service",
+		BaseDN:            "ou=people,dc=example,dc=com",
+		UserFilter:        "(&(objectClass=person)(uid=%s))",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		Timeout:           5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func TestLDAPIdentityStore(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+	store := newTestLDAPIdentityStore(t, server)
+
+	identity, err := store.Authenticate("Alice", "alice-password")
+	if err != nil || identity.Username != "alice" || identity.Email != "alice@example.com" {
+		t.Errorf("expected the identity of alice as in the directory, got %+v (%v)", identity, err)
+	}
+
+	if _, err := store.Authenticate("alice", "bob-password"); err != ErrInvalidPassword {
+		t.Errorf("a wrong password should be refused, got %v", err)
+	}
+
+	if _, err := store.Authenticate("alice", ""); err != ErrInvalidPassword {
+		t.Errorf("an empty password should be refused rather than taken as an unauthenticated bind, got %v", err)
+	}
+
+	if _, err := store.Authenticate("carol", "alice-password"); err != ErrIdentityNotFound {
+		t.Errorf("an unknown user should not be found, got %v", err)
+	}
+
+	for _, username := range []string{"*", "alice)(uid=*"} {
+		if _, err := store.GetIdentity(username); err != ErrIdentityNotFound {
+			t.Errorf("the username %v should be escaped in the filter, got %v", username, err)
+		}
+	}
+
+	if identity, err := store.GetIdentity("bob"); err != nil || identity.Email != "bob@example.com" {
+		t.Errorf("expected the identity of bob, got %+v (%v)", identity, err)
+	}
+
+	if _, err := new(LDAPIdentityStore).Init(LDAPConfig{URL: server.URL(), BaseDN: "dc=example,dc=com", UserFilter: "(uid=alice)"}); err == nil {
+		t.Error("a user filter without the username should be refused")
+	}
+}
+
+func TestLDAPUserCredentials(t *testing.T) {
+	server := newTestLDAPServer(t)
+	defer server.Close()
+	db := newTestDB(t)
+	defer db.Close()
+
+	dao := newTestRealmUserCredentialsDAO(t, db, "", nil, newTestLDAPIdentityStore(t, server))
+
+	alice := dao.CheckCredentials("alice", "alice-password")
+	if alice.ID == "" || alice.Email != "alice@example.com" || !alice.EmailValidated || !alice.IsActive() {
+		t.Fatalf("alice should be mirrored in the database, got %+v", alice)
+	}
+
+	expectPanic(t, http.StatusUnauthorized, func() { dao.CheckCredentials("alice", "wrong") })
+	expectPanic(t, http.StatusUnauthorized, func() { dao.CheckCredentials("carol", "alice-password") })
+
+	server.setMail("uid=alice,ou=people,dc=example,dc=com", "alice@example.org")
+	if user := dao.CheckCredentials("alice", "alice-password"); user.ID != alice.ID || user.Email != "alice@example.org" {
+		t.Errorf("the email should follow the directory, got %+v", user)
+	}
+
+	if !dao.IsReadOnly() {
+		t.Error("the directory should be read only")
+	}
+
+	expectPanic(t, http.StatusForbidden, func() { _, _ = dao.CreateUserCredential("carol", "password", "carol@example.com") })
+	expectPanic(t, http.StatusForbidden, func() { _ = dao.UpdateUserCredential("alice", "alice@example.org", "new-password") })
+
+	if err := dao.SetUserCredentialStatus(alice.ID, UserStatusSuspended, "testing"); err != nil {
+		t.Fatal(err)
+	}
+	expectPanic(t, http.StatusForbidden, func() { dao.CheckCredentials("alice", "alice-password") })
+
+	if err := dao.CheckIdentity("alice"); err != nil {
+		t.Errorf("alice should still be in the directory, got %v", err)
+	}
+
+	server.remove("uid=alice,ou=people,dc=example,dc=com")
+	if err := dao.CheckIdentity("alice"); err != ErrIdentityNotFound {
+		t.Errorf("alice should be gone from the directory, got %v", err)
+	}
+}
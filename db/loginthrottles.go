@@ -0,0 +1,107 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LoginThrottle holds the failed login attempts of a username or client ip, shared by every whisper replica
+type LoginThrottle struct {
+	ThrottleKey   string `gorm:"primary_key;not null;"`
+	Failures      int    `gorm:"not null;"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+	UpdatedAt     time.Time
+}
+
+// LoginThrottlesDAO defines the methods that can be performed over login throttles
+type LoginThrottlesDAO interface {
+	Init(db *gorm.DB) LoginThrottlesDAO
+	GetLoginThrottle(key string) (LoginThrottle, error)
+	RegisterLoginFailure(key string, window time.Duration) (LoginThrottle, error)
+	LockLoginThrottle(key string, until time.Time) error
+	UnlockLoginThrottle(key string) (bool, error)
+	ForgetLoginFailure(key string) error
+	ResetLoginThrottle(key string) error
+}
+
+// DefaultLoginThrottlesDAO a default LoginThrottlesDAO interface implementation
+type DefaultLoginThrottlesDAO struct {
+	db *gorm.DB
+}
+
+// Init initializes a default login throttles DAO
+func (dao *DefaultLoginThrottlesDAO) Init(db *gorm.DB) LoginThrottlesDAO {
+	dao.db = db
+
+	return dao
+}
+
+// GetLoginThrottle gets the throttle of a key, an empty one if it never failed
+func (dao *DefaultLoginThrottlesDAO) GetLoginThrottle(key string) (LoginThrottle, error) {
+	throttle := LoginThrottle{ThrottleKey: key}
+
+	err := dao.db.Where("throttle_key = ?", key).First(&throttle).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return throttle, nil
+	}
+
+	return throttle, err
+}
+
+// RegisterLoginFailure atomically counts a failed attempt of a key, starting over when the last one is older than the window
+func (dao *DefaultLoginThrottlesDAO) RegisterLoginFailure(key string, window time.Duration) (LoginThrottle, error) {
+	now := time.Now()
+
+	res := dao.db.Model(&LoginThrottle{}).Where("throttle_key = ? AND last_failure_at < ?", key, now.Add(-window)).
+		Updates(map[string]interface{}{"failures": 0, "locked_until": nil})
+	if res.Error != nil {
+		return LoginThrottle{}, res.Error
+	}
+
+	res = dao.db.Model(&LoginThrottle{}).Where("throttle_key = ?", key).
+		Updates(map[string]interface{}{"failures": gorm.Expr("failures + 1"), "last_failure_at": now})
+	if res.Error != nil {
+		return LoginThrottle{}, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		throttle := LoginThrottle{ThrottleKey: key, Failures: 1, LastFailureAt: now}
+		if err := dao.db.Create(&throttle).Error; err == nil {
+			return throttle, nil
+		}
+
+		// created concurrently by another attempt
+		err := dao.db.Model(&LoginThrottle{}).Where("throttle_key = ?", key).
+			Updates(map[string]interface{}{"failures": gorm.Expr("failures + 1"), "last_failure_at": now}).Error
+		if err != nil {
+			return LoginThrottle{}, err
+		}
+	}
+
+	return dao.GetLoginThrottle(key)
+}
+
+// LockLoginThrottle locks a key until the given time
+func (dao *DefaultLoginThrottlesDAO) LockLoginThrottle(key string, until time.Time) error {
+	return dao.db.Model(&LoginThrottle{}).Where("throttle_key = ?", key).Update("locked_until", &until).Error
+}
+
+// UnlockLoginThrottle clears the lock of a key once it expired, telling whether there was one to clear. Only one of
+// concurrent calls clears it
+func (dao *DefaultLoginThrottlesDAO) UnlockLoginThrottle(key string) (bool, error) {
+	res := dao.db.Model(&LoginThrottle{}).Where("throttle_key = ? AND locked_until <= ?", key, time.Now()).Update("locked_until", nil)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ForgetLoginFailure takes back an attempt counted on a key
+func (dao *DefaultLoginThrottlesDAO) ForgetLoginFailure(key string) error {
+	return dao.db.Model(&LoginThrottle{}).Where("throttle_key = ? AND failures > 0", key).
+		Update("failures", gorm.Expr("failures - 1")).Error
+}
+
+// ResetLoginThrottle forgets the failed attempts of a key
+func (dao *DefaultLoginThrottlesDAO) ResetLoginThrottle(key string) error {
+	return dao.db.Where("throttle_key = ?", key).Delete(&LoginThrottle{}).Error
+}
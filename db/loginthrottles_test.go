@@ -0,0 +1,55 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottles(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	dao := new(DefaultLoginThrottlesDAO).Init(db)
+
+	for i := 1; i <= 3; i++ {
+		throttle, err := dao.RegisterLoginFailure("user:alice", time.Hour)
+		if err != nil || throttle.Failures != i {
+			t.Fatalf("expected %v failures, got %v (%v)", i, throttle.Failures, err)
+		}
+	}
+
+	if err := dao.ForgetLoginFailure("user:alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if throttle, _ := dao.GetLoginThrottle("user:alice"); throttle.Failures != 2 {
+		t.Errorf("expected the attempt taken back, got %v failures", throttle.Failures)
+	}
+
+	if err := dao.LockLoginThrottle("user:alice", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if unlocked, err := dao.UnlockLoginThrottle("user:alice"); unlocked || err != nil {
+		t.Errorf("the lock should be kept until it expires (%v)", err)
+	}
+
+	if err := dao.LockLoginThrottle("user:alice", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if unlocked, err := dao.UnlockLoginThrottle("user:alice"); !unlocked || err != nil {
+		t.Errorf("the expired lock should be cleared (%v)", err)
+	}
+
+	if unlocked, err := dao.UnlockLoginThrottle("user:alice"); unlocked || err != nil {
+		t.Errorf("the lock should be cleared once (%v)", err)
+	}
+
+	if err := dao.ResetLoginThrottle("user:alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if throttle, _ := dao.GetLoginThrottle("user:alice"); throttle.Failures != 0 {
+		t.Errorf("the failures should be forgotten, got %v", throttle.Failures)
+	}
+}
@@ -0,0 +1,533 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// Migration is an ordered, reversible change to the database schema
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+}
+
+// SchemaMigration records a migration applied to the database
+type SchemaMigration struct {
+	Version     int    `gorm:"primary_key;auto_increment:false"`
+	Description string `gorm:"not null;"`
+	AppliedAt   time.Time
+}
+
+// MigrationStatus tells whether a known migration was applied to the database, and when
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations lists every change to the database schema, in the order they are applied.
+// Applied migrations must never be edited: change the schema by appending a new one
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create the user credentials, webauthn credentials, recovery codes and login throttles tables",
+		Up: func(tx *gorm.DB) error {
+			// additive, so it also adopts the databases created by the versions that auto migrated on startup
+			return tx.AutoMigrate(&userCredentialV1{}, &webAuthnCredentialV1{}, &recoveryCodeV1{}, &loginThrottleV1{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&loginThrottleV1{}, &recoveryCodeV1{}, &webAuthnCredentialV1{}, &userCredentialV1{}).Error
+		},
+	},
+	{
+		Version:     2,
+		Description: "fill the account status of the users",
+		Up: func(tx *gorm.DB) error {
+			return tx.Table("user_credentials").Where("status IS NULL OR status = ''").Update("status", UserStatusActive).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil // the filled statuses are still valid
+		},
+	},
+	{
+		Version:     3,
+		Description: "create the user identities table, linking users to the subjects of upstream providers",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userIdentityV3{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&userIdentityV3{}).Error
+		},
+	},
+	{
+		Version:     4,
+		Description: "create the used tokens table, recording the single use tokens already spent",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&usedTokenV4{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&usedTokenV4{}).Error
+		},
+	},
+	{
+		Version:     5,
+		Description: "add the security stamp of the users, binding the mailed tokens to their password and email",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&userCredentialV5{}).Error; err != nil {
+				return err
+			}
+
+			var ids []string
+			if err := tx.Table("user_credentials").Where("security_stamp IS NULL OR security_stamp = ''").Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+
+			for _, id := range ids { // a stamp of their own, so the tokens of a user never match another one
+				if err := tx.Table("user_credentials").Where("id = ?", id).Update("security_stamp", uuid.New().String()).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumnUnlessSQLite(tx, "user_credentials", "security_stamp")
+		},
+	},
+	{
+		Version:     6,
+		Description: "create the user attributes table, holding the profile attributes of the users",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&userAttributeV6{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&userAttributeV6{}).Error
+		},
+	},
+	{
+		Version:     7,
+		Description: "create the groups and group members tables, holding the groups and roles of the users",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&groupV7{}, &groupMemberV7{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&groupMemberV7{}, &groupV7{}).Error
+		},
+	},
+	{
+		Version:     8,
+		Description: "add the realm of the users, identities and groups, keeping their names unique within a realm",
+		Up: func(tx *gorm.DB) error {
+			// the existing rows fall in the default realm
+			if err := tx.AutoMigrate(&userCredentialV8{}, &userIdentityV8{}, &groupV8{}).Error; err != nil {
+				return err
+			}
+
+			for _, index := range realmIndexesV8 {
+				if err := tx.Table(index.table).RemoveIndex(index.former).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Table(index.table).AddUniqueIndex(index.name, append([]string{"realm"}, index.columns...)...).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			// fails while two realms share a name, which the former indexes do not allow
+			for _, index := range realmIndexesV8 {
+				if err := tx.Table(index.table).RemoveIndex(index.name).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Table(index.table).AddUniqueIndex(index.former, index.columns...).Error; err != nil {
+					return err
+				}
+			}
+
+			for _, table := range []string{"user_credentials", "user_identities", "user_groups"} {
+				if err := dropColumnUnlessSQLite(tx, table, "realm"); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		Version:     9,
+		Description: "add the pending flag of the users, telling the registrations never confirmed from the changed emails",
+		Up: func(tx *gorm.DB) error {
+			// the unconfirmed users of before may have changed their email, so none of them is taken as pending
+			return tx.AutoMigrate(&userCredentialV9{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumnUnlessSQLite(tx, "user_credentials", "pending")
+		},
+	},
+	{
+		Version:     10,
+		Description: "compare the usernames and emails regardless of case in their unique indexes, as mysql already does",
+		Up: func(tx *gorm.DB) error {
+			if tx.Dialect().GetName() == MySQL { // its case insensitive collations already compare so
+				return nil
+			}
+
+			// fails while two users of a realm only differ by the case of their usernames or emails, to be merged first
+			for _, index := range foldedIndexesV10 {
+				if err := tx.Table("user_credentials").RemoveIndex(index.name).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Table("user_credentials").AddUniqueIndex(index.name, "realm", "LOWER("+index.column+")").Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialect().GetName() == MySQL {
+				return nil
+			}
+
+			for _, index := range foldedIndexesV10 {
+				if err := tx.Table("user_credentials").RemoveIndex(index.name).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Table("user_credentials").AddUniqueIndex(index.name, "realm", index.column).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+}
+
+// GetLatestSchemaVersion gets the schema version this build of whisper expects
+func GetLatestSchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// GetSchemaVersion gets the version of the last migration applied to the database, 0 if none
+func GetSchemaVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return 0, err
+	}
+
+	var versions []int
+	if err := db.Model(&SchemaMigration{}).Order("version DESC").Limit(1).Pluck("version", &versions).Error; err != nil {
+		return 0, err
+	}
+
+	if len(versions) == 0 {
+		return 0, nil
+	}
+
+	return versions[0], nil
+}
+
+// CheckSchemaVersion fails unless the database is at the schema version this build of whisper expects
+func CheckSchemaVersion(db *gorm.DB) error {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if latest := GetLatestSchemaVersion(); version < latest {
+		return fmt.Errorf("the database schema is at version %v, older than the expected %v: run 'whisper migrate up'", version, latest)
+	} else if version > latest {
+		return fmt.Errorf("the database schema is at version %v, newer than the expected %v: run a newer whisper or 'whisper migrate down' from it", version, latest)
+	}
+
+	return nil
+}
+
+// GetMigrationStatus lists every known migration and whether it was applied to the database
+func GetMigrationStatus(db *gorm.DB) ([]MigrationStatus, error) {
+	if _, err := GetSchemaVersion(db); err != nil {
+		return nil, err
+	}
+
+	var applied []SchemaMigration
+	if err := db.Find(&applied).Error; err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int]time.Time)
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, 0, len(Migrations))
+	for _, migration := range Migrations {
+		status := MigrationStatus{Migration: migration}
+		if t, ok := appliedAt[migration.Version]; ok {
+			status.AppliedAt = &t
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp applies, in order, the migrations up to the target version, or every pending one when the target is 0
+func MigrateUp(db *gorm.DB, target int) ([]Migration, error) {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]Migration, 0)
+	for _, migration := range Migrations {
+		if migration.Version <= version || (target > 0 && migration.Version > target) {
+			continue
+		}
+
+		err := runMigration(db, migration.Up, func(tx *gorm.DB) error {
+			return tx.Create(&SchemaMigration{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("unable to apply migration %v: %v", migration.Version, err)
+		}
+
+		logrus.Infof("Migration %v applied: %v", migration.Version, migration.Description)
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown reverts, from the last one, the given number of applied migrations
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > GetLatestSchemaVersion() {
+		return nil, fmt.Errorf("the database schema is at version %v, unknown to this build of whisper", version)
+	}
+
+	reverted := make([]Migration, 0)
+	for i := len(Migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := Migrations[i]
+		if migration.Version > version {
+			continue
+		}
+
+		err := runMigration(db, migration.Down, func(tx *gorm.DB) error {
+			return tx.Where("version = ?", migration.Version).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("unable to revert migration %v: %v", migration.Version, err)
+		}
+
+		logrus.Infof("Migration %v reverted: %v", migration.Version, migration.Description)
+		reverted = append(reverted, migration)
+	}
+
+	return reverted, nil
+}
+
+// runMigration runs a migration step and records it in a single transaction, where the dialect allows transactional ddl
+func runMigration(db *gorm.DB, step, record func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := step(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// dropColumnUnlessSQLite drops a column added by a migration when reverting it. The column is kept on sqlite, which has
+// no DROP COLUMN before its version 3.35, since a column no model uses is harmless
+func dropColumnUnlessSQLite(tx *gorm.DB, table, column string) error {
+	if tx.Dialect().GetName() == SQLite {
+		return nil
+	}
+
+	return tx.Table(table).DropColumn(column).Error
+}
+
+// Snapshots of the models as created by the migrations, so later changes to the models do not change what an applied
+// migration did
+
+type userCredentialV1 struct {
+	ID              string `gorm:"primary_key;not null;"`
+	Username        string `gorm:"unique_index;not null;"`
+	Email           string `gorm:"unique_index;not null;"`
+	Password        string `gorm:"not null;"`
+	Salt            string `gorm:"not null;"`
+	EmailValidated  bool   `gorm:"not null;"`
+	TOTPSecret      string
+	TOTPEnabled     bool
+	TOTPLastStep    int64
+	Status          string `gorm:"not null;default:'active'"`
+	StatusReason    string
+	StatusChangedAt *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+func (userCredentialV1) TableName() string { return "user_credentials" }
+
+type webAuthnCredentialV1 struct {
+	ID               string `gorm:"primary_key;not null;"`
+	UserCredentialID string `gorm:"index;not null;"`
+	CredentialID     string `gorm:"unique_index;not null;"`
+	PublicKey        []byte `gorm:"not null;"`
+	SignCount        uint32 `gorm:"not null;"`
+	Name             string `gorm:"not null;"`
+	LastUsedAt       *time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func (webAuthnCredentialV1) TableName() string { return "web_authn_credentials" }
+
+type recoveryCodeV1 struct {
+	ID               string `gorm:"primary_key;not null;"`
+	UserCredentialID string `gorm:"index;not null;"`
+	Hash             string `gorm:"not null;"`
+	Salt             string `gorm:"not null;"`
+	UsedAt           *time.Time
+	CreatedAt        time.Time
+}
+
+func (recoveryCodeV1) TableName() string { return "recovery_codes" }
+
+type loginThrottleV1 struct {
+	ThrottleKey   string `gorm:"primary_key;not null;"`
+	Failures      int    `gorm:"not null;"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+	UpdatedAt     time.Time
+}
+
+func (loginThrottleV1) TableName() string { return "login_throttles" }
+
+type userIdentityV3 struct {
+	ID               string `gorm:"primary_key;not null;"`
+	UserCredentialID string `gorm:"index;not null;"`
+	Provider         string `gorm:"unique_index:idx_user_identities_provider_subject;not null;"`
+	Subject          string `gorm:"unique_index:idx_user_identities_provider_subject;not null;"`
+	Email            string
+	CreatedAt        time.Time
+}
+
+func (userIdentityV3) TableName() string { return "user_identities" }
+
+type usedTokenV4 struct {
+	JTI       string    `gorm:"primary_key;not null;"`
+	ExpiresAt time.Time `gorm:"index;not null;"`
+	CreatedAt time.Time
+}
+
+func (usedTokenV4) TableName() string { return "used_tokens" }
+
+// userCredentialV5 only holds the column added by the migration 5, which auto migrating adds to the existing table
+type userCredentialV5 struct {
+	SecurityStamp string
+}
+
+func (userCredentialV5) TableName() string { return "user_credentials" }
+
+type userAttributeV6 struct {
+	UserCredentialID string `gorm:"primary_key;not null;"`
+	Name             string `gorm:"primary_key;not null;"`
+	Value            string `gorm:"type:text;not null;"`
+	UpdatedAt        time.Time
+}
+
+func (userAttributeV6) TableName() string { return "user_attributes" }
+
+type groupV7 struct {
+	ID          string `gorm:"primary_key;not null;"`
+	Kind        string `gorm:"unique_index:idx_user_groups_kind_name;not null;"`
+	Name        string `gorm:"unique_index:idx_user_groups_kind_name;not null;"`
+	Description string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (groupV7) TableName() string { return "user_groups" }
+
+type groupMemberV7 struct {
+	GroupID          string `gorm:"primary_key;not null;"`
+	UserCredentialID string `gorm:"primary_key;not null;index;"`
+	CreatedAt        time.Time
+}
+
+func (groupMemberV7) TableName() string { return "user_group_members" }
+
+// userCredentialV8 only holds the column added by the migration 8, which auto migrating adds to the existing table
+type userCredentialV8 struct {
+	Realm string `gorm:"not null;default:''"`
+}
+
+func (userCredentialV8) TableName() string { return "user_credentials" }
+
+type userIdentityV8 struct {
+	Realm string `gorm:"not null;default:''"`
+}
+
+func (userIdentityV8) TableName() string { return "user_identities" }
+
+type groupV8 struct {
+	Realm string `gorm:"not null;default:''"`
+}
+
+func (groupV8) TableName() string { return "user_groups" }
+
+// realmIndexV8 is a unique index the migration 8 replaces by one holding within a realm
+type realmIndexV8 struct {
+	table   string
+	former  string
+	name    string
+	columns []string // of the former index, which the new one prefixes with the realm
+}
+
+var realmIndexesV8 = []realmIndexV8{
+	{table: "user_credentials", former: "uix_user_credentials_username", name: "idx_user_credentials_realm_username", columns: []string{"username"}},
+	{table: "user_credentials", former: "uix_user_credentials_email", name: "idx_user_credentials_realm_email", columns: []string{"email"}},
+	{table: "user_identities", former: "idx_user_identities_provider_subject", name: "idx_user_identities_realm_provider_subject", columns: []string{"provider", "subject"}},
+	{table: "user_groups", former: "idx_user_groups_kind_name", name: "idx_user_groups_realm_kind_name", columns: []string{"kind", "name"}},
+}
+
+// userCredentialV9 only holds the column added by the migration 9, which auto migrating adds to the existing table
+type userCredentialV9 struct {
+	Pending bool `gorm:"not null;default:false"`
+}
+
+func (userCredentialV9) TableName() string { return "user_credentials" }
+
+// foldedIndexV10 is a unique index of the users the migration 10 makes compare its column regardless of case
+type foldedIndexV10 struct {
+	name   string
+	column string
+}
+
+var foldedIndexesV10 = []foldedIndexV10{
+	{name: "idx_user_credentials_realm_username", column: "username"},
+	{name: "idx_user_credentials_realm_email", column: "email"},
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// RecoveryCode holds a hashed single use code that replaces the second factor of a login
+type RecoveryCode struct {
+	ID               string `gorm:"primary_key;not null;"`
+	UserCredentialID string `gorm:"index;not null;"`
+	Hash             string `gorm:"not null;"`
+	Salt             string `gorm:"not null;"` // only filled for legacy hmac-sha512 hashes
+	UsedAt           *time.Time
+	CreatedAt        time.Time
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (code *RecoveryCode) BeforeCreate(scope *gorm.Scope) error {
+	return scope.SetColumn("ID", uuid.New().String())
+}
+
+// RecoveryCodesDAO defines the methods that can be performed over recovery codes
+type RecoveryCodesDAO interface {
+	Init(keyring *misc.Keyring, hasher misc.PasswordHasher, db *gorm.DB) RecoveryCodesDAO
+	GenerateRecoveryCodes(userCredentialID string) ([]string, error)
+	ConsumeRecoveryCode(userCredentialID, code string) (int, error)
+	CountRecoveryCodes(userCredentialID string) (int, error)
+	RekeyRecoveryCodes() (rekeyed, pending int, err error)
+}
+
+// DefaultRecoveryCodesDAO a default RecoveryCodesDAO interface implementation
+type DefaultRecoveryCodesDAO struct {
+	db      *gorm.DB
+	keyring *misc.Keyring
+	hasher  misc.PasswordHasher
+}
+
+// Init initializes a default recovery codes DAO
+func (dao *DefaultRecoveryCodesDAO) Init(keyring *misc.Keyring, hasher misc.PasswordHasher, db *gorm.DB) RecoveryCodesDAO {
+	dao.keyring = keyring
+	dao.hasher = hasher
+	dao.db = db
+
+	return dao
+}
+
+// GenerateRecoveryCodes replaces all the recovery codes of a user, returning the new codes in plain text
+func (dao *DefaultRecoveryCodesDAO) GenerateRecoveryCodes(userCredentialID string) ([]string, error) {
+	codes := make([]string, 0, misc.RecoveryCodeCount)
+
+	tx := dao.db.Begin()
+	if err := tx.Where("user_credential_id = ?", userCredentialID).Delete(&RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for i := 0; i < misc.RecoveryCodeCount; i++ {
+		code, err := misc.GenerateRecoveryCode()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		hash, err := dao.hasher.Hash(misc.NormalizeRecoveryCode(code))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		recoveryCode := RecoveryCode{
+			UserCredentialID: userCredentialID,
+			Hash:             hash,
+		}
+
+		if err := tx.Create(&recoveryCode).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, tx.Commit().Error
+}
+
+// ConsumeRecoveryCode marks a matching unused code as used, returning how many unused codes remain
+func (dao *DefaultRecoveryCodesDAO) ConsumeRecoveryCode(userCredentialID, code string) (int, error) {
+	var recoveryCodes []RecoveryCode
+	if err := dao.db.Where("user_credential_id = ? AND used_at IS NULL", userCredentialID).Find(&recoveryCodes).Error; err != nil {
+		return 0, err
+	}
+
+	normalized := misc.NormalizeRecoveryCode(code)
+	for _, recoveryCode := range recoveryCodes {
+		if ok, _ := dao.hasher.Verify(normalized, misc.EncodeLegacyPasswordHash(recoveryCode.Hash, recoveryCode.Salt)); !ok {
+			continue
+		}
+
+		now := time.Now()
+		res := dao.db.Model(&RecoveryCode{}).Where("id = ? AND used_at IS NULL", recoveryCode.ID).Update("used_at", &now)
+		if res.Error != nil {
+			return 0, res.Error
+		}
+
+		if res.RowsAffected == 0 { // consumed concurrently
+			break
+		}
+
+		return len(recoveryCodes) - 1, nil
+	}
+
+	return 0, fmt.Errorf("invalid recovery code")
+}
+
+// CountRecoveryCodes counts the unused recovery codes of a user
+func (dao *DefaultRecoveryCodesDAO) CountRecoveryCodes(userCredentialID string) (count int, err error) {
+	err = dao.db.Model(&RecoveryCode{}).Where("user_credential_id = ? AND used_at IS NULL", userCredentialID).Count(&count).Error
+	return
+}
+
+// RekeyRecoveryCodes moves the hashes of the unused recovery codes to the active key.
+// The legacy hashes that need the code to be moved are counted as pending
+func (dao *DefaultRecoveryCodesDAO) RekeyRecoveryCodes() (rekeyed, pending int, err error) {
+	var recoveryCodes []RecoveryCode
+	if err := dao.db.Where("used_at IS NULL").Find(&recoveryCodes).Error; err != nil {
+		return 0, 0, err
+	}
+
+	for _, recoveryCode := range recoveryCodes {
+		encoded := misc.EncodeLegacyPasswordHash(recoveryCode.Hash, recoveryCode.Salt)
+
+		hash, err := misc.RekeyPasswordHash(dao.keyring, encoded)
+		if err == misc.ErrRekeyNeedsPassword {
+			pending++
+			continue
+		} else if err != nil {
+			return rekeyed, pending, err
+		}
+
+		if hash == recoveryCode.Hash {
+			continue
+		}
+
+		err = dao.db.Model(&RecoveryCode{}).Where("id = ?", recoveryCode.ID).Updates(map[string]interface{}{"hash": hash, "salt": ""}).Error
+		if err != nil {
+			return rekeyed, pending, err
+		}
+		rekeyed++
+	}
+
+	return rekeyed, pending, nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// StartTOTPEnrollment generates and stores a new totp secret for the user, which stays inactive until confirmed
+func (dao *DefaultUserCredentialsDAO) StartTOTPEnrollment(username string) (string, error) {
+	userCredential, err := dao.GetUserCredential(username)
+	if err != nil {
+		return "", err
+	}
+
+	if userCredential.TOTPEnabled {
+		return "", fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	secret, err := misc.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := dao.keyring.Encrypt(secret)
+	if err != nil {
+		return "", err
+	}
+
+	userCredential.TOTPSecret = encrypted
+	userCredential.TOTPLastStep = 0
+
+	return secret, dao.db.Save(userCredential).Error
+}
+
+// ConfirmTOTPEnrollment activates a pending totp secret once the user proves to own it
+func (dao *DefaultUserCredentialsDAO) ConfirmTOTPEnrollment(username, code string) error {
+	userCredential, err := dao.GetUserCredential(username)
+	if err != nil {
+		return err
+	}
+
+	if userCredential.TOTPEnabled {
+		return fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	if err := dao.verifyTOTPCode(&userCredential, code); err != nil {
+		return err
+	}
+
+	userCredential.TOTPEnabled = true
+
+	return dao.db.Save(userCredential).Error
+}
+
+// DisableTOTP removes the totp secret of the user after verifying a current code
+func (dao *DefaultUserCredentialsDAO) DisableTOTP(username, code string) error {
+	userCredential, err := dao.GetUserCredential(username)
+	if err != nil {
+		return err
+	}
+
+	if !userCredential.TOTPEnabled {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	if err := dao.verifyTOTPCode(&userCredential, code); err != nil {
+		return err
+	}
+
+	userCredential.TOTPEnabled = false
+	userCredential.TOTPSecret = ""
+	userCredential.TOTPLastStep = 0
+
+	return dao.db.Save(userCredential).Error
+}
+
+// CheckTOTPCode verifies a code as the second factor of a login
+func (dao *DefaultUserCredentialsDAO) CheckTOTPCode(username, code string) error {
+	userCredential, err := dao.GetUserCredential(username)
+	if err != nil {
+		return err
+	}
+
+	if !userCredential.TOTPEnabled {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	if err := dao.verifyTOTPCode(&userCredential, code); err != nil {
+		return err
+	}
+
+	return dao.db.Save(userCredential).Error
+}
+
+// verifyTOTPCode checks the code against the stored secret and records its time step so it cannot be replayed
+func (dao *DefaultUserCredentialsDAO) verifyTOTPCode(userCredential *UserCredential, code string) error {
+	if userCredential.TOTPSecret == "" {
+		return fmt.Errorf("no two-factor authentication secret found")
+	}
+
+	secret, err := dao.keyring.Decrypt(userCredential.TOTPSecret)
+	if err != nil {
+		return err
+	}
+
+	step, err := misc.ValidateTOTPCode(secret, code, time.Now(), userCredential.TOTPLastStep)
+	if err != nil {
+		return err
+	}
+
+	userCredential.TOTPLastStep = step
+
+	return nil
+}
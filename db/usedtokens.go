@@ -0,0 +1,70 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrTokenUsed is returned when a single use token is spent again
+var ErrTokenUsed = errors.New("token already used")
+
+// UsedToken records the id of a single use token already spent, kept until the token would expire anyway
+type UsedToken struct {
+	JTI       string    `gorm:"primary_key;not null;"`
+	ExpiresAt time.Time `gorm:"index;not null;"`
+	CreatedAt time.Time
+}
+
+// UsedTokensDAO defines the methods that can be performed over the single use tokens already spent
+type UsedTokensDAO interface {
+	Init(db *gorm.DB) UsedTokensDAO
+	UseToken(jti string, expiresAt time.Time) error
+	IsTokenUsed(jti string) (bool, error)
+}
+
+// DefaultUsedTokensDAO a default UsedTokensDAO interface implementation
+type DefaultUsedTokensDAO struct {
+	db *gorm.DB
+}
+
+// Init initializes a default used tokens DAO
+func (dao *DefaultUsedTokensDAO) Init(db *gorm.DB) UsedTokensDAO {
+	dao.db = db
+
+	return dao
+}
+
+// UseToken spends a single use token, failing with ErrTokenUsed if it was already spent.
+// The tokens that expired are forgotten on the way, since they are refused anyway
+func (dao *DefaultUsedTokensDAO) UseToken(jti string, expiresAt time.Time) error {
+	if err := dao.db.Where("expires_at < ?", time.Now()).Delete(&UsedToken{}).Error; err != nil {
+		return err
+	}
+
+	var count int
+	if err := dao.db.Model(&UsedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrTokenUsed
+	}
+
+	if err := dao.db.Create(&UsedToken{JTI: jti, ExpiresAt: expiresAt}).Error; err != nil {
+		if dao.db.Model(&UsedToken{}).Where("jti = ?", jti).Count(&count).Error == nil && count > 0 { // spent concurrently
+			return ErrTokenUsed
+		}
+		return err
+	}
+
+	return nil
+}
+
+// IsTokenUsed tells whether a single use token was already spent, without spending it
+func (dao *DefaultUsedTokensDAO) IsTokenUsed(jti string) (bool, error) {
+	var count int
+	err := dao.db.Model(&UsedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// UserAttribute holds the value of a profile attribute of a user, as defined by the profile schema
+type UserAttribute struct {
+	UserCredentialID string `gorm:"primary_key;not null;"`
+	Name             string `gorm:"primary_key;not null;"`
+	Value            string `gorm:"type:text;not null;"`
+	UpdatedAt        time.Time
+}
+
+// UserAttributesDAO defines the methods that can be performed over the profile attributes of the users
+type UserAttributesDAO interface {
+	Init(db *gorm.DB) UserAttributesDAO
+	GetUserAttributes(userCredentialID string) (map[string]string, error)
+	SetUserAttributes(userCredentialID string, values map[string]string) error
+}
+
+// DefaultUserAttributesDAO a default UserAttributesDAO interface implementation
+type DefaultUserAttributesDAO struct {
+	db *gorm.DB
+}
+
+// Init initializes a default user attributes DAO
+func (dao *DefaultUserAttributesDAO) Init(db *gorm.DB) UserAttributesDAO {
+	dao.db = db
+
+	return dao
+}
+
+// GetUserAttributes gets the profile attributes of a user by their names
+func (dao *DefaultUserAttributesDAO) GetUserAttributes(userCredentialID string) (map[string]string, error) {
+	var attributes []UserAttribute
+	if err := dao.db.Where("user_credential_id = ?", userCredentialID).Find(&attributes).Error; err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(attributes))
+	for _, attribute := range attributes {
+		values[attribute.Name] = attribute.Value
+	}
+
+	return values, nil
+}
+
+// SetUserAttributes sets the given profile attributes of a user, leaving the others as they are. An empty value
+// removes the attribute
+func (dao *DefaultUserAttributesDAO) SetUserAttributes(userCredentialID string, values map[string]string) error {
+	tx := dao.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	for name, value := range values {
+		err := tx.Where("user_credential_id = ? AND name = ?", userCredentialID, name).Delete(&UserAttribute{}).Error
+		if err == nil && value != "" {
+			err = tx.Create(&UserAttribute{UserCredentialID: userCredentialID, Name: name, Value: value}).Error
+		}
+
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
@@ -1,6 +1,10 @@
 package db
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/labbsr0x/whisper/mail"
 	"net/http"
 	"time"
@@ -10,88 +14,175 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/sirupsen/logrus"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
 )
 
+// Account statuses of a user credential. Only active accounts can sign in
+const (
+	UserStatusActive          = "active"
+	UserStatusSuspended       = "suspended"
+	UserStatusLocked          = "locked"
+	UserStatusPendingDeletion = "pending-deletion"
+)
+
+// UserStatuses lists the valid account statuses
+var UserStatuses = []string{UserStatusActive, UserStatusSuspended, UserStatusLocked, UserStatusPendingDeletion}
+
 // UserCredential holds the information from a user credential
 type UserCredential struct {
-	ID             string `gorm:"primary_key;not null;"`
-	Username       string `gorm:"unique_index;not null;"`
-	Email          string `gorm:"unique_index;not null;"`
-	Password       string `gorm:"not null;"`
-	Salt           string `gorm:"not null;"`
-	EmailValidated bool   `gorm:"not null;"`
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
+	ID              string `gorm:"primary_key;not null;"`
+	Realm           string `gorm:"unique_index:idx_user_credentials_realm_username,idx_user_credentials_realm_email;not null;default:''"`
+	Username        string `gorm:"unique_index:idx_user_credentials_realm_username;not null;"`
+	Email           string `gorm:"unique_index:idx_user_credentials_realm_email;not null;"`
+	Password        string `gorm:"not null;"`
+	Salt            string `gorm:"not null;"` // only filled for legacy hmac-sha512 hashes
+	EmailValidated  bool   `gorm:"not null;"`
+	Pending         bool   `gorm:"not null;default:false"` // registered and never confirmed its email, so it expires
+	TOTPSecret      string
+	TOTPEnabled     bool
+	TOTPLastStep    int64
+	Status          string `gorm:"not null;default:'active'"`
+	StatusReason    string
+	StatusChangedAt *time.Time
+	SecurityStamp   string // changes along with the password, email or username, voiding the tokens mailed before
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// EncodedPassword gets the self-describing password hash, wrapping the legacy hashes stored apart from their salt
+func (user *UserCredential) EncodedPassword() string {
+	return misc.EncodeLegacyPasswordHash(user.Password, user.Salt)
+}
+
+// IsActive tells whether the account may sign in
+func (user *UserCredential) IsActive() bool {
+	return user.Status == UserStatusActive
 }
 
 // BeforeCreate will set a UUID rather than numeric ID.
 func (user *UserCredential) BeforeCreate(scope *gorm.Scope) error {
+	if user.Status == "" {
+		if err := scope.SetColumn("Status", UserStatusActive); err != nil {
+			return err
+		}
+	}
+
+	if user.SecurityStamp == "" {
+		if err := scope.SetColumn("SecurityStamp", uuid.New().String()); err != nil {
+			return err
+		}
+	}
+
 	return scope.SetColumn("ID", uuid.New().String())
 }
 
 // UserCredentialsDAO defines the methods that can be performed
 type UserCredentialsDAO interface {
-	Init(secretKey, baseUIPath, publicAddressURL string, outbox chan<- mail.Mail, db *gorm.DB) UserCredentialsDAO
+	Init(keyring *misc.Keyring, hasher misc.PasswordHasher, hardened bool, baseUIPath, publicAddressURL, realm string, outbox chan<- mail.Mail, identityStore IdentityStore, db *gorm.DB) UserCredentialsDAO
 	CreateUserCredential(username, password, email string) (string, error)
 	UpdateUserCredential(username, email, password string) error
 	GetUserCredential(username string) (UserCredential, error)
+	GetUserCredentialByID(id string) (UserCredential, error)
 	GetUserCredentialByEmail(email string) (UserCredential, error)
 	CheckCredentials(username, password string) UserCredential
 	ValidateUserCredentialEmail(username string) error
+	StartTOTPEnrollment(username string) (string, error)
+	ConfirmTOTPEnrollment(username, code string) error
+	DisableTOTP(username, code string) error
+	CheckTOTPCode(username, code string) error
+	RekeyUserCredentials() (rekeyed, pending int, err error)
+	ListUserCredentials(search string, offset, limit int) ([]UserCredential, int, error)
+	UpdateUserCredentialIdentity(id, username, email string) error
+	SetUserCredentialStatus(id, status, reason string) error
+	DeleteUserCredential(id string) error
+	IsReadOnly() bool
+	CheckIdentity(username string) error
+	CreateFederatedUserCredential(provider, subject, email, preferredUsername string) (UserCredential, error)
+	ExpirePendingUserCredentials(createdBefore time.Time) ([]UserCredential, error)
 }
 
-// DefaultUserCredentialsDAO a default UserCredentialsDAO interface implementation
+// DefaultUserCredentialsDAO a default UserCredentialsDAO interface implementation, only reaching the users of its realm
 type DefaultUserCredentialsDAO struct {
 	db               *gorm.DB
 	outbox           chan<- mail.Mail
-	secretKey        string
+	identityStore    IdentityStore
+	keyring          *misc.Keyring
+	hasher           misc.PasswordHasher
+	hardened         bool
+	dummyHash        string
+	dummyHashOnce    sync.Once
 	baseUIPath       string
 	publicAddressURL string
+	realm            string
 }
 
 // InitFromWebBuilder initializes a default user credentials DAO from web builder
-func (dao *DefaultUserCredentialsDAO) Init(secretKey, baseUIPath, publicAddressURL string, outbox chan<- mail.Mail, db *gorm.DB) UserCredentialsDAO {
-	dao.secretKey = secretKey
+func (dao *DefaultUserCredentialsDAO) Init(keyring *misc.Keyring, hasher misc.PasswordHasher, hardened bool, baseUIPath, publicAddressURL, realm string, outbox chan<- mail.Mail, identityStore IdentityStore, db *gorm.DB) UserCredentialsDAO {
+	dao.keyring = keyring
+	dao.hasher = hasher
+	dao.hardened = hardened
 	dao.outbox = outbox
+	dao.identityStore = identityStore
 	dao.db = db
 	dao.baseUIPath = baseUIPath
 	dao.publicAddressURL = publicAddressURL
-
-	err := dao.db.AutoMigrate(&UserCredential{}).Error
-	gohtypes.PanicIfError("Not possible to migrate db", http.StatusInternalServerError, err)
+	dao.realm = realm
 
 	return dao
 }
 
+// getDummyHash gets the hash verified in place of the hash of unknown users, so they take as long to be refused as the
+// known ones. It is hashed by the configured hasher on the first unknown user, then kept
+func (dao *DefaultUserCredentialsDAO) getDummyHash() string {
+	dao.dummyHashOnce.Do(func() {
+		var err error
+		dao.dummyHash, err = dao.hasher.Hash(misc.GenerateSalt())
+		if err != nil {
+			logrus.Errorf("Unable to hash the dummy password: %v", err)
+		}
+	})
+
+	return dao.dummyHash
+}
+
 // CreateUserCredential creates a user
 func (dao *DefaultUserCredentialsDAO) CreateUserCredential(username, password, email string) (string, error) {
+	RefuseIfReadOnly(dao)
+
+	if err := misc.CheckUsername(username); err != nil {
+		gohtypes.Panic(err.Error(), http.StatusBadRequest)
+	}
+
 	var users []UserCredential
 
-	if res := dao.db.Model(&UserCredential{}).Where("username = ?", username).Or("email = ?", email).Find(&users); res.Error != nil {
+	if res := dao.users().Where(equalFold(dao.db, "username")+" OR "+equalFold(dao.db, "email"), strings.ToLower(username), strings.ToLower(email)).Find(&users); res.Error != nil {
 		return "", res.Error
 	}
 
 	for _, user := range users {
-		if user.Username == username {
+		if strings.EqualFold(user.Username, username) {
 			gohtypes.Panic("Username already taken", http.StatusConflict)
 		}
 
-		if user.Email == email {
+		if strings.EqualFold(user.Email, email) {
 			gohtypes.Panic("Email already taken", http.StatusConflict)
 		}
 	}
 
-	salt := misc.GenerateSalt()
-	hPassword := misc.GetEncryptedPassword(dao.secretKey, password, salt)
+	hPassword, err := dao.hasher.Hash(password)
+	if err != nil {
+		return "", err
+	}
+
 	userCredential := UserCredential{
+		Realm:          dao.realm,
 		Username:       username,
 		Password:       hPassword,
 		Email:          email,
-		Salt:           salt,
 		EmailValidated: false,
+		Pending:        true,
 	}
 
 	if res := dao.db.Create(&userCredential); res.Error != nil {
@@ -108,60 +199,337 @@ func (dao *DefaultUserCredentialsDAO) ValidateUserCredentialEmail(username strin
 	}
 
 	userCredential.EmailValidated = true
+	userCredential.Pending = false
 
 	return dao.db.Save(userCredential).Error
 }
 
 // UpdateUserCredential updates a user
 func (dao *DefaultUserCredentialsDAO) UpdateUserCredential(username, email, password string) error {
+	RefuseIfReadOnly(dao)
+
 	userCredential := UserCredential{}
 
-	err := dao.db.Where("username = ?", username).First(&userCredential).Error
+	err := dao.users().Where(equalFold(dao.db, "username"), strings.ToLower(username)).First(&userCredential).Error
 	gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
 
-	if hNewPassword := misc.GetEncryptedPassword(dao.secretKey, password, userCredential.Salt); hNewPassword != userCredential.Password {
-		salt := misc.GenerateSalt()
-		hPassword := misc.GetEncryptedPassword(dao.secretKey, password, salt)
+	if same, _ := dao.hasher.Verify(password, userCredential.EncodedPassword()); !same {
+		hPassword, err := dao.hasher.Hash(password)
+		gohtypes.PanicIfError("Unable to hash password", http.StatusInternalServerError, err)
 
 		userCredential.Password = hPassword
-		userCredential.Salt = salt
+		userCredential.Salt = ""
+		userCredential.SecurityStamp = uuid.New().String()
 	}
 
 	if email != userCredential.Email {
 		userCredential.Email = email
 		userCredential.EmailValidated = false
+		userCredential.SecurityStamp = uuid.New().String()
 
-		dao.outbox <- mail.GetEmailConfirmationMail(dao.baseUIPath, dao.secretKey, dao.publicAddressURL, username, email, "")
+		dao.outbox <- mail.GetEmailConfirmationMail(dao.baseUIPath, dao.keyring, dao.publicAddressURL, username, email, userCredential.SecurityStamp, "")
 	}
 
 	return dao.db.Save(userCredential).Error
 }
 
-// GetUserCredential gets an user credential by its username
+// GetUserCredential gets an user credential by its username, whatever its case
 func (dao *DefaultUserCredentialsDAO) GetUserCredential(username string) (userCredential UserCredential, err error) {
-	err = dao.db.Where("username = ?", username).First(&userCredential).Error
+	err = dao.users().Where(equalFold(dao.db, "username"), strings.ToLower(username)).First(&userCredential).Error
+	return
+}
+
+// GetUserCredentialByID gets an user credential by its id
+func (dao *DefaultUserCredentialsDAO) GetUserCredentialByID(id string) (userCredential UserCredential, err error) {
+	err = dao.users().Where("id = ?", id).First(&userCredential).Error
 	return
 }
 
-// GetUserCredential gets an user credential by its email
+// GetUserCredentialByEmail gets an user credential by its email, whatever its case
 func (dao *DefaultUserCredentialsDAO) GetUserCredentialByEmail(email string) (userCredential UserCredential, err error) {
-	err = dao.db.Where("email = ?", email).First(&userCredential).Error
+	err = dao.users().Where(equalFold(dao.db, "email"), strings.ToLower(email)).First(&userCredential).Error
 	return
 }
 
-// CheckCredentials verifies if the informed credentials are valid
+// CheckCredentials verifies if the informed credentials are valid.
+// In hardened mode, unknown users and incorrect passwords are refused alike
 func (dao *DefaultUserCredentialsDAO) CheckCredentials(username, password string) UserCredential {
+	if dao.identityStore != nil {
+		return dao.checkStoreCredentials(username, password)
+	}
+
 	userCredential, err := dao.GetUserCredential(username)
 
+	if dao.hardened && gorm.IsRecordNotFoundError(err) {
+		_, _ = dao.hasher.Verify(password, dao.getDummyHash())
+		gohtypes.Panic("Incorrect username or password", http.StatusUnauthorized)
+	}
+
 	if err != nil {
 		gohtypes.PanicIfError("Unable to authenticate user", http.StatusInternalServerError, err)
 	}
 
-	hPassword := misc.GetEncryptedPassword(dao.secretKey, password, userCredential.Salt)
+	encoded := userCredential.EncodedPassword()
+	ok, err := dao.hasher.Verify(password, encoded)
+	if ok && !userCredential.IsActive() { // only told to whom knows the password
+		gohtypes.Panic(GetUserStatusMessage(userCredential.Status), http.StatusForbidden)
+	}
+
+	if !ok {
+		if err != nil {
+			logrus.Errorf("Unable to verify the password of '%v': %v", username, err)
+		}
 
-	if hPassword != userCredential.Password {
+		if dao.hardened {
+			gohtypes.Panic("Incorrect username or password", http.StatusUnauthorized)
+		}
 		gohtypes.Panic("Incorrect password", http.StatusUnauthorized)
 	}
 
+	if dao.hasher.NeedsRehash(encoded) {
+		dao.rehashPassword(&userCredential, password)
+	}
+
 	return userCredential
 }
+
+// rehashPassword upgrades the stored hash of a just verified password to the preferred hasher.
+// A failure here does not fail the login, the upgrade is tried again on the next one
+func (dao *DefaultUserCredentialsDAO) rehashPassword(userCredential *UserCredential, password string) {
+	hPassword, err := dao.hasher.Hash(password)
+	if err != nil {
+		logrus.Errorf("Unable to rehash the password of '%v': %v", userCredential.Username, err)
+		return
+	}
+
+	err = dao.db.Model(&UserCredential{}).Where("id = ? AND password = ?", userCredential.ID, userCredential.Password).
+		Updates(map[string]interface{}{"password": hPassword, "salt": ""}).Error
+	if err != nil {
+		logrus.Errorf("Unable to store the rehashed password of '%v': %v", userCredential.Username, err)
+		return
+	}
+
+	logrus.Debugf("Password of '%v' rehashed", userCredential.Username)
+	userCredential.Password = hPassword
+	userCredential.Salt = ""
+}
+
+// RekeyUserCredentials moves the password hashes and totp secrets of every user to the active key.
+// The legacy password hashes that need the password to be moved are counted as pending
+func (dao *DefaultUserCredentialsDAO) RekeyUserCredentials() (rekeyed, pending int, err error) {
+	var userCredentials []UserCredential
+	if err := dao.db.Find(&userCredentials).Error; err != nil { // every realm, as they share the keys
+		return 0, 0, err
+	}
+
+	for _, userCredential := range userCredentials {
+		updates := map[string]interface{}{}
+
+		if password, err := misc.RekeyPasswordHash(dao.keyring, userCredential.EncodedPassword()); err == misc.ErrRekeyNeedsPassword {
+			pending++
+		} else if err != nil {
+			return rekeyed, pending, fmt.Errorf("unable to rekey the password of '%v': %v", userCredential.Username, err)
+		} else if password != userCredential.Password {
+			updates["password"] = password
+			updates["salt"] = ""
+		}
+
+		if userCredential.TOTPSecret != "" && dao.keyring.NeedsReencrypt(userCredential.TOTPSecret) {
+			secret, err := dao.keyring.Decrypt(userCredential.TOTPSecret)
+			if err != nil {
+				return rekeyed, pending, fmt.Errorf("unable to rekey the totp secret of '%v': %v", userCredential.Username, err)
+			}
+
+			if updates["totp_secret"], err = dao.keyring.Encrypt(secret); err != nil {
+				return rekeyed, pending, err
+			}
+		}
+
+		if len(updates) == 0 {
+			continue
+		}
+
+		if err := dao.db.Model(&UserCredential{}).Where("id = ?", userCredential.ID).Updates(updates).Error; err != nil {
+			return rekeyed, pending, err
+		}
+		rekeyed++
+	}
+
+	return rekeyed, pending, nil
+}
+
+// ListUserCredentials lists a page of the users whose username or email contain the search, along with how many there are
+func (dao *DefaultUserCredentialsDAO) ListUserCredentials(search string, offset, limit int) ([]UserCredential, int, error) {
+	var userCredentials []UserCredential
+	var total int
+
+	query := dao.users()
+	if search != "" {
+		pattern := "%" + escapeLike(strings.ToLower(search)) + "%"
+		query = query.Where("LOWER(username) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!'", pattern, pattern)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("username").Offset(offset).Limit(limit).Find(&userCredentials).Error
+	return userCredentials, total, err
+}
+
+// UpdateUserCredentialIdentity changes the username and email of a user. The email is taken as verified
+func (dao *DefaultUserCredentialsDAO) UpdateUserCredentialIdentity(id, username, email string) error {
+	RefuseIfReadOnly(dao)
+
+	if err := misc.CheckUsername(username); err != nil {
+		gohtypes.Panic(err.Error(), http.StatusBadRequest)
+	}
+
+	var users []UserCredential
+
+	if res := dao.users().Where("id <> ?", id).Where(equalFold(dao.db, "username")+" OR "+equalFold(dao.db, "email"), strings.ToLower(username), strings.ToLower(email)).Find(&users); res.Error != nil {
+		return res.Error
+	}
+
+	for _, user := range users {
+		if strings.EqualFold(user.Username, username) {
+			gohtypes.Panic("Username already taken", http.StatusConflict)
+		}
+
+		if strings.EqualFold(user.Email, email) {
+			gohtypes.Panic("Email already taken", http.StatusConflict)
+		}
+	}
+
+	userCredential, err := dao.GetUserCredentialByID(id)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"username": username, "email": email, "email_validated": true, "pending": false}
+	if username != userCredential.Username || email != userCredential.Email {
+		updates["security_stamp"] = uuid.New().String()
+	}
+
+	res := dao.users().Where("id = ?", id).Updates(updates)
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return res.Error
+}
+
+// SetUserCredentialStatus changes the account status of a user, recording why and when
+func (dao *DefaultUserCredentialsDAO) SetUserCredentialStatus(id, status, reason string) error {
+	if !IsValidUserStatus(status) {
+		return fmt.Errorf("invalid status '%v'", status)
+	}
+
+	res := dao.users().Where("id = ?", id).
+		Updates(map[string]interface{}{"status": status, "status_reason": reason, "status_changed_at": time.Now()})
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return res.Error
+}
+
+// DeleteUserCredential deletes a user along with its second factors and linked identities. Nothing is deleted unless
+// the user belongs to the realm
+func (dao *DefaultUserCredentialsDAO) DeleteUserCredential(id string) error {
+	tx := dao.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := tx.Where("user_credential_id = ?", id).Delete(&WebAuthnCredential{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("user_credential_id = ?", id).Delete(&RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("user_credential_id = ?", id).Delete(&UserIdentity{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("user_credential_id = ?", id).Delete(&UserAttribute{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("user_credential_id = ?", id).Delete(&GroupMember{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	res := tx.Where("id = ? AND realm = ?", id, dao.realm).Delete(&UserCredential{})
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		tx.Rollback()
+		return gorm.ErrRecordNotFound
+	}
+
+	return tx.Commit().Error
+}
+
+// ExpirePendingUserCredentials deletes the users that registered before the given time and never confirmed their
+// email, releasing their usernames and emails. The users waiting to confirm a changed email are kept. The deleted users
+// are returned
+func (dao *DefaultUserCredentialsDAO) ExpirePendingUserCredentials(createdBefore time.Time) ([]UserCredential, error) {
+	var userCredentials []UserCredential
+	if err := dao.users().Where("pending = ? AND created_at < ?", true, createdBefore).Find(&userCredentials).Error; err != nil {
+		return nil, err
+	}
+
+	expired := make([]UserCredential, 0, len(userCredentials))
+	for _, userCredential := range userCredentials {
+		if err := dao.DeleteUserCredential(userCredential.ID); gorm.IsRecordNotFoundError(err) { // deleted concurrently
+			continue
+		} else if err != nil {
+			return expired, err
+		}
+
+		expired = append(expired, userCredential)
+	}
+
+	return expired, nil
+}
+
+// users starts a query over the users of the realm
+func (dao *DefaultUserCredentialsDAO) users() *gorm.DB {
+	return dao.db.Model(&UserCredential{}).Where("realm = ?", dao.realm)
+}
+
+// IsValidUserStatus tells whether the status is one of the known account statuses
+func IsValidUserStatus(status string) bool {
+	for _, s := range UserStatuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetUserStatusMessage gets the message shown to a user whose account can not sign in
+func GetUserStatusMessage(status string) string {
+	switch status {
+	case UserStatusSuspended:
+		return "This account is suspended"
+	case UserStatusLocked:
+		return "This account is locked"
+	case UserStatusPendingDeletion:
+		return "This account is being deleted"
+	}
+
+	return "This account is not active"
+}
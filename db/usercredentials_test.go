@@ -0,0 +1,93 @@
+package db
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestCheckCredentials(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	dao := newTestUserCredentialsDAO(t, db)
+
+	id, err := dao.CreateUserCredential("alice", "password", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user := dao.CheckCredentials("alice", "password"); user.ID != id || !user.IsActive() {
+		t.Errorf("expected the active user %v, got %+v", id, user)
+	}
+
+	expectPanic(t, http.StatusUnauthorized, func() { dao.CheckCredentials("alice", "wrong") })
+
+	if err := dao.SetUserCredentialStatus(id, UserStatusSuspended, "testing"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectPanic(t, http.StatusForbidden, func() { dao.CheckCredentials("alice", "password") })
+	expectPanic(t, http.StatusUnauthorized, func() { dao.CheckCredentials("alice", "wrong") })
+
+	if err := dao.SetUserCredentialStatus(id, "unknown", ""); err == nil {
+		t.Error("unknown statuses should be refused")
+	}
+}
+
+func TestListUserCredentials(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	dao := newTestUserCredentialsDAO(t, db)
+
+	for _, username := range []string{"alice", "bob", "carol_1", "carolx"} {
+		if _, err := dao.CreateUserCredential(username, "password", username+"@Example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users, total, err := dao.ListUserCredentials("", 1, 2)
+	if err != nil || total != 4 || len(users) != 2 || users[0].Username != "bob" {
+		t.Errorf("expected the second page of every user, got %v of %v (%v)", users, total, err)
+	}
+
+	if _, total, _ := dao.ListUserCredentials("EXAMPLE", 0, 10); total != 4 {
+		t.Errorf("the search should ignore the case, got %v users", total)
+	}
+
+	if users, total, _ := dao.ListUserCredentials("carol_", 0, 10); total != 1 || users[0].Username != "carol_1" {
+		t.Errorf("the search should not take wildcards, got %v", users)
+	}
+}
+
+func TestDeleteUserCredential(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	dao := newTestUserCredentialsDAO(t, db)
+	webAuthnDAO := new(DefaultWebAuthnCredentialsDAO).Init(db)
+
+	id, err := dao.CreateUserCredential("alice", "password", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := webAuthnDAO.CreateWebAuthnCredential(id, "credential", "key", []byte{1}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dao.DeleteUserCredential(id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dao.GetUserCredentialByID(id); !gorm.IsRecordNotFoundError(err) {
+		t.Errorf("the user should be deleted, got %v", err)
+	}
+
+	if credentials, _ := webAuthnDAO.ListWebAuthnCredentials(id); len(credentials) != 0 {
+		t.Errorf("the second factors should be deleted along, got %v", credentials)
+	}
+
+	if err := dao.DeleteUserCredential(id); !gorm.IsRecordNotFoundError(err) {
+		t.Errorf("deleting an unknown user should not be found, got %v", err)
+	}
+}
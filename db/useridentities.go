@@ -0,0 +1,94 @@
+package db
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+	"github.com/labbsr0x/goh/gohtypes"
+)
+
+// UserIdentity links a user to its subject at an upstream provider, so it signs in with that provider.
+// A subject is linked to at most one user of each realm
+type UserIdentity struct {
+	ID               string `gorm:"primary_key;not null;"`
+	Realm            string `gorm:"unique_index:idx_user_identities_realm_provider_subject;not null;default:''"`
+	UserCredentialID string `gorm:"index;not null;"`
+	Provider         string `gorm:"unique_index:idx_user_identities_realm_provider_subject;not null;"`
+	Subject          string `gorm:"unique_index:idx_user_identities_realm_provider_subject;not null;"`
+	Email            string // as shared by the provider when linked
+	CreatedAt        time.Time
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (identity *UserIdentity) BeforeCreate(scope *gorm.Scope) error {
+	return scope.SetColumn("ID", uuid.New().String())
+}
+
+// UserIdentitiesDAO defines the methods that can be performed over the identities linked to the users
+type UserIdentitiesDAO interface {
+	Init(realm string, db *gorm.DB) UserIdentitiesDAO
+	CreateUserIdentity(userCredentialID, provider, subject, email string) (string, error)
+	GetUserIdentity(provider, subject string) (UserIdentity, error)
+	ListUserIdentities(userCredentialID string) ([]UserIdentity, error)
+	DeleteUserIdentity(userCredentialID, id string) error
+}
+
+// DefaultUserIdentitiesDAO a default UserIdentitiesDAO interface implementation, only reaching the identities of its realm
+type DefaultUserIdentitiesDAO struct {
+	db    *gorm.DB
+	realm string
+}
+
+// Init initializes a default user identities DAO
+func (dao *DefaultUserIdentitiesDAO) Init(realm string, db *gorm.DB) UserIdentitiesDAO {
+	dao.db = db
+	dao.realm = realm
+
+	return dao
+}
+
+// CreateUserIdentity links a subject of a provider to a user. Linking it again to the same user does nothing
+func (dao *DefaultUserIdentitiesDAO) CreateUserIdentity(userCredentialID, provider, subject, email string) (string, error) {
+	identity, err := dao.GetUserIdentity(provider, subject)
+	if err == nil {
+		if identity.UserCredentialID != userCredentialID {
+			gohtypes.Panic("This account of the provider is already linked to another user", http.StatusConflict)
+		}
+		return identity.ID, nil
+	}
+
+	if !gorm.IsRecordNotFoundError(err) {
+		return "", err
+	}
+
+	identity = UserIdentity{Realm: dao.realm, UserCredentialID: userCredentialID, Provider: provider, Subject: subject, Email: email}
+	if res := dao.db.Create(&identity); res.Error != nil {
+		return "", res.Error
+	}
+
+	return identity.ID, nil
+}
+
+// GetUserIdentity gets the identity of a subject of a provider
+func (dao *DefaultUserIdentitiesDAO) GetUserIdentity(provider, subject string) (identity UserIdentity, err error) {
+	err = dao.db.Where("realm = ? AND provider = ? AND subject = ?", dao.realm, provider, subject).First(&identity).Error
+	return
+}
+
+// ListUserIdentities lists the identities linked to a user
+func (dao *DefaultUserIdentitiesDAO) ListUserIdentities(userCredentialID string) (identities []UserIdentity, err error) {
+	err = dao.db.Where("user_credential_id = ?", userCredentialID).Order("created_at").Find(&identities).Error
+	return
+}
+
+// DeleteUserIdentity unlinks an identity from a user
+func (dao *DefaultUserIdentitiesDAO) DeleteUserIdentity(userCredentialID, id string) error {
+	res := dao.db.Where("id = ? AND user_credential_id = ?", id, userCredentialID).Delete(&UserIdentity{})
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return res.Error
+}
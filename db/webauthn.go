@@ -0,0 +1,94 @@
+package db
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
+)
+
+// WebAuthnCredential holds a public key credential registered by a user with a WebAuthn authenticator
+type WebAuthnCredential struct {
+	ID               string `gorm:"primary_key;not null;"`
+	UserCredentialID string `gorm:"index;not null;"`
+	CredentialID     string `gorm:"unique_index;not null;"`
+	PublicKey        []byte `gorm:"not null;"`
+	SignCount        uint32 `gorm:"not null;"`
+	Name             string `gorm:"not null;"`
+	LastUsedAt       *time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// BeforeCreate will set a UUID rather than numeric ID.
+func (credential *WebAuthnCredential) BeforeCreate(scope *gorm.Scope) error {
+	return scope.SetColumn("ID", uuid.New().String())
+}
+
+// WebAuthnCredentialsDAO defines the methods that can be performed over webauthn credentials
+type WebAuthnCredentialsDAO interface {
+	Init(db *gorm.DB) WebAuthnCredentialsDAO
+	CreateWebAuthnCredential(userCredentialID, credentialID, name string, publicKey []byte, signCount uint32) (string, error)
+	ListWebAuthnCredentials(userCredentialID string) ([]WebAuthnCredential, error)
+	GetWebAuthnCredential(credentialID string) (WebAuthnCredential, error)
+	UpdateWebAuthnCredentialSignCount(credentialID string, signCount uint32) error
+	DeleteWebAuthnCredential(userCredentialID, id string) error
+}
+
+// DefaultWebAuthnCredentialsDAO a default WebAuthnCredentialsDAO interface implementation
+type DefaultWebAuthnCredentialsDAO struct {
+	db *gorm.DB
+}
+
+// Init initializes a default webauthn credentials DAO
+func (dao *DefaultWebAuthnCredentialsDAO) Init(db *gorm.DB) WebAuthnCredentialsDAO {
+	dao.db = db
+
+	return dao
+}
+
+// CreateWebAuthnCredential stores a newly registered credential
+func (dao *DefaultWebAuthnCredentialsDAO) CreateWebAuthnCredential(userCredentialID, credentialID, name string, publicKey []byte, signCount uint32) (string, error) {
+	credential := WebAuthnCredential{
+		UserCredentialID: userCredentialID,
+		CredentialID:     credentialID,
+		PublicKey:        publicKey,
+		SignCount:        signCount,
+		Name:             name,
+	}
+
+	if res := dao.db.Create(&credential); res.Error != nil {
+		return "", res.Error
+	}
+
+	return credential.ID, nil
+}
+
+// ListWebAuthnCredentials lists the credentials registered by a user
+func (dao *DefaultWebAuthnCredentialsDAO) ListWebAuthnCredentials(userCredentialID string) (credentials []WebAuthnCredential, err error) {
+	err = dao.db.Where("user_credential_id = ?", userCredentialID).Order("created_at").Find(&credentials).Error
+	return
+}
+
+// GetWebAuthnCredential gets a credential by its authenticator generated id
+func (dao *DefaultWebAuthnCredentialsDAO) GetWebAuthnCredential(credentialID string) (credential WebAuthnCredential, err error) {
+	err = dao.db.Where("credential_id = ?", credentialID).First(&credential).Error
+	return
+}
+
+// UpdateWebAuthnCredentialSignCount records the last signature counter and usage of a credential
+func (dao *DefaultWebAuthnCredentialsDAO) UpdateWebAuthnCredentialSignCount(credentialID string, signCount uint32) error {
+	now := time.Now()
+	return dao.db.Model(&WebAuthnCredential{}).Where("credential_id = ?", credentialID).
+		Updates(map[string]interface{}{"sign_count": signCount, "last_used_at": &now}).Error
+}
+
+// DeleteWebAuthnCredential removes a credential of a user
+func (dao *DefaultWebAuthnCredentialsDAO) DeleteWebAuthnCredential(userCredentialID, id string) error {
+	res := dao.db.Where("id = ? AND user_credential_id = ?", id, userCredentialID).Delete(&WebAuthnCredential{})
+	if res.Error == nil && res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return res.Error
+}
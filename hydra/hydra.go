@@ -5,14 +5,22 @@ import (
 	"github.com/labbsr0x/goh/gohclient"
 	"github.com/labbsr0x/goh/gohtypes"
 	"net/http"
+	"net/url"
 )
 
 type Api interface {
 	GetLoginRequestInfo(challenge string) map[string]interface{}
 	AcceptLoginRequest(challenge string, payload AcceptLoginRequestPayload) map[string]interface{}
+	RejectLoginRequest(challenge string, payload RejectLoginRequestPayload) map[string]interface{}
 	GetConsentRequestInfo(challenge string) map[string]interface{}
 	AcceptConsentRequest(challenge string, payload AcceptConsentRequestPayload) map[string]interface{}
 	RejectConsentRequest(challenge string, payload RejectConsentRequestPayload) map[string]interface{}
+	GetLogoutRequestInfo(challenge string) map[string]interface{}
+	AcceptLogoutRequest(challenge string) map[string]interface{}
+	RejectLogoutRequest(challenge string) map[string]interface{}
+	ListConsentSessions(subject string) []map[string]interface{}
+	RevokeConsentSessions(subject, clientID string) map[string]interface{}
+	RevokeLoginSessions(subject string) map[string]interface{}
 }
 
 type DefaultHydraHelper struct {
@@ -39,6 +47,12 @@ func (dhh *DefaultHydraHelper) AcceptLoginRequest(challenge string, payload Acce
 	return put(dhh.client, "login", challenge, "accept", data)
 }
 
+// RejectLoginRequest sends a reject login request to hydra
+func (dhh *DefaultHydraHelper) RejectLoginRequest(challenge string, payload RejectLoginRequestPayload) map[string]interface{} {
+	data, _ := json.Marshal(&payload)
+	return put(dhh.client, "login", challenge, "reject", data)
+}
+
 // GetConsentRequestInfo retrieves information to drive decisions over how to deal with the consent request
 func (dhh *DefaultHydraHelper) GetConsentRequestInfo(challenge string) map[string]interface{} {
 	return get(dhh.client, "consent", challenge)
@@ -55,3 +69,38 @@ func (dhh *DefaultHydraHelper) RejectConsentRequest(challenge string, payload Re
 	data, _ := json.Marshal(&payload)
 	return put(dhh.client, "consent", challenge, "reject", data)
 }
+
+// GetLogoutRequestInfo retrieves information to drive decisions over how to deal with the logout request
+func (dhh *DefaultHydraHelper) GetLogoutRequestInfo(challenge string) map[string]interface{} {
+	return get(dhh.client, "logout", challenge)
+}
+
+// AcceptLogoutRequest sends an accept logout request to hydra
+func (dhh *DefaultHydraHelper) AcceptLogoutRequest(challenge string) map[string]interface{} {
+	return put(dhh.client, "logout", challenge, "accept", nil)
+}
+
+// RejectLogoutRequest sends a reject logout request to hydra
+func (dhh *DefaultHydraHelper) RejectLogoutRequest(challenge string) map[string]interface{} {
+	return put(dhh.client, "logout", challenge, "reject", nil)
+}
+
+// ListConsentSessions lists the consents a subject has granted to clients that were remembered by hydra
+func (dhh *DefaultHydraHelper) ListConsentSessions(subject string) []map[string]interface{} {
+	return list(dhh.client, "consent", url.Values{"subject": {subject}})
+}
+
+// RevokeConsentSessions revokes the consents a subject has granted to a client, along with its tokens; every client's when clientID is empty
+func (dhh *DefaultHydraHelper) RevokeConsentSessions(subject, clientID string) map[string]interface{} {
+	query := url.Values{"subject": {subject}}
+	if clientID != "" {
+		query.Set("client", clientID)
+	}
+
+	return revoke(dhh.client, "consent", query)
+}
+
+// RevokeLoginSessions invalidates every login session of a subject, forcing it to sign in again
+func (dhh *DefaultHydraHelper) RevokeLoginSessions(subject string) map[string]interface{} {
+	return revoke(dhh.client, "login", url.Values{"subject": {subject}})
+}
@@ -19,9 +19,23 @@ func put(client *gohclient.Default, flow, challenge, action string, data []byte)
 	return treatResponse(client.Put(p, data))
 }
 
+func list(client *gohclient.Default, session string, query url.Values) []map[string]interface{} {
+	p := path.Join(client.BaseURL.Path, "/oauth2/auth/sessions/", session) + "?" + query.Encode()
+	return treatListResponse(client.Get(p))
+}
+
+func revoke(client *gohclient.Default, session string, query url.Values) map[string]interface{} {
+	p := path.Join(client.BaseURL.Path, "/oauth2/auth/sessions/", session) + "?" + query.Encode()
+	return treatResponse(client.Delete(p))
+}
+
 func treatResponse(resp *http.Response, data []byte, err error) map[string]interface{} {
 	if err == nil {
 		if resp.StatusCode >= 200 && resp.StatusCode <= 302 {
+			if len(data) == 0 { // e.g. the 204 answered to a rejected logout
+				return map[string]interface{}{}
+			}
+
 			var result map[string]interface{}
 			if err := json.Unmarshal(data, &result); err == nil {
 				return result
@@ -31,3 +45,16 @@ func treatResponse(resp *http.Response, data []byte, err error) map[string]inter
 	}
 	panic(gohtypes.Error{Code: 500, Err: err, Message: "Error while communicating with Hydra"})
 }
+
+func treatListResponse(resp *http.Response, data []byte, err error) []map[string]interface{} {
+	if err == nil {
+		if resp.StatusCode >= 200 && resp.StatusCode <= 302 {
+			result := make([]map[string]interface{}, 0)
+			if err := json.Unmarshal(data, &result); err == nil {
+				return result
+			}
+			panic(gohtypes.Error{Code: 500, Err: err, Message: "Error while decoding hydra's response bytes"})
+		}
+	}
+	panic(gohtypes.Error{Code: 500, Err: err, Message: "Error while communicating with Hydra"})
+}
@@ -0,0 +1,80 @@
+package hydra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/labbsr0x/goh/gohtypes"
+)
+
+// newTestHydraServer starts a hydra admin api answering every request with the given status and body, sending the
+// urls it gets to the returned channel
+func newTestHydraServer(t *testing.T, status int, body string) (Api, chan *url.URL) {
+	requests := make(chan *url.URL, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return new(DefaultHydraHelper).Init(server.URL), requests
+}
+
+// expectHydraError fails the test unless f panics with the error reported when hydra can not be talked to
+func expectHydraError(t *testing.T, name string, f func()) {
+	t.Helper()
+
+	defer func() {
+		if err, ok := recover().(gohtypes.Error); !ok || err.Code != http.StatusInternalServerError {
+			t.Errorf("%v: expected an error communicating with hydra, got %v", name, err)
+		}
+	}()
+
+	f()
+}
+
+func TestListConsentSessions(t *testing.T) {
+	helper, requests := newTestHydraServer(t, http.StatusOK, `[{"consent_request": {"client": {"client_id": "app"}}}, {}]`)
+
+	if sessions := helper.ListConsentSessions("alice"); len(sessions) != 2 {
+		t.Errorf("expected the two sessions listed, got %v", sessions)
+	}
+
+	if u := <-requests; u.Path != "/oauth2/auth/sessions/consent" || u.Query().Get("subject") != "alice" {
+		t.Errorf("expected the sessions of alice asked for, got %v", u)
+	}
+
+	helper, _ = newTestHydraServer(t, http.StatusInternalServerError, `{"error": "unavailable"}`)
+	expectHydraError(t, "failure", func() { helper.ListConsentSessions("alice") })
+
+	helper, _ = newTestHydraServer(t, http.StatusOK, `{"error": "not a list"}`)
+	expectHydraError(t, "malformed", func() { helper.ListConsentSessions("alice") })
+}
+
+func TestRevokeSessions(t *testing.T) {
+	helper, requests := newTestHydraServer(t, http.StatusNoContent, "")
+
+	if result := helper.RevokeConsentSessions("alice", "app"); len(result) != 0 {
+		t.Errorf("expected nothing answered, got %v", result)
+	}
+	if u := <-requests; u.Path != "/oauth2/auth/sessions/consent" || u.Query().Get("subject") != "alice" || u.Query().Get("client") != "app" {
+		t.Errorf("expected the consent of alice to the app revoked, got %v", u)
+	}
+
+	helper.RevokeConsentSessions("alice", "")
+	if u := <-requests; u.Query().Get("subject") != "alice" || len(u.Query()["client"]) != 0 {
+		t.Errorf("expected every consent of alice revoked, got %v", u)
+	}
+
+	helper.RevokeLoginSessions("alice")
+	if u := <-requests; u.Path != "/oauth2/auth/sessions/login" || u.Query().Get("subject") != "alice" {
+		t.Errorf("expected the login sessions of alice revoked, got %v", u)
+	}
+
+	helper, _ = newTestHydraServer(t, http.StatusNotFound, `{"error": "not found"}`)
+	expectHydraError(t, "consent", func() { helper.RevokeConsentSessions("alice", "app") })
+	expectHydraError(t, "login", func() { helper.RevokeLoginSessions("alice") })
+}
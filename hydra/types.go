@@ -1,11 +1,33 @@
 package hydra
 
+// Authentication context class references informed to hydra when accepting a login
+const (
+	ACRSingleFactor = "0"
+	ACRMultiFactor  = "1"
+)
+
+// Authentication method references (RFC 8176) informed to hydra when accepting a login
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+	AMRHWK      = "hwk"
+	AMRUser     = "user"
+	// AMRRecoveryCode is not registered by RFC 8176 and flags logins completed with a single use recovery code
+	AMRRecoveryCode = "rc"
+	// AMRFederated is not registered by RFC 8176 and flags logins brokered to an upstream OpenID Connect provider
+	AMRFederated = "fed"
+	// AMRMagicLink is not registered by RFC 8176 and flags logins completed with a single use link mailed to the user
+	AMRMagicLink = "email"
+)
+
 // AcceptLoginRequestPayload holds the data to communicate with hydra's accept login api
 type AcceptLoginRequestPayload struct {
-	Subject     string `json:"subject"`
-	Remember    bool   `json:"remember"`
-	RememberFor int    `json:"remember_for"`
-	ACR         string `json:"acr"`
+	Subject     string   `json:"subject"`
+	Remember    bool     `json:"remember"`
+	RememberFor int      `json:"remember_for"`
+	ACR         string   `json:"acr"`
+	AMR         []string `json:"amr,omitempty"`
 }
 
 // AcceptConsentRequestPayload holds the data to communicate with hydra's accept consent api
@@ -23,6 +45,12 @@ type TokenSessionPayload struct {
 	AccessToken interface{} `json:"access_token"`
 }
 
+// RejectLoginRequestPayload holds the data to communicate with hydra's reject login api
+type RejectLoginRequestPayload struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
 // RejectConsentRequestPayload holds the data to communicate with hydra's reject consent api
 type RejectConsentRequestPayload struct {
 	Error            string `json:"error"`
@@ -16,9 +16,9 @@ type changePasswordMailContent struct {
 }
 
 // GetChangePasswordMail render the mail for changing password
-func GetChangePasswordMail(baseUIPath, secret, publicAddress, username, email, redirectTo string) Mail {
+func GetChangePasswordMail(baseUIPath string, keyring *misc.Keyring, publicAddress, username, email, stamp, redirectTo string) Mail {
 	to := []string{email}
-	token := misc.GetChangePasswordToken(secret, username, redirectTo)
+	token := misc.GetChangePasswordToken(keyring, username, stamp, redirectTo)
 	link := fmt.Sprintf("%v/change-password/step-2?token=%v", publicAddress, token)
 	page := changePasswordMailContent{Link: link, Username: username}
 	content := render(baseUIPath, changePasswordMail, &page)
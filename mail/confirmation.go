@@ -16,9 +16,9 @@ type emailConfirmationMailContent struct {
 }
 
 // GetEmailConfirmationMail render the mail for email confirmation
-func GetEmailConfirmationMail(baseUIPath, secret, publicAddress, username, email, challenge string) Mail {
+func GetEmailConfirmationMail(baseUIPath string, keyring *misc.Keyring, publicAddress, username, email, stamp, challenge string) Mail {
 	to := []string{email}
-	token := misc.GetEmailConfirmationToken(secret, username, challenge)
+	token := misc.GetEmailConfirmationToken(keyring, username, stamp, challenge)
 	link := fmt.Sprintf("%v/email-confirmation?token=%v", publicAddress, token)
 	page := emailConfirmationMailContent{Link: link, Username: username}
 	content := render(baseUIPath, emailConfirmationMail, &page)
@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"fmt"
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// Enum
+const (
+	magicLinkMail = "magic_link_mail.html"
+)
+
+type magicLinkMailContent struct {
+	Link      string
+	Username  string
+	ExpiresIn string
+}
+
+// GetMagicLinkMail render the mail with the single use link that signs a user in without a password
+func GetMagicLinkMail(baseUIPath string, keyring *misc.Keyring, publicAddress, username, email, stamp, challenge string, remember bool) Mail {
+	to := []string{email}
+	token := misc.GetMagicLinkToken(keyring, username, stamp, challenge, remember)
+	link := fmt.Sprintf("%v/login/magic-link?token=%v", publicAddress, token)
+	expiresIn := fmt.Sprintf("%v minutes", int(keyring.Lifetimes.MagicLink.Minutes()))
+	page := magicLinkMailContent{Link: link, Username: username, ExpiresIn: expiresIn}
+	content := render(baseUIPath, magicLinkMail, &page)
+
+	return Mail{To: to, Content: content}
+}
@@ -0,0 +1,26 @@
+package mail
+
+import (
+	"fmt"
+)
+
+// Enum
+const (
+	recoveryCodeUsedMail = "recovery_code_used_mail.html"
+)
+
+type recoveryCodeUsedMailContent struct {
+	Link      string
+	Username  string
+	Remaining int
+}
+
+// GetRecoveryCodeUsedMail render the mail warning that a recovery code was used to sign in
+func GetRecoveryCodeUsedMail(baseUIPath, publicAddress, username, email string, remaining int) Mail {
+	to := []string{email}
+	link := fmt.Sprintf("%v/change-password/step-1", publicAddress)
+	page := recoveryCodeUsedMailContent{Link: link, Username: username, Remaining: remaining}
+	content := render(baseUIPath, recoveryCodeUsedMail, &page)
+
+	return Mail{To: to, Content: content}
+}
@@ -0,0 +1,28 @@
+package mail
+
+import (
+	"fmt"
+)
+
+// Enum
+const (
+	registrationConflictMail = "registration_conflict_mail.html"
+)
+
+type registrationConflictMailContent struct {
+	Link       string
+	Username   string
+	EmailTaken bool
+}
+
+// GetRegistrationConflictMail render the mail telling the owner of an account that a registration could not be done
+// because it took its email or username. It is sent to the owner instead of answering the registration
+func GetRegistrationConflictMail(baseUIPath, publicAddress, username, email string, emailTaken bool) Mail {
+	to := []string{email}
+	link := fmt.Sprintf("%v/change-password/step-1", publicAddress)
+
+	page := registrationConflictMailContent{Link: link, Username: username, EmailTaken: emailTaken}
+	content := render(baseUIPath, registrationConflictMail, &page)
+
+	return Mail{To: to, Content: content}
+}
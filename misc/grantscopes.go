@@ -1,10 +1,72 @@
 package misc
 
+import "fmt"
+
+// Scopes of OpenID Connect whose standard claims are released without being declared in the scopes file
+const (
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// Sources of the claims that are not profile attributes
+const (
+	ClaimFromUsername      = "username"
+	ClaimFromEmail         = "email"
+	ClaimFromEmailVerified = "email_verified"
+	ClaimFromUpdatedAt     = "updated_at"
+	ClaimFromGroups        = "groups"
+	ClaimFromRoles         = "roles"
+)
+
+// RoleNamespaceSeparator separates the client id a role belongs to from its name, e.g. billing:admin
+const RoleNamespaceSeparator = ":"
+
+// builtInClaimLabels are how the consent page shows the claims taken from the user credentials
+var builtInClaimLabels = map[string]string{
+	ClaimFromUsername:      "Your username",
+	ClaimFromEmail:         "Your email address",
+	ClaimFromEmailVerified: "Whether your email was confirmed",
+	ClaimFromUpdatedAt:     "When your account was last updated",
+	ClaimFromGroups:        "The groups you are a member of",
+	ClaimFromRoles:         "Your roles",
+}
+
+// standardScopeClaims are the claims the standard scopes release unless the scopes file declares them otherwise
+var standardScopeClaims = map[string][]ScopeClaim{
+	ScopeProfile: {
+		{Claim: "preferred_username", Attribute: ClaimFromUsername},
+		{Claim: "updated_at", Attribute: ClaimFromUpdatedAt},
+	},
+	ScopeEmail: {
+		{Claim: "email", Attribute: ClaimFromEmail},
+		{Claim: "email_verified", Attribute: ClaimFromEmailVerified},
+	},
+}
+
+// protocolClaims are set by hydra, so no scope can release them nor profile attribute take their name
+var protocolClaims = map[string]bool{
+	"sub": true, "iss": true, "aud": true, "exp": true, "iat": true, "nbf": true, "jti": true, "auth_time": true,
+	"nonce": true, "acr": true, "amr": true, "azp": true, "at_hash": true, "c_hash": true, "sid": true,
+}
+
+// ScopeClaim defines a claim a grant scope releases into the ID and access tokens, taken either from the user or
+// from a static value
+type ScopeClaim struct {
+	Claim     string
+	Attribute string      // one of the ClaimFrom constants or a profile attribute
+	Value     interface{} // released as is, for the claims without an attribute
+	Label     string      // how the consent page shows the claim
+	// ClientNamespace releases only the groups or roles named after the client, as in <client id>:<name>, without
+	// the client id
+	ClientNamespace bool
+}
+
 // GrantScope defines the structure of a grant scope
 type GrantScope struct {
 	Description string
 	Details     string
 	Scope       string
+	Claims      []ScopeClaim
 }
 
 // GrantScopes defines a map of grant scopes
@@ -18,3 +80,85 @@ func (gss GrantScopes) GetScopeListFromGrantScopeMap() []string {
 	}
 	return toReturn
 }
+
+// NewGrantScopes checks the claims declared by the grant scopes, adding the standard claims of their scope and the
+// profile attributes the schema releases with it. A declared claim takes the place of a standard one of the same name
+func NewGrantScopes(scopes GrantScopes, schema ProfileSchema) (GrantScopes, error) {
+	grantScopes := make(GrantScopes, len(scopes))
+	for name, scope := range scopes {
+		if scope.Scope == "" {
+			scope.Scope = name
+		}
+
+		claims := make([]ScopeClaim, 0, len(scope.Claims))
+		declared := make(map[string]bool)
+		for _, claim := range scope.Claims {
+			if claim.Claim == "" || protocolClaims[claim.Claim] {
+				return nil, fmt.Errorf("invalid claim '%v' for the scope '%v'", claim.Claim, scope.Scope)
+			}
+
+			if declared[claim.Claim] {
+				return nil, fmt.Errorf("the claim '%v' is released twice by the scope '%v'", claim.Claim, scope.Scope)
+			}
+
+			if (claim.Attribute == "") == (claim.Value == nil) {
+				return nil, fmt.Errorf("the claim '%v' of the scope '%v' needs either an attribute or a value", claim.Claim, scope.Scope)
+			}
+
+			if claim.ClientNamespace && claim.Attribute != ClaimFromGroups && claim.Attribute != ClaimFromRoles {
+				return nil, fmt.Errorf("only the groups and roles claims can be restricted to the client namespace, not '%v' of the scope '%v'", claim.Claim, scope.Scope)
+			}
+
+			if claim.Attribute != "" {
+				label, ok := getAttributeLabel(claim.Attribute, schema)
+				if !ok {
+					return nil, fmt.Errorf("unknown attribute '%v' for the claim '%v' of the scope '%v'", claim.Attribute, claim.Claim, scope.Scope)
+				}
+
+				if claim.Label == "" && claim.ClientNamespace {
+					claim.Label = label + " in this app"
+				} else if claim.Label == "" {
+					claim.Label = label
+				}
+			} else if claim.Label == "" {
+				claim.Label = claim.Claim
+			}
+
+			declared[claim.Claim] = true
+			claims = append(claims, claim)
+		}
+
+		for _, claim := range standardScopeClaims[scope.Scope] {
+			if !declared[claim.Claim] {
+				claim.Label, _ = getAttributeLabel(claim.Attribute, schema)
+				declared[claim.Claim] = true
+				claims = append(claims, claim)
+			}
+		}
+
+		for _, attribute := range schema {
+			if attribute.Scope == scope.Scope && !declared[attribute.Name] {
+				declared[attribute.Name] = true
+				claims = append(claims, ScopeClaim{Claim: attribute.Name, Attribute: attribute.Name, Label: attribute.Label})
+			}
+		}
+
+		scope.Claims = claims
+		grantScopes[name] = scope
+	}
+
+	return grantScopes, nil
+}
+
+// getAttributeLabel gets how the consent page shows a claim taken from the user, telling whether the user has it
+func getAttributeLabel(attribute string, schema ProfileSchema) (string, bool) {
+	if label, ok := builtInClaimLabels[attribute]; ok {
+		return label, true
+	}
+
+	if profileAttribute, ok := schema.Get(attribute); ok {
+		return profileAttribute.Label, true
+	}
+
+	return "", false
+}
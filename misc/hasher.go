@@ -0,0 +1,348 @@
+package misc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	Argon2id   = "argon2id"
+	Bcrypt     = "bcrypt"
+	HMACSHA512 = "hmac-sha512"
+)
+
+// PasswordHasher defines how passwords are hashed into and verified against a self-describing encoded string
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idHasher hashes passwords with argon2id, encoding them in the PHC string format
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint32 // up to 255
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// BcryptHasher hashes passwords with bcrypt, encoding them in the modular crypt format
+type BcryptHasher struct {
+	Cost int
+}
+
+// HMACHasher verifies the legacy HMAC(SHA512) hashes, encoded as $hmac-sha512$salt$hash
+type HMACHasher struct {
+	Keyring *Keyring
+}
+
+// ErrRekeyNeedsPassword is returned when a hash can only be moved to another key by hashing the password again
+var ErrRekeyNeedsPassword = fmt.Errorf("the password is needed to rekey a %v hash", HMACSHA512)
+
+// DefaultPasswordHasher hashes new passwords with a preferred hasher while still verifying every supported format.
+// The hashes are encrypted with the active key of the keyring, so a leaked database is not enough to crack them
+type DefaultPasswordHasher struct {
+	Preferred PasswordHasher
+	keyring   *Keyring
+	argon2id  *Argon2idHasher
+	bcrypt    *BcryptHasher
+	hmac      *HMACHasher
+}
+
+// Init initializes the default password hasher for the given algorithm
+func (h *DefaultPasswordHasher) Init(algorithm string, keyring *Keyring, argon2id Argon2idHasher, bcrypt BcryptHasher) (PasswordHasher, error) {
+	if argon2id.KeyLen == 0 {
+		argon2id.KeyLen = 32
+	}
+
+	if argon2id.SaltLen == 0 {
+		argon2id.SaltLen = 16
+	}
+
+	if err := argon2id.check(); err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.check(); err != nil {
+		return nil, err
+	}
+
+	h.argon2id = &argon2id
+	h.bcrypt = &bcrypt
+	h.keyring = keyring
+	h.hmac = &HMACHasher{Keyring: keyring}
+
+	switch algorithm {
+	case Argon2id:
+		h.Preferred = h.argon2id
+	case Bcrypt:
+		h.Preferred = h.bcrypt
+	default:
+		return nil, fmt.Errorf("unsupported password hasher '%v'", algorithm)
+	}
+
+	return h, nil
+}
+
+// Hash hashes a password with the preferred hasher, encrypting the hash with the active key
+func (h *DefaultPasswordHasher) Hash(password string) (string, error) {
+	encoded, err := h.Preferred.Hash(password)
+	if err != nil {
+		return "", err
+	}
+
+	return h.keyring.Encrypt(encoded)
+}
+
+// Verify verifies a password with the hasher that produced the encoded hash
+func (h *DefaultPasswordHasher) Verify(password, encoded string) (bool, error) {
+	encoded, err := h.decrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	hasher, err := h.getHasher(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	return hasher.Verify(password, encoded)
+}
+
+// NeedsRehash tells whether the encoded hash was not produced by the preferred hasher with its current parameters and key
+func (h *DefaultPasswordHasher) NeedsRehash(encoded string) bool {
+	if !IsKeyringEncrypted(encoded) || h.keyring.NeedsReencrypt(encoded) {
+		return true
+	}
+
+	encoded, err := h.decrypt(encoded)
+	if err != nil {
+		return true
+	}
+
+	hasher, err := h.getHasher(encoded)
+	return err != nil || hasher != h.Preferred || hasher.NeedsRehash(encoded)
+}
+
+// decrypt unwraps a hash encrypted by the keyring, leaving the hashes stored before the keyring untouched
+func (h *DefaultPasswordHasher) decrypt(encoded string) (string, error) {
+	if !IsKeyringEncrypted(encoded) {
+		return encoded, nil
+	}
+
+	return h.keyring.Decrypt(encoded)
+}
+
+func (h *DefaultPasswordHasher) getHasher(encoded string) (PasswordHasher, error) {
+	switch GetPasswordHashAlgorithm(encoded) {
+	case Argon2id:
+		return h.argon2id, nil
+	case Bcrypt:
+		return h.bcrypt, nil
+	case HMACSHA512:
+		return h.hmac, nil
+	}
+
+	return nil, fmt.Errorf("unknown password hash format")
+}
+
+// GetPasswordHashAlgorithm identifies the algorithm of an encoded password hash
+func GetPasswordHashAlgorithm(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return Argon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return Bcrypt
+	case strings.HasPrefix(encoded, "$"+HMACSHA512+"$"):
+		return HMACSHA512
+	}
+
+	return ""
+}
+
+// Hash hashes a password with argon2id and a random salt
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, uint8(h.Threads), h.KeyLen)
+
+	return fmt.Sprintf("$%v$v=%v$m=%v,t=%v,p=%v$%v$%v", Argon2id, argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify verifies a password against an argon2id encoded hash, using the parameters recorded in it
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, uint8(params.Threads), uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// NeedsRehash tells whether the encoded hash was produced with other parameters
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := decodeArgon2id(encoded)
+	return err != nil || params.Time != h.Time || params.Memory != h.Memory || params.Threads != h.Threads || uint32(len(key)) != h.KeyLen
+}
+
+// argon2idMinKeyLen is the shortest key, in bytes, the argon2id hashes are accepted with
+const argon2idMinKeyLen = 16
+
+// check verifies the parameters are ones argon2id can hash with, rather than panic on the first password
+func (h Argon2idHasher) check() error {
+	if h.Threads < 1 || h.Threads > 255 {
+		return fmt.Errorf("the argon2id threads should be from 1 to 255, got %v", h.Threads)
+	}
+
+	if h.Time < 1 {
+		return fmt.Errorf("the argon2id time should be at least 1, got %v", h.Time)
+	}
+
+	if h.Memory < 8*h.Threads {
+		return fmt.Errorf("the argon2id memory should be at least 8 KiB per thread, %v KiB, got %v KiB", 8*h.Threads, h.Memory)
+	}
+
+	if h.KeyLen < argon2idMinKeyLen {
+		return fmt.Errorf("the argon2id key length should be at least %v bytes, got %v", argon2idMinKeyLen, h.KeyLen)
+	}
+
+	return nil
+}
+
+func decodeArgon2id(encoded string) (params Argon2idHasher, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return params, nil, nil, fmt.Errorf("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version")
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id parameters")
+	}
+
+	params.KeyLen = argon2idMinKeyLen // checked apart, from the key itself
+	if err = params.check(); err != nil {
+		return params, nil, nil, err
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, fmt.Errorf("malformed argon2id salt")
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("malformed argon2id key")
+	}
+
+	return params, salt, key, nil
+}
+
+// Hash hashes a password with bcrypt
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+// Verify verifies a password against a bcrypt hash
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// NeedsRehash tells whether the encoded hash was produced with another cost
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// check verifies the cost is one bcrypt hashes with, rather than one it silently replaces by its default
+func (h BcryptHasher) check() error {
+	if h.Cost < bcrypt.MinCost || h.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("the bcrypt cost should be from %v to %v, got %v", bcrypt.MinCost, bcrypt.MaxCost, h.Cost)
+	}
+
+	return nil
+}
+
+// Hash hashes a password with the legacy HMAC(SHA512) algorithm and the active key
+func (h *HMACHasher) Hash(password string) (string, error) {
+	salt := GenerateSalt()
+	return EncodeLegacyPasswordHash(GetEncryptedPassword(h.Keyring.Active(), password, salt), salt), nil
+}
+
+// Verify verifies a password against a legacy HMAC(SHA512) hash. These hashes do not record their key, so every key is tried
+func (h *HMACHasher) Verify(password, encoded string) (bool, error) {
+	parts := strings.SplitN(encoded, "$", 4)
+	if len(parts) != 4 || parts[1] != HMACSHA512 {
+		return false, fmt.Errorf("malformed %v hash", HMACSHA512)
+	}
+
+	for _, key := range h.Keyring.Keys() {
+		if other := GetEncryptedPassword(key, password, parts[2]); hmac.Equal([]byte(parts[3]), []byte(other)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// NeedsRehash always asks for legacy hashes to be upgraded
+func (h *HMACHasher) NeedsRehash(encoded string) bool {
+	return true
+}
+
+// EncodeLegacyPasswordHash builds the self-describing form of a hash stored before the password hashers existed,
+// when the hash and its salt were kept in separated columns
+func EncodeLegacyPasswordHash(hash, salt string) string {
+	if IsKeyringEncrypted(hash) || GetPasswordHashAlgorithm(hash) != "" {
+		return hash
+	}
+
+	return fmt.Sprintf("$%v$%v$%v", HMACSHA512, salt, hash)
+}
+
+// RekeyPasswordHash encrypts an encoded hash with the active key without knowing the password.
+// The legacy HMAC(SHA512) hashes can not be rekeyed this way and are only upgraded on login
+func RekeyPasswordHash(keyring *Keyring, encoded string) (string, error) {
+	if IsKeyringEncrypted(encoded) {
+		if !keyring.NeedsReencrypt(encoded) {
+			return encoded, nil
+		}
+
+		decrypted, err := keyring.Decrypt(encoded)
+		if err != nil {
+			return "", err
+		}
+		encoded = decrypted
+	}
+
+	switch GetPasswordHashAlgorithm(encoded) {
+	case Argon2id, Bcrypt:
+		return keyring.Encrypt(encoded)
+	case HMACSHA512:
+		return "", ErrRekeyNeedsPassword
+	}
+
+	return "", fmt.Errorf("unknown password hash format")
+}
@@ -0,0 +1,22 @@
+package misc
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The cheapest parameters of the hashers, for the tests
+var (
+	TestArgon2idHasher = Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}
+	TestBcryptHasher   = BcryptHasher{Cost: bcrypt.MinCost}
+)
+
+// NewTestPasswordHasher builds a keyring and a password hasher quick enough for the tests of the packages storing passwords
+func NewTestPasswordHasher(algorithm string) (*Keyring, PasswordHasher, error) {
+	keyring, err := new(Keyring).Init("1", "secret", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hasher, err := new(DefaultPasswordHasher).Init(algorithm, keyring, TestArgon2idHasher, TestBcryptHasher)
+	return keyring, hasher, err
+}
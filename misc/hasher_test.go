@@ -0,0 +1,159 @@
+package misc
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestKeyring(t *testing.T, activeID, activeKey string, retiredKeys ...string) *Keyring {
+	keyring, err := new(Keyring).Init(activeID, activeKey, retiredKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keyring
+}
+
+func newTestPasswordHasher(t *testing.T, algorithm string) PasswordHasher {
+	hasher, err := new(DefaultPasswordHasher).Init(algorithm, newTestKeyring(t, "1", "secret"), TestArgon2idHasher, TestBcryptHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hasher
+}
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{Argon2id, Bcrypt} {
+		hasher := newTestPasswordHasher(t, algorithm)
+
+		encoded, err := hasher.Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if GetKeyID(encoded) != "1" {
+			t.Errorf("expected a hash encrypted by the active key, got %v", encoded)
+		}
+
+		if ok, err := hasher.Verify("password", encoded); !ok || err != nil {
+			t.Errorf("%v: the password should be verified (%v)", algorithm, err)
+		}
+
+		if ok, _ := hasher.Verify("wrong", encoded); ok {
+			t.Errorf("%v: a wrong password should not be verified", algorithm)
+		}
+
+		if hasher.NeedsRehash(encoded) {
+			t.Errorf("%v: a fresh hash should not need a rehash", algorithm)
+		}
+	}
+}
+
+func TestPasswordHasherLegacy(t *testing.T) {
+	hasher := newTestPasswordHasher(t, Argon2id)
+
+	salt := GenerateSalt()
+	encoded := EncodeLegacyPasswordHash(GetEncryptedPassword("secret", "password", salt), salt)
+
+	if ok, err := hasher.Verify("password", encoded); !ok || err != nil {
+		t.Errorf("the legacy password should be verified (%v)", err)
+	}
+
+	if ok, _ := hasher.Verify("wrong", encoded); ok {
+		t.Error("a wrong legacy password should not be verified")
+	}
+
+	if !hasher.NeedsRehash(encoded) {
+		t.Error("a legacy hash should need a rehash")
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	encoded, _ := newTestPasswordHasher(t, Bcrypt).Hash("password")
+
+	hasher := newTestPasswordHasher(t, Argon2id)
+	if ok, _ := hasher.Verify("password", encoded); !ok {
+		t.Error("hashes of other supported algorithms should be verified")
+	}
+
+	if !hasher.NeedsRehash(encoded) {
+		t.Error("hashes of other algorithms should need a rehash")
+	}
+
+	encoded, _ = hasher.Hash("password")
+	stronger, _ := new(DefaultPasswordHasher).Init(Argon2id, newTestKeyring(t, "1", "secret"), Argon2idHasher{Time: 2, Memory: 1024, Threads: 1}, TestBcryptHasher)
+	if !stronger.NeedsRehash(encoded) {
+		t.Error("hashes with outdated parameters should need a rehash")
+	}
+
+	if ok, _ := stronger.Verify("password", encoded); !ok {
+		t.Error("hashes with outdated parameters should still be verified")
+	}
+}
+
+func TestPasswordHasherRekey(t *testing.T) {
+	encoded, _ := newTestPasswordHasher(t, Argon2id).Hash("password")
+
+	keyring := newTestKeyring(t, "2", "other", "1:secret")
+	hasher, _ := new(DefaultPasswordHasher).Init(Argon2id, keyring, TestArgon2idHasher, TestBcryptHasher)
+
+	if ok, _ := hasher.Verify("password", encoded); !ok {
+		t.Error("hashes encrypted by a retired key should be verified")
+	}
+
+	if !hasher.NeedsRehash(encoded) {
+		t.Error("hashes encrypted by a retired key should need a rehash")
+	}
+
+	rekeyed, err := RekeyPasswordHash(keyring, encoded)
+	if err != nil || GetKeyID(rekeyed) != "2" {
+		t.Fatalf("the hash should be moved to the active key, got %v (%v)", rekeyed, err)
+	}
+
+	if ok, _ := hasher.Verify("password", rekeyed); !ok || hasher.NeedsRehash(rekeyed) {
+		t.Error("the rekeyed hash should be verified without needing a rehash")
+	}
+
+	legacy := EncodeLegacyPasswordHash(GetEncryptedPassword("secret", "password", "salt"), "salt")
+	if _, err := RekeyPasswordHash(keyring, legacy); err != ErrRekeyNeedsPassword {
+		t.Errorf("legacy hashes should need the password to be rekeyed, got %v", err)
+	}
+
+	if ok, _ := hasher.Verify("password", legacy); !ok {
+		t.Error("legacy hashes of a retired key should be verified")
+	}
+}
+
+func TestPasswordHasherArgon2idParameters(t *testing.T) {
+	invalid := []Argon2idHasher{
+		{Time: 1, Memory: 1024, Threads: 0},
+		{Time: 1, Memory: 4096, Threads: 256},
+		{Time: 0, Memory: 1024, Threads: 1},
+		{Time: 1, Memory: 31, Threads: 4},
+		{Time: 1, Memory: 1024, Threads: 1, KeyLen: 8},
+	}
+
+	for _, argon2id := range invalid {
+		if _, err := new(DefaultPasswordHasher).Init(Argon2id, newTestKeyring(t, "1", "secret"), argon2id, TestBcryptHasher); err == nil {
+			t.Errorf("expected the argon2id parameters %+v refused", argon2id)
+		}
+	}
+
+	if ok, err := TestArgon2idHasher.Verify("password", "$argon2id$v=19$m=1024,t=1,p=0$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5"); ok || err == nil {
+		t.Error("expected the hashes with invalid parameters refused")
+	}
+}
+
+func TestPasswordHasherBcryptCost(t *testing.T) {
+	for _, cost := range []int{0, bcrypt.MinCost - 1, bcrypt.MaxCost + 1} {
+		if _, err := new(DefaultPasswordHasher).Init(Bcrypt, newTestKeyring(t, "1", "secret"), TestArgon2idHasher, BcryptHasher{Cost: cost}); err == nil {
+			t.Errorf("expected the bcrypt cost %v refused", cost)
+		}
+	}
+
+	if _, err := new(DefaultPasswordHasher).Init(Bcrypt, newTestKeyring(t, "1", "secret"), TestArgon2idHasher, BcryptHasher{Cost: bcrypt.MaxCost}); err != nil {
+		t.Errorf("expected the bcrypt cost %v accepted, got %v", bcrypt.MaxCost, err)
+	}
+}
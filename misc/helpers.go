@@ -2,11 +2,15 @@ package misc
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 )
 
@@ -56,3 +60,49 @@ func GetEncryptedPassword(secretKey, password, salt string) string {
 
 	return base64.URLEncoding.EncodeToString(hash.Sum(nil))
 }
+
+// EncryptSecret encrypts a secret with aes-gcm using a key derived from the secret key
+func EncryptSecret(secretKey, secret string) (string, error) {
+	gcm, err := getSecretCipher(secretKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.URLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret decrypts a secret encrypted by EncryptSecret
+func DecryptSecret(secretKey, encrypted string) (string, error) {
+	gcm, err := getSecretCipher(secretKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.URLEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to decrypt secret")
+	}
+
+	return string(plain), nil
+}
+
+func getSecretCipher(secretKey string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secretKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
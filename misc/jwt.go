@@ -3,30 +3,41 @@ package misc
 import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	"github.com/labbsr0x/goh/gohtypes"
 	"net/http"
 	"net/url"
 	"time"
 )
 
-// GenerateToken generates a jwt token
-func GenerateToken(secret string, data jwt.MapClaims) (string, error) {
+// GenerateToken generates a jwt token signed by the active key, identified by the kid header
+func GenerateToken(keyring *Keyring, data jwt.MapClaims) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, data)
+	token.Header["kid"] = keyring.ActiveID
 
-	return token.SignedString([]byte(secret))
+	return token.SignedString([]byte(keyring.Active()))
 }
 
-// ParseToken extract the claims from a token string
-func ParseToken(tokenString, secret string) (jwt.MapClaims, error) {
-	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// ParseToken extract the claims from a token string, verifying it with the key named by its kid header
+func ParseToken(tokenString string, keyring *Keyring) (jwt.MapClaims, error) {
+	var token *jwt.Token
+	var err error
+
+	if kid, ok := getTokenKeyID(tokenString); ok {
+		key, found := keyring.Get(kid)
+		if !found {
+			return nil, fmt.Errorf("unknown token key id")
 		}
 
-		return []byte(secret), nil
+		token, err = jwt.Parse(tokenString, getTokenKeyFunc(key))
+	} else { // tokens issued before the key ids existed
+		for _, key := range keyring.Keys() {
+			if token, err = jwt.Parse(tokenString, getTokenKeyFunc(key)); err == nil {
+				break
+			}
+		}
 	}
 
-	token, err := jwt.Parse(tokenString, keyFunc)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse the email confirmation token")
 	}
@@ -40,26 +51,76 @@ func ParseToken(tokenString, secret string) (jwt.MapClaims, error) {
 		return nil, fmt.Errorf("unable to parse claims from the email confirmation token")
 	}
 
-	exp, ok := claims["exp"].(int64)
-	if ok && exp > time.Now().Unix() {
+	exp, ok := claims["exp"].(float64) // json numbers are decoded as float64
+	if !ok || int64(exp) <= time.Now().Unix() {
 		return nil, fmt.Errorf("expired token")
 	}
 
 	return claims, nil
 }
 
+// SingleUseToken holds what makes a mailed token single use: its id, recorded once used, when it expires, and the
+// security stamp of the user it was mailed to, which changes along with the password or email of the user
+type SingleUseToken struct {
+	ID        string
+	ExpiresAt time.Time
+	Stamp     string
+}
+
+// addSingleUseClaims makes the claims of a mailed token single use, bound to the security stamp of its user
+func addSingleUseClaims(claims jwt.MapClaims, stamp string, lifetime time.Duration) jwt.MapClaims {
+	claims["jti"] = uuid.New().String()             // Token ID, recorded once used
+	claims["stamp"] = stamp                         // Security Stamp of the user
+	claims["exp"] = time.Now().Add(lifetime).Unix() // Expiration
+	claims["iat"] = time.Now().Unix()               // Issued At
+	return claims
+}
+
+// unmarshalSingleUseToken extracts what makes a mailed token single use
+func unmarshalSingleUseToken(claims jwt.MapClaims) (SingleUseToken, error) {
+	id, _ := claims["jti"].(string)
+	stamp, ok := claims["stamp"].(string)
+	exp, _ := claims["exp"].(float64)
+	if id == "" || !ok || exp == 0 {
+		return SingleUseToken{}, fmt.Errorf("unable to find the token id")
+	}
+
+	return SingleUseToken{ID: id, ExpiresAt: time.Unix(int64(exp), 0), Stamp: stamp}, nil
+}
+
+func getTokenKeyFunc(key string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return []byte(key), nil
+	}
+}
+
+// getTokenKeyID reads the kid header of a token before its signature is verified
+func getTokenKeyID(tokenString string) (string, bool) {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return "", false
+	}
+
+	kid, ok := token.Header["kid"].(string)
+	return kid, ok
+}
+
 // ExtractClaimsTokenFromRequest extract a jwt token from a given request
-func ExtractClaimsTokenFromRequest(secret string, r *http.Request) (jwt.MapClaims, error) {
+func ExtractClaimsTokenFromRequest(keyring *Keyring, r *http.Request) (jwt.MapClaims, error) {
 	tokenString, err := url.QueryUnescape(r.URL.Query().Get("token"))
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve the email confirmation token")
 	}
 
-	return ParseToken(tokenString, secret)
+	return ParseToken(tokenString, keyring)
 }
 
 // UnmarshalEmailConfirmationToken verify it is an email confirmation token and extract the extras information
-func UnmarshalEmailConfirmationToken(claims jwt.MapClaims) (username, challenge string) {
+func UnmarshalEmailConfirmationToken(claims jwt.MapClaims) (username, challenge string, singleUse SingleUseToken) {
 	emt, ok := claims["emt"].(bool)
 	if !ok || !emt {
 		gohtypes.Panic("Email confirmation token not valid", http.StatusNotAcceptable)
@@ -75,60 +136,282 @@ func UnmarshalEmailConfirmationToken(claims jwt.MapClaims) (username, challenge
 		gohtypes.Panic("Unable to find the login challenge", http.StatusNotFound)
 	}
 
+	singleUse, err := unmarshalSingleUseToken(claims)
+	if err != nil {
+		gohtypes.Panic("Email confirmation token not valid", http.StatusNotAcceptable)
+	}
+
 	return
 }
 
 // UnmarshalChangePasswordToken verify it is an change password token and extract the extras information
-func UnmarshalChangePasswordToken(claims jwt.MapClaims) (string, string, error) {
+func UnmarshalChangePasswordToken(claims jwt.MapClaims) (string, string, SingleUseToken, error) {
 	cp, ok := claims["cp"].(bool)
 	if !ok || !cp {
-		return "", "", fmt.Errorf("change password token not valid")
+		return "", "", SingleUseToken{}, fmt.Errorf("change password token not valid")
 	}
 
 	username, ok := claims["sub"].(string)
 	if !ok {
-		return "", "", fmt.Errorf("unable to find the user")
+		return "", "", SingleUseToken{}, fmt.Errorf("unable to find the user")
 	}
 
 	redirectTo, ok := claims["redirect_to"].(string)
 	if !ok {
-		return "", "", fmt.Errorf("unable to find the user")
+		return "", "", SingleUseToken{}, fmt.Errorf("unable to find the user")
 	}
 
-	return username, redirectTo, nil
+	singleUse, err := unmarshalSingleUseToken(claims)
+	if err != nil {
+		return "", "", SingleUseToken{}, err
+	}
+
+	return username, redirectTo, singleUse, nil
 }
 
-// GetEmailConfirmationToken builds a token for email confirmation
-func GetEmailConfirmationToken(secret, username, challenge string) string {
-	claims := jwt.MapClaims{
-		"sub":       username,                                // Subject
-		"exp":       time.Now().Add(10 * time.Minute).Unix(), // Expiration
-		"challenge": challenge,                               // Login Challenge
-		"emt":       true,                                    // Email Confirmation Token
-		"iat":       time.Now().Unix(),                       // Issued At
-	}
+// GetEmailConfirmationToken builds a single use token for email confirmation
+func GetEmailConfirmationToken(keyring *Keyring, username, stamp, challenge string) string {
+	claims := addSingleUseClaims(jwt.MapClaims{
+		"sub":       username,  // Subject
+		"challenge": challenge, // Login Challenge
+		"emt":       true,      // Email Confirmation Token
+	}, stamp, keyring.Lifetimes.EmailConfirmation)
 
-	token, err := GenerateToken(secret, claims)
+	token, err := GenerateToken(keyring, claims)
 	gohtypes.PanicIfError("Not possible to create token", http.StatusInternalServerError, err)
 
 	return token
 }
 
-// GetEmailConfirmationToken builds a token for changing password
-func GetChangePasswordToken(secret, username, redirectTo string) string {
+// GetChangePasswordToken builds a single use token for changing password
+func GetChangePasswordToken(keyring *Keyring, username, stamp, redirectTo string) string {
 	if len(redirectTo) == 0 {
 		redirectTo = "/login"
 	}
 
+	claims := addSingleUseClaims(jwt.MapClaims{
+		"sub":         username,   // Subject
+		"redirect_to": redirectTo, // Redirect Back To
+		"cp":          true,       // Change Password Token
+	}, stamp, keyring.Lifetimes.ChangePassword)
+
+	token, err := GenerateToken(keyring, claims)
+	gohtypes.PanicIfError("Not possible to create token", http.StatusInternalServerError, err)
+
+	return token
+}
+
+// UnmarshalSecondFactorToken verify it is a second factor token and extract the extras information
+func UnmarshalSecondFactorToken(claims jwt.MapClaims) (username, challenge, firstFactor string, remember bool, err error) {
+	sf, ok := claims["sf"].(bool)
+	if !ok || !sf {
+		return "", "", "", false, fmt.Errorf("second factor token not valid")
+	}
+
+	username, ok = claims["sub"].(string)
+	if !ok {
+		return "", "", "", false, fmt.Errorf("unable to find the user")
+	}
+
+	challenge, ok = claims["challenge"].(string)
+	if !ok {
+		return "", "", "", false, fmt.Errorf("unable to find the login challenge")
+	}
+
+	firstFactor, ok = claims["ff"].(string)
+	if !ok { // issued before the magic links, always after a password
+		firstFactor = "pwd"
+	}
+
+	remember, _ = claims["remember"].(bool)
+
+	return username, challenge, firstFactor, remember, nil
+}
+
+// GetSecondFactorToken builds a token that carries a login whose first factor, the authentication method reference
+// given, was already verified to the second factor step
+func GetSecondFactorToken(keyring *Keyring, username, challenge, firstFactor string, remember bool) string {
+	claims := jwt.MapClaims{
+		"sub":       username,                               // Subject
+		"exp":       time.Now().Add(5 * time.Minute).Unix(), // Expiration
+		"challenge": challenge,                              // Login Challenge
+		"remember":  remember,                               // Remember Login
+		"sf":        true,                                   // Second Factor Token
+		"ff":        firstFactor,                            // First Factor Verified
+		"iat":       time.Now().Unix(),                      // Issued At
+	}
+
+	token, err := GenerateToken(keyring, claims)
+	gohtypes.PanicIfError("Not possible to create token", http.StatusInternalServerError, err)
+
+	return token
+}
+
+// UnmarshalMagicLinkToken verify it is a magic link token and extract the extras information
+func UnmarshalMagicLinkToken(claims jwt.MapClaims) (username, challenge string, remember bool, singleUse SingleUseToken, err error) {
+	ml, ok := claims["ml"].(bool)
+	if !ok || !ml {
+		return "", "", false, SingleUseToken{}, fmt.Errorf("magic link token not valid")
+	}
+
+	username, _ = claims["sub"].(string)
+	challenge, _ = claims["challenge"].(string)
+	if username == "" || challenge == "" {
+		return "", "", false, SingleUseToken{}, fmt.Errorf("unable to find the user or login challenge")
+	}
+
+	if singleUse, err = unmarshalSingleUseToken(claims); err != nil {
+		return "", "", false, SingleUseToken{}, err
+	}
+
+	remember, _ = claims["remember"].(bool)
+
+	return username, challenge, remember, singleUse, nil
+}
+
+// GetMagicLinkToken builds a single use token that signs a user in when the link mailed to it is followed
+func GetMagicLinkToken(keyring *Keyring, username, stamp, challenge string, remember bool) string {
+	claims := addSingleUseClaims(jwt.MapClaims{
+		"sub":       username,  // Subject
+		"challenge": challenge, // Login Challenge
+		"remember":  remember,  // Remember Login
+		"ml":        true,      // Magic Link Token
+	}, stamp, keyring.Lifetimes.MagicLink)
+
+	token, err := GenerateToken(keyring, claims)
+	gohtypes.PanicIfError("Not possible to create token", http.StatusInternalServerError, err)
+
+	return token
+}
+
+// UnmarshalWebAuthnToken verify it is a webauthn token of the given ceremony and extract the extras information
+func UnmarshalWebAuthnToken(claims jwt.MapClaims, ceremony string) (subject, challenge, loginChallenge string, remember bool, err error) {
+	wa, ok := claims["wa"].(string)
+	if !ok || wa != ceremony {
+		return "", "", "", false, fmt.Errorf("webauthn token not valid")
+	}
+
+	subject, _ = claims["sub"].(string)
+
+	challenge, ok = claims["challenge"].(string)
+	if !ok {
+		return "", "", "", false, fmt.Errorf("unable to find the webauthn challenge")
+	}
+
+	loginChallenge, _ = claims["login_challenge"].(string)
+	remember, _ = claims["remember"].(bool)
+
+	return subject, challenge, loginChallenge, remember, nil
+}
+
+// GetWebAuthnToken builds a token that keeps the webauthn challenge of an ongoing ceremony
+func GetWebAuthnToken(keyring *Keyring, ceremony, subject, challenge, loginChallenge string, remember bool) string {
+	claims := jwt.MapClaims{
+		"sub":             subject,                                // Subject
+		"exp":             time.Now().Add(5 * time.Minute).Unix(), // Expiration
+		"challenge":       challenge,                              // WebAuthn Challenge
+		"login_challenge": loginChallenge,                         // Login Challenge
+		"remember":        remember,                               // Remember Login
+		"wa":              ceremony,                               // WebAuthn Ceremony
+		"iat":             time.Now().Unix(),                      // Issued At
+	}
+
+	token, err := GenerateToken(keyring, claims)
+	gohtypes.PanicIfError("Not possible to create token", http.StatusInternalServerError, err)
+
+	return token
+}
+
+// FederationRequest holds a login or a link started at an upstream provider, until the provider sends the browser back.
+// Logins carry the login challenge, links carry the id of the signed in user and where to return to
+type FederationRequest struct {
+	Provider       string
+	State          string
+	Nonce          string
+	CodeVerifier   string
+	LoginChallenge string
+	Remember       bool
+	LinkUserID     string
+	ReturnTo       string
+}
+
+// UnmarshalFederationToken verify it is a federation token and extract the extras information
+func UnmarshalFederationToken(claims jwt.MapClaims) (request FederationRequest, err error) {
+	request.Provider, _ = claims["fed"].(string)
+	if request.Provider == "" {
+		return FederationRequest{}, fmt.Errorf("federation token not valid")
+	}
+
+	request.State, _ = claims["state"].(string)
+	request.Nonce, _ = claims["nonce"].(string)
+	request.CodeVerifier, _ = claims["code_verifier"].(string)
+	if request.State == "" || request.Nonce == "" || request.CodeVerifier == "" {
+		return FederationRequest{}, fmt.Errorf("unable to find the upstream authorization request")
+	}
+
+	request.LoginChallenge, _ = claims["login_challenge"].(string)
+	request.Remember, _ = claims["remember"].(bool)
+	request.LinkUserID, _ = claims["link"].(string)
+	request.ReturnTo, _ = claims["return_to"].(string)
+	if request.LoginChallenge == "" && request.LinkUserID == "" {
+		return FederationRequest{}, fmt.Errorf("unable to find the login challenge")
+	}
+
+	return request, nil
+}
+
+// GetFederationToken builds a token that keeps the upstream authorization request of a login or link brokered to a provider
+func GetFederationToken(keyring *Keyring, request FederationRequest) string {
+	claims := jwt.MapClaims{
+		"fed":             request.Provider,                        // Upstream Provider
+		"exp":             time.Now().Add(10 * time.Minute).Unix(), // Expiration
+		"state":           request.State,                           // Upstream State
+		"nonce":           request.Nonce,                           // Upstream Nonce
+		"code_verifier":   request.CodeVerifier,                    // Upstream PKCE Code Verifier
+		"login_challenge": request.LoginChallenge,                  // Login Challenge
+		"remember":        request.Remember,                        // Remember Login
+		"link":            request.LinkUserID,                      // User Linking the Provider
+		"return_to":       request.ReturnTo,                        // Page to Return to after Linking
+		"iat":             time.Now().Unix(),                       // Issued At
+	}
+
+	token, err := GenerateToken(keyring, claims)
+	gohtypes.PanicIfError("Not possible to create token", http.StatusInternalServerError, err)
+
+	return token
+}
+
+// UnmarshalLinkConfirmationToken verify it is a link confirmation token and extract the extras information
+func UnmarshalLinkConfirmationToken(claims jwt.MapClaims) (provider, subject, email, userCredentialID, loginChallenge string, remember bool, err error) {
+	provider, _ = claims["fed_link"].(string)
+	subject, _ = claims["upstream_sub"].(string)
+	email, _ = claims["email"].(string)
+	userCredentialID, _ = claims["sub"].(string)
+	loginChallenge, _ = claims["login_challenge"].(string)
+	if provider == "" || subject == "" || userCredentialID == "" || loginChallenge == "" {
+		return "", "", "", "", "", false, fmt.Errorf("link confirmation token not valid")
+	}
+
+	remember, _ = claims["remember"].(bool)
+
+	return provider, subject, email, userCredentialID, loginChallenge, remember, nil
+}
+
+// GetLinkConfirmationToken builds a token that keeps an identity of an upstream provider waiting for the user to
+// confirm it is linked to the local account with the same email
+func GetLinkConfirmationToken(keyring *Keyring, provider, subject, email, userCredentialID, loginChallenge string, remember bool) string {
 	claims := jwt.MapClaims{
-		"sub":         username,                                // Subject
-		"redirect_to": redirectTo,                              // Redirect Back To
-		"exp":         time.Now().Add(10 * time.Minute).Unix(), // Expiration
-		"cp":          true,                                    // Change Password Token
-		"iat":         time.Now().Unix(),                       // Issued At
+		"fed_link":        provider,                                // Upstream Provider
+		"upstream_sub":    subject,                                 // Upstream Subject
+		"email":           email,                                   // Upstream Verified Email
+		"sub":             userCredentialID,                        // Subject
+		"exp":             time.Now().Add(10 * time.Minute).Unix(), // Expiration
+		"login_challenge": loginChallenge,                          // Login Challenge
+		"remember":        remember,                                // Remember Login
+		"iat":             time.Now().Unix(),                       // Issued At
 	}
 
-	token, err := GenerateToken(secret, claims)
+	token, err := GenerateToken(keyring, claims)
 	gohtypes.PanicIfError("Not possible to create token", http.StatusInternalServerError, err)
 
 	return token
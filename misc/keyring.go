@@ -0,0 +1,169 @@
+package misc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// encryptedPrefix marks the values encrypted by a keyring, which are stored as $enc$<key id>$<data>
+const encryptedPrefix = "$enc$"
+
+// TokenLifetimes holds how long each kind of token mailed to the users can be used
+type TokenLifetimes struct {
+	EmailConfirmation time.Duration
+	ChangePassword    time.Duration
+	MagicLink         time.Duration
+}
+
+// DefaultTokenLifetimes are the lifetimes of the mailed tokens unless configured otherwise
+var DefaultTokenLifetimes = TokenLifetimes{
+	EmailConfirmation: 10 * time.Minute,
+	ChangePassword:    10 * time.Minute,
+	MagicLink:         10 * time.Minute,
+}
+
+// Keyring holds the secret keys identified by an id: the active one signs and encrypts, while the retired ones
+// are only used to verify and decrypt what was produced before a rotation. It also holds the lifetimes of the
+// tokens it signs
+type Keyring struct {
+	ActiveID  string
+	Lifetimes TokenLifetimes
+	keys      map[string]string
+}
+
+// Init initializes a keyring from its active key and a list of retired keys in the id:key format
+func (k *Keyring) Init(activeID, activeKey string, retiredKeys []string) (*Keyring, error) {
+	if err := checkKeyID(activeID); err != nil {
+		return nil, err
+	}
+
+	if activeKey == "" {
+		return nil, fmt.Errorf("the active secret key is empty")
+	}
+
+	k.ActiveID = activeID
+	k.Lifetimes = DefaultTokenLifetimes
+	k.keys = map[string]string{activeID: activeKey}
+
+	for _, retired := range retiredKeys {
+		if retired = strings.TrimSpace(retired); retired == "" {
+			continue
+		}
+
+		parts := strings.SplitN(retired, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("retired secret keys must be in the id:key format")
+		}
+
+		if err := checkKeyID(parts[0]); err != nil {
+			return nil, err
+		}
+
+		if _, ok := k.keys[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicated secret key id '%v'", parts[0])
+		}
+
+		k.keys[parts[0]] = parts[1]
+	}
+
+	return k, nil
+}
+
+func checkKeyID(id string) error {
+	if id == "" || strings.ContainsAny(id, "$:, ") {
+		return fmt.Errorf("invalid secret key id '%v'", id)
+	}
+
+	return nil
+}
+
+// Active gets the key used to sign and encrypt
+func (k *Keyring) Active() string {
+	return k.keys[k.ActiveID]
+}
+
+// Get gets a key by its id
+func (k *Keyring) Get(id string) (string, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// Keys lists every key, starting with the active one, so values that predate the key ids can be tried against all of them
+func (k *Keyring) Keys() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		if id != k.ActiveID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	keys := []string{k.Active()}
+	for _, id := range ids {
+		keys = append(keys, k.keys[id])
+	}
+
+	return keys
+}
+
+// Encrypt encrypts a value with the active key, recording the key id along with it
+func (k *Keyring) Encrypt(value string) (string, error) {
+	encrypted, err := EncryptSecret(k.Active(), value)
+	if err != nil {
+		return "", err
+	}
+
+	return encryptedPrefix + k.ActiveID + "$" + encrypted, nil
+}
+
+// Decrypt decrypts a value encrypted by the keyring. Values encrypted before the key ids existed are tried against every key
+func (k *Keyring) Decrypt(encrypted string) (string, error) {
+	if !IsKeyringEncrypted(encrypted) {
+		for _, key := range k.Keys() {
+			if value, err := DecryptSecret(key, encrypted); err == nil {
+				return value, nil
+			}
+		}
+
+		return "", fmt.Errorf("unable to decrypt secret")
+	}
+
+	id, data := splitKeyringEncrypted(encrypted)
+	key, ok := k.Get(id)
+	if !ok {
+		return "", fmt.Errorf("unknown secret key id '%v'", id)
+	}
+
+	return DecryptSecret(key, data)
+}
+
+// NeedsReencrypt tells whether the value was not encrypted by the active key
+func (k *Keyring) NeedsReencrypt(encrypted string) bool {
+	return GetKeyID(encrypted) != k.ActiveID
+}
+
+// IsKeyringEncrypted tells whether the value was encrypted by a keyring
+func IsKeyringEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// GetKeyID gets the id of the key that encrypted a value, empty if it is unknown
+func GetKeyID(encrypted string) string {
+	if !IsKeyringEncrypted(encrypted) {
+		return ""
+	}
+
+	id, _ := splitKeyringEncrypted(encrypted)
+	return id
+}
+
+func splitKeyringEncrypted(encrypted string) (id, data string) {
+	parts := strings.SplitN(strings.TrimPrefix(encrypted, encryptedPrefix), "$", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	return parts[0], parts[1]
+}
@@ -0,0 +1,97 @@
+package misc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyringInit(t *testing.T) {
+	invalid := []struct {
+		activeID    string
+		activeKey   string
+		retiredKeys []string
+	}{
+		{"", "secret", nil},
+		{"1", "", nil},
+		{"a$b", "secret", nil},
+		{"1", "secret", []string{"old"}},
+		{"1", "secret", []string{"1:old"}},
+		{"1", "secret", []string{"2:"}},
+	}
+
+	for _, test := range invalid {
+		if _, err := new(Keyring).Init(test.activeID, test.activeKey, test.retiredKeys); err == nil {
+			t.Errorf("expected %+v to be refused", test)
+		}
+	}
+
+	keyring := newTestKeyring(t, "2", "new", "1:old:with:colons", " ")
+	if key, ok := keyring.Get("1"); !ok || key != "old:with:colons" {
+		t.Errorf("unexpected retired key %v", key)
+	}
+}
+
+func TestKeyringEncrypt(t *testing.T) {
+	old := newTestKeyring(t, "1", "old")
+	encrypted, err := old.Encrypt("value")
+	if err != nil || GetKeyID(encrypted) != "1" {
+		t.Fatalf("unexpected encrypted value %v (%v)", encrypted, err)
+	}
+
+	legacy, _ := EncryptSecret("old", "value")
+
+	keyring := newTestKeyring(t, "2", "new", "1:old")
+	for _, value := range []string{encrypted, legacy} {
+		if decrypted, err := keyring.Decrypt(value); err != nil || decrypted != "value" {
+			t.Errorf("expected %v to be decrypted, got %v (%v)", value, decrypted, err)
+		}
+
+		if !keyring.NeedsReencrypt(value) {
+			t.Errorf("expected %v to need a reencryption", value)
+		}
+	}
+
+	if _, err := newTestKeyring(t, "2", "new").Decrypt(encrypted); err == nil {
+		t.Error("values of unknown keys should not be decrypted")
+	}
+}
+
+func TestKeyringTokens(t *testing.T) {
+	old := newTestKeyring(t, "1", "old")
+	token := GetChangePasswordToken(old, "user", "stamp", "")
+
+	if _, err := ParseToken(token, newTestKeyring(t, "2", "new", "1:old")); err != nil {
+		t.Errorf("tokens signed by a retired key should be accepted: %v", err)
+	}
+
+	if _, err := ParseToken(token, newTestKeyring(t, "2", "new")); err == nil {
+		t.Error("tokens signed by a removed key should be refused")
+	}
+
+	if _, err := ParseToken(token, newTestKeyring(t, "1", "other")); err == nil {
+		t.Error("tokens signed by another key with the same id should be refused")
+	}
+}
+
+func TestSingleUseTokens(t *testing.T) {
+	keyring := newTestKeyring(t, "1", "key")
+
+	claims, err := ParseToken(GetChangePasswordToken(keyring, "user", "stamp", ""), keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	username, _, singleUse, err := UnmarshalChangePasswordToken(claims)
+	if err != nil || username != "user" || singleUse.ID == "" || singleUse.Stamp != "stamp" {
+		t.Errorf("expected a single use token of user, got %v %+v (%v)", username, singleUse, err)
+	}
+
+	if !singleUse.ExpiresAt.After(time.Now()) || singleUse.ExpiresAt.After(time.Now().Add(keyring.Lifetimes.ChangePassword)) {
+		t.Errorf("the token should expire within its lifetime, got %v", singleUse.ExpiresAt)
+	}
+
+	keyring.Lifetimes.ChangePassword = -time.Minute
+	if _, err := ParseToken(GetChangePasswordToken(keyring, "user", "stamp", ""), keyring); err == nil {
+		t.Error("expired tokens should be refused")
+	}
+}
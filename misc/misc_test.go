@@ -26,3 +26,34 @@ func TestValidatePassword(t *testing.T) {
 		}
 	}
 }
+
+func TestNewGrantScopes(t *testing.T) {
+	schema, err := NewProfileSchema([]ProfileAttribute{{Name: "given_name", Label: "Given name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []ScopeClaim{
+		{Claim: "sub", Attribute: ClaimFromUsername},
+		{Claim: "tenant"},
+		{Claim: "tenant", Attribute: ClaimFromEmail, Value: "acme"},
+		{Claim: "dept", Attribute: "department"},
+		{Claim: "mail", Attribute: ClaimFromEmail, ClientNamespace: true},
+	}
+
+	for _, claim := range invalid {
+		if _, err := NewGrantScopes(GrantScopes{"org": {Scope: "org", Claims: []ScopeClaim{claim}}}, schema); err == nil {
+			t.Errorf("expected the claim %v refused", claim)
+		}
+	}
+
+	scopes, err := NewGrantScopes(GrantScopes{"profile": {Scope: ScopeProfile, Claims: []ScopeClaim{{Claim: "updated_at", Value: 0}}}}, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := scopes[ScopeProfile].Claims
+	if len(claims) != 3 || claims[0].Value != 0 || claims[1].Claim != "preferred_username" || claims[2].Label != "Given name" {
+		t.Errorf("expected the declared claim, then the standard one left and the profile attribute, got %v", claims)
+	}
+}
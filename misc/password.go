@@ -26,17 +26,45 @@ var passwordTooltipTemplate = `
 </div>
 `
 
+// PasswordPolicy defines the rules a valid password follows
+type PasswordPolicy struct {
+	MinChar       int `json:"min_characters"`
+	MaxChar       int `json:"max_characters"`
+	MinUniqueChar int `json:"min_unique_characters"`
+}
+
+// DefaultPasswordPolicy is the password policy of the default realm
+var DefaultPasswordPolicy = PasswordPolicy{MinChar: PasswordMinChar, MaxChar: PasswordMaxChar, MinUniqueChar: PasswordMinUniqueChar}
+
 // PasswordTooltip is an explanation snippet of the rules to a valid password
-var PasswordTooltip = fmt.Sprintf(passwordTooltipTemplate, PasswordMinChar, PasswordMaxChar, PasswordMinUniqueChar)
+var PasswordTooltip = DefaultPasswordPolicy.Tooltip()
 
-// ValidatePassword verify if a password is valid
-func ValidatePassword(password, username, email string) error {
-	if len(password) < PasswordMinChar {
-		return fmt.Errorf("password should have at least %v characters", PasswordMinChar)
+// Check verifies the rules of the policy can be followed
+func (policy PasswordPolicy) Check() error {
+	if policy.MinChar <= 0 || policy.MaxChar < policy.MinChar {
+		return fmt.Errorf("the password policy should have a positive minimum of characters, up to its maximum")
+	}
+
+	if policy.MinUniqueChar < 0 || policy.MinUniqueChar > policy.MaxChar {
+		return fmt.Errorf("the password policy should not ask for more unique characters than its maximum of characters")
+	}
+
+	return nil
+}
+
+// Tooltip gets an explanation snippet of the rules of the policy
+func (policy PasswordPolicy) Tooltip() string {
+	return fmt.Sprintf(passwordTooltipTemplate, policy.MinChar, policy.MaxChar, policy.MinUniqueChar)
+}
+
+// Validate verifies if a password follows the policy
+func (policy PasswordPolicy) Validate(password, username, email string) error {
+	if len(password) < policy.MinChar {
+		return fmt.Errorf("password should have at least %v characters", policy.MinChar)
 	}
 
-	if len(password) > PasswordMaxChar {
-		return fmt.Errorf("password should have at most %v characters", PasswordMaxChar)
+	if len(password) > policy.MaxChar {
+		return fmt.Errorf("password should have at most %v characters", policy.MaxChar)
 	}
 
 	pass := strings.ToLower(password)
@@ -51,9 +79,14 @@ func ValidatePassword(password, username, email string) error {
 		return fmt.Errorf("password is too similar to your email")
 	}
 
-	if CountUniqueCharacters(pass) < PasswordMinUniqueChar {
-		return fmt.Errorf("password should have at least %v unique characters", PasswordMinUniqueChar)
+	if CountUniqueCharacters(pass) < policy.MinUniqueChar {
+		return fmt.Errorf("password should have at least %v unique characters", policy.MinUniqueChar)
 	}
 
 	return nil
 }
+
+// ValidatePassword verify if a password is valid under the default password policy
+func ValidatePassword(password, username, email string) error {
+	return DefaultPasswordPolicy.Validate(password, username, email)
+}
@@ -0,0 +1,218 @@
+package misc
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Types of the values of the profile attributes
+const (
+	AttributeString   = "string"
+	AttributeURL      = "url"
+	AttributePhone    = "phone"
+	AttributeLocale   = "locale"
+	AttributeZoneinfo = "zoneinfo"
+)
+
+// Who changes the profile attributes, which decides the attributes that can be changed
+const (
+	ProfileByRegistration = "registration"
+	ProfileByUser         = "user"
+	ProfileByAdmin        = "admin"
+)
+
+// defaultAttributeMaxLength is the longest an attribute value can be when the schema does not say
+const defaultAttributeMaxLength = 255
+
+var (
+	attributeNameRegex   = regexp.MustCompile("^[a-z][a-z0-9_]{0,62}$")
+	attributePhoneRegex  = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+	attributeLocaleRegex = regexp.MustCompile("^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$")
+)
+
+// standardAttributeScopes are the OpenID Connect scopes that release the standard claims, which the attributes of
+// the same name are released with unless the schema says otherwise
+var standardAttributeScopes = map[string]string{
+	"name":         "profile",
+	"given_name":   "profile",
+	"family_name":  "profile",
+	"middle_name":  "profile",
+	"nickname":     "profile",
+	"profile":      "profile",
+	"picture":      "profile",
+	"website":      "profile",
+	"gender":       "profile",
+	"birthdate":    "profile",
+	"zoneinfo":     "profile",
+	"locale":       "profile",
+	"phone_number": "phone",
+	"address":      "address",
+}
+
+// reservedAttributeNames are claims whisper already sets, which no attribute can take, as neither can the protocol claims
+var reservedAttributeNames = map[string]bool{
+	"email": true, "email_verified": true, "preferred_username": true, "updated_at": true, "username": true,
+	"password": true, "groups": true, "roles": true,
+}
+
+// ProfileAttribute defines an attribute of the user profiles
+type ProfileAttribute struct {
+	Name      string `json:"name"`
+	Label     string `json:"label"`
+	Type      string `json:"type"`
+	Required  bool   `json:"required"`
+	Editable  bool   `json:"editable"`   // the users change it from the update page
+	AdminOnly bool   `json:"admin_only"` // only the admin apis set it
+	MaxLength int    `json:"max_length"`
+	Pattern   string `json:"pattern"`
+	Scope     string `json:"scope"` // releases it as a claim of its name when granted
+	pattern   *regexp.Regexp
+}
+
+// ProfileSchema lists the attributes of the user profiles, in the order they are shown
+type ProfileSchema []ProfileAttribute
+
+// NewProfileSchema checks the attributes of a profile schema, filling in their defaults
+func NewProfileSchema(attributes []ProfileAttribute) (ProfileSchema, error) {
+	schema := make(ProfileSchema, 0, len(attributes))
+	for _, attribute := range attributes {
+		if !attributeNameRegex.MatchString(attribute.Name) {
+			return nil, fmt.Errorf("invalid profile attribute name '%v'", attribute.Name)
+		}
+
+		if protocolClaims[attribute.Name] || reservedAttributeNames[attribute.Name] {
+			return nil, fmt.Errorf("the profile attribute name '%v' is reserved", attribute.Name)
+		}
+
+		if _, ok := schema.Get(attribute.Name); ok {
+			return nil, fmt.Errorf("the profile attribute '%v' is defined twice", attribute.Name)
+		}
+
+		switch attribute.Type {
+		case "":
+			attribute.Type = AttributeString
+		case AttributeString, AttributeURL, AttributePhone, AttributeLocale, AttributeZoneinfo:
+		default:
+			return nil, fmt.Errorf("invalid type '%v' for the profile attribute '%v'", attribute.Type, attribute.Name)
+		}
+
+		if attribute.Pattern != "" {
+			pattern, err := regexp.Compile("^(?:" + attribute.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern for the profile attribute '%v': %v", attribute.Name, err)
+			}
+			attribute.pattern = pattern
+		}
+
+		if attribute.Label == "" {
+			attribute.Label = attribute.Name
+		}
+
+		if attribute.MaxLength <= 0 {
+			attribute.MaxLength = defaultAttributeMaxLength
+		}
+
+		if attribute.Scope == "" {
+			attribute.Scope = standardAttributeScopes[attribute.Name]
+		}
+
+		schema = append(schema, attribute)
+	}
+
+	return schema, nil
+}
+
+// Get gets an attribute of the schema by its name
+func (schema ProfileSchema) Get(name string) (ProfileAttribute, bool) {
+	for _, attribute := range schema {
+		if attribute.Name == name {
+			return attribute, true
+		}
+	}
+
+	return ProfileAttribute{}, false
+}
+
+// CanChange tells if an attribute can be changed by who, one of the ProfileBy constants
+func (attribute ProfileAttribute) CanChange(by string) bool {
+	switch by {
+	case ProfileByAdmin:
+		return true
+	case ProfileByRegistration:
+		return !attribute.AdminOnly
+	default:
+		return attribute.Editable && !attribute.AdminOnly
+	}
+}
+
+// CheckProfileAttributes validates the attribute values changed by who, one of the ProfileBy constants, returning
+// them trimmed. An empty value removes an attribute. The registrations must also give every required attribute
+// they can set
+func (schema ProfileSchema) CheckProfileAttributes(values map[string]string, by string) (map[string]string, error) {
+	checked := make(map[string]string, len(values))
+	for name, value := range values {
+		attribute, ok := schema.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown profile attribute '%v'", name)
+		}
+
+		if !attribute.CanChange(by) {
+			return nil, fmt.Errorf("the profile attribute '%v' cannot be changed", attribute.Label)
+		}
+
+		value = strings.TrimSpace(value)
+		if err := attribute.validate(value); err != nil {
+			return nil, err
+		}
+
+		checked[name] = value
+	}
+
+	if by == ProfileByRegistration {
+		for _, attribute := range schema {
+			if attribute.Required && attribute.CanChange(by) && checked[attribute.Name] == "" {
+				return nil, fmt.Errorf("the profile attribute '%v' is required", attribute.Label)
+			}
+		}
+	}
+
+	return checked, nil
+}
+
+// validate checks a trimmed value of the attribute
+func (attribute ProfileAttribute) validate(value string) error {
+	if value == "" {
+		if attribute.Required {
+			return fmt.Errorf("the profile attribute '%v' is required", attribute.Label)
+		}
+		return nil
+	}
+
+	if utf8.RuneCountInString(value) > attribute.MaxLength {
+		return fmt.Errorf("the profile attribute '%v' should have at most %v characters", attribute.Label, attribute.MaxLength)
+	}
+
+	valid := true
+	switch attribute.Type {
+	case AttributeURL:
+		u, err := url.Parse(value)
+		valid = err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	case AttributePhone:
+		valid = attributePhoneRegex.MatchString(value)
+	case AttributeLocale:
+		valid = attributeLocaleRegex.MatchString(value)
+	case AttributeZoneinfo:
+		_, err := time.LoadLocation(value)
+		valid = err == nil && value != "Local"
+	}
+
+	if !valid || (attribute.pattern != nil && !attribute.pattern.MatchString(value)) {
+		return fmt.Errorf("invalid value for the profile attribute '%v'", attribute.Label)
+	}
+
+	return nil
+}
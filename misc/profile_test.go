@@ -0,0 +1,73 @@
+package misc
+
+import "testing"
+
+func TestNewProfileSchema(t *testing.T) {
+	invalid := [][]ProfileAttribute{
+		{{Name: "Given Name"}},
+		{{Name: "email"}},
+		{{Name: "sid"}},
+		{{Name: "nickname"}, {Name: "nickname"}},
+		{{Name: "nickname", Type: "number"}},
+		{{Name: "nickname", Pattern: "("}},
+	}
+
+	for _, attributes := range invalid {
+		if _, err := NewProfileSchema(attributes); err == nil {
+			t.Errorf("expected the schema %v refused", attributes)
+		}
+	}
+
+	schema, err := NewProfileSchema([]ProfileAttribute{{Name: "phone_number", Type: AttributePhone}, {Name: "department"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if phone, _ := schema.Get("phone_number"); phone.Scope != "phone" || phone.Label != "phone_number" {
+		t.Errorf("the standard attributes should default to their scope, got %v", phone)
+	}
+
+	if department, _ := schema.Get("department"); department.Scope != "" || department.Type != AttributeString || department.MaxLength != defaultAttributeMaxLength {
+		t.Errorf("the custom attributes should not be released by default, got %v", department)
+	}
+}
+
+func TestCheckProfileAttributes(t *testing.T) {
+	schema, err := NewProfileSchema([]ProfileAttribute{
+		{Name: "given_name", Required: true, Editable: true},
+		{Name: "picture", Type: AttributeURL, Editable: true},
+		{Name: "zoneinfo", Type: AttributeZoneinfo},
+		{Name: "employee_id", Required: true, AdminOnly: true, Pattern: "[0-9]{4}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if values, err := schema.CheckProfileAttributes(map[string]string{"given_name": " Alice ", "zoneinfo": "UTC"}, ProfileByRegistration); err != nil || values["given_name"] != "Alice" {
+		t.Errorf("expected the registration accepted and trimmed, got %v (%v)", values, err)
+	}
+
+	invalid := []struct {
+		values map[string]string
+		by     string
+	}{
+		{map[string]string{"zoneinfo": "UTC"}, ProfileByRegistration},                            // missing a required attribute
+		{map[string]string{"given_name": "Alice", "employee_id": "1234"}, ProfileByRegistration}, // admin only
+		{map[string]string{"zoneinfo": "UTC"}, ProfileByUser},                                    // not editable
+		{map[string]string{"given_name": ""}, ProfileByUser},                                     // removing a required attribute
+		{map[string]string{"picture": "javascript:alert(1)"}, ProfileByUser},
+		{map[string]string{"nickname": "al"}, ProfileByAdmin},
+		{map[string]string{"zoneinfo": "Mars/Olympus"}, ProfileByAdmin},
+		{map[string]string{"employee_id": "12a4"}, ProfileByAdmin},
+	}
+
+	for _, c := range invalid {
+		if _, err := schema.CheckProfileAttributes(c.values, c.by); err == nil {
+			t.Errorf("expected %v by %v refused", c.values, c.by)
+		}
+	}
+
+	if _, err := schema.CheckProfileAttributes(map[string]string{"employee_id": "1234", "picture": ""}, ProfileByAdmin); err != nil {
+		t.Errorf("the admins should set any attribute, got %v", err)
+	}
+}
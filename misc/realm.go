@@ -0,0 +1,70 @@
+package misc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RealmSeparator separates the realm from the username in the hydra subjects of the users of a realm other than the
+// default one, e.g. sales/alice. The usernames can not have it, so the subjects of two realms never match
+const RealmSeparator = "/"
+
+// realmIDRegex matches the ids of the realms, which name them in the hydra subjects and the client metadata
+var realmIDRegex = regexp.MustCompile("^[a-z0-9][a-z0-9-]{0,62}$")
+
+// CheckRealmID verifies the id of a realm other than the default one
+func CheckRealmID(id string) error {
+	if !realmIDRegex.MatchString(id) {
+		return fmt.Errorf("invalid realm id '%v', it should have up to 63 lowercase letters, digits or dashes", id)
+	}
+
+	return nil
+}
+
+// CheckUsername verifies a username may name a user of any realm
+func CheckUsername(username string) error {
+	if strings.Contains(username, RealmSeparator) {
+		return fmt.Errorf("the username should not have a '%v'", RealmSeparator)
+	}
+
+	return nil
+}
+
+// GetRealmSubject gets the hydra subject of a user of a realm. The users of the default realm keep their username
+func GetRealmSubject(realm, username string) string {
+	if realm == "" {
+		return username
+	}
+
+	return realm + RealmSeparator + username
+}
+
+// GetRealmUsername gets the username of a hydra subject, telling whether the subject belongs to the realm.
+// When other realms are served, the subjects of the default realm can not look like theirs
+func GetRealmUsername(realm, subject string, isolated bool) (string, bool) {
+	if realm == "" {
+		return subject, !isolated || !strings.Contains(subject, RealmSeparator)
+	}
+
+	username := strings.TrimPrefix(subject, realm+RealmSeparator)
+	return username, username != subject && !strings.Contains(username, RealmSeparator)
+}
+
+// GetSubjectRealm gets the realm a hydra subject belongs to, the default one when it has no realm
+func GetSubjectRealm(subject string) string {
+	if i := strings.Index(subject, RealmSeparator); i >= 0 {
+		return subject[:i]
+	}
+
+	return ""
+}
+
+// GetClientRealm gets the realm the client of a hydra login request names in its metadata, telling whether it names one
+func GetClientRealm(info map[string]interface{}) (string, bool) {
+	client, _ := info["client"].(map[string]interface{})
+	metadata, _ := client["metadata"].(map[string]interface{})
+	realm, ok := metadata["realm"].(string)
+
+	return realm, ok
+}
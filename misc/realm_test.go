@@ -0,0 +1,65 @@
+package misc
+
+import "testing"
+
+var testGetRealmUsernameData = []struct {
+	realm    string
+	subject  string
+	isolated bool
+	username string
+	ok       bool
+}{
+	{"", "alice", false, "alice", true},
+	{"", "sales/alice", false, "sales/alice", true},
+	{"", "sales/alice", true, "sales/alice", false},
+	{"sales", "sales/alice", true, "alice", true},
+	{"sales", "alice", true, "alice", false},
+	{"sales", "marketing/alice", true, "marketing/alice", false},
+	{"sales", "sales/marketing/alice", true, "marketing/alice", false},
+}
+
+func TestGetRealmUsername(t *testing.T) {
+	for _, test := range testGetRealmUsernameData {
+		username, ok := GetRealmUsername(test.realm, test.subject, test.isolated)
+		if username != test.username || ok != test.ok {
+			t.Errorf("%v in '%v': expected %v %v, got %v %v", test.subject, test.realm, test.username, test.ok, username, ok)
+		}
+	}
+
+	if subject := GetRealmSubject("sales", "alice"); subject != "sales/alice" || GetSubjectRealm(subject) != "sales" {
+		t.Errorf("expected the subject of the sales realm, got %v", subject)
+	}
+
+	if subject := GetRealmSubject("", "alice"); subject != "alice" || GetSubjectRealm(subject) != "" {
+		t.Errorf("the subjects of the default realm should be the username, got %v", subject)
+	}
+}
+
+func TestCheckRealmID(t *testing.T) {
+	for _, id := range []string{"", "Sales", "-sales", "sales/eu", "sales eu"} {
+		if CheckRealmID(id) == nil {
+			t.Errorf("expected the realm id '%v' refused", id)
+		}
+	}
+
+	if err := CheckRealmID("sales-eu"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinChar: 4, MaxChar: 8, MinUniqueChar: 3}
+	if err := policy.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	if policy.Validate("qwer", "alice", "alice@example.com") != nil || policy.Validate("qwertyuio", "alice", "alice@example.com") == nil || policy.Validate("aaab", "alice", "alice@example.com") == nil {
+		t.Error("expected the rules of the policy followed")
+	}
+
+	for _, invalid := range []PasswordPolicy{{}, {MinChar: 8, MaxChar: 4}, {MinChar: 4, MaxChar: 8, MinUniqueChar: 9}} {
+		if invalid.Check() == nil {
+			t.Errorf("expected the policy %+v refused", invalid)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package misc
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+const (
+	// RecoveryCodeCount is the number of recovery codes generated at once
+	RecoveryCodeCount = 10
+	// recoveryCodeAlphabet avoids characters that are easily mistaken for one another
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeLength   = 10
+)
+
+// GenerateRecoveryCode generates a random single use code formatted as two groups of five characters, every character
+// of the alphabet being equally likely
+func GenerateRecoveryCode() (string, error) {
+	alphabetLength := big.NewInt(int64(len(recoveryCodeAlphabet)))
+
+	code := make([]byte, 0, recoveryCodeLength+1)
+	for i := 0; i < recoveryCodeLength; i++ {
+		if i == recoveryCodeLength/2 {
+			code = append(code, '-')
+		}
+
+		index, err := rand.Int(rand.Reader, alphabetLength)
+		if err != nil {
+			return "", err
+		}
+		code = append(code, recoveryCodeAlphabet[index.Int64()])
+	}
+
+	return string(code), nil
+}
+
+// NormalizeRecoveryCode removes the formatting the user may have typed along with a recovery code
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
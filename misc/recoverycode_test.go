@@ -0,0 +1,18 @@
+package misc
+
+import "testing"
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	code, err := GenerateRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(code) != recoveryCodeLength+1 || code[recoveryCodeLength/2] != '-' {
+		t.Errorf("unexpected recovery code format '%v'", code)
+	}
+
+	if NormalizeRecoveryCode(" "+code[:3]+" "+code[3:]+" ") != NormalizeRecoveryCode(code) {
+		t.Error("formatting should not change a normalized recovery code")
+	}
+}
@@ -0,0 +1,106 @@
+package misc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TOTPDigits is the number of digits of a generated TOTP code
+	TOTPDigits = 6
+	// TOTPPeriod is the number of seconds a TOTP code stays valid
+	TOTPPeriod = 30
+	// TOTPSkew is the number of periods before and after the current one that are also accepted
+	TOTPSkew = 1
+	// TOTPIssuer is the issuer name displayed by authenticator apps
+	TOTPIssuer = "Whisper"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates a base32 encoded secret with 20 bytes of crypto/rand data.
+func GenerateTOTPSecret() (string, error) {
+	randomBytes := make([]byte, 20)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(randomBytes), nil
+}
+
+// GetTOTPStep gets the RFC 6238 time step of a given instant
+func GetTOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// GetTOTPCode computes the RFC 6238 code of a base32 encoded secret for a given time step
+func GetTOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret")
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// ValidateTOTPCode verifies a code against a secret, accepting the steps within the allowed skew after lastStep.
+// It returns the matched time step so that callers can prevent the same code from being replayed
+func ValidateTOTPCode(secret, code string, t time.Time, lastStep int64) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, fmt.Errorf("the code should have %v digits", TOTPDigits)
+	}
+
+	current := GetTOTPStep(t)
+	for step := current - TOTPSkew; step <= current+TOTPSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := GetTOTPCode(secret, step)
+		if err != nil {
+			return 0, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid code")
+}
+
+// GetTOTPURI builds the otpauth uri used to enroll the secret in an authenticator app
+func GetTOTPURI(secret, username string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", TOTPIssuer)
+	params.Set("digits", fmt.Sprint(TOTPDigits))
+	params.Set("period", fmt.Sprint(TOTPPeriod))
+
+	label := url.PathEscape(TOTPIssuer + ":" + username)
+	return fmt.Sprintf("otpauth://totp/%v?%v", label, params.Encode())
+}
@@ -0,0 +1,64 @@
+package misc
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B vectors for SHA1, truncated to 6 digits
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+var testGetTOTPCodeData = []struct {
+	unix   int64
+	output string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestGetTOTPCode(t *testing.T) {
+	for _, test := range testGetTOTPCodeData {
+		code, err := GetTOTPCode(rfcSecret, GetTOTPStep(time.Unix(test.unix, 0)))
+		if err != nil || code != test.output {
+			t.Errorf("expected %v at %v, got %v (%v)", test.output, test.unix, code, err)
+		}
+	}
+}
+
+func TestValidateTOTPCode(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := GetTOTPCode(rfcSecret, GetTOTPStep(now)-1)
+
+	step, err := ValidateTOTPCode(rfcSecret, code, now, 0)
+	if err != nil {
+		t.Fatalf("code within skew should be accepted: %v", err)
+	}
+
+	if _, err := ValidateTOTPCode(rfcSecret, code, now, step); err == nil {
+		t.Error("replayed code should be rejected")
+	}
+
+	if _, err := ValidateTOTPCode(rfcSecret, code, now.Add(3*TOTPPeriod*time.Second), 0); err == nil {
+		t.Error("code outside skew should be rejected")
+	}
+}
+
+func TestEncryptSecret(t *testing.T) {
+	encrypted, err := EncryptSecret("key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decrypted, err := DecryptSecret("key", encrypted); err != nil || decrypted != "secret" {
+		t.Errorf("unable to decrypt secret: %v", err)
+	}
+
+	if _, err := DecryptSecret("another key", encrypted); err == nil {
+		t.Error("secret should not be decrypted with another key")
+	}
+}
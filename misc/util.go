@@ -2,7 +2,10 @@ package misc
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"regexp"
+	"strings"
 )
 
 // CountUniqueCharacters counts the unique characters in a string
@@ -26,3 +29,18 @@ func VerifyEmail(email string) error {
 
 	return nil
 }
+
+// GetClientIP gets the ip of the client that sent the request. The X-Forwarded-For header is only trusted when whisper
+// runs behind a proxy that sets it, otherwise clients could forge it
+func GetClientIP(r *http.Request, trustForwardedFor bool) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); trustForwardedFor && forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
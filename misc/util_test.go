@@ -1,6 +1,9 @@
 package misc
 
-import "testing"
+import (
+	"net/http"
+	"testing"
+)
 
 var testCountUniqueCharactersData = []struct {
 	input  string
@@ -23,3 +26,17 @@ func TestCountUniqueCharacters(t *testing.T) {
 		}
 	}
 }
+
+func TestGetClientIP(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "10.0.0.1:51234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	if ip := GetClientIP(r, false); ip != "10.0.0.1" {
+		t.Errorf("expected the remote address, got %v", ip)
+	}
+
+	if ip := GetClientIP(r, true); ip != "203.0.113.7" {
+		t.Errorf("expected the forwarded address, got %v", ip)
+	}
+}
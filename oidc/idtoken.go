@@ -0,0 +1,245 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// refetchInterval bounds how often unknown key ids make the key set be fetched again
+const refetchInterval = time.Minute
+
+// signingMethods are the asymmetric algorithms accepted for the ID tokens. The symmetric ones would make the client
+// secret a signing key, and none is never accepted
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// keySet caches the public keys published at the jwks_uri of a provider (RFC 7517)
+type keySet struct {
+	uri       string
+	client    *http.Client
+	mutex     sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// jsonWebKey holds the members of the RSA and EC json web keys (RFC 7518 §6)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verifyIDToken checks the signature and the claims of an ID token (OpenID Connect Core §3.1.3.7)
+func (p *Provider) verifyIDToken(raw, issuer, nonce string) (Claims, error) {
+	parser := &jwt.Parser{ValidMethods: signingMethods}
+	token, err := parser.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.get(kid)
+	})
+	if err != nil {
+		return Claims{}, fmt.Errorf("invalid id token from '%v': %v", p.ID, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Claims{}, fmt.Errorf("unable to read the id token claims from '%v'", p.ID)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != issuer {
+		return Claims{}, fmt.Errorf("the id token from '%v' was issued by '%v'", p.ID, iss)
+	}
+
+	audiences := getAudiences(claims["aud"])
+	if !contains(audiences, p.ClientID) {
+		return Claims{}, fmt.Errorf("the id token from '%v' is not meant for this client", p.ID)
+	}
+
+	if azp, ok := claims["azp"].(string); (ok || len(audiences) > 1) && azp != p.ClientID {
+		return Claims{}, fmt.Errorf("the id token from '%v' was authorized for another party", p.ID)
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return Claims{}, fmt.Errorf("the id token from '%v' does not expire", p.ID)
+	}
+
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return Claims{}, fmt.Errorf("the id token from '%v' does not carry the nonce of this login", p.ID)
+	}
+
+	result := Claims{}
+	result.Subject, _ = claims["sub"].(string)
+	result.Email, _ = claims["email"].(string)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	result.Name, _ = claims["name"].(string)
+
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		result.EmailVerified = verified
+	case string: // as sent by some providers
+		result.EmailVerified = verified == "true"
+	}
+
+	if result.Subject == "" {
+		return Claims{}, fmt.Errorf("the id token from '%v' has no subject", p.ID)
+	}
+
+	return result, nil
+}
+
+// get gets the key of an id, fetching the key set when the id is unknown, as the provider may have rotated its keys
+func (ks *keySet) get(kid string) (crypto.PublicKey, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	key, ok := ks.find(kid)
+	if !ok && time.Since(ks.fetchedAt) > refetchInterval {
+		if err := ks.fetch(); err != nil {
+			return nil, err
+		}
+		key, ok = ks.find(kid)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key id '%v'", kid)
+	}
+
+	return key, nil
+}
+
+// find finds the key of an id, or the only key when the token names none
+func (ks *keySet) find(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *keySet) fetch() error {
+	resp, err := ks.client.Get(ks.uri)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil || resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch the keys at %v (%v): %v", ks.uri, resp.StatusCode, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	ks.keys = keys
+	ks.fetchedAt = time.Now()
+	return nil
+}
+
+// publicKey decodes the RSA and EC keys, the only ones usable with the accepted signing methods
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid rsa exponent")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%v'", jwk.Crv)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("the ec key is not on its curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type '%v'", jwk.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := Encoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// getAudiences reads the aud claim, either a string or an array of strings
+func getAudiences(aud interface{}) []string {
+	switch aud := aud.(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		audiences := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+
+	return nil
+}
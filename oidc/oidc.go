@@ -0,0 +1,226 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	randomSize    = 32
+	timeout       = 10 * time.Second
+)
+
+// Encoding is the encoding of the states, nonces and code verifiers
+var Encoding = base64.RawURLEncoding
+
+// ProviderConfig holds how to reach an upstream OpenID Connect provider, as read from the providers file
+type ProviderConfig struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// Claims holds the claims of a verified ID token that whisper relies on
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// Provider is an upstream OpenID Connect provider whisper brokers logins to. Its metadata is discovered on first use
+type Provider struct {
+	ProviderConfig
+	client   *http.Client
+	mutex    sync.Mutex
+	metadata *metadata
+	keys     *keySet
+}
+
+// metadata holds the part of the provider metadata (OpenID Connect Discovery §3) used by the authorization code flow
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// tokenResponse holds the response of the token endpoint (OpenID Connect Core §3.1.3.3)
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// NewProvider builds a provider, checking its configuration
+func NewProvider(config ProviderConfig) (*Provider, error) {
+	if config.ID == "" || config.ClientID == "" {
+		return nil, fmt.Errorf("the upstream providers need an id and a client_id")
+	}
+
+	if u, err := url.Parse(config.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid issuer '%v' for the upstream provider '%v'", config.Issuer, config.ID)
+	}
+
+	if config.Name == "" {
+		config.Name = config.ID
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	} else if !contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	return &Provider{ProviderConfig: config, client: client, keys: &keySet{client: client}}, nil
+}
+
+// NewRandom generates a random value for the states, nonces and code verifiers
+func NewRandom() (string, error) {
+	b := make([]byte, randomSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return Encoding.EncodeToString(b), nil
+}
+
+// GetAuthorizationURL builds the url the browser is sent to so the user signs in at the provider, using PKCE (RFC 7636)
+func (p *Provider) GetAuthorizationURL(redirectURI, state, nonce, codeVerifier string) (string, error) {
+	m, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Encoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return m.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint, returning the claims of the verified ID token
+func (p *Provider) Exchange(code, redirectURI, codeVerifier, nonce string) (Claims, error) {
+	m, err := p.discover()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret)) // client_secret_basic (RFC 6749 §2.3.1)
+
+	var response tokenResponse
+	status, err := p.getJSON(req, &response)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if status != http.StatusOK || response.IDToken == "" {
+		return Claims{}, fmt.Errorf("the token endpoint of '%v' refused the code (%v): %v %v", p.ID, status, response.Error, response.ErrorDescription)
+	}
+
+	return p.verifyIDToken(response.IDToken, m.Issuer, nonce)
+}
+
+// discover fetches the provider metadata, keeping it once fetched
+func (p *Provider) discover() (*metadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(p.Issuer, "/")+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var m metadata
+	status, err := p.getJSON(req, &m)
+	if err != nil || status != http.StatusOK {
+		return nil, fmt.Errorf("unable to discover the upstream provider '%v' (%v): %v", p.ID, status, err)
+	}
+
+	if m.Issuer != p.Issuer { // OpenID Connect Discovery §4.3
+		return nil, fmt.Errorf("the upstream provider '%v' announces the issuer '%v' instead of '%v'", p.ID, m.Issuer, p.Issuer)
+	}
+
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("the upstream provider '%v' lacks an authorization, token or jwks endpoint", p.ID)
+	}
+
+	p.keys.uri = m.JWKSURI
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// getJSON sends a request and decodes its json response, whatever its status
+func (p *Provider) getJSON(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return resp.StatusCode, fmt.Errorf("unable to decode the response of %v: %v", req.URL, err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
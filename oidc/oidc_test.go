@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// testProvider is a local stand-in of an OpenID Connect provider that signs in whoever it is told to
+type testProvider struct {
+	*httptest.Server
+	key           *rsa.PrivateKey
+	kid           string
+	codeChallenge string
+	claims        jwt.MapClaims
+	method        jwt.SigningMethod
+	signingKey    interface{}
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProvider{key: key, kid: "1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": p.kid,
+			"use": "sig",
+			"n":   Encoding.EncodeToString(p.key.N.Bytes()),
+			"e":   Encoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if id != "whisper" || secret != "secret" || r.PostFormValue("code") != "code" || Encoding.EncodeToString(verifier[:]) != p.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(p.method, p.claims)
+		token.Header["kid"] = p.kid
+		idToken, _ := token.SignedString(p.signingKey)
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+	})
+	p.Server = httptest.NewServer(mux)
+
+	return p
+}
+
+// signIn has the provider sign in a user with the given claims, overriding the default ones
+func (p *testProvider) signIn(claims jwt.MapClaims) {
+	p.method = jwt.SigningMethodRS256
+	p.signingKey = p.key
+	p.claims = jwt.MapClaims{
+		"iss":            p.URL,
+		"sub":            "upstream-alice",
+		"aud":            "whisper",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          "nonce",
+		"email":          "alice@example.com",
+		"email_verified": true,
+	}
+
+	for name, value := range claims {
+		if value == nil {
+			delete(p.claims, name)
+		} else {
+			p.claims[name] = value
+		}
+	}
+}
+
+func newTestOIDCProvider(t *testing.T, p *testProvider) *Provider {
+	provider, err := NewProvider(ProviderConfig{ID: "test", Issuer: p.URL, ClientID: "whisper", ClientSecret: "secret", Scopes: []string{"email"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+// authorize starts a login as the browser would, keeping the code challenge sent to the provider
+func authorize(t *testing.T, p *testProvider, provider *Provider) {
+	authorizationURL, err := provider.GetAuthorizationURL("http://whisper/callback", "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse(authorizationURL)
+	query := u.Query()
+	if !strings.HasPrefix(authorizationURL, p.URL+"/authorize?") || query.Get("scope") != "openid email" || query.Get("code_challenge_method") != "S256" || query.Get("nonce") != "nonce" {
+		t.Fatalf("unexpected authorization url %v", authorizationURL)
+	}
+	p.codeChallenge = query.Get("code_challenge")
+}
+
+func TestExchange(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	provider := newTestOIDCProvider(t, p)
+	authorize(t, p, provider)
+
+	p.signIn(jwt.MapClaims{"preferred_username": "alice"})
+	claims, err := provider.Exchange("code", "http://whisper/callback", "verifier", "nonce")
+	if err != nil || claims.Subject != "upstream-alice" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.PreferredUsername != "alice" {
+		t.Errorf("expected the claims of alice, got %+v (%v)", claims, err)
+	}
+
+	if _, err := provider.Exchange("code", "http://whisper/callback", "other-verifier", "nonce"); err == nil {
+		t.Error("a code redeemed with another verifier should be refused")
+	}
+
+	p.signIn(jwt.MapClaims{"email_verified": "false"})
+	if claims, err := provider.Exchange("code", "http://whisper/callback", "verifier", "nonce"); err != nil || claims.EmailVerified {
+		t.Errorf("expected an unverified email, got %+v (%v)", claims, err)
+	}
+
+	p.kid = "2" // rotated
+	p.signIn(nil)
+	if _, err := provider.Exchange("code", "http://whisper/callback", "verifier", "nonce"); err == nil {
+		t.Error("the keys should not be fetched again right after the last fetch")
+	}
+	provider.keys.fetchedAt = time.Time{}
+	if _, err := provider.Exchange("code", "http://whisper/callback", "verifier", "nonce"); err != nil {
+		t.Errorf("the rotated keys should be fetched again, got %v", err)
+	}
+}
+
+var testInvalidIDTokenData = []struct {
+	name   string
+	claims jwt.MapClaims
+}{
+	{"another nonce", jwt.MapClaims{"nonce": "replayed"}},
+	{"no nonce", jwt.MapClaims{"nonce": nil}},
+	{"another audience", jwt.MapClaims{"aud": "someone-else"}},
+	{"another authorized party", jwt.MapClaims{"aud": []string{"whisper", "someone-else"}, "azp": "someone-else"}},
+	{"another issuer", jwt.MapClaims{"iss": "https://evil.example.com"}},
+	{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}},
+	{"no expiration", jwt.MapClaims{"exp": nil}},
+	{"no subject", jwt.MapClaims{"sub": nil}},
+}
+
+func TestExchangeInvalidIDTokens(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	provider := newTestOIDCProvider(t, p)
+	authorize(t, p, provider)
+
+	for _, test := range testInvalidIDTokenData {
+		p.signIn(test.claims)
+		if _, err := provider.Exchange("code", "http://whisper/callback", "verifier", "nonce"); err == nil {
+			t.Errorf("an id token with %v should be refused", test.name)
+		}
+	}
+
+	p.signIn(nil)
+	p.method, p.signingKey = jwt.SigningMethodHS256, []byte("secret") // signed with the client secret
+	if _, err := provider.Exchange("code", "http://whisper/callback", "verifier", "nonce"); err == nil {
+		t.Error("symmetric id tokens should be refused")
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	provider, err := NewProvider(ProviderConfig{ID: "test", Issuer: "https://accounts.example.com", ClientID: "whisper"})
+	if err != nil || provider.Name != "test" || strings.Join(provider.Scopes, " ") != "openid email profile" {
+		t.Errorf("expected the default name and scopes, got %+v (%v)", provider, err)
+	}
+
+	if _, err := NewProvider(ProviderConfig{ID: "test", Issuer: "accounts.example.com", ClientID: "whisper"}); err == nil {
+		t.Error("an issuer that is not an url should be refused")
+	}
+
+	p := newTestProvider(t)
+	defer p.Close()
+	provider, _ = NewProvider(ProviderConfig{ID: "test", Issuer: p.URL + "/", ClientID: "whisper"})
+	if _, err := provider.GetAuthorizationURL("http://whisper/callback", "state", "nonce", "verifier"); err == nil {
+		t.Error("an issuer other than the announced one should be refused")
+	}
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/labbsr0x/goh/gohserver"
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/mail"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Pagination of the user listing
+const (
+	adminDefaultPerPage = 20
+	adminMaxPerPage     = 100
+)
+
+// AdminAPI defines the available user management apis
+type AdminAPI interface {
+	ListUsersGETHandler() http.Handler
+	UserGETHandler() http.Handler
+	UserPOSTHandler() http.Handler
+	UserPUTHandler() http.Handler
+	UserDELETEHandler() http.Handler
+	StatusPUTHandler() http.Handler
+	DisablePOSTHandler() http.Handler
+	EnablePOSTHandler() http.Handler
+	PasswordResetPOSTHandler() http.Handler
+	AttributesGETHandler() http.Handler
+	AttributesPUTHandler() http.Handler
+	UserGroupsGETHandler() http.Handler
+	GroupsGETHandler(kind string) http.Handler
+	GroupPOSTHandler(kind string) http.Handler
+	GroupDELETEHandler(kind string) http.Handler
+	GroupMembersGETHandler(kind string) http.Handler
+	GroupMemberPUTHandler(kind string) http.Handler
+	GroupMemberDELETEHandler(kind string) http.Handler
+}
+
+// DefaultAdminAPI holds the default implementation of the Admin API interface
+type DefaultAdminAPI struct {
+	*config.WebBuilder
+	UserCredentialsDAO db.UserCredentialsDAO
+	UserAttributesDAO  db.UserAttributesDAO
+	GroupsDAO          db.GroupsDAO
+}
+
+// InitFromWebBuilder initializes the default admin API from a WebBuilder
+func (dapi *DefaultAdminAPI) InitFromWebBuilder(w *config.WebBuilder) *DefaultAdminAPI {
+	dapi.WebBuilder = w
+	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.Keyring, w.Hasher, w.HardenedMode, w.BaseUIPath, w.PublicURL, w.Realm, w.Outbox, w.IdentityStore, w.DB)
+	dapi.UserAttributesDAO = new(db.DefaultUserAttributesDAO).Init(w.DB)
+	dapi.GroupsDAO = new(db.DefaultGroupsDAO).Init(w.Realm, w.DB)
+
+	return dapi
+}
+
+// ListUsersGETHandler lists a page of users, optionally filtered by a search over their usernames and emails
+func (dapi *DefaultAdminAPI) ListUsersGETHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		page := getPositiveIntParam(query.Get("page"), 1)
+		perPage := getPositiveIntParam(query.Get("per_page"), adminDefaultPerPage)
+		if perPage > adminMaxPerPage {
+			perPage = adminMaxPerPage
+		}
+
+		userCredentials, total, err := dapi.UserCredentialsDAO.ListUserCredentials(query.Get("search"), (page-1)*perPage, perPage)
+		gohtypes.PanicIfError("Unable to list users", http.StatusInternalServerError, err)
+
+		response := types.AdminUserListResponsePayload{
+			Users:   make([]types.AdminUserResponsePayload, 0, len(userCredentials)),
+			Total:   total,
+			Page:    page,
+			PerPage: perPage,
+		}
+		for _, userCredential := range userCredentials {
+			response.Users = append(response.Users, types.GetAdminUserResponsePayload(userCredential))
+		}
+
+		gohserver.WriteJSONResponse(response, http.StatusOK, w)
+	})
+}
+
+// UserGETHandler gets a user by its id
+func (dapi *DefaultAdminAPI) UserGETHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userCredential := dapi.getUser(r)
+		gohserver.WriteJSONResponse(types.GetAdminUserResponsePayload(userCredential), http.StatusOK, w)
+	})
+}
+
+// UserPOSTHandler creates a user with a verified email. Without a password, the user is mailed a link to choose one
+func (dapi *DefaultAdminAPI) UserPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.AdminAddUserRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		attributes := dapi.checkAttributes(payload.Attributes)
+
+		password := payload.Password
+		if password == "" {
+			password = misc.GenerateSalt()
+		} else {
+			err = dapi.PasswordPolicy.Validate(payload.Password, payload.Username, payload.Email)
+			gohtypes.PanicIfError("Invalid Password", http.StatusBadRequest, err)
+		}
+
+		userID, err := dapi.UserCredentialsDAO.CreateUserCredential(payload.Username, password, payload.Email)
+		gohtypes.PanicIfError("Not possible to create user", http.StatusInternalServerError, err)
+
+		err = dapi.UserCredentialsDAO.ValidateUserCredentialEmail(payload.Username)
+		gohtypes.PanicIfError("Unable to validate user email", http.StatusInternalServerError, err)
+		logrus.Infof("User created by an admin: %v", userID)
+
+		err = dapi.UserAttributesDAO.SetUserAttributes(userID, attributes)
+		gohtypes.PanicIfError("Unable to save the profile attributes", http.StatusInternalServerError, err)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByID(userID)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		if payload.Password == "" {
+			dapi.Outbox <- mail.GetChangePasswordMail(dapi.BaseUIPath, dapi.Keyring, dapi.PublicURL, userCredential.Username, userCredential.Email, userCredential.SecurityStamp, "")
+		}
+
+		gohserver.WriteJSONResponse(types.GetAdminUserResponsePayload(userCredential), http.StatusCreated, w)
+	})
+}
+
+// UserPUTHandler changes the username or email of a user
+func (dapi *DefaultAdminAPI) UserPUTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userCredential := dapi.getUser(r)
+
+		var payload types.AdminUpdateUserRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		err = dapi.UserCredentialsDAO.UpdateUserCredentialIdentity(userCredential.ID, payload.Username, payload.Email)
+		gohtypes.PanicIfError("Unable to update user", http.StatusInternalServerError, err)
+		logrus.Infof("User '%v' updated by an admin", userCredential.ID)
+
+		if payload.Username != userCredential.Username { // hydra knows the user by its username
+			dapi.revokeSessions(userCredential.Username)
+		}
+
+		userCredential, err = dapi.UserCredentialsDAO.GetUserCredentialByID(userCredential.ID)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		gohserver.WriteJSONResponse(types.GetAdminUserResponsePayload(userCredential), http.StatusOK, w)
+	})
+}
+
+// UserDELETEHandler deletes a user, signing it out of every session
+func (dapi *DefaultAdminAPI) UserDELETEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userCredential := dapi.getUser(r)
+
+		err := dapi.UserCredentialsDAO.DeleteUserCredential(userCredential.ID)
+		gohtypes.PanicIfError("Unable to delete user", http.StatusInternalServerError, err)
+		logrus.Infof("User '%v' deleted by an admin", userCredential.ID)
+
+		dapi.revokeSessions(userCredential.Username)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// StatusPUTHandler changes the account status of a user. Users that are no longer active are signed out of every session
+func (dapi *DefaultAdminAPI) StatusPUTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.AdminUserStatusRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		if !db.IsValidUserStatus(payload.Status) {
+			gohtypes.Panic("Unknown status, it should be one of "+strings.Join(db.UserStatuses, ", "), http.StatusBadRequest)
+		}
+
+		dapi.setStatus(w, r, payload.Status, payload.Reason)
+	})
+}
+
+// DisablePOSTHandler suspends a user, signing it out of every session
+func (dapi *DefaultAdminAPI) DisablePOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dapi.setStatus(w, r, db.UserStatusSuspended, r.URL.Query().Get("reason"))
+	})
+}
+
+// EnablePOSTHandler makes a user active again
+func (dapi *DefaultAdminAPI) EnablePOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dapi.setStatus(w, r, db.UserStatusActive, r.URL.Query().Get("reason"))
+	})
+}
+
+// PasswordResetPOSTHandler sends a user the change password mail
+func (dapi *DefaultAdminAPI) PasswordResetPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db.RefuseIfReadOnly(dapi.UserCredentialsDAO)
+		userCredential := dapi.getUser(r)
+
+		dapi.Outbox <- mail.GetChangePasswordMail(dapi.BaseUIPath, dapi.Keyring, dapi.PublicURL, userCredential.Username, userCredential.Email, userCredential.SecurityStamp, r.URL.Query().Get("redirect_to"))
+		logrus.Infof("Password reset of '%v' requested by an admin", userCredential.ID)
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// AttributesGETHandler gets the profile attributes of a user
+func (dapi *DefaultAdminAPI) AttributesGETHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userCredential := dapi.getUser(r)
+
+		attributes, err := dapi.UserAttributesDAO.GetUserAttributes(userCredential.ID)
+		gohtypes.PanicIfError("Unable to retrieve the profile attributes", http.StatusInternalServerError, err)
+
+		gohserver.WriteJSONResponse(types.AdminUserAttributesPayload{Attributes: attributes}, http.StatusOK, w)
+	})
+}
+
+// AttributesPUTHandler sets the given profile attributes of a user, including the ones only admins manage. An empty
+// value removes an attribute
+func (dapi *DefaultAdminAPI) AttributesPUTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userCredential := dapi.getUser(r)
+
+		var payload types.AdminUserAttributesPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		err = dapi.UserAttributesDAO.SetUserAttributes(userCredential.ID, dapi.checkAttributes(payload.Attributes))
+		gohtypes.PanicIfError("Unable to save the profile attributes", http.StatusInternalServerError, err)
+		logrus.Infof("Profile of '%v' updated by an admin", userCredential.ID)
+
+		attributes, err := dapi.UserAttributesDAO.GetUserAttributes(userCredential.ID)
+		gohtypes.PanicIfError("Unable to retrieve the profile attributes", http.StatusInternalServerError, err)
+
+		gohserver.WriteJSONResponse(types.AdminUserAttributesPayload{Attributes: attributes}, http.StatusOK, w)
+	})
+}
+
+// checkAttributes validates the profile attributes set by an admin against the profile schema
+func (dapi *DefaultAdminAPI) checkAttributes(values map[string]string) map[string]string {
+	attributes, err := dapi.ProfileSchema.CheckProfileAttributes(values, misc.ProfileByAdmin)
+	if err != nil {
+		gohtypes.Panic(err.Error(), http.StatusBadRequest)
+	}
+
+	return attributes
+}
+
+// getUser gets the user whose id is in the request path
+func (dapi *DefaultAdminAPI) getUser(r *http.Request) db.UserCredential {
+	userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByID(mux.Vars(r)["id"])
+	if gorm.IsRecordNotFoundError(err) {
+		gohtypes.Panic("User not found", http.StatusNotFound)
+	}
+	gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+	return userCredential
+}
+
+// setStatus changes the account status of the user whose id is in the request path
+func (dapi *DefaultAdminAPI) setStatus(w http.ResponseWriter, r *http.Request, status, reason string) {
+	userCredential := dapi.getUser(r)
+
+	err := dapi.UserCredentialsDAO.SetUserCredentialStatus(userCredential.ID, status, reason)
+	gohtypes.PanicIfError("Unable to change the user status", http.StatusInternalServerError, err)
+	logrus.Infof("User '%v' set %v by an admin", userCredential.ID, status)
+
+	if status != db.UserStatusActive {
+		dapi.revokeSessions(userCredential.Username)
+	}
+
+	userCredential, err = dapi.UserCredentialsDAO.GetUserCredentialByID(userCredential.ID)
+	gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+	gohserver.WriteJSONResponse(types.GetAdminUserResponsePayload(userCredential), http.StatusOK, w)
+}
+
+// revokeSessions signs a user out of every hydra session, so a change made by an admin takes effect at once.
+// A failure here is logged, the change itself was already made
+func (dapi *DefaultAdminAPI) revokeSessions(username string) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Unable to revoke the sessions of '%v': %v", username, r)
+		}
+	}()
+
+	dapi.HydraHelper.RevokeLoginSessions(dapi.GetSubject(username))
+	dapi.HydraHelper.RevokeConsentSessions(dapi.GetSubject(username), "")
+}
+
+func getPositiveIntParam(value string, defaultValue int) int {
+	if i, err := strconv.Atoi(value); err == nil && i > 0 {
+		return i
+	}
+
+	return defaultValue
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/mail"
+	"github.com/labbsr0x/whisper/web/api/types"
+)
+
+// newTestAdminAPI builds an admin api mailing through the returned outbox, with hydra answering the session revocations
+func newTestAdminAPI(t *testing.T) (*DefaultAdminAPI, *testHydra, chan mail.Mail) {
+	testHydra := newTestHydra(t, map[string]interface{}{
+		"DELETE /oauth2/auth/sessions/login":   http.StatusNoContent,
+		"DELETE /oauth2/auth/sessions/consent": http.StatusNoContent,
+	})
+
+	outbox := make(chan mail.Mail, 1)
+	builder := newTestWebBuilder(t)
+	builder.BaseUIPath = "../ui/www"
+	builder.Outbox = outbox
+	builder.HydraHelper = testHydra.Helper()
+
+	return new(DefaultAdminAPI).InitFromWebBuilder(builder), testHydra, outbox
+}
+
+// newTestUserRequest builds a request of the admin api about the user of the given id
+func newTestUserRequest(method, target, id string, payload interface{}) *http.Request {
+	return mux.SetURLVars(newTestRequest(method, target, payload), map[string]string{"id": id})
+}
+
+func TestAdminListUsers(t *testing.T) {
+	dapi, _, _ := newTestAdminAPI(t)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		newTestUser(t, dapi.UserCredentialsDAO, username)
+	}
+
+	var tests = []struct {
+		target    string
+		usernames []string
+		total     int
+		perPage   int
+	}{
+		{"/admin/users", []string{"alice", "bob", "carol"}, 3, adminDefaultPerPage},
+		{"/admin/users?page=2&per_page=2", []string{"carol"}, 3, 2},
+		{"/admin/users?page=0&per_page=-1", []string{"alice", "bob", "carol"}, 3, adminDefaultPerPage},
+		{"/admin/users?per_page=1000", []string{"alice", "bob", "carol"}, 3, adminMaxPerPage},
+		{"/admin/users?search=BO", []string{"bob"}, 1, adminDefaultPerPage},
+		{"/admin/users?search=nobody", []string{}, 0, adminDefaultPerPage},
+	}
+
+	for _, test := range tests {
+		w := serve(dapi.ListUsersGETHandler(), newTestRequest(http.MethodGet, test.target, nil))
+
+		var response types.AdminUserListResponsePayload
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%v: expected a page of users, got %v %v", test.target, w.Code, w.Body)
+		}
+
+		usernames := make([]string, 0)
+		for _, user := range response.Users {
+			usernames = append(usernames, user.Username)
+		}
+
+		if len(usernames) != len(test.usernames) || response.Total != test.total || response.PerPage != test.perPage {
+			t.Errorf("%v: expected %v of %v by %v, got %v of %v by %v", test.target, test.usernames, test.total, test.perPage, usernames, response.Total, response.PerPage)
+			continue
+		}
+
+		for i := range usernames {
+			if usernames[i] != test.usernames[i] {
+				t.Errorf("%v: expected %v, got %v", test.target, test.usernames, usernames)
+				break
+			}
+		}
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	dapi, testHydra, outbox := newTestAdminAPI(t)
+	alice := newTestUser(t, dapi.UserCredentialsDAO, "alice")
+
+	// without a password, the user is mailed a link to choose one
+	w := serve(dapi.UserPOSTHandler(), newTestRequest(http.MethodPost, "/admin/users", types.AdminAddUserRequestPayload{Username: "bob", Email: "bob@example.com"}))
+	var bob types.AdminUserResponsePayload
+	if err := json.Unmarshal(w.Body.Bytes(), &bob); err != nil || w.Code != http.StatusCreated || !bob.EmailValidated {
+		t.Fatalf("expected bob created with a verified email, got %v %v", w.Code, w.Body)
+	}
+
+	if sent := <-outbox; len(sent.To) != 1 || sent.To[0] != "bob@example.com" {
+		t.Errorf("expected bob mailed a link to choose a password, got %v", sent.To)
+	}
+
+	var conflicts = []struct {
+		handler http.Handler
+		request *http.Request
+	}{
+		{dapi.UserPOSTHandler(), newTestRequest(http.MethodPost, "/admin/users", types.AdminAddUserRequestPayload{Username: "alice", Email: "other@example.com"})},
+		{dapi.UserPOSTHandler(), newTestRequest(http.MethodPost, "/admin/users", types.AdminAddUserRequestPayload{Username: "carol", Email: "alice@example.com"})},
+		{dapi.UserPUTHandler(), newTestUserRequest(http.MethodPut, "/admin/users/"+bob.ID, bob.ID, types.AdminUpdateUserRequestPayload{Username: "alice", Email: "bob@example.com"})},
+		{dapi.UserPUTHandler(), newTestUserRequest(http.MethodPut, "/admin/users/"+bob.ID, bob.ID, types.AdminUpdateUserRequestPayload{Username: "bob", Email: "alice@example.com"})},
+	}
+
+	for _, conflict := range conflicts {
+		if w := serve(conflict.handler, conflict.request); w.Code != http.StatusConflict {
+			t.Errorf("%v %v: expected a conflict, got %v %v", conflict.request.Method, conflict.request.URL, w.Code, w.Body)
+		}
+	}
+
+	if w := serve(dapi.PasswordResetPOSTHandler(), newTestUserRequest(http.MethodPost, "/admin/users/"+alice.ID+"/password-reset", alice.ID, nil)); w.Code != http.StatusAccepted {
+		t.Errorf("expected the password reset of alice accepted, got %v %v", w.Code, w.Body)
+	} else if sent := <-outbox; len(sent.To) != 1 || sent.To[0] != "alice@example.com" {
+		t.Errorf("expected alice mailed a link to change the password, got %v", sent.To)
+	}
+
+	if w := serve(dapi.UserDELETEHandler(), newTestUserRequest(http.MethodDelete, "/admin/users/"+alice.ID, alice.ID, nil)); w.Code != http.StatusNoContent {
+		t.Errorf("expected alice deleted, got %v %v", w.Code, w.Body)
+	}
+
+	if revoked := testHydra.Requests(http.MethodDelete, "/oauth2/auth/sessions/login"); len(revoked) != 1 || revoked[0].Query.Get("subject") != "alice" {
+		t.Errorf("expected alice signed out, got %+v", revoked)
+	}
+
+	var unknown = []struct {
+		handler http.Handler
+		method  string
+	}{
+		{dapi.UserGETHandler(), http.MethodGet},
+		{dapi.UserPUTHandler(), http.MethodPut},
+		{dapi.UserDELETEHandler(), http.MethodDelete},
+		{dapi.DisablePOSTHandler(), http.MethodPost},
+		{dapi.PasswordResetPOSTHandler(), http.MethodPost},
+	}
+
+	for _, test := range unknown {
+		payload := types.AdminUpdateUserRequestPayload{Username: "alice", Email: "alice@example.com"}
+		if w := serve(test.handler, newTestUserRequest(test.method, "/admin/users/"+alice.ID, alice.ID, payload)); w.Code != http.StatusNotFound {
+			t.Errorf("%v: expected the deleted alice not found, got %v %v", test.method, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAdminUserStatus(t *testing.T) {
+	dapi, testHydra, _ := newTestAdminAPI(t)
+	alice := newTestUser(t, dapi.UserCredentialsDAO, "alice")
+
+	var tests = []struct {
+		handler http.Handler
+		payload interface{}
+		status  string
+		revoked int
+	}{
+		{dapi.StatusPUTHandler(), types.AdminUserStatusRequestPayload{Status: db.UserStatusLocked, Reason: "testing"}, db.UserStatusLocked, 1},
+		{dapi.EnablePOSTHandler(), nil, db.UserStatusActive, 1},
+		{dapi.DisablePOSTHandler(), nil, db.UserStatusSuspended, 2},
+		{dapi.StatusPUTHandler(), types.AdminUserStatusRequestPayload{Status: db.UserStatusActive}, db.UserStatusActive, 2},
+	}
+
+	for _, test := range tests {
+		w := serve(test.handler, newTestUserRequest(http.MethodPut, "/admin/users/"+alice.ID+"/status", alice.ID, test.payload))
+
+		var user types.AdminUserResponsePayload
+		if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil || w.Code != http.StatusOK || user.Status != test.status {
+			t.Errorf("%v: expected alice %v, got %v %v", test.status, test.status, w.Code, w.Body)
+		}
+
+		// signed out of hydra as soon as no longer active
+		if revoked := testHydra.Requests(http.MethodDelete, "/oauth2/auth/sessions/login"); len(revoked) != test.revoked {
+			t.Errorf("%v: expected alice signed out %v times, got %v", test.status, test.revoked, len(revoked))
+		}
+	}
+
+	if userCredential := dapi.UserCredentialsDAO.CheckCredentials("alice", "password of alice"); !userCredential.IsActive() {
+		t.Errorf("expected alice able to sign in again, got %v", userCredential.Status)
+	}
+
+	payload := types.AdminUserStatusRequestPayload{Status: "unknown"}
+	if w := serve(dapi.StatusPUTHandler(), newTestUserRequest(http.MethodPut, "/admin/users/"+alice.ID+"/status", alice.ID, payload)); w.Code != http.StatusBadRequest {
+		t.Errorf("expected the unknown status refused, got %v %v", w.Code, w.Body)
+	}
+}
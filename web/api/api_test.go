@@ -1,2 +1,152 @@
 package api
 
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/hydra"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/labbsr0x/whisper/web/middleware"
+)
+
+// newTestWebBuilder builds the web builder of a server on an in memory database, without hydra nor a mail sender
+func newTestWebBuilder(t *testing.T) *config.WebBuilder {
+	database, err := db.Open("sqlite://:memory:")
+	if err != nil { // e.g. built without cgo
+		t.Skipf("sqlite unavailable: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if _, err := db.MigrateUp(database, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, hasher, err := misc.NewTestPasswordHasher(misc.Bcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &config.WebBuilder{Flags: &config.Flags{AdminScope: "whisper.admin"}, DB: database, Keyring: keyring, Hasher: hasher, PasswordPolicy: misc.DefaultPasswordPolicy}
+}
+
+// newTestUser creates a user with a confirmed email, returning it
+func newTestUser(t *testing.T, dao db.UserCredentialsDAO, username string) db.UserCredential {
+	id, err := dao.CreateUserCredential(username, "password of "+username, username+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dao.ValidateUserCredentialEmail(username); err != nil {
+		t.Fatal(err)
+	}
+
+	userCredential, err := dao.GetUserCredentialByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return userCredential
+}
+
+// testHydra is a hydra admin api answering with canned responses, recording the requests it gets
+type testHydra struct {
+	server    *httptest.Server
+	mutex     sync.Mutex
+	responses map[string]interface{}
+	requests  []testHydraRequest
+}
+
+// testHydraRequest is a request a testHydra got
+type testHydraRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Body   map[string]interface{}
+}
+
+// newTestHydra starts a hydra admin api answering the requests by method and path, e.g. "GET /oauth2/auth/requests/login",
+// with the given responses encoded as json. An int response is answered as a status, the unlisted requests with a 404
+func newTestHydra(t *testing.T, responses map[string]interface{}) *testHydra {
+	h := &testHydra{responses: responses}
+	h.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := testHydraRequest{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query()}
+		if data, _ := ioutil.ReadAll(r.Body); len(data) > 0 {
+			_ = json.Unmarshal(data, &request.Body)
+		}
+
+		h.mutex.Lock()
+		h.requests = append(h.requests, request)
+		response, ok := h.responses[r.Method+" "+r.URL.Path]
+		h.mutex.Unlock()
+
+		if status, isStatus := response.(int); !ok || isStatus {
+			if !ok {
+				status = http.StatusNotFound
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(h.server.Close)
+
+	return h
+}
+
+// Helper gets a hydra helper reaching the test hydra
+func (h *testHydra) Helper() hydra.Api {
+	return new(hydra.DefaultHydraHelper).Init(h.server.URL)
+}
+
+// Requests gets the requests got by method and path, in the order they came
+func (h *testHydra) Requests(method, path string) []testHydraRequest {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	requests := make([]testHydraRequest, 0)
+	for _, request := range h.requests {
+		if request.Method == method && request.Path == path {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+// newTestRequest builds a request carrying a payload encoded as json
+func newTestRequest(method, target string, payload interface{}) *http.Request {
+	var body bytes.Buffer
+	if payload != nil {
+		_ = json.NewEncoder(&body).Encode(payload)
+	}
+
+	return httptest.NewRequest(method, target, &body)
+}
+
+// serve serves a request with a handler behind the error middleware, as the server does
+func serve(handler http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	middleware.GetErrorMiddleware()(handler).ServeHTTP(w, r)
+
+	return w
+}
+
+// getTestLoginInfo builds the login request info hydra answers for a client with the given metadata
+func getTestLoginInfo(clientID string, metadata map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"challenge": "challenge",
+		"skip":      false,
+		"subject":   "",
+		"client":    map[string]interface{}{"client_id": clientID, "metadata": metadata},
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/labbsr0x/goh/gohserver"
 	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/db"
 	"github.com/labbsr0x/whisper/hydra"
 	"github.com/labbsr0x/whisper/misc"
 	"github.com/labbsr0x/whisper/web/api/types"
@@ -22,11 +23,17 @@ type ConsentAPI interface {
 // DefaultConsentAPI holds the default implementation of the User API interface
 type DefaultConsentAPI struct {
 	*config.WebBuilder
+	UserCredentialsDAO db.UserCredentialsDAO
+	UserAttributesDAO  db.UserAttributesDAO
+	GroupsDAO          db.GroupsDAO
 }
 
 // InitFromWebBuilder initializes a default consent api instance from a web builder instance
 func (dapi *DefaultConsentAPI) InitFromWebBuilder(webBuilder *config.WebBuilder) *DefaultConsentAPI {
 	dapi.WebBuilder = webBuilder
+	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(webBuilder.Keyring, webBuilder.Hasher, webBuilder.HardenedMode, webBuilder.BaseUIPath, webBuilder.PublicURL, webBuilder.Realm, webBuilder.Outbox, webBuilder.IdentityStore, webBuilder.DB)
+	dapi.UserAttributesDAO = new(db.DefaultUserAttributesDAO).Init(webBuilder.DB)
+	dapi.GroupsDAO = new(db.DefaultGroupsDAO).Init(webBuilder.Realm, webBuilder.DB)
 	return dapi
 }
 
@@ -42,6 +49,7 @@ func (dapi *DefaultConsentAPI) ConsentPOSTHandler() http.Handler {
 			info := dapi.HydraHelper.GetConsentRequestInfo(payload.Challenge)
 			logrus.Debugf("Consent request info: '%v'", info)
 			if info != nil {
+				userCredential := dapi.getUserCredential(info)
 				acceptInfo := dapi.HydraHelper.AcceptConsentRequest(
 					payload.Challenge,
 					hydra.AcceptConsentRequestPayload{
@@ -49,6 +57,7 @@ func (dapi *DefaultConsentAPI) ConsentPOSTHandler() http.Handler {
 						GrantScope:               payload.GrantScope,
 						Remember:                 payload.Remember,
 						RememberFor:              3600,
+						Session:                  dapi.getTokenSession(userCredential, info, payload.GrantScope),
 					})
 
 				logrus.Debugf("Consent Accept Info: '%v'", acceptInfo)
@@ -78,12 +87,15 @@ func (dapi *DefaultConsentAPI) ConsentGETHandler(route string) http.Handler {
 		gohtypes.PanicIfError("Unable to parse the consent_challenge parameter", http.StatusBadRequest, err)
 		info := dapi.HydraHelper.GetConsentRequestInfo(challenge)
 		logrus.Debugf("Consent Request Info: '%v'", info)
+		userCredential := dapi.getUserCredential(info)
 		if info["skip"].(bool) {
+			grantScope := misc.ConvertInterfaceArrayToStringArray(info["requested_scope"].([]interface{}))
 			info = dapi.HydraHelper.AcceptConsentRequest(
 				challenge,
 				hydra.AcceptConsentRequestPayload{
-					GrantScope:               misc.ConvertInterfaceArrayToStringArray(info["requested_scope"].([]interface{})),
-					GrantAccessTokenAudience: misc.ConvertInterfaceArrayToStringArray(info["requested_access_token_audience"].([]interface{}))},
+					GrantScope:               grantScope,
+					GrantAccessTokenAudience: misc.ConvertInterfaceArrayToStringArray(info["requested_access_token_audience"].([]interface{})),
+					Session:                  dapi.getTokenSession(userCredential, info, grantScope)},
 			)
 
 			if info != nil {
@@ -97,6 +109,39 @@ func (dapi *DefaultConsentAPI) ConsentGETHandler(route string) http.Handler {
 	}))
 }
 
+// getUserCredential gets the user of the consent request subject, which must belong to the realm
+func (dapi *DefaultConsentAPI) getUserCredential(consentRequestInfo map[string]interface{}) db.UserCredential {
+	subject, _ := consentRequestInfo["subject"].(string)
+
+	username, ok := dapi.GetUsername(subject)
+	if !ok {
+		gohtypes.Panic("The user signed in to another realm", http.StatusForbidden)
+	}
+
+	userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(username)
+	gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+	return userCredential
+}
+
+// getTokenSession builds the claims the tokens of the consent request user carry for the granted scopes
+func (dapi *DefaultConsentAPI) getTokenSession(userCredential db.UserCredential, consentRequestInfo map[string]interface{}, grantScope []string) hydra.TokenSessionPayload {
+	attributes, err := dapi.UserAttributesDAO.GetUserAttributes(userCredential.ID)
+	gohtypes.PanicIfError("Unable to retrieve the profile attributes", http.StatusInternalServerError, err)
+
+	groups, err := dapi.GroupsDAO.ListUserGroups(userCredential.ID, db.GroupKindGroup)
+	gohtypes.PanicIfError("Unable to retrieve the groups", http.StatusInternalServerError, err)
+
+	roles, err := dapi.GroupsDAO.ListUserGroups(userCredential.ID, db.GroupKindRole)
+	gohtypes.PanicIfError("Unable to retrieve the roles", http.StatusInternalServerError, err)
+
+	client, _ := consentRequestInfo["client"].(map[string]interface{})
+	clientID, _ := client["client_id"].(string)
+
+	user := types.TokenUser{UserCredential: userCredential, Attributes: attributes, Groups: getGroupNames(groups), Roles: getGroupNames(roles)}
+	return types.GetTokenSession(user, clientID, dapi.GrantScopes, grantScope)
+}
+
 // getConsentPageInfo builds the data structure for a consent page
 func getConsentPage(consentRequestInfo map[string]interface{}, scopes misc.GrantScopes) types.ConsentPage {
 	consentPageInfo := types.ConsentPage{ClientName: "Unknown", ClientURI: "#", RequestedScopes: make([]misc.GrantScope, 0)}
@@ -0,0 +1,433 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/labbsr0x/goh/gohserver"
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/hydra"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/oidc"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/labbsr0x/whisper/web/ui"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	federationCookie     = "whisper_federation"
+	federationLinkCookie = "whisper_federation_link"
+	federationCookiePath = "/federation/"
+)
+
+// FederationAPI defines the available apis to sign in with an upstream OpenID Connect provider
+type FederationAPI interface {
+	LoginGETHandler() http.Handler
+	CallbackGETHandler() http.Handler
+	LinkGETHandler(route string) http.Handler
+	LinkPOSTHandler() http.Handler
+	IdentityPOSTHandler() http.Handler
+	IdentityDELETEHandler() http.Handler
+}
+
+// DefaultFederationAPI holds the default implementation of the Federation API interface
+type DefaultFederationAPI struct {
+	*config.WebBuilder
+	UserCredentialsDAO     db.UserCredentialsDAO
+	UserIdentitiesDAO      db.UserIdentitiesDAO
+	WebAuthnCredentialsDAO db.WebAuthnCredentialsDAO
+	LoginThrottler         *loginThrottler
+}
+
+// InitFromWebBuilder initializes a default federation api instance from a web builder instance
+func (dapi *DefaultFederationAPI) InitFromWebBuilder(w *config.WebBuilder) *DefaultFederationAPI {
+	dapi.WebBuilder = w
+	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.Keyring, w.Hasher, w.HardenedMode, w.BaseUIPath, w.PublicURL, w.Realm, w.Outbox, w.IdentityStore, w.DB)
+	dapi.UserIdentitiesDAO = new(db.DefaultUserIdentitiesDAO).Init(w.Realm, w.DB)
+	dapi.WebAuthnCredentialsDAO = new(db.DefaultWebAuthnCredentialsDAO).Init(w.DB)
+	dapi.LoginThrottler = new(loginThrottler).InitFromWebBuilder(w)
+	return dapi
+}
+
+// LoginGETHandler redirects the browser to the upstream provider, keeping the authorization request in a cookie
+func (dapi *DefaultFederationAPI) LoginGETHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider := dapi.getProvider(r)
+
+		challenge := r.URL.Query().Get("login_challenge")
+		if challenge == "" {
+			gohtypes.Panic("Unable to parse the login_challenge", http.StatusBadRequest)
+		}
+
+		request := misc.FederationRequest{LoginChallenge: challenge, Remember: r.URL.Query().Get("remember") == "true"}
+		http.Redirect(w, r, dapi.startAuthorization(w, provider, request), http.StatusFound)
+	})
+}
+
+// IdentityPOSTHandler starts linking an upstream provider to the signed in user, answering where to send the browser
+func (dapi *DefaultFederationAPI) IdentityPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		var payload types.LinkIdentityRequestPayload
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		provider := dapi.getProvider(r)
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		request := misc.FederationRequest{LinkUserID: userCredential.ID, ReturnTo: payload.ReturnTo}
+		gohserver.WriteJSONResponse(map[string]interface{}{
+			"redirect_to": dapi.startAuthorization(w, provider, request),
+		}, http.StatusOK, w)
+	})
+}
+
+// IdentityDELETEHandler unlinks an identity of the signed in user, unless it is the last way left to sign in
+func (dapi *DefaultFederationAPI) IdentityDELETEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		refuseIfLastLoginMethod(userCredential, dapi.IdentityStore != nil, dapi.WebAuthnCredentialsDAO, dapi.UserIdentitiesDAO)
+
+		err = dapi.UserIdentitiesDAO.DeleteUserIdentity(userCredential.ID, mux.Vars(r)["id"])
+		gohtypes.PanicIfError("Unable to unlink the account", http.StatusNotFound, err)
+		logrus.Infof("Identity unlinked from '%v'", userCredential.Username)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// CallbackGETHandler finishes the login or link at the upstream provider
+func (dapi *DefaultFederationAPI) CallbackGETHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider := dapi.getProvider(r)
+		query := r.URL.Query()
+
+		cookie, err := r.Cookie(federationCookie)
+		gohtypes.PanicIfError("Your login session expired, please sign in again", http.StatusUnauthorized, err)
+		dapi.setFederationCookie(w, federationCookie, "", -1)
+
+		claims, err := misc.ParseToken(cookie.Value, dapi.Keyring)
+		gohtypes.PanicIfError("Your login session expired, please sign in again", http.StatusUnauthorized, err)
+
+		request, err := misc.UnmarshalFederationToken(claims)
+		gohtypes.PanicIfError("Unable to unmarshal token", http.StatusBadRequest, err)
+
+		if request.Provider != provider.ID || subtle.ConstantTimeCompare([]byte(request.State), []byte(query.Get("state"))) != 1 {
+			gohtypes.Panic("The login does not match the one started", http.StatusBadRequest)
+		}
+
+		if upstreamError := query.Get("error"); upstreamError != "" {
+			logrus.Infof("Login refused by the upstream provider '%v': %v %v", provider.ID, upstreamError, query.Get("error_description"))
+			dapi.redirectWithError(w, r, request, fmt.Sprintf("The sign in with %v was not completed", provider.Name))
+			return
+		}
+
+		upstreamClaims, err := provider.Exchange(query.Get("code"), dapi.GetUpstreamRedirectURI(provider.ID), request.CodeVerifier, request.Nonce)
+		if err != nil {
+			logrus.Errorf("Unable to finish the login at the upstream provider '%v': %v", provider.ID, err)
+			gohtypes.Panic(fmt.Sprintf("Unable to sign in with %v", provider.Name), http.StatusBadGateway)
+		}
+
+		if request.LinkUserID != "" {
+			dapi.finishLink(w, r, provider, request, upstreamClaims)
+			return
+		}
+
+		identity, err := dapi.UserIdentitiesDAO.GetUserIdentity(provider.ID, upstreamClaims.Subject)
+		if err == nil {
+			userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByID(identity.UserCredentialID)
+			gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+			http.Redirect(w, r, dapi.acceptLogin(userCredential, provider, request.LoginChallenge, request.Remember), http.StatusFound)
+			return
+		}
+
+		if !gorm.IsRecordNotFoundError(err) {
+			gohtypes.PanicIfError("Unable to retrieve the linked account", http.StatusInternalServerError, err)
+		}
+
+		if upstreamClaims.Email == "" || !upstreamClaims.EmailVerified || misc.VerifyEmail(upstreamClaims.Email) != nil {
+			gohtypes.Panic(fmt.Sprintf("%v did not share a verified email", provider.Name), http.StatusForbidden)
+		}
+		email := strings.ToLower(upstreamClaims.Email)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByEmail(email)
+		if err == nil { // never linked silently, the user confirms it first
+			if !userCredential.EmailValidated {
+				gohtypes.Panic("An account with this email is waiting for its email to be confirmed, confirm it before signing in with another provider", http.StatusConflict)
+			}
+
+			token := misc.GetLinkConfirmationToken(dapi.Keyring, provider.ID, upstreamClaims.Subject, email, userCredential.ID, request.LoginChallenge, request.Remember)
+			dapi.setFederationCookie(w, federationLinkCookie, token, 600)
+			http.Redirect(w, r, "/federation/link", http.StatusFound)
+			return
+		}
+
+		if !gorm.IsRecordNotFoundError(err) {
+			gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+		}
+
+		userCredential, err = dapi.UserCredentialsDAO.CreateFederatedUserCredential(provider.ID, upstreamClaims.Subject, email, upstreamClaims.PreferredUsername)
+		gohtypes.PanicIfError("Unable to create user", http.StatusInternalServerError, err)
+
+		http.Redirect(w, r, dapi.acceptLogin(userCredential, provider, request.LoginChallenge, request.Remember), http.StatusFound)
+	})
+}
+
+// LinkGETHandler builds the page asking the user to confirm an identity is linked to the account with the same email
+func (dapi *DefaultFederationAPI) LinkGETHandler(route string) http.Handler {
+	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provider, _, email, userCredentialID, challenge, _ := dapi.getLinkConfirmation(r)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByID(userCredentialID)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		page := types.FederationLinkPage{
+			ProviderName: provider.Name,
+			Username:     userCredential.Username,
+			Email:        email,
+			Challenge:    challenge,
+			HasPassword:  hasPassword(userCredential, dapi.IdentityStore != nil),
+		}
+		ui.WritePage(w, dapi.BaseUIPath, ui.FederationLink, &page)
+	}))
+}
+
+// LinkPOSTHandler links the confirmed identity to the account with the same email, signing the user in. Accounts with a
+// password are only linked once it is typed, so owning the email alone is not enough to take them over
+func (dapi *DefaultFederationAPI) LinkPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.ConfirmLinkRequestPayload
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		provider, subject, email, userCredentialID, challenge, remember := dapi.getLinkConfirmation(r)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByID(userCredentialID)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		if userCredential.Email != email {
+			gohtypes.Panic("The email of the account changed, please sign in again", http.StatusConflict)
+		}
+
+		if hasPassword(userCredential, dapi.IdentityStore != nil) {
+			dapi.LoginThrottler.Check(w, r, userCredential.Username)
+			func() {
+				defer dapi.LoginThrottler.FailOnPanic(r, userCredential.Username)
+				dapi.UserCredentialsDAO.CheckCredentials(userCredential.Username, payload.Password)
+			}()
+			dapi.LoginThrottler.Reset(userCredential.Username)
+		}
+		dapi.setFederationCookie(w, federationLinkCookie, "", -1)
+
+		_, err = dapi.UserIdentitiesDAO.CreateUserIdentity(userCredential.ID, provider.ID, subject, email)
+		gohtypes.PanicIfError("Unable to link the account", http.StatusInternalServerError, err)
+		logrus.Infof("User '%v' linked to '%v' of the upstream provider '%v'", userCredential.Username, subject, provider.ID)
+
+		gohserver.WriteJSONResponse(map[string]interface{}{
+			"redirect_to": dapi.acceptLogin(userCredential, provider, challenge, remember),
+		}, http.StatusOK, w)
+	})
+}
+
+// startAuthorization keeps a new authorization request in a cookie, returning where to send the browser at the provider
+func (dapi *DefaultFederationAPI) startAuthorization(w http.ResponseWriter, provider *oidc.Provider, request misc.FederationRequest) string {
+	var err error
+	request.Provider = provider.ID
+
+	request.State, err = oidc.NewRandom()
+	gohtypes.PanicIfError("Unable to start the login", http.StatusInternalServerError, err)
+	request.Nonce, err = oidc.NewRandom()
+	gohtypes.PanicIfError("Unable to start the login", http.StatusInternalServerError, err)
+	request.CodeVerifier, err = oidc.NewRandom()
+	gohtypes.PanicIfError("Unable to start the login", http.StatusInternalServerError, err)
+
+	authorizationURL, err := provider.GetAuthorizationURL(dapi.GetUpstreamRedirectURI(provider.ID), request.State, request.Nonce, request.CodeVerifier)
+	if err != nil {
+		logrus.Errorf("Unable to reach the upstream provider '%v': %v", provider.ID, err)
+		gohtypes.Panic(fmt.Sprintf("Unable to reach %v", provider.Name), http.StatusBadGateway)
+	}
+
+	dapi.setFederationCookie(w, federationCookie, misc.GetFederationToken(dapi.Keyring, request), 600)
+	return authorizationURL
+}
+
+// finishLink links the identity signed in at the provider to the user that started the link, unless another user has it
+func (dapi *DefaultFederationAPI) finishLink(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, request misc.FederationRequest, upstreamClaims oidc.Claims) {
+	identity, err := dapi.UserIdentitiesDAO.GetUserIdentity(provider.ID, upstreamClaims.Subject)
+	if err == nil && identity.UserCredentialID != request.LinkUserID {
+		dapi.redirectWithError(w, r, request, fmt.Sprintf("This %v account is already linked to another user", provider.Name))
+		return
+	}
+
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		gohtypes.PanicIfError("Unable to retrieve the linked account", http.StatusInternalServerError, err)
+	}
+
+	_, err = dapi.UserIdentitiesDAO.CreateUserIdentity(request.LinkUserID, provider.ID, upstreamClaims.Subject, strings.ToLower(upstreamClaims.Email))
+	gohtypes.PanicIfError("Unable to link the account", http.StatusInternalServerError, err)
+	logrus.Infof("User '%v' linked to '%v' of the upstream provider '%v'", request.LinkUserID, upstreamClaims.Subject, provider.ID)
+
+	http.Redirect(w, r, addQueryParam(request.ReturnTo, "federation_linked", provider.Name), http.StatusFound)
+}
+
+// acceptLogin accepts the login request of a user signed in by an upstream provider, returning where hydra sends the
+// browser to. The provider stands for the password only: the users with a second factor are sent to the login page to
+// type their code
+func (dapi *DefaultFederationAPI) acceptLogin(userCredential db.UserCredential, provider *oidc.Provider, challenge string, remember bool) string {
+	refuseForeignRealm(dapi.Realm, dapi.HydraHelper.GetLoginRequestInfo(challenge))
+
+	if !userCredential.IsActive() {
+		gohtypes.Panic(db.GetUserStatusMessage(userCredential.Status), http.StatusForbidden)
+	}
+
+	err := dapi.UserCredentialsDAO.CheckIdentity(userCredential.Username)
+	if err == db.ErrIdentityNotFound {
+		gohtypes.Panic("The account no longer exists", http.StatusForbidden)
+	}
+	gohtypes.PanicIfError("Unable to reach the identity store", http.StatusBadGateway, err)
+
+	if userCredential.TOTPEnabled {
+		logrus.Infof("User '%v' signed in with the upstream provider '%v', waiting for its second factor", userCredential.Username, provider.ID)
+		token := misc.GetSecondFactorToken(dapi.Keyring, userCredential.Username, challenge, hydra.AMRFederated, remember)
+		return "/login?login_challenge=" + url.QueryEscape(challenge) + "&second_factor_token=" + url.QueryEscape(token)
+	}
+
+	logrus.Infof("User '%v' signed in with the upstream provider '%v'", userCredential.Username, provider.ID)
+	info := dapi.HydraHelper.AcceptLoginRequest(
+		challenge,
+		hydra.AcceptLoginRequestPayload{
+			ACR:         hydra.ACRSingleFactor,
+			AMR:         []string{hydra.AMRFederated},
+			Remember:    remember,
+			RememberFor: 3600,
+			Subject:     dapi.GetSubject(userCredential.Username),
+		},
+	)
+	logrus.Debugf("Accept login request info: %v", info)
+	if info == nil {
+		gohtypes.Panic("Unable to accept the login request", http.StatusInternalServerError)
+	}
+
+	return info["redirect_to"].(string)
+}
+
+// redirectWithError sends the browser back to where the login or link started, telling what went wrong
+func (dapi *DefaultFederationAPI) redirectWithError(w http.ResponseWriter, r *http.Request, request misc.FederationRequest, message string) {
+	if request.LinkUserID != "" {
+		http.Redirect(w, r, addQueryParam(request.ReturnTo, "federation_error", message), http.StatusFound)
+		return
+	}
+
+	redirectTo := "/login?login_challenge=" + url.QueryEscape(request.LoginChallenge)
+	http.Redirect(w, r, addQueryParam(redirectTo, "federation_error", message), http.StatusFound)
+}
+
+// getLinkConfirmation reads the identity waiting for the user to confirm it is linked
+func (dapi *DefaultFederationAPI) getLinkConfirmation(r *http.Request) (provider *oidc.Provider, subject, email, userCredentialID, challenge string, remember bool) {
+	cookie, err := r.Cookie(federationLinkCookie)
+	gohtypes.PanicIfError("Your login session expired, please sign in again", http.StatusUnauthorized, err)
+
+	claims, err := misc.ParseToken(cookie.Value, dapi.Keyring)
+	gohtypes.PanicIfError("Your login session expired, please sign in again", http.StatusUnauthorized, err)
+
+	providerID, subject, email, userCredentialID, challenge, remember, err := misc.UnmarshalLinkConfirmationToken(claims)
+	gohtypes.PanicIfError("Unable to unmarshal token", http.StatusBadRequest, err)
+
+	provider = dapi.GetUpstreamProvider(providerID)
+	if provider == nil {
+		gohtypes.Panic("Unknown upstream provider", http.StatusNotFound)
+	}
+
+	return provider, subject, email, userCredentialID, challenge, remember
+}
+
+// getProvider gets the upstream provider named in the route
+func (dapi *DefaultFederationAPI) getProvider(r *http.Request) *oidc.Provider {
+	provider := dapi.GetUpstreamProvider(mux.Vars(r)["provider"])
+	if provider == nil {
+		gohtypes.Panic("Unknown upstream provider", http.StatusNotFound)
+	}
+
+	return provider
+}
+
+// setFederationCookie keeps the state of a login until the provider sends the browser back or the user confirms the
+// link. It is sent along top-level navigations only, as the provider redirects with a GET
+func (dapi *DefaultFederationAPI) setFederationCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     federationCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(dapi.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// hasPassword tells whether the user signs in with a password, kept in the database or in the identity store
+func hasPassword(userCredential db.UserCredential, hasIdentityStore bool) bool {
+	return userCredential.Password != "" || hasIdentityStore
+}
+
+// refuseIfLastLoginMethod refuses to remove a way to sign in when the user has no other: a password, a passkey or a
+// linked identity
+func refuseIfLastLoginMethod(userCredential db.UserCredential, hasIdentityStore bool, webAuthnDAO db.WebAuthnCredentialsDAO, identitiesDAO db.UserIdentitiesDAO) {
+	methods := 0
+	if hasPassword(userCredential, hasIdentityStore) {
+		methods++
+	}
+
+	credentials, err := webAuthnDAO.ListWebAuthnCredentials(userCredential.ID)
+	gohtypes.PanicIfError("Unable to retrieve the registered security keys", http.StatusInternalServerError, err)
+	methods += len(credentials)
+
+	identities, err := identitiesDAO.ListUserIdentities(userCredential.ID)
+	gohtypes.PanicIfError("Unable to retrieve the linked accounts", http.StatusInternalServerError, err)
+	methods += len(identities)
+
+	if methods <= 1 {
+		gohtypes.Panic("This is the last way left to sign in to your account, add a password, a passkey or another account first", http.StatusConflict)
+	}
+}
+
+// getUpstreamProviderItems lists the upstream providers offered on the login and update pages
+func getUpstreamProviderItems(providers []*oidc.Provider) []types.UpstreamProviderItem {
+	items := make([]types.UpstreamProviderItem, 0, len(providers))
+	for _, provider := range providers {
+		items = append(items, types.UpstreamProviderItem{ID: provider.ID, Name: provider.Name})
+	}
+
+	return items
+}
+
+// addQueryParam adds a query parameter to a relative url
+func addQueryParam(u, name, value string) string {
+	separator := "?"
+	if strings.Contains(u, "?") {
+		separator = "&"
+	}
+
+	return u + separator + name + "=" + url.QueryEscape(value)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/hydra"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/oidc"
+)
+
+func TestFederatedLoginSecondFactor(t *testing.T) {
+	builder := newTestWebBuilder(t)
+	testHydra := newTestHydra(t, map[string]interface{}{
+		"GET /oauth2/auth/requests/login": getTestLoginInfo("app", nil),
+	})
+	builder.HydraHelper = testHydra.Helper()
+	dapi := new(DefaultFederationAPI).InitFromWebBuilder(builder)
+	provider := &oidc.Provider{ProviderConfig: oidc.ProviderConfig{ID: "upstream", Name: "Upstream"}}
+
+	alice := newTestUser(t, dapi.UserCredentialsDAO, "alice")
+	if err := dapi.DB.Model(&db.UserCredential{}).Where("id = ?", alice.ID).Update("totp_enabled", true).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dapi.UserIdentitiesDAO.CreateUserIdentity(alice.ID, provider.ID, "upstream-alice", alice.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	// as the callback of the provider signs in a linked user, the login not being accepted before the second factor
+	identity, err := dapi.UserIdentitiesDAO.GetUserIdentity(provider.ID, "upstream-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByID(identity.UserCredentialID)
+	if err != nil || !userCredential.TOTPEnabled {
+		t.Fatalf("expected alice with a second factor, got %v (%v)", userCredential, err)
+	}
+
+	redirectTo, err := url.Parse(dapi.acceptLogin(userCredential, provider, "challenge", true))
+	if err != nil || redirectTo.Path != "/login" || redirectTo.Query().Get("login_challenge") != "challenge" {
+		t.Fatalf("expected the login page asking for the second factor, got %v (%v)", redirectTo, err)
+	}
+
+	claims, err := misc.ParseToken(redirectTo.Query().Get("second_factor_token"), dapi.Keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	username, challenge, firstFactor, remember, err := misc.UnmarshalSecondFactorToken(claims)
+	if err != nil || username != "alice" || challenge != "challenge" || firstFactor != hydra.AMRFederated || !remember {
+		t.Errorf("expected the second factor of alice after the upstream provider, got %v %v %v %v (%v)", username, challenge, firstFactor, remember, err)
+	}
+
+	if accepted := testHydra.Requests("PUT", "/oauth2/auth/requests/login/accept"); len(accepted) != 0 {
+		t.Errorf("expected the login accepted only after the second factor, got %v", accepted)
+	}
+}
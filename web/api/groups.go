@@ -0,0 +1,163 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+	"github.com/labbsr0x/goh/gohserver"
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+// GroupsGETHandler lists the groups or roles, as given by kind
+func (dapi *DefaultAdminAPI) GroupsGETHandler(kind string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		groups, err := dapi.GroupsDAO.ListGroups(kind)
+		gohtypes.PanicIfError("Unable to list the "+kind+"s", http.StatusInternalServerError, err)
+
+		gohserver.WriteJSONResponse(types.AdminGroupListResponsePayload{Groups: types.GetAdminGroupListResponsePayload(groups)}, http.StatusOK, w)
+	})
+}
+
+// GroupPOSTHandler creates a group or role, as given by kind
+func (dapi *DefaultAdminAPI) GroupPOSTHandler(kind string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.AdminAddGroupRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		group, err := dapi.GroupsDAO.CreateGroup(kind, payload.Name, payload.Description)
+		gohtypes.PanicIfError("Unable to create the "+kind, http.StatusInternalServerError, err)
+		logrus.Infof("The %v '%v' was created by an admin", kind, group.Name)
+
+		gohserver.WriteJSONResponse(types.GetAdminGroupResponsePayload(group), http.StatusCreated, w)
+	})
+}
+
+// GroupDELETEHandler deletes a group or role, as given by kind. Its members are asked for consent again, so the
+// clients no longer get it
+func (dapi *DefaultAdminAPI) GroupDELETEHandler(kind string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := dapi.getGroup(r, kind)
+
+		members, err := dapi.GroupsDAO.ListGroupMembers(group.ID)
+		gohtypes.PanicIfError("Unable to list the members of the "+kind, http.StatusInternalServerError, err)
+
+		err = dapi.GroupsDAO.DeleteGroup(kind, group.Name)
+		gohtypes.PanicIfError("Unable to delete the "+kind, http.StatusInternalServerError, err)
+		logrus.Infof("The %v '%v' was deleted by an admin", kind, group.Name)
+
+		for _, member := range members {
+			dapi.revokeConsentSessions(member.Username)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// GroupMembersGETHandler lists the members of a group or role, as given by kind
+func (dapi *DefaultAdminAPI) GroupMembersGETHandler(kind string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := dapi.getGroup(r, kind)
+
+		members, err := dapi.GroupsDAO.ListGroupMembers(group.ID)
+		gohtypes.PanicIfError("Unable to list the members of the "+kind, http.StatusInternalServerError, err)
+
+		response := types.AdminGroupMembersResponsePayload{Users: make([]types.AdminUserResponsePayload, 0, len(members))}
+		for _, member := range members {
+			response.Users = append(response.Users, types.GetAdminUserResponsePayload(member))
+		}
+
+		gohserver.WriteJSONResponse(response, http.StatusOK, w)
+	})
+}
+
+// GroupMemberPUTHandler makes a user a member of a group or role, as given by kind
+func (dapi *DefaultAdminAPI) GroupMemberPUTHandler(kind string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := dapi.getGroup(r, kind)
+		userCredential := dapi.getUser(r)
+
+		err := dapi.GroupsDAO.AddGroupMember(group.ID, userCredential.ID)
+		gohtypes.PanicIfError("Unable to add the member", http.StatusInternalServerError, err)
+		logrus.Infof("User '%v' added to the %v '%v' by an admin", userCredential.ID, kind, group.Name)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// GroupMemberDELETEHandler removes a user from a group or role, as given by kind. The user is asked for consent
+// again, so the clients no longer get it
+func (dapi *DefaultAdminAPI) GroupMemberDELETEHandler(kind string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := dapi.getGroup(r, kind)
+		userCredential := dapi.getUser(r)
+
+		err := dapi.GroupsDAO.RemoveGroupMember(group.ID, userCredential.ID)
+		if gorm.IsRecordNotFoundError(err) {
+			gohtypes.Panic("The user is not a member of the "+kind, http.StatusNotFound)
+		}
+		gohtypes.PanicIfError("Unable to remove the member", http.StatusInternalServerError, err)
+		logrus.Infof("User '%v' removed from the %v '%v' by an admin", userCredential.ID, kind, group.Name)
+
+		dapi.revokeConsentSessions(userCredential.Username)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// UserGroupsGETHandler lists the groups and roles of a user
+func (dapi *DefaultAdminAPI) UserGroupsGETHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userCredential := dapi.getUser(r)
+
+		groups, err := dapi.GroupsDAO.ListUserGroups(userCredential.ID, db.GroupKindGroup)
+		gohtypes.PanicIfError("Unable to list the groups of the user", http.StatusInternalServerError, err)
+
+		roles, err := dapi.GroupsDAO.ListUserGroups(userCredential.ID, db.GroupKindRole)
+		gohtypes.PanicIfError("Unable to list the roles of the user", http.StatusInternalServerError, err)
+
+		gohserver.WriteJSONResponse(types.AdminUserGroupsResponsePayload{
+			Groups: types.GetAdminGroupListResponsePayload(groups),
+			Roles:  types.GetAdminGroupListResponsePayload(roles),
+		}, http.StatusOK, w)
+	})
+}
+
+// getGroup gets the group or role whose name is in the request path
+func (dapi *DefaultAdminAPI) getGroup(r *http.Request, kind string) db.Group {
+	group, err := dapi.GroupsDAO.GetGroup(kind, mux.Vars(r)["name"])
+	if gorm.IsRecordNotFoundError(err) {
+		gohtypes.Panic("The "+kind+" was not found", http.StatusNotFound)
+	}
+	gohtypes.PanicIfError("Unable to retrieve the "+kind, http.StatusInternalServerError, err)
+
+	return group
+}
+
+// revokeConsentSessions removes the consents of a user, so the clients ask for them again and get the current
+// groups and roles. A failure here is logged, the change itself was already made
+func (dapi *DefaultAdminAPI) revokeConsentSessions(username string) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Unable to revoke the consent sessions of '%v': %v", username, r)
+		}
+	}()
+
+	dapi.HydraHelper.RevokeConsentSessions(dapi.GetSubject(username), "")
+}
+
+// getGroupNames gets the names of groups or roles
+func getGroupNames(groups []db.Group) []string {
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		names = append(names, group.Name)
+	}
+
+	return names
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
 	"github.com/labbsr0x/goh/gohserver"
 	"github.com/labbsr0x/goh/gohtypes"
 	"github.com/labbsr0x/whisper/db"
@@ -9,8 +11,12 @@ import (
 	"github.com/labbsr0x/whisper/misc"
 	"github.com/labbsr0x/whisper/web/ui"
 	"github.com/sirupsen/logrus"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labbsr0x/whisper/web/api/types"
 	"github.com/labbsr0x/whisper/web/config"
@@ -20,18 +26,28 @@ import (
 type LoginAPI interface {
 	LoginGETHandler(route string) http.Handler
 	LoginPOSTHandler() http.Handler
+	LoginSecondFactorPOSTHandler() http.Handler
+	MagicLinkPOSTHandler() http.Handler
+	MagicLinkGETHandler(route string) http.Handler
+	MagicLinkPUTHandler() http.Handler
 }
 
 // DefaultLoginAPI holds the default implementation of the User API interface
 type DefaultLoginAPI struct {
 	*config.WebBuilder
 	UserCredentialsDAO db.UserCredentialsDAO
+	RecoveryCodesDAO   db.RecoveryCodesDAO
+	UsedTokensDAO      db.UsedTokensDAO
+	LoginThrottler     *loginThrottler
 }
 
 // InitFromWebBuilder initializes a default login api instance
 func (dapi *DefaultLoginAPI) InitFromWebBuilder(w *config.WebBuilder) *DefaultLoginAPI {
 	dapi.WebBuilder = w
-	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.SecretKey, w.BaseUIPath, w.PublicURL, w.Outbox, w.DB)
+	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.Keyring, w.Hasher, w.HardenedMode, w.BaseUIPath, w.PublicURL, w.Realm, w.Outbox, w.IdentityStore, w.DB)
+	dapi.RecoveryCodesDAO = new(db.DefaultRecoveryCodesDAO).Init(w.Keyring, w.Hasher, w.DB)
+	dapi.UsedTokensDAO = new(db.DefaultUsedTokensDAO).Init(w.DB)
+	dapi.LoginThrottler = new(loginThrottler).InitFromWebBuilder(w)
 	return dapi
 }
 
@@ -43,16 +59,79 @@ func (dapi *DefaultLoginAPI) LoginPOSTHandler() http.Handler {
 		err := misc.UnmarshalPayloadFromRequest(&payload, r)
 		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
 
-		userCredential := dapi.UserCredentialsDAO.CheckCredentials(payload.Username, payload.Password)
+		refuseForeignRealm(dapi.Realm, dapi.HydraHelper.GetLoginRequestInfo(payload.Challenge))
+
+		dapi.LoginThrottler.Check(w, r, payload.Username)
+		userCredential := func() db.UserCredential {
+			defer dapi.LoginThrottler.FailOnPanic(r, payload.Username)
+			return dapi.UserCredentialsDAO.CheckCredentials(payload.Username, payload.Password)
+		}()
 
 		if !userCredential.EmailValidated {
-			dapi.Outbox <- mail.GetEmailConfirmationMail(dapi.BaseUIPath, dapi.SecretKey, dapi.PublicURL, userCredential.Username, userCredential.Email, payload.Challenge)
-			gohtypes.Panic("This account email is not authenticated, an email was sent to you confirm your email", http.StatusUnauthorized)
+			gohtypes.Panic("This account email is not confirmed yet, check your inbox or ask for the confirmation email to be sent again", http.StatusUnauthorized)
 		}
 
+		if userCredential.TOTPEnabled {
+			gohserver.WriteJSONResponse(map[string]interface{}{
+				"second_factor": "totp",
+				"token":         misc.GetSecondFactorToken(dapi.Keyring, userCredential.Username, payload.Challenge, hydra.AMRPassword, payload.Remember),
+			}, http.StatusOK, w)
+			return
+		}
+
+		dapi.LoginThrottler.Reset(payload.Username)
 		info := dapi.HydraHelper.AcceptLoginRequest(
 			payload.Challenge,
-			hydra.AcceptLoginRequestPayload{ACR: "0", Remember: payload.Remember, RememberFor: 3600, Subject: payload.Username},
+			hydra.AcceptLoginRequestPayload{
+				ACR:         hydra.ACRSingleFactor,
+				AMR:         []string{hydra.AMRPassword},
+				Remember:    payload.Remember,
+				RememberFor: 3600,
+				Subject:     dapi.GetSubject(userCredential.Username),
+			},
+		)
+		logrus.Debugf("Accept login request info: %v", info)
+		if info != nil {
+			gohserver.WriteJSONResponse(map[string]interface{}{
+				"redirect_to": info["redirect_to"],
+			}, http.StatusOK, w)
+			return
+		}
+	})
+}
+
+// LoginSecondFactorPOSTHandler post form handler for finishing the login of users with a second factor
+func (dapi *DefaultLoginAPI) LoginSecondFactorPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.RequestSecondFactorPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		claims, err := misc.ParseToken(payload.Token, dapi.Keyring)
+		gohtypes.PanicIfError("Your login session expired, please sign in again", http.StatusUnauthorized, err)
+
+		username, challenge, firstFactor, remember, err := misc.UnmarshalSecondFactorToken(claims)
+		gohtypes.PanicIfError("Unable to unmarshal token", http.StatusBadRequest, err)
+
+		refuseForeignRealm(dapi.Realm, dapi.HydraHelper.GetLoginRequestInfo(challenge))
+
+		dapi.LoginThrottler.Check(w, r, username)
+		amr := func() []string {
+			defer dapi.LoginThrottler.FailOnPanic(r, username)
+			return dapi.checkSecondFactor(username, firstFactor, payload)
+		}()
+
+		dapi.LoginThrottler.Reset(username)
+		info := dapi.HydraHelper.AcceptLoginRequest(
+			challenge,
+			hydra.AcceptLoginRequestPayload{
+				ACR:         hydra.ACRMultiFactor,
+				AMR:         amr,
+				Remember:    remember,
+				RememberFor: 3600,
+				Subject:     dapi.GetSubject(username),
+			},
 		)
 		logrus.Debugf("Accept login request info: %v", info)
 		if info != nil {
@@ -64,6 +143,168 @@ func (dapi *DefaultLoginAPI) LoginPOSTHandler() http.Handler {
 	})
 }
 
+// checkSecondFactor verifies the totp or recovery code of a login, returning the authentication methods used
+func (dapi *DefaultLoginAPI) checkSecondFactor(username, firstFactor string, payload types.RequestSecondFactorPayload) []string {
+	if len(payload.Code) > 0 {
+		err := dapi.UserCredentialsDAO.CheckTOTPCode(username, payload.Code)
+		gohtypes.PanicIfError("Invalid two-factor authentication code", http.StatusUnauthorized, err)
+
+		return []string{firstFactor, hydra.AMROTP, hydra.AMRMFA}
+	}
+
+	userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(username)
+	gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+	remaining, err := dapi.RecoveryCodesDAO.ConsumeRecoveryCode(userCredential.ID, payload.RecoveryCode)
+	gohtypes.PanicIfError("Invalid recovery code", http.StatusUnauthorized, err)
+	logrus.Infof("Recovery code used by '%v', %v remaining", username, remaining)
+
+	dapi.Outbox <- mail.GetRecoveryCodeUsedMail(dapi.BaseUIPath, dapi.PublicURL, userCredential.Username, userCredential.Email, remaining)
+	return []string{firstFactor, hydra.AMRRecoveryCode, hydra.AMRMFA}
+}
+
+// MagicLinkPOSTHandler post form handler for mailing a single use sign in link to the owner of an email.
+// It answers the same whether or not the email belongs to someone
+func (dapi *DefaultLoginAPI) MagicLinkPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.RequestMagicLinkPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		info := dapi.HydraHelper.GetLoginRequestInfo(payload.Challenge)
+		refuseForeignRealm(dapi.Realm, info)
+		if !isMagicLinkEnabled(info) {
+			gohtypes.Panic("Sign in links are not enabled for this application", http.StatusForbidden)
+		}
+
+		email := strings.ToLower(strings.TrimSpace(payload.Email))
+		dapi.LoginThrottler.RefuseLockedOut(w, r, email)
+		dapi.limitMagicLinks(w, r, email)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByEmail(email)
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+		}
+
+		// the registrant of an unconfirmed email may not own it, a link would sign its owner in to their account
+		if err == nil && userCredential.IsActive() && userCredential.EmailValidated {
+			dapi.Outbox <- mail.GetMagicLinkMail(dapi.BaseUIPath, dapi.Keyring, dapi.PublicURL, userCredential.Username, userCredential.Email, userCredential.SecurityStamp, payload.Challenge, payload.Remember)
+			logrus.Infof("Sign in link mailed to '%v'", userCredential.Username)
+		}
+
+		gohserver.WriteJSONResponse(map[string]interface{}{
+			"message": "If this email belongs to an account, a sign in link was sent to it",
+		}, http.StatusOK, w)
+	})
+}
+
+// limitMagicLinks refuses with a 429 the sign in links asked for too often, to the same email or from the same client
+// ip, so whisper can not be used to flood a mailbox
+func (dapi *DefaultLoginAPI) limitMagicLinks(w http.ResponseWriter, r *http.Request, email string) {
+	retryAfter := dapi.LoginThrottler.Limit(throttleScopeMagicLinkIP, misc.GetClientIP(r, dapi.TrustForwardedFor), dapi.MagicLinkIPLimit, time.Hour)
+	if retryAfter == 0 {
+		retryAfter = dapi.LoginThrottler.Limit(throttleScopeMagicLink, email, 1, time.Second*dapi.MagicLinkInterval)
+	}
+
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		gohtypes.Panic("Too many sign in links asked for, please wait before asking for another one", http.StatusTooManyRequests)
+	}
+}
+
+// MagicLinkGETHandler builds the page where the user that followed a link mailed to it confirms to sign in. The link is
+// only spent once confirmed, so the mail scanners following it do not sign in in place of the user
+func (dapi *DefaultLoginAPI) MagicLinkGETHandler(route string) http.Handler {
+	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := misc.ExtractClaimsTokenFromRequest(dapi.Keyring, r)
+		gohtypes.PanicIfError("This sign in link expired, please request another one", http.StatusUnauthorized, err)
+
+		userCredential, _, _, _ := dapi.getMagicLinkUser(claims)
+
+		ui.WritePage(w, dapi.BaseUIPath, ui.MagicLink, &types.MagicLinkPage{Username: userCredential.Username})
+	}))
+}
+
+// MagicLinkPUTHandler signs in the user that confirmed to sign in with a link mailed to it, spending the link
+func (dapi *DefaultLoginAPI) MagicLinkPUTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.ConfirmMagicLinkPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		claims, err := misc.ParseToken(payload.Token, dapi.Keyring)
+		gohtypes.PanicIfError("This sign in link expired, please request another one", http.StatusUnauthorized, err)
+
+		userCredential, challenge, remember, singleUse := dapi.getMagicLinkUser(claims)
+		refuseForeignRealm(dapi.Realm, dapi.HydraHelper.GetLoginRequestInfo(challenge))
+		useSingleUseToken(dapi.UsedTokensDAO, userCredential, singleUse)
+		username := userCredential.Username
+
+		if userCredential.TOTPEnabled {
+			token := misc.GetSecondFactorToken(dapi.Keyring, username, challenge, hydra.AMRMagicLink, remember)
+			gohserver.WriteJSONResponse(map[string]interface{}{
+				"redirect_to": "/login?login_challenge=" + url.QueryEscape(challenge) + "&second_factor_token=" + url.QueryEscape(token),
+			}, http.StatusOK, w)
+			return
+		}
+
+		info := dapi.HydraHelper.AcceptLoginRequest(
+			challenge,
+			hydra.AcceptLoginRequestPayload{
+				ACR:         hydra.ACRSingleFactor,
+				AMR:         []string{hydra.AMRMagicLink},
+				Remember:    remember,
+				RememberFor: 3600,
+				Subject:     dapi.GetSubject(username),
+			},
+		)
+		logrus.Debugf("Accept login request info: %v", info)
+		if info != nil {
+			gohserver.WriteJSONResponse(map[string]interface{}{"redirect_to": info["redirect_to"]}, http.StatusOK, w)
+		}
+	})
+}
+
+// getMagicLinkUser gets the user a sign in link token was mailed to, along with its login request, refusing the users
+// that can not sign in with it
+func (dapi *DefaultLoginAPI) getMagicLinkUser(claims jwt.MapClaims) (userCredential db.UserCredential, challenge string, remember bool, singleUse misc.SingleUseToken) {
+	username, challenge, remember, singleUse, err := misc.UnmarshalMagicLinkToken(claims)
+	gohtypes.PanicIfError("Unable to unmarshal token", http.StatusBadRequest, err)
+
+	userCredential, err = dapi.UserCredentialsDAO.GetUserCredential(username)
+	if err == nil {
+		err = dapi.UserCredentialsDAO.CheckIdentity(username)
+	}
+	if err != nil || !userCredential.IsActive() {
+		gohtypes.Panic("This account is not able to sign in", http.StatusUnauthorized)
+	}
+
+	if !userCredential.EmailValidated {
+		gohtypes.Panic("The email of this account is not confirmed, please confirm it first", http.StatusForbidden)
+	}
+
+	return userCredential, challenge, remember, singleUse
+}
+
+// isMagicLinkEnabled tells whether the client of a login request opted in to sign in links through its metadata
+func isMagicLinkEnabled(info map[string]interface{}) bool {
+	client, _ := info["client"].(map[string]interface{})
+	metadata, _ := client["metadata"].(map[string]interface{})
+	enabled, _ := metadata["magic_link"].(bool)
+
+	return enabled
+}
+
+// refuseForeignRealm refuses with a 403 to sign in for the client of a login request that names another realm. Only
+// the login pages are redirected to the realm of their client, the posted logins may still reach any realm
+func refuseForeignRealm(realm string, info map[string]interface{}) {
+	if clientRealm, ok := misc.GetClientRealm(info); ok && clientRealm != realm {
+		gohtypes.Panic("This application signs in the users of another realm", http.StatusForbidden)
+	}
+}
+
 // LoginGETHandler prompts the browser to the login UI or redirects it to hydra
 func (dapi *DefaultLoginAPI) LoginGETHandler(route string) http.Handler {
 	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -73,6 +314,22 @@ func (dapi *DefaultLoginAPI) LoginGETHandler(route string) http.Handler {
 			logrus.Debugf("Login Request Info: %v", info)
 			if info["skip"].(bool) {
 				subject := info["subject"].(string)
+				username, ok := dapi.GetUsername(subject)
+				if !ok {
+					dapi.refuseForeignLogin(w, r, challenge, subject)
+					return
+				}
+
+				userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(username)
+				if err == nil {
+					err = dapi.UserCredentialsDAO.CheckIdentity(username)
+				}
+
+				if err != nil || !userCredential.IsActive() {
+					dapi.refuseSkippedLogin(w, r, challenge, subject, userCredential, err)
+					return
+				}
+
 				info = dapi.HydraHelper.AcceptLoginRequest(
 					challenge,
 					hydra.AcceptLoginRequestPayload{Subject: subject},
@@ -82,7 +339,12 @@ func (dapi *DefaultLoginAPI) LoginGETHandler(route string) http.Handler {
 					http.Redirect(w, r, info["redirect_to"].(string), http.StatusFound)
 				}
 			} else {
-				page := types.LoginPage{Challenge: challenge}
+				page := types.LoginPage{
+					Challenge: challenge,
+					ReadOnly:  dapi.UserCredentialsDAO.IsReadOnly(),
+					Providers: getUpstreamProviderItems(dapi.UpstreamProviders),
+					MagicLink: isMagicLinkEnabled(info),
+				}
 				ui.WritePage(w, dapi.BaseUIPath, ui.Login, &page)
 			}
 			return
@@ -90,3 +352,35 @@ func (dapi *DefaultLoginAPI) LoginGETHandler(route string) http.Handler {
 		panic(gohtypes.Error{Code: http.StatusBadRequest, Err: err, Message: "Unable to parse the login_challenge"})
 	}))
 }
+
+// refuseForeignLogin rejects the login hydra would skip for a subject of another realm, whose session the browser
+// still has. The session is kept, the user signs out of it to sign in to this realm
+func (dapi *DefaultLoginAPI) refuseForeignLogin(w http.ResponseWriter, r *http.Request, challenge, subject string) {
+	logrus.Infof("Login request skip refused for subject '%v' of another realm", subject)
+
+	info := dapi.HydraHelper.RejectLoginRequest(challenge, hydra.RejectLoginRequestPayload{
+		Error:            "access_denied",
+		ErrorDescription: "You are signed in to another realm, sign out of it first",
+	})
+	http.Redirect(w, r, info["redirect_to"].(string), http.StatusFound)
+}
+
+// refuseSkippedLogin rejects the login hydra would skip for a subject that is gone or no longer active, signing it out
+// of the sessions it still has
+func (dapi *DefaultLoginAPI) refuseSkippedLogin(w http.ResponseWriter, r *http.Request, challenge, subject string, userCredential db.UserCredential, err error) {
+	if err != nil && !gorm.IsRecordNotFoundError(err) && err != db.ErrIdentityNotFound {
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+	}
+
+	description := "The account no longer exists"
+	if err == nil {
+		description = db.GetUserStatusMessage(userCredential.Status)
+	}
+	logrus.Infof("Login request skip refused for subject '%v': %v", subject, description)
+
+	dapi.HydraHelper.RevokeLoginSessions(subject)
+	dapi.HydraHelper.RevokeConsentSessions(subject, "")
+
+	info := dapi.HydraHelper.RejectLoginRequest(challenge, hydra.RejectLoginRequestPayload{Error: "access_denied", ErrorDescription: description})
+	http.Redirect(w, r, info["redirect_to"].(string), http.StatusFound)
+}
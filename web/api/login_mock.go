@@ -15,3 +15,7 @@ func (mock *MockLoginAPI) LoginGETHandler(route string) http.Handler {
 func (mock *MockLoginAPI) LoginPOSTHandler() http.Handler {
 	return nil
 }
+
+func (mock *MockLoginAPI) LoginSecondFactorPOSTHandler() http.Handler {
+	return nil
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/hydra"
+	"github.com/labbsr0x/whisper/mail"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/api/types"
+)
+
+// testIdentityStore is a directory matching the usernames regardless of their case, as ldap and active directory do
+type testIdentityStore map[string]db.Identity
+
+func (store testIdentityStore) Authenticate(username, password string) (db.Identity, error) {
+	identity, err := store.GetIdentity(username)
+	if err == nil && password != "password of "+identity.Username {
+		err = db.ErrInvalidPassword
+	}
+
+	return identity, err
+}
+
+func (store testIdentityStore) GetIdentity(username string) (db.Identity, error) {
+	identity, ok := store[strings.ToLower(username)]
+	if !ok {
+		return db.Identity{}, db.ErrIdentityNotFound
+	}
+
+	return identity, nil
+}
+
+func (store testIdentityStore) IsReadOnly() bool {
+	return true
+}
+
+func TestLoginSubject(t *testing.T) {
+	testHydra := newTestHydra(t, map[string]interface{}{
+		"GET /oauth2/auth/requests/login":        getTestLoginInfo("app", nil),
+		"PUT /oauth2/auth/requests/login/accept": map[string]interface{}{"redirect_to": "http://hydra/consent"},
+	})
+
+	builder := newTestWebBuilder(t)
+	builder.HydraHelper = testHydra.Helper()
+	builder.IdentityStore = testIdentityStore{"alice": {Username: "alice", Email: "alice@example.com"}}
+	dapi := new(DefaultLoginAPI).InitFromWebBuilder(builder)
+
+	payload := types.RequestLoginPayload{Username: "ALICE", Password: "password of alice", Challenge: "challenge"}
+	if w := serve(dapi.LoginPOSTHandler(), newTestRequest(http.MethodPost, "/login", payload)); w.Code != http.StatusOK {
+		t.Fatalf("expected alice signed in, got %v %v", w.Code, w.Body)
+	}
+
+	accepted := testHydra.Requests(http.MethodPut, "/oauth2/auth/requests/login/accept")
+	if len(accepted) != 1 || accepted[0].Body["subject"] != "alice" {
+		t.Errorf("expected the login accepted for the username of the directory, got %+v", accepted)
+	}
+}
+
+func TestLoginRealm(t *testing.T) {
+	var tests = []struct {
+		metadata map[string]interface{}
+		code     int
+	}{
+		{nil, http.StatusOK},
+		{map[string]interface{}{"realm": ""}, http.StatusOK},
+		{map[string]interface{}{"realm": "sales"}, http.StatusForbidden},
+	}
+
+	builder := newTestWebBuilder(t)
+	newTestUser(t, new(DefaultLoginAPI).InitFromWebBuilder(builder).UserCredentialsDAO, "alice")
+
+	for _, test := range tests {
+		testHydra := newTestHydra(t, map[string]interface{}{
+			"GET /oauth2/auth/requests/login":        getTestLoginInfo("app", test.metadata),
+			"PUT /oauth2/auth/requests/login/accept": map[string]interface{}{"redirect_to": "http://hydra/consent"},
+		})
+		builder.HydraHelper = testHydra.Helper()
+		dapi := new(DefaultLoginAPI).InitFromWebBuilder(builder)
+
+		// posted straight to the default realm, without following the redirect of the login page to the realm of the client
+		payload := types.RequestLoginPayload{Username: "alice", Password: "password of alice", Challenge: "challenge"}
+		if w := serve(dapi.LoginPOSTHandler(), newTestRequest(http.MethodPost, "/login", payload)); w.Code != test.code {
+			t.Errorf("%v: expected %v signing alice in, got %v %v", test.metadata, test.code, w.Code, w.Body)
+		}
+
+		token := misc.GetSecondFactorToken(dapi.Keyring, "alice", "challenge", hydra.AMRPassword, false)
+		secondFactor := types.RequestSecondFactorPayload{Token: token, Code: "000000"}
+		if w := serve(dapi.LoginSecondFactorPOSTHandler(), newTestRequest(http.MethodPost, "/login/second-factor", secondFactor)); test.code == http.StatusForbidden && w.Code != test.code {
+			t.Errorf("%v: expected the second factor of alice refused, got %v %v", test.metadata, w.Code, w.Body)
+		}
+
+		accepted := testHydra.Requests(http.MethodPut, "/oauth2/auth/requests/login/accept")
+		if test.code == http.StatusForbidden && len(accepted) != 0 {
+			t.Errorf("%v: expected no login accepted for the client of another realm, got %+v", test.metadata, accepted)
+		}
+	}
+}
+
+func TestLoginSkip(t *testing.T) {
+	builder := newTestWebBuilder(t)
+	dao := new(DefaultLoginAPI).InitFromWebBuilder(builder).UserCredentialsDAO
+
+	alice := newTestUser(t, dao, "alice")
+	bob := newTestUser(t, dao, "bob")
+	if err := dao.SetUserCredentialStatus(bob.ID, db.UserStatusSuspended, "testing"); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		subject     string
+		accepted    bool
+		description string
+	}{
+		{alice.Username, true, ""},
+		{bob.Username, false, "This account is suspended"},
+		{"carol", false, "The account no longer exists"},
+	}
+
+	for _, test := range tests {
+		info := getTestLoginInfo("app", nil)
+		info["skip"] = true
+		info["subject"] = test.subject
+
+		testHydra := newTestHydra(t, map[string]interface{}{
+			"GET /oauth2/auth/requests/login":        info,
+			"PUT /oauth2/auth/requests/login/accept": map[string]interface{}{"redirect_to": "http://hydra/consent"},
+			"PUT /oauth2/auth/requests/login/reject": map[string]interface{}{"redirect_to": "http://app/callback?error=access_denied"},
+			"DELETE /oauth2/auth/sessions/login":     http.StatusNoContent,
+			"DELETE /oauth2/auth/sessions/consent":   http.StatusNoContent,
+		})
+		builder.HydraHelper = testHydra.Helper()
+		dapi := new(DefaultLoginAPI).InitFromWebBuilder(builder)
+
+		w := serve(dapi.LoginGETHandler("/login"), newTestRequest(http.MethodGet, "/login?login_challenge=challenge", nil))
+		if w.Code != http.StatusFound {
+			t.Errorf("%v: expected a redirect, got %v %v", test.subject, w.Code, w.Body)
+			continue
+		}
+
+		accepted := testHydra.Requests(http.MethodPut, "/oauth2/auth/requests/login/accept")
+		rejected := testHydra.Requests(http.MethodPut, "/oauth2/auth/requests/login/reject")
+		revoked := testHydra.Requests(http.MethodDelete, "/oauth2/auth/sessions/login")
+
+		if test.accepted {
+			if len(accepted) != 1 || len(rejected) != 0 || len(revoked) != 0 || w.Header().Get("Location") != "http://hydra/consent" {
+				t.Errorf("%v: expected the login skipped, got %v accepted, %v rejected, %v revoked", test.subject, len(accepted), len(rejected), len(revoked))
+			}
+			continue
+		}
+
+		if len(accepted) != 0 || len(rejected) != 1 || rejected[0].Body["error_description"] != test.description {
+			t.Errorf("%v: expected the skip rejected with '%v', got %+v", test.subject, test.description, rejected)
+		}
+
+		if len(revoked) != 1 || revoked[0].Query.Get("subject") != test.subject || len(testHydra.Requests(http.MethodDelete, "/oauth2/auth/sessions/consent")) != 1 {
+			t.Errorf("%v: expected the sessions revoked, got %+v", test.subject, revoked)
+		}
+	}
+}
+
+func TestMagicLinkEmailCase(t *testing.T) {
+	testHydra := newTestHydra(t, map[string]interface{}{
+		"GET /oauth2/auth/requests/login": getTestLoginInfo("app", map[string]interface{}{"magic_link": true}),
+	})
+
+	outbox := make(chan mail.Mail, 1)
+	builder := newTestWebBuilder(t)
+	builder.BaseUIPath = "../ui/www"
+	builder.Outbox = outbox
+	builder.HydraHelper = testHydra.Helper()
+	builder.MagicLinkIPLimit = 10
+	dapi := new(DefaultLoginAPI).InitFromWebBuilder(builder)
+
+	// registered with an email as typed, in mixed case
+	if _, err := dapi.UserCredentialsDAO.CreateUserCredential("alice", "password of alice", "Alice@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dapi.UserCredentialsDAO.ValidateUserCredentialEmail("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := types.RequestMagicLinkPayload{Email: " alice@EXAMPLE.com", Challenge: "challenge"}
+	if w := serve(dapi.MagicLinkPOSTHandler(), newTestRequest(http.MethodPost, "/login/magic-link", payload)); w.Code != http.StatusOK {
+		t.Fatalf("expected the link asked for, got %v %v", w.Code, w.Body)
+	}
+
+	select {
+	case sent := <-outbox:
+		if len(sent.To) != 1 || sent.To[0] != "Alice@Example.com" {
+			t.Errorf("expected the link mailed to the email of alice, got %v", sent.To)
+		}
+	default:
+		t.Error("expected a link mailed to alice")
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labbsr0x/goh/gohserver"
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/labbsr0x/whisper/web/ui"
+	"github.com/sirupsen/logrus"
+)
+
+// LogoutAPI defines the available logout apis
+type LogoutAPI interface {
+	LogoutGETHandler(route string) http.Handler
+	LogoutPOSTHandler() http.Handler
+}
+
+// DefaultLogoutAPI holds the default implementation of the Logout API interface
+type DefaultLogoutAPI struct {
+	*config.WebBuilder
+}
+
+// InitFromWebBuilder initializes a default logout api instance from a web builder instance
+func (dapi *DefaultLogoutAPI) InitFromWebBuilder(webBuilder *config.WebBuilder) *DefaultLogoutAPI {
+	dapi.WebBuilder = webBuilder
+	return dapi
+}
+
+// LogoutGETHandler prompts the browser to the logout confirmation UI or, for trusted clients, redirects it to hydra
+func (dapi *DefaultLogoutAPI) LogoutGETHandler(route string) http.Handler {
+	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		challenge, err := url.QueryUnescape(r.URL.Query().Get("logout_challenge"))
+		gohtypes.PanicIfError("Unable to parse the logout_challenge parameter", http.StatusBadRequest, err)
+
+		info := dapi.HydraHelper.GetLogoutRequestInfo(challenge)
+		logrus.Debugf("Logout Request Info: '%v'", info)
+
+		if rpInitiated, _ := info["rp_initiated"].(bool); rpInitiated && dapi.isTrustedLogoutClient(getLogoutClientID(info)) {
+			info = dapi.HydraHelper.AcceptLogoutRequest(challenge)
+			logrus.Debugf("Logout request skipped for '%v'", info)
+
+			redirectTo, ok := info["redirect_to"].(string)
+			if !ok {
+				gohtypes.Panic("Unable to accept the logout request", http.StatusInternalServerError)
+			}
+			http.Redirect(w, r, redirectTo, http.StatusFound)
+			return
+		}
+
+		subject, _ := info["subject"].(string)
+		page := types.LogoutPage{Subject: subject, Challenge: challenge}
+		ui.WritePage(w, dapi.BaseUIPath, ui.Logout, &page)
+	}))
+}
+
+// LogoutPOSTHandler post form handler for confirming or cancelling a logout
+func (dapi *DefaultLogoutAPI) LogoutPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.LogoutRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		if !payload.Accept {
+			dapi.HydraHelper.RejectLogoutRequest(payload.Challenge)
+			gohserver.WriteJSONResponse(map[string]interface{}{}, http.StatusOK, w)
+			return
+		}
+
+		info := dapi.HydraHelper.AcceptLogoutRequest(payload.Challenge)
+		logrus.Debugf("Logout Accept Info: '%v'", info)
+		gohserver.WriteJSONResponse(map[string]interface{}{
+			"redirect_to": info["redirect_to"],
+		}, http.StatusOK, w)
+	})
+}
+
+// isTrustedLogoutClient verifies if the client may log users out without their confirmation
+func (dapi *DefaultLogoutAPI) isTrustedLogoutClient(clientID string) bool {
+	for _, trusted := range dapi.TrustedLogout {
+		if trusted != "" && trusted == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getLogoutClientID finds the client that initiated a logout, either informed by hydra or taken from the id token hint
+// of the logout request, which hydra already validated before issuing the challenge
+func getLogoutClientID(info map[string]interface{}) string {
+	if client, ok := info["client"].(map[string]interface{}); ok {
+		if clientID, ok := client["client_id"].(string); ok {
+			return clientID
+		}
+	}
+
+	requestURL, _ := info["request_url"].(string)
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return ""
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(u.Query().Get("id_token_hint"), claims); err != nil {
+		return ""
+	}
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud
+	case []interface{}:
+		if len(aud) == 1 {
+			clientID, _ := aud[0].(string)
+			return clientID
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/labbsr0x/whisper/web/api/types"
+)
+
+// getTestIDTokenHint signs an id token with the given audience, as hint of a logout request
+func getTestIDTokenHint(t *testing.T, aud interface{}) string {
+	hint, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"aud": aud, "sub": "alice"}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hint
+}
+
+func TestGetLogoutClientID(t *testing.T) {
+	logoutURL := "http://localhost:4444/oauth2/sessions/logout?id_token_hint="
+
+	var tests = []struct {
+		info     map[string]interface{}
+		clientID string
+	}{
+		{map[string]interface{}{"client": map[string]interface{}{"client_id": "app"}, "request_url": logoutURL + getTestIDTokenHint(t, "other")}, "app"},
+		{map[string]interface{}{"request_url": logoutURL + getTestIDTokenHint(t, "app")}, "app"},
+		{map[string]interface{}{"request_url": logoutURL + getTestIDTokenHint(t, []string{"app"})}, "app"},
+		{map[string]interface{}{"request_url": logoutURL + getTestIDTokenHint(t, []string{"app", "other"})}, ""},
+		{map[string]interface{}{"request_url": logoutURL + getTestIDTokenHint(t, 42)}, ""},
+		{map[string]interface{}{"request_url": logoutURL + "not.a.token"}, ""},
+		{map[string]interface{}{"request_url": logoutURL}, ""},
+		{map[string]interface{}{"request_url": "%zz"}, ""},
+		{map[string]interface{}{}, ""},
+	}
+
+	for i, test := range tests {
+		if clientID := getLogoutClientID(test.info); clientID != test.clientID {
+			t.Errorf("%v: expected the client '%v', got '%v'", i, test.clientID, clientID)
+		}
+	}
+}
+
+func TestIsTrustedLogoutClient(t *testing.T) {
+	builder := newTestWebBuilder(t)
+	builder.TrustedLogout = []string{"app", ""}
+	dapi := new(DefaultLogoutAPI).InitFromWebBuilder(builder)
+
+	for clientID, trusted := range map[string]bool{"app": true, "other": false, "": false} {
+		if dapi.isTrustedLogoutClient(clientID) != trusted {
+			t.Errorf("'%v': expected trusted to be %v", clientID, trusted)
+		}
+	}
+}
+
+func TestLogoutGET(t *testing.T) {
+	var tests = []struct {
+		clientID string
+		accepted interface{}
+		code     int
+	}{
+		{"app", map[string]interface{}{"redirect_to": "http://app/logged-out"}, http.StatusFound},
+		{"app", map[string]interface{}{}, http.StatusInternalServerError},
+		{"app", http.StatusInternalServerError, http.StatusInternalServerError},
+		{"other", nil, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		testHydra := newTestHydra(t, map[string]interface{}{
+			"GET /oauth2/auth/requests/logout":        map[string]interface{}{"subject": "alice", "rp_initiated": true, "client": map[string]interface{}{"client_id": test.clientID}},
+			"PUT /oauth2/auth/requests/logout/accept": test.accepted,
+		})
+
+		builder := newTestWebBuilder(t)
+		builder.BaseUIPath = "../ui/www"
+		builder.HydraHelper = testHydra.Helper()
+		builder.TrustedLogout = []string{"app"}
+		dapi := new(DefaultLogoutAPI).InitFromWebBuilder(builder)
+
+		w := serve(dapi.LogoutGETHandler("/logout"), newTestRequest(http.MethodGet, "/logout?logout_challenge=challenge", nil))
+		if w.Code != test.code {
+			t.Errorf("%v answered %v: expected %v, got %v %v", test.clientID, test.accepted, test.code, w.Code, w.Body)
+			continue
+		}
+
+		accepted := len(testHydra.Requests(http.MethodPut, "/oauth2/auth/requests/logout/accept"))
+		if test.code == http.StatusFound && w.Header().Get("Location") != "http://app/logged-out" {
+			t.Errorf("%v: expected a redirect to the client, got '%v'", test.clientID, w.Header().Get("Location"))
+		}
+
+		if test.code == http.StatusOK && accepted != 0 {
+			t.Errorf("%v: expected the user asked to confirm, got the logout accepted", test.clientID)
+		}
+	}
+}
+
+func TestLogoutPOST(t *testing.T) {
+	testHydra := newTestHydra(t, map[string]interface{}{
+		"PUT /oauth2/auth/requests/logout/accept": map[string]interface{}{"redirect_to": "http://app/logged-out"},
+		"PUT /oauth2/auth/requests/logout/reject": http.StatusNoContent,
+	})
+
+	builder := newTestWebBuilder(t)
+	builder.HydraHelper = testHydra.Helper()
+	dapi := new(DefaultLogoutAPI).InitFromWebBuilder(builder)
+
+	w := serve(dapi.LogoutPOSTHandler(), newTestRequest(http.MethodPost, "/logout", types.LogoutRequestPayload{Accept: true, Challenge: "challenge"}))
+	var response map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil || w.Code != http.StatusOK || response["redirect_to"] != "http://app/logged-out" {
+		t.Errorf("expected the logout accepted, got %v %v (%v)", w.Code, response, err)
+	}
+
+	w = serve(dapi.LogoutPOSTHandler(), newTestRequest(http.MethodPost, "/logout", types.LogoutRequestPayload{Accept: false, Challenge: "challenge"}))
+	if rejected := testHydra.Requests(http.MethodPut, "/oauth2/auth/requests/logout/reject"); w.Code != http.StatusOK || len(rejected) != 1 || rejected[0].Query.Get("challenge") != "challenge" {
+		t.Errorf("expected the logout rejected, got %v with %+v", w.Code, rejected)
+	}
+
+	testHydra.mutex.Lock()
+	testHydra.responses["PUT /oauth2/auth/requests/logout/accept"] = http.StatusConflict
+	testHydra.mutex.Unlock()
+	w = serve(dapi.LogoutPOSTHandler(), newTestRequest(http.MethodPost, "/logout", types.LogoutRequestPayload{Accept: true, Challenge: "challenge"}))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected the refusal of hydra reported, got %v %v", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labbsr0x/goh/gohserver"
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/sirupsen/logrus"
+)
+
+// RecoveryCodesAPI defines the available recovery codes apis
+type RecoveryCodesAPI interface {
+	POSTHandler() http.Handler
+}
+
+// DefaultRecoveryCodesAPI holds the default implementation of the Recovery Codes API interface
+type DefaultRecoveryCodesAPI struct {
+	*config.WebBuilder
+	UserCredentialsDAO db.UserCredentialsDAO
+	RecoveryCodesDAO   db.RecoveryCodesDAO
+}
+
+// InitFromWebBuilder initializes the default recovery codes API from a WebBuilder
+func (dapi *DefaultRecoveryCodesAPI) InitFromWebBuilder(w *config.WebBuilder) *DefaultRecoveryCodesAPI {
+	dapi.WebBuilder = w
+	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.Keyring, w.Hasher, w.HardenedMode, w.BaseUIPath, w.PublicURL, w.Realm, w.Outbox, w.IdentityStore, w.DB)
+	dapi.RecoveryCodesDAO = new(db.DefaultRecoveryCodesDAO).Init(w.Keyring, w.Hasher, w.DB)
+
+	return dapi
+}
+
+// POSTHandler regenerates the recovery codes of the signed in user, invalidating the previous ones
+func (dapi *DefaultRecoveryCodesAPI) POSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		codes, err := dapi.RecoveryCodesDAO.GenerateRecoveryCodes(userCredential.ID)
+		gohtypes.PanicIfError("Unable to generate recovery codes", http.StatusInternalServerError, err)
+		logrus.Infof("Recovery codes regenerated for '%v'", token.Subject)
+
+		gohserver.WriteJSONResponse(types.RecoveryCodesResponsePayload{RecoveryCodes: codes}, http.StatusOK, w)
+	})
+}
+
+// issueMissingRecoveryCodes generates recovery codes for a user whose logins are gated by a totp second factor, when it
+// has none left. The codes only replace the totp code, so the users signing in with a passkey get none
+func issueMissingRecoveryCodes(dao db.RecoveryCodesDAO, userCredential db.UserCredential) []string {
+	if !userCredential.TOTPEnabled {
+		return make([]string, 0)
+	}
+
+	count, err := dao.CountRecoveryCodes(userCredential.ID)
+	gohtypes.PanicIfError("Unable to retrieve recovery codes", http.StatusInternalServerError, err)
+
+	if count > 0 {
+		return make([]string, 0)
+	}
+
+	codes, err := dao.GenerateRecoveryCodes(userCredential.ID)
+	gohtypes.PanicIfError("Unable to generate recovery codes", http.StatusInternalServerError, err)
+
+	return codes
+}
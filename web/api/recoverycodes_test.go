@@ -0,0 +1,28 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestIssueMissingRecoveryCodes(t *testing.T) {
+	dapi := new(DefaultRecoveryCodesAPI).InitFromWebBuilder(newTestWebBuilder(t))
+	alice := newTestUser(t, dapi.UserCredentialsDAO, "alice")
+
+	// signing in with a passkey, without a totp code to replace
+	if codes := issueMissingRecoveryCodes(dapi.RecoveryCodesDAO, alice); len(codes) != 0 {
+		t.Errorf("expected no codes without two-factor authentication, got %v", codes)
+	}
+
+	if count, err := dapi.RecoveryCodesDAO.CountRecoveryCodes(alice.ID); count != 0 || err != nil {
+		t.Errorf("expected no codes stored, got %v (%v)", count, err)
+	}
+
+	alice.TOTPEnabled = true
+	if codes := issueMissingRecoveryCodes(dapi.RecoveryCodesDAO, alice); len(codes) == 0 {
+		t.Error("expected codes issued with two-factor authentication")
+	}
+
+	if codes := issueMissingRecoveryCodes(dapi.RecoveryCodesDAO, alice); len(codes) != 0 {
+		t.Errorf("expected the codes issued once, got %v", codes)
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/labbsr0x/whisper/web/ui"
+	"github.com/sirupsen/logrus"
+)
+
+// SessionsAPI defines the available session management apis
+type SessionsAPI interface {
+	GETPageHandler(route string) http.Handler
+	ConsentDELETEHandler() http.Handler
+	LoginDELETEHandler() http.Handler
+}
+
+// DefaultSessionsAPI holds the default implementation of the Sessions API interface
+type DefaultSessionsAPI struct {
+	*config.WebBuilder
+}
+
+// InitFromWebBuilder initializes the default sessions API from a WebBuilder
+func (dapi *DefaultSessionsAPI) InitFromWebBuilder(w *config.WebBuilder) *DefaultSessionsAPI {
+	dapi.WebBuilder = w
+	return dapi
+}
+
+// GETPageHandler builds the page where the signed in user sees the clients it has consented to
+func (dapi *DefaultSessionsAPI) GETPageHandler(route string) http.Handler {
+	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := getSessionsToken(r)
+
+		redirectTo, err := url.QueryUnescape(r.URL.Query().Get("redirect_to"))
+		gohtypes.PanicIfError("Unable to parse the redirect_to parameter", http.StatusBadRequest, err)
+
+		page := types.SessionsPage{
+			Username:   token.Subject,
+			RedirectTo: redirectTo,
+			Consents:   make([]types.ConsentSessionItem, 0),
+		}
+		for _, session := range dapi.HydraHelper.ListConsentSessions(dapi.GetSubject(token.Subject)) {
+			page.Consents = append(page.Consents, getConsentSessionItem(session))
+		}
+
+		ui.WritePage(w, dapi.BaseUIPath, ui.Sessions, &page)
+	}))
+}
+
+// ConsentDELETEHandler revokes the consents the signed in user has granted to a client, along with the client's tokens
+func (dapi *DefaultSessionsAPI) ConsentDELETEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := getSessionsToken(r)
+
+		clientID := mux.Vars(r)["client"]
+		if clientID == "" {
+			gohtypes.Panic("Client not informed", http.StatusBadRequest)
+		}
+
+		dapi.HydraHelper.RevokeConsentSessions(dapi.GetSubject(token.Subject), clientID)
+		logrus.Infof("Consent to '%v' revoked by '%v'", clientID, token.Subject)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// LoginDELETEHandler signs the signed in user out of every login session
+func (dapi *DefaultSessionsAPI) LoginDELETEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := getSessionsToken(r)
+
+		dapi.HydraHelper.RevokeLoginSessions(dapi.GetSubject(token.Subject))
+		logrus.Infof("Login sessions revoked by '%v'", token.Subject)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func getSessionsToken(r *http.Request) whisper.Token {
+	token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+	if !ok {
+		gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+	}
+
+	return token
+}
+
+// getConsentSessionItem extracts what is shown of a consent session listed by hydra
+func getConsentSessionItem(session map[string]interface{}) types.ConsentSessionItem {
+	item := types.ConsentSessionItem{Scopes: make([]string, 0)}
+
+	if request, ok := session["consent_request"].(map[string]interface{}); ok {
+		if client, ok := request["client"].(map[string]interface{}); ok {
+			item.ClientID, _ = client["client_id"].(string)
+			item.ClientName, _ = client["client_name"].(string)
+		}
+	}
+
+	if item.ClientName == "" {
+		item.ClientName = item.ClientID
+	}
+
+	if scopes, ok := session["grant_scope"].([]interface{}); ok {
+		for _, scope := range scopes {
+			if s, ok := scope.(string); ok {
+				item.Scopes = append(item.Scopes, s)
+			}
+		}
+	}
+
+	if handledAt, ok := session["handled_at"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, handledAt); err == nil {
+			item.GrantedAt = t.Format("2006-01-02 15:04")
+		}
+	}
+
+	return item
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	whisper "github.com/labbsr0x/whisper-client/client"
+	"github.com/labbsr0x/whisper/web/api/types"
+)
+
+func TestGetConsentSessionItem(t *testing.T) {
+	var tests = []struct {
+		session map[string]interface{}
+		item    types.ConsentSessionItem
+	}{
+		{
+			map[string]interface{}{
+				"consent_request": map[string]interface{}{"client": map[string]interface{}{"client_id": "app", "client_name": "The App"}},
+				"grant_scope":     []interface{}{"openid", "offline"},
+				"handled_at":      "2019-08-01T13:45:00Z",
+			},
+			types.ConsentSessionItem{ClientID: "app", ClientName: "The App", Scopes: []string{"openid", "offline"}, GrantedAt: "2019-08-01 13:45"},
+		},
+		{
+			map[string]interface{}{
+				"consent_request": map[string]interface{}{"client": map[string]interface{}{"client_id": "app"}},
+				"grant_scope":     []interface{}{"openid", 42},
+				"handled_at":      "yesterday",
+			},
+			types.ConsentSessionItem{ClientID: "app", ClientName: "app", Scopes: []string{"openid"}},
+		},
+		{
+			map[string]interface{}{"consent_request": "app", "grant_scope": "openid"},
+			types.ConsentSessionItem{Scopes: []string{}},
+		},
+	}
+
+	for i, test := range tests {
+		if item := getConsentSessionItem(test.session); !reflect.DeepEqual(item, test.item) {
+			t.Errorf("%v: expected %+v, got %+v", i, test.item, item)
+		}
+	}
+}
+
+func TestSessionsRevoke(t *testing.T) {
+	testHydra := newTestHydra(t, map[string]interface{}{
+		"DELETE /oauth2/auth/sessions/login":   http.StatusNoContent,
+		"DELETE /oauth2/auth/sessions/consent": http.StatusNoContent,
+	})
+
+	builder := newTestWebBuilder(t)
+	builder.HydraHelper = testHydra.Helper()
+	dapi := new(DefaultSessionsAPI).InitFromWebBuilder(builder)
+
+	signedIn := func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), whisper.TokenKey, whisper.Token{Subject: "alice"}))
+	}
+
+	r := mux.SetURLVars(signedIn(newTestRequest(http.MethodDelete, "/secure/sessions/consent/app", nil)), map[string]string{"client": "app"})
+	if w := serve(dapi.ConsentDELETEHandler(), r); w.Code != http.StatusOK {
+		t.Errorf("expected the consent revoked, got %v %v", w.Code, w.Body)
+	}
+
+	if revoked := testHydra.Requests(http.MethodDelete, "/oauth2/auth/sessions/consent"); len(revoked) != 1 || revoked[0].Query.Get("subject") != "alice" || revoked[0].Query.Get("client") != "app" {
+		t.Errorf("expected the consent of alice to the app revoked, got %+v", revoked)
+	}
+
+	if w := serve(dapi.LoginDELETEHandler(), signedIn(newTestRequest(http.MethodDelete, "/secure/sessions/login", nil))); w.Code != http.StatusOK {
+		t.Errorf("expected the login sessions revoked, got %v %v", w.Code, w.Body)
+	}
+
+	if revoked := testHydra.Requests(http.MethodDelete, "/oauth2/auth/sessions/login"); len(revoked) != 1 || revoked[0].Query.Get("subject") != "alice" {
+		t.Errorf("expected the login sessions of alice revoked, got %+v", revoked)
+	}
+
+	if w := serve(dapi.LoginDELETEHandler(), newTestRequest(http.MethodDelete, "/secure/sessions/login", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the anonymous revocation refused, got %v", w.Code)
+	}
+}
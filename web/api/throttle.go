@@ -0,0 +1,262 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/labbsr0x/whisper/web/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// Scopes of the login throttles
+const (
+	throttleScopeUser     = "user"
+	throttleScopeIP       = "ip"
+	throttleScopeResend   = "resend"
+	throttleScopeResendIP = "resend-ip"
+
+	throttleScopeMagicLink   = "magic-link"
+	throttleScopeMagicLinkIP = "magic-link-ip"
+)
+
+// loginThrottlePolicy defines after how many failed attempts a key is locked out and for how long
+type loginThrottlePolicy struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// loginThrottler counts the failed login attempts per username and per client ip, locking them out with an exponential back-off
+type loginThrottler struct {
+	dao               db.LoginThrottlesDAO
+	policies          map[string]loginThrottlePolicy
+	window            time.Duration
+	trustForwardedFor bool
+	realm             string
+}
+
+// InitFromWebBuilder initializes a login throttler from a WebBuilder
+func (t *loginThrottler) InitFromWebBuilder(w *config.WebBuilder) *loginThrottler {
+	t.dao = new(db.DefaultLoginThrottlesDAO).Init(w.DB)
+	t.window = time.Second * w.ThrottleWindow
+	t.trustForwardedFor = w.TrustForwardedFor
+	t.realm = w.Realm
+	t.policies = map[string]loginThrottlePolicy{
+		throttleScopeUser: {Threshold: w.ThrottleUser, BaseDelay: time.Second * w.ThrottleBaseDelay, MaxDelay: time.Second * w.ThrottleMaxDelay},
+		throttleScopeIP:   {Threshold: w.ThrottleIP, BaseDelay: time.Second * w.ThrottleBaseDelay, MaxDelay: time.Second * w.ThrottleMaxDelay},
+	}
+
+	return t
+}
+
+// Check counts a login attempt against its username and client ip before it is made, refusing it with a 429 while
+// either one is locked out. The attempt is counted as failed until its step succeeds, so concurrent attempts are decided
+// from the count each one got and can not all be made before the first failures lock the key out
+func (t *loginThrottler) Check(w http.ResponseWriter, r *http.Request, username string) {
+	keys := t.getKeys(r, username)
+	retryAfter := t.getRetryAfter(keys)
+
+	if retryAfter == 0 {
+		var counted []string
+		unlocked := map[string]string{}
+
+		for scope, key := range keys {
+			throttle, err := t.dao.RegisterLoginFailure(key, t.window)
+			gohtypes.PanicIfError("Unable to check the login attempts", http.StatusInternalServerError, err)
+			counted = append(counted, key)
+
+			delay := t.policies[scope].getDelay(throttle.Failures - 1)
+			if delay == 0 {
+				continue
+			}
+
+			// the attempts counted before this one deserve a lock out: only the first attempt once it expired is made
+			if ok, err := t.dao.UnlockLoginThrottle(key); err != nil {
+				logrus.Errorf("Unable to unlock '%v': %v", key, err)
+			} else if ok {
+				unlocked[scope] = key
+				continue
+			}
+
+			if delay > retryAfter {
+				retryAfter = delay
+			}
+		}
+
+		if retryAfter > 0 {
+			t.takeBack(counted, unlocked)
+		} else {
+			for scope := range unlocked {
+				metrics.LoginUnlocks.WithLabelValues(scope, "expired").Inc()
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		refuse(w, retryAfter, "Too many failed login attempts, please try again later")
+	}
+}
+
+// takeBack forgets the attempts counted on keys for a refused login, giving back the expired locks it cleared
+func (t *loginThrottler) takeBack(counted []string, unlocked map[string]string) {
+	for _, key := range counted {
+		if err := t.dao.ForgetLoginFailure(key); err != nil {
+			logrus.Errorf("Unable to forget the refused login attempt of '%v': %v", key, err)
+		}
+	}
+
+	for _, key := range unlocked {
+		if err := t.dao.LockLoginThrottle(key, time.Now()); err != nil {
+			logrus.Errorf("Unable to lock out '%v' again: %v", key, err)
+		}
+	}
+}
+
+// RefuseLockedOut refuses with a 429 an action made for a username or from a client ip while it is locked out,
+// without counting it as a login attempt
+func (t *loginThrottler) RefuseLockedOut(w http.ResponseWriter, r *http.Request, username string) {
+	if retryAfter := t.getRetryAfter(t.getKeys(r, username)); retryAfter > 0 {
+		refuse(w, retryAfter, "Too many failed login attempts, please try again later")
+	}
+}
+
+// Limit lets an action on a key run up to the given runs per interval, such as mailing a user again. It records the run
+// and returns 0 when the action may run, otherwise how long until it may
+func (t *loginThrottler) Limit(scope, value string, runs int, interval time.Duration) time.Duration {
+	key := t.getKey(scope, value)
+
+	if retryAfter := t.getRetryAfter(map[string]string{scope: key}); retryAfter > 0 {
+		return retryAfter
+	}
+
+	// counted like a failed login, the key being locked until the next run is allowed once it ran all its runs
+	throttle, err := t.dao.RegisterLoginFailure(key, interval)
+	gohtypes.PanicIfError("Unable to record the attempt", http.StatusInternalServerError, err)
+
+	// concurrent runs were counted first
+	if throttle.Failures > runs {
+		if throttle.LockedUntil != nil && time.Until(*throttle.LockedUntil) > 0 {
+			return time.Until(*throttle.LockedUntil)
+		}
+		return interval
+	}
+
+	if throttle.Failures == runs {
+		err = t.dao.LockLoginThrottle(key, time.Now().Add(interval))
+		gohtypes.PanicIfError("Unable to record the attempt", http.StatusInternalServerError, err)
+	}
+
+	return 0
+}
+
+// FailOnPanic must be deferred around an authentication step: when the step panics, the attempt is counted as failed,
+// otherwise the attempt counted by Check is taken back
+func (t *loginThrottler) FailOnPanic(r *http.Request, username string) {
+	if recovered := recover(); recovered != nil {
+		t.fail(r, username)
+		panic(recovered)
+	}
+
+	for _, key := range t.getKeys(r, username) {
+		if err := t.dao.ForgetLoginFailure(key); err != nil {
+			logrus.Errorf("Unable to forget the login attempt of '%v': %v", key, err)
+		}
+	}
+}
+
+// Reset forgets the failed attempts of a username once it signs in
+func (t *loginThrottler) Reset(username string) {
+	if err := t.dao.ResetLoginThrottle(t.getKey(throttleScopeUser, username)); err != nil {
+		logrus.Errorf("Unable to reset the login attempts of '%v': %v", username, err)
+	}
+}
+
+// fail locks out the keys whose counted attempts went over their threshold
+func (t *loginThrottler) fail(r *http.Request, username string) {
+	for scope, key := range t.getKeys(r, username) {
+		throttle, err := t.dao.GetLoginThrottle(key)
+		if err != nil {
+			logrus.Errorf("Unable to count the failed login attempt of '%v': %v", key, err)
+			continue
+		}
+
+		delay := t.policies[scope].getDelay(throttle.Failures)
+		if delay == 0 {
+			continue
+		}
+
+		if err := t.dao.LockLoginThrottle(key, time.Now().Add(delay)); err != nil {
+			logrus.Errorf("Unable to lock out '%v': %v", key, err)
+			continue
+		}
+
+		metrics.LoginLockouts.WithLabelValues(scope).Inc()
+		logrus.Warnf("'%v' locked out for %v after %v failed login attempts", key, delay, throttle.Failures)
+	}
+}
+
+func (t *loginThrottler) getKeys(r *http.Request, username string) map[string]string {
+	return map[string]string{
+		throttleScopeUser: t.getKey(throttleScopeUser, username),
+		throttleScopeIP:   t.getKey(throttleScopeIP, misc.GetClientIP(r, t.trustForwardedFor)),
+	}
+}
+
+// getRetryAfter gets how long until none of the keys is locked out anymore
+func (t *loginThrottler) getRetryAfter(keys map[string]string) time.Duration {
+	var retryAfter time.Duration
+
+	for _, key := range keys {
+		throttle, err := t.dao.GetLoginThrottle(key)
+		gohtypes.PanicIfError("Unable to check the login attempts", http.StatusInternalServerError, err)
+
+		if throttle.LockedUntil != nil {
+			if wait := time.Until(*throttle.LockedUntil); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	return retryAfter
+}
+
+// getKey gets the key of a throttle. The client ips are throttled across the realms, the rest within their realm. The
+// usernames and emails being case insensitive, they are throttled whatever their case
+func (t *loginThrottler) getKey(scope, value string) string {
+	if scope != throttleScopeIP && scope != throttleScopeResendIP && scope != throttleScopeMagicLinkIP {
+		value = misc.GetRealmSubject(t.realm, strings.ToLower(strings.TrimSpace(value)))
+	}
+
+	return scope + ":" + value
+}
+
+// getDelay gets how long a key is locked out after the given failed attempts, doubling at each attempt over the threshold
+func (p loginThrottlePolicy) getDelay(failures int) time.Duration {
+	if p.Threshold <= 0 || failures < p.Threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+
+	return delay
+}
+
+// refuse answers a 429 telling when to try again
+func refuse(w http.ResponseWriter, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	gohtypes.Panic(message, http.StatusTooManyRequests)
+}
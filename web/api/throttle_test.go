@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/goh/gohtypes"
+)
+
+func TestLoginThrottlePolicyDelay(t *testing.T) {
+	policy := loginThrottlePolicy{Threshold: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	var tests = []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		if delay := policy.getDelay(test.failures); delay != test.delay {
+			t.Errorf("%v failures: expected a %v delay, got %v", test.failures, test.delay, delay)
+		}
+	}
+
+	if delay := (loginThrottlePolicy{BaseDelay: time.Second, MaxDelay: time.Minute}).getDelay(100); delay != 0 {
+		t.Errorf("a policy without threshold should never lock out, got %v", delay)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	builder := newTestWebBuilder(t)
+	builder.ThrottleUser = 2
+	builder.ThrottleIP = 100
+	builder.ThrottleBaseDelay = 60
+	builder.ThrottleMaxDelay = 600
+	builder.ThrottleWindow = 3600
+	throttler := new(loginThrottler).InitFromWebBuilder(builder)
+
+	// check runs the check of a login attempt only, as it is seen by the attempts concurrent to it
+	check := func(username string) int {
+		return serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			throttler.Check(w, r, username)
+		}), newTestRequest(http.MethodPost, "/login", nil)).Code
+	}
+
+	attempt := func(username string, success bool) int {
+		return serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			throttler.Check(w, r, username)
+			defer throttler.FailOnPanic(r, username)
+			if !success {
+				gohtypes.Panic("Wrong password", http.StatusUnauthorized)
+			}
+		}), newTestRequest(http.MethodPost, "/login", nil)).Code
+	}
+
+	for i := 0; i < 5; i++ {
+		if code := attempt("alice", true); code != http.StatusOK {
+			t.Fatalf("the successful attempts should not lock alice out, got %v at the attempt %v", code, i+1)
+		}
+	}
+
+	if check("bob") != http.StatusOK || check("bob") != http.StatusOK {
+		t.Fatal("expected the attempts under the threshold made")
+	}
+	if code := check("bob"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the attempt over the threshold refused while the others are made, got %v", code)
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := attempt("Carol", false); code != http.StatusUnauthorized {
+			t.Fatalf("expected the attempt %v made, got %v", i+1, code)
+		}
+	}
+
+	w := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		throttler.Check(w, r, " carol")
+	}), newTestRequest(http.MethodPost, "/login", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected carol locked out whatever the case of the username, got %v retry after '%v'", w.Code, w.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/labbsr0x/goh/gohserver"
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+)
+
+// TOTPAPI defines the available totp enrollment apis
+type TOTPAPI interface {
+	POSTHandler() http.Handler
+	PUTHandler() http.Handler
+	DELETEHandler() http.Handler
+}
+
+// DefaultTOTPAPI holds the default implementation of the TOTP API interface
+type DefaultTOTPAPI struct {
+	*config.WebBuilder
+	UserCredentialsDAO db.UserCredentialsDAO
+	RecoveryCodesDAO   db.RecoveryCodesDAO
+}
+
+// InitFromWebBuilder initializes the default totp API from a WebBuilder
+func (dapi *DefaultTOTPAPI) InitFromWebBuilder(w *config.WebBuilder) *DefaultTOTPAPI {
+	dapi.WebBuilder = w
+	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.Keyring, w.Hasher, w.HardenedMode, w.BaseUIPath, w.PublicURL, w.Realm, w.Outbox, w.IdentityStore, w.DB)
+	dapi.RecoveryCodesDAO = new(db.DefaultRecoveryCodesDAO).Init(w.Keyring, w.Hasher, w.DB)
+
+	return dapi
+}
+
+// POSTHandler starts a totp enrollment, answering with the secret to be registered in an authenticator app
+func (dapi *DefaultTOTPAPI) POSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		secret, err := dapi.UserCredentialsDAO.StartTOTPEnrollment(token.Subject)
+		gohtypes.PanicIfError("Unable to start two-factor authentication enrollment", http.StatusBadRequest, err)
+
+		uri := misc.GetTOTPURI(secret, token.Subject)
+
+		png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		gohtypes.PanicIfError("Unable to generate QR code", http.StatusInternalServerError, err)
+
+		gohserver.WriteJSONResponse(types.TOTPEnrollmentResponsePayload{
+			Secret: secret,
+			URI:    uri,
+			QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		}, http.StatusOK, w)
+	})
+}
+
+// PUTHandler confirms a totp enrollment with a code generated by the authenticator app, answering with recovery codes if the user has none
+func (dapi *DefaultTOTPAPI) PUTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.TOTPCodeRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		err = dapi.UserCredentialsDAO.ConfirmTOTPEnrollment(token.Subject, payload.Code)
+		gohtypes.PanicIfError("Unable to confirm two-factor authentication", http.StatusBadRequest, err)
+		logrus.Infof("Two-factor authentication enabled for '%v'", token.Subject)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		codes := issueMissingRecoveryCodes(dapi.RecoveryCodesDAO, userCredential)
+		gohserver.WriteJSONResponse(types.RecoveryCodesResponsePayload{RecoveryCodes: codes}, http.StatusOK, w)
+	})
+}
+
+// DELETEHandler disables the totp second factor of the user
+func (dapi *DefaultTOTPAPI) DELETEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.TOTPCodeRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		err = dapi.UserCredentialsDAO.DisableTOTP(token.Subject, payload.Code)
+		gohtypes.PanicIfError("Unable to disable two-factor authentication", http.StatusBadRequest, err)
+		logrus.Infof("Two-factor authentication disabled for '%v'", token.Subject)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
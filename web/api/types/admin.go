@@ -0,0 +1,112 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// AdminUserResponsePayload defines how a user is shown by the admin apis
+type AdminUserResponsePayload struct {
+	ID              string     `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	EmailValidated  bool       `json:"email_validated"`
+	TOTPEnabled     bool       `json:"totp_enabled"`
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"status_reason,omitempty"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// GetAdminUserResponsePayload gets how a stored user is shown by the admin apis
+func GetAdminUserResponsePayload(userCredential db.UserCredential) AdminUserResponsePayload {
+	return AdminUserResponsePayload{
+		ID:              userCredential.ID,
+		Username:        userCredential.Username,
+		Email:           userCredential.Email,
+		EmailValidated:  userCredential.EmailValidated,
+		TOTPEnabled:     userCredential.TOTPEnabled,
+		Status:          userCredential.Status,
+		StatusReason:    userCredential.StatusReason,
+		StatusChangedAt: userCredential.StatusChangedAt,
+		CreatedAt:       userCredential.CreatedAt,
+		UpdatedAt:       userCredential.UpdatedAt,
+	}
+}
+
+// AdminUserListResponsePayload defines the response payload of a page of users
+type AdminUserListResponsePayload struct {
+	Users   []AdminUserResponsePayload `json:"users"`
+	Total   int                        `json:"total"`
+	Page    int                        `json:"page"`
+	PerPage int                        `json:"per_page"`
+}
+
+// AdminAddUserRequestPayload defines the payload for an admin to add a user, whose email is taken as verified
+type AdminAddUserRequestPayload struct {
+	Username   string            `json:"username"`
+	Email      string            `json:"email"`
+	Password   string            `json:"password"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// Check validates payload. The password policy of the realm is checked apart
+func (payload *AdminAddUserRequestPayload) Check() error {
+	if len(payload.Username) == 0 || len(payload.Email) == 0 {
+		return fmt.Errorf("username and email fields should not be empty")
+	}
+
+	if err := misc.CheckUsername(payload.Username); err != nil {
+		return err
+	}
+
+	return misc.VerifyEmail(payload.Email)
+}
+
+// AdminUpdateUserRequestPayload defines the payload for an admin to change the username or email of a user
+type AdminUpdateUserRequestPayload struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// Check validates payload
+func (payload *AdminUpdateUserRequestPayload) Check() error {
+	if len(payload.Username) == 0 || len(payload.Email) == 0 {
+		return fmt.Errorf("username and email fields should not be empty")
+	}
+
+	return misc.VerifyEmail(payload.Email)
+}
+
+// AdminUserAttributesPayload defines the profile attributes of a user, as set and shown by the admin apis
+type AdminUserAttributesPayload struct {
+	Attributes map[string]string `json:"attributes"`
+}
+
+// Check validates payload
+func (payload *AdminUserAttributesPayload) Check() error {
+	if len(payload.Attributes) == 0 {
+		return fmt.Errorf("attributes field should not be empty")
+	}
+
+	return nil
+}
+
+// AdminUserStatusRequestPayload defines the payload for an admin to change the account status of a user
+type AdminUserStatusRequestPayload struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// Check validates payload
+func (payload *AdminUserStatusRequestPayload) Check() error {
+	if len(payload.Status) == 0 {
+		return fmt.Errorf("status field should not be empty")
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package types
+
+import (
+	"strings"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/hydra"
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// TokenUser holds what the claims about a user are taken from
+type TokenUser struct {
+	UserCredential db.UserCredential
+	Attributes     map[string]string
+	Groups         []string
+	Roles          []string
+}
+
+// GetTokenSession builds the claims hydra adds to the ID and access tokens a client gets for a user, releasing only
+// the claims of the granted scopes. Resource servers find the access token claims in the ext of its introspection
+func GetTokenSession(user TokenUser, clientID string, scopes misc.GrantScopes, grantedScopes []string) hydra.TokenSessionPayload {
+	claims := map[string]interface{}{}
+
+	for _, scope := range grantedScopes {
+		for _, claim := range scopes[scope].Claims {
+			if value := getClaimValue(claim, user, clientID); value != nil {
+				claims[claim.Claim] = value
+			}
+		}
+	}
+
+	return hydra.TokenSessionPayload{IDToken: claims, AccessToken: claims}
+}
+
+// getClaimValue gets the value a scope claim takes for a user, nil when the user has no such profile attribute
+func getClaimValue(claim misc.ScopeClaim, user TokenUser, clientID string) interface{} {
+	switch claim.Attribute {
+	case "":
+		return claim.Value
+	case misc.ClaimFromUsername:
+		return user.UserCredential.Username
+	case misc.ClaimFromEmail:
+		return user.UserCredential.Email
+	case misc.ClaimFromEmailVerified:
+		return user.UserCredential.EmailValidated
+	case misc.ClaimFromUpdatedAt:
+		return user.UserCredential.UpdatedAt.Unix()
+	case misc.ClaimFromGroups:
+		return getNamespacedNames(user.Groups, clientID, claim.ClientNamespace)
+	case misc.ClaimFromRoles:
+		return getNamespacedNames(user.Roles, clientID, claim.ClientNamespace)
+	}
+
+	if value := user.Attributes[claim.Attribute]; value != "" {
+		return value
+	}
+
+	return nil
+}
+
+// getNamespacedNames keeps the group or role names in the namespace of the client, without it, when restricted
+func getNamespacedNames(names []string, clientID string, restricted bool) []string {
+	released := make([]string, 0, len(names))
+	for _, name := range names {
+		if !restricted {
+			released = append(released, name)
+		} else if prefix := clientID + misc.RoleNamespaceSeparator; clientID != "" && strings.HasPrefix(name, prefix) {
+			released = append(released, strings.TrimPrefix(name, prefix))
+		}
+	}
+
+	return released
+}
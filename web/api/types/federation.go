@@ -0,0 +1,61 @@
+package types
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// UpstreamProviderItem defines an upstream provider offered on the login and update pages
+type UpstreamProviderItem struct {
+	ID   string
+	Name string
+}
+
+// UserIdentityItem defines the information of a linked identity shown in the update page
+type UserIdentityItem struct {
+	ID           string
+	ProviderName string
+	Email        string
+}
+
+// FederationLinkPage defines the data needed to build the page confirming an identity is linked to an existing account
+type FederationLinkPage struct {
+	misc.BasePage
+	ProviderName string
+	Username     string
+	Email        string
+	Challenge    string
+	HasPassword  bool
+}
+
+// SetHTML exposes the HTML from base page
+func (p *FederationLinkPage) SetHTML(html template.HTML) {
+	p.HTML = html
+}
+
+// LinkIdentityRequestPayload holds the data that starts linking an upstream provider to the signed in user
+type LinkIdentityRequestPayload struct {
+	ReturnTo string `json:"returnTo"`
+}
+
+// Check validates payload
+func (payload *LinkIdentityRequestPayload) Check() error {
+	if !strings.HasPrefix(payload.ReturnTo, "/secure/update") {
+		return fmt.Errorf("the page to return to should be the update page")
+	}
+
+	return nil
+}
+
+// ConfirmLinkRequestPayload holds the data that confirms an identity is linked to the account with the same email
+type ConfirmLinkRequestPayload struct {
+	Password string `json:"password"`
+}
+
+// Check validates payload
+func (payload *ConfirmLinkRequestPayload) Check() error {
+	return nil // the password is only needed by the accounts that have one
+}
@@ -0,0 +1,71 @@
+package types
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/labbsr0x/whisper/db"
+)
+
+// groupNameRegex matches the names of the groups and roles, which may be namespaced by a client id as in billing:admin
+var groupNameRegex = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9._:-]{0,127}$")
+
+// AdminGroupResponsePayload defines how a group or role is shown by the admin apis
+type AdminGroupResponsePayload struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GetAdminGroupResponsePayload gets how a stored group or role is shown by the admin apis
+func GetAdminGroupResponsePayload(group db.Group) AdminGroupResponsePayload {
+	return AdminGroupResponsePayload{
+		ID:          group.ID,
+		Name:        group.Name,
+		Description: group.Description,
+		CreatedAt:   group.CreatedAt,
+	}
+}
+
+// GetAdminGroupListResponsePayload gets how a list of groups or roles is shown by the admin apis
+func GetAdminGroupListResponsePayload(groups []db.Group) []AdminGroupResponsePayload {
+	payload := make([]AdminGroupResponsePayload, 0, len(groups))
+	for _, group := range groups {
+		payload = append(payload, GetAdminGroupResponsePayload(group))
+	}
+
+	return payload
+}
+
+// AdminGroupListResponsePayload defines the response payload of the groups or roles
+type AdminGroupListResponsePayload struct {
+	Groups []AdminGroupResponsePayload `json:"groups"`
+}
+
+// AdminUserGroupsResponsePayload defines the response payload of the groups and roles of a user
+type AdminUserGroupsResponsePayload struct {
+	Groups []AdminGroupResponsePayload `json:"groups"`
+	Roles  []AdminGroupResponsePayload `json:"roles"`
+}
+
+// AdminGroupMembersResponsePayload defines the response payload of the members of a group or role
+type AdminGroupMembersResponsePayload struct {
+	Users []AdminUserResponsePayload `json:"users"`
+}
+
+// AdminAddGroupRequestPayload defines the payload for an admin to add a group or role
+type AdminAddGroupRequestPayload struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Check validates payload
+func (payload *AdminAddGroupRequestPayload) Check() error {
+	if !groupNameRegex.MatchString(payload.Name) {
+		return fmt.Errorf("the name should have up to 128 letters, digits, dots, dashes, underscores or colons")
+	}
+
+	return nil
+}
@@ -13,6 +13,9 @@ type LoginPage struct {
 	ClientName      string
 	RequestedScopes []misc.GrantScope
 	Challenge       string
+	ReadOnly        bool
+	Providers       []UpstreamProviderItem
+	MagicLink       bool
 }
 
 // SetHTML exposes the HTML from base page
@@ -36,3 +39,60 @@ func (payload *RequestLoginPayload) Check() error {
 
 	return nil
 }
+
+// RequestSecondFactorPayload holds the data that completes a login request with a second factor
+type RequestSecondFactorPayload struct {
+	Token        string `json:"token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// Check validates payload
+func (payload *RequestSecondFactorPayload) Check() error {
+	if len(payload.Token) == 0 || (len(payload.Code) == 0 && len(payload.RecoveryCode) == 0) {
+		return fmt.Errorf("either a code or a recovery code should be informed")
+	}
+
+	return nil
+}
+
+// RequestMagicLinkPayload holds the data that asks for a sign in link mailed to the owner of an email
+type RequestMagicLinkPayload struct {
+	Email     string
+	Challenge string
+	Remember  bool
+}
+
+// Check validates payload
+func (payload *RequestMagicLinkPayload) Check() error {
+	if len(payload.Challenge) == 0 || len(payload.Email) == 0 {
+		return fmt.Errorf("no field should be empty")
+	}
+
+	return nil
+}
+
+// MagicLinkPage defines the data needed to build the page where a sign in link is followed
+type MagicLinkPage struct {
+	misc.BasePage
+	Username string
+}
+
+// SetHTML exposes the HTML from base page
+func (p *MagicLinkPage) SetHTML(html template.HTML) {
+	p.HTML = html
+}
+
+// ConfirmMagicLinkPayload holds the sign in link token the user confirmed to sign in with
+type ConfirmMagicLinkPayload struct {
+	Token string `json:"token"`
+}
+
+// Check validates payload
+func (payload *ConfirmMagicLinkPayload) Check() error {
+	if len(payload.Token) == 0 {
+		return fmt.Errorf("no field should be empty")
+	}
+
+	return nil
+}
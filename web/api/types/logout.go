@@ -0,0 +1,33 @@
+package types
+
+import (
+	"fmt"
+	"github.com/labbsr0x/whisper/misc"
+	"html/template"
+)
+
+// LogoutPage defines the data needed to build a logout page
+type LogoutPage struct {
+	misc.BasePage
+	Subject   string
+	Challenge string
+}
+
+// SetHTML exposes the HTML from base page
+func (p *LogoutPage) SetHTML(html template.HTML) {
+	p.HTML = html
+}
+
+// LogoutRequestPayload holds the data that defines a logout request to Whisper
+type LogoutRequestPayload struct {
+	Accept    bool
+	Challenge string
+}
+
+// Check validates payload
+func (payload *LogoutRequestPayload) Check() error {
+	if len(payload.Challenge) == 0 {
+		return fmt.Errorf("there must be a challenge")
+	}
+	return nil
+}
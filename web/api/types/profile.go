@@ -0,0 +1,62 @@
+package types
+
+import (
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// ProfileAttributeItem defines the information needed to show a profile attribute in the pages
+type ProfileAttributeItem struct {
+	Name      string
+	Label     string
+	InputType string
+	Value     string
+	MaxLength int
+	Required  bool
+	ReadOnly  bool
+}
+
+// GetProfileAttributeItems lists the profile attributes shown to who, one of the misc.ProfileBy constants, with their
+// current values. The attributes who cannot change are only shown when they have a value
+func GetProfileAttributeItems(schema misc.ProfileSchema, values map[string]string, by string) []ProfileAttributeItem {
+	items := make([]ProfileAttributeItem, 0, len(schema))
+	for _, attribute := range schema {
+		readOnly := !attribute.CanChange(by)
+		if readOnly && values[attribute.Name] == "" {
+			continue
+		}
+
+		items = append(items, ProfileAttributeItem{
+			Name:      attribute.Name,
+			Label:     attribute.Label,
+			InputType: getInputType(attribute.Type),
+			Value:     values[attribute.Name],
+			MaxLength: attribute.MaxLength,
+			Required:  attribute.Required,
+			ReadOnly:  readOnly,
+		})
+	}
+
+	return items
+}
+
+// getInputType gets the type of the html input of a profile attribute type
+func getInputType(attributeType string) string {
+	switch attributeType {
+	case misc.AttributeURL:
+		return "url"
+	case misc.AttributePhone:
+		return "tel"
+	default:
+		return "text"
+	}
+}
+
+// UpdateProfileRequestPayload defines the payload for updating the profile attributes of a user
+type UpdateProfileRequestPayload struct {
+	Attributes map[string]string `json:"attributes"`
+}
+
+// Check validates payload
+func (payload *UpdateProfileRequestPayload) Check() error {
+	return nil
+}
@@ -0,0 +1,28 @@
+package types
+
+import (
+	"html/template"
+
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// ConsentSessionItem defines the information of a consent granted to a client shown in the sessions page
+type ConsentSessionItem struct {
+	ClientID   string
+	ClientName string
+	Scopes     []string
+	GrantedAt  string
+}
+
+// SessionsPage defines the information needed to load the page where a user manages its sessions and consents
+type SessionsPage struct {
+	misc.BasePage
+	Username   string
+	RedirectTo string
+	Consents   []ConsentSessionItem
+}
+
+// SetHTML exposes the HTML from base page
+func (p *SessionsPage) SetHTML(html template.HTML) {
+	p.HTML = html
+}
@@ -0,0 +1,31 @@
+package types
+
+import (
+	"fmt"
+)
+
+// TOTPEnrollmentResponsePayload defines the response payload after starting a totp enrollment
+type TOTPEnrollmentResponsePayload struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qrcode"`
+}
+
+// TOTPCodeRequestPayload defines the payload for confirming or disabling a totp enrollment
+type TOTPCodeRequestPayload struct {
+	Code string `json:"code"`
+}
+
+// Check validates payload
+func (payload *TOTPCodeRequestPayload) Check() error {
+	if len(payload.Code) == 0 {
+		return fmt.Errorf("code field should not be empty")
+	}
+
+	return nil
+}
+
+// RecoveryCodesResponsePayload defines the response payload carrying newly generated recovery codes
+type RecoveryCodesResponsePayload struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
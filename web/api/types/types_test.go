@@ -1 +1,72 @@
 package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/misc"
+)
+
+func TestGetTokenSession(t *testing.T) {
+	userCredential := db.UserCredential{Username: "alice", Email: "alice@example.com", EmailValidated: true, UpdatedAt: time.Unix(1600000000, 0)}
+
+	schema, err := misc.NewProfileSchema([]misc.ProfileAttribute{{Name: "given_name"}, {Name: "phone_number"}, {Name: "department"}, {Name: "team", Scope: "org"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scopes, err := misc.NewGrantScopes(misc.GrantScopes{
+		"openid":  {Scope: "openid"},
+		"profile": {Scope: misc.ScopeProfile},
+		"email":   {Scope: misc.ScopeEmail},
+		"phone":   {Scope: "phone"},
+		"org":     {Scope: "org", Claims: []misc.ScopeClaim{{Claim: "dept", Attribute: "department"}, {Claim: "tenant", Value: "acme"}}},
+		"authz": {Scope: "authz", Claims: []misc.ScopeClaim{
+			{Claim: "groups", Attribute: misc.ClaimFromGroups},
+			{Claim: "roles", Attribute: misc.ClaimFromRoles, ClientNamespace: true},
+		}},
+	}, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := GetTokenSession(TokenUser{UserCredential: userCredential}, "app", scopes, []string{"openid"})
+	if claims := session.IDToken.(map[string]interface{}); len(claims) != 0 {
+		t.Errorf("only openid should release no claims, got %v", claims)
+	}
+
+	session = GetTokenSession(TokenUser{UserCredential: userCredential}, "app", scopes, []string{"openid", misc.ScopeEmail})
+	claims := session.IDToken.(map[string]interface{})
+	if claims["email"] != "alice@example.com" || claims["email_verified"] != true || claims["preferred_username"] != nil {
+		t.Errorf("the email scope should only release the email claims, got %v", claims)
+	}
+
+	session = GetTokenSession(TokenUser{UserCredential: userCredential}, "app", scopes, []string{"openid", misc.ScopeProfile})
+	claims = session.IDToken.(map[string]interface{})
+	if claims["preferred_username"] != "alice" || claims["updated_at"] != int64(1600000000) || claims["email"] != nil {
+		t.Errorf("the profile scope should only release the profile claims, got %v", claims)
+	}
+
+	attributes := map[string]string{"given_name": "Alice", "phone_number": "+5511999999999", "department": "R&D", "team": "Identity"}
+	session = GetTokenSession(TokenUser{UserCredential: userCredential, Attributes: attributes}, "app", scopes, []string{"openid", misc.ScopeProfile, "org"})
+	claims = session.IDToken.(map[string]interface{})
+	if claims["given_name"] != "Alice" || claims["team"] != "Identity" || claims["phone_number"] != nil || claims["department"] != nil {
+		t.Errorf("only the attributes of the granted scopes should be released, got %v", claims)
+	}
+
+	if claims["dept"] != "R&D" || claims["tenant"] != "acme" {
+		t.Errorf("the claims declared by a scope should be released, got %v", claims)
+	}
+
+	user := TokenUser{UserCredential: userCredential, Groups: []string{"staff"}, Roles: []string{"app:admin", "billing:admin", "apprentice"}}
+	session = GetTokenSession(user, "app", scopes, []string{"openid", "authz"})
+	claims = session.IDToken.(map[string]interface{})
+	if groups := claims["groups"].([]string); len(groups) != 1 || groups[0] != "staff" {
+		t.Errorf("expected every group released, got %v", claims)
+	}
+
+	if roles := claims["roles"].([]string); len(roles) != 1 || roles[0] != "admin" {
+		t.Errorf("expected only the roles in the namespace of the client released, got %v", claims)
+	}
+}
@@ -14,6 +14,7 @@ type RegistrationPage struct {
 	PasswordMinChar       int
 	PasswordMaxChar       int
 	PasswordMinUniqueChar int
+	Attributes            []ProfileAttributeItem
 }
 
 // SetHTML exposes the HTML from base page
@@ -61,6 +62,13 @@ type UpdatePage struct {
 	PasswordMinChar       int
 	PasswordMaxChar       int
 	PasswordMinUniqueChar int
+	TOTPEnabled           bool
+	WebAuthnCredentials   []WebAuthnCredentialItem
+	RecoveryCodes         int
+	ReadOnly              bool
+	Identities            []UserIdentityItem
+	Providers             []UpstreamProviderItem
+	Attributes            []ProfileAttributeItem
 }
 
 // SetHTML exposes the HTML from base page
@@ -75,14 +83,15 @@ type AddUserCredentialResponsePayload struct {
 
 // AddUserCredentialRequestPayload defines the payload for adding a user
 type AddUserCredentialRequestPayload struct {
-	Email                string `json:"email"`
-	Username             string `json:"username"`
-	Password             string `json:"password"`
-	PasswordConfirmation string `json:"passwordConfirmation"`
-	Challenge            string `json:"challenge"`
+	Email                string            `json:"email"`
+	Username             string            `json:"username"`
+	Password             string            `json:"password"`
+	PasswordConfirmation string            `json:"passwordConfirmation"`
+	Challenge            string            `json:"challenge"`
+	Attributes           map[string]string `json:"attributes"`
 }
 
-// Check validates payload
+// Check validates payload. The password policy of the realm is checked apart
 func (payload *AddUserCredentialRequestPayload) Check() error {
 	if len(payload.Username) == 0 || len(payload.Password) == 0 || len(payload.PasswordConfirmation) == 0 || len(payload.Email) == 0 {
 		return fmt.Errorf("only challenge field can be empty")
@@ -92,8 +101,7 @@ func (payload *AddUserCredentialRequestPayload) Check() error {
 		return fmt.Errorf("wrong password confirmation")
 	}
 
-	err := misc.ValidatePassword(payload.Password, payload.Username, payload.Email)
-	if err != nil {
+	if err := misc.CheckUsername(payload.Username); err != nil {
 		return err
 	}
 
@@ -136,6 +144,21 @@ func (payload *ChangePasswordStep1UserCredentialRequestPayload) Check() error {
 	return misc.VerifyEmail(payload.Email)
 }
 
+// ResendEmailConfirmationRequestPayload defines the payload for sending the email confirmation again
+type ResendEmailConfirmationRequestPayload struct {
+	Email     string `json:"email"`
+	Challenge string `json:"challenge"`
+}
+
+// Check validates payload
+func (payload *ResendEmailConfirmationRequestPayload) Check() error {
+	if len(payload.Email) == 0 {
+		return fmt.Errorf("email field should not be empty")
+	}
+
+	return misc.VerifyEmail(payload.Email)
+}
+
 // ChangePasswordStep2UserCredentialRequestPayload defines the payload for finish changing password
 type ChangePasswordStep2UserCredentialRequestPayload struct {
 	Token                   string `json:"token"`
@@ -0,0 +1,77 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/labbsr0x/whisper/webauthn"
+)
+
+// WebAuthnCredentialItem defines the information of a registered webauthn credential shown in the update page
+type WebAuthnCredentialItem struct {
+	ID        string
+	Name      string
+	CreatedAt string
+}
+
+// WebAuthnRegistrationOptionsResponsePayload defines the response payload to start a webauthn credential registration
+type WebAuthnRegistrationOptionsResponsePayload struct {
+	Token     string                   `json:"token"`
+	PublicKey webauthn.CreationOptions `json:"publicKey"`
+}
+
+// WebAuthnRegistrationRequestPayload defines the payload for finishing a webauthn credential registration
+type WebAuthnRegistrationRequestPayload struct {
+	Token      string                       `json:"token"`
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+// Check validates payload
+func (payload *WebAuthnRegistrationRequestPayload) Check() error {
+	if len(payload.Token) == 0 || len(payload.Credential.RawID) == 0 {
+		return fmt.Errorf("token and credential fields should not be empty")
+	}
+
+	if len(payload.Name) == 0 {
+		payload.Name = "Security key"
+	}
+
+	return nil
+}
+
+// WebAuthnLoginOptionsRequestPayload defines the payload to start a passwordless login
+type WebAuthnLoginOptionsRequestPayload struct {
+	Challenge string `json:"challenge"`
+	Username  string `json:"username"`
+	Remember  bool   `json:"remember"`
+}
+
+// Check validates payload
+func (payload *WebAuthnLoginOptionsRequestPayload) Check() error {
+	if len(payload.Challenge) == 0 {
+		return fmt.Errorf("there must be a challenge")
+	}
+
+	return nil
+}
+
+// WebAuthnLoginOptionsResponsePayload defines the response payload to start a passwordless login
+type WebAuthnLoginOptionsResponsePayload struct {
+	Token     string                  `json:"token"`
+	PublicKey webauthn.RequestOptions `json:"publicKey"`
+}
+
+// WebAuthnLoginRequestPayload defines the payload for finishing a passwordless login
+type WebAuthnLoginRequestPayload struct {
+	Token      string                     `json:"token"`
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+// Check validates payload
+func (payload *WebAuthnLoginRequestPayload) Check() error {
+	if len(payload.Token) == 0 || len(payload.Credential.RawID) == 0 {
+		return fmt.Errorf("token and credential fields should not be empty")
+	}
+
+	return nil
+}
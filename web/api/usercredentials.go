@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"github.com/jinzhu/gorm"
 	"github.com/labbsr0x/goh/gohserver"
 	"github.com/labbsr0x/goh/gohtypes"
 	whisper "github.com/labbsr0x/whisper-client/client"
@@ -11,10 +12,15 @@ import (
 	"github.com/labbsr0x/whisper/misc"
 	"github.com/labbsr0x/whisper/web/api/types"
 	"github.com/labbsr0x/whisper/web/config"
+	"github.com/labbsr0x/whisper/web/metrics"
 	"github.com/labbsr0x/whisper/web/ui"
 	"github.com/sirupsen/logrus"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // UserCredentialsAPI defines the available user apis
@@ -22,24 +28,39 @@ type UserCredentialsAPI interface {
 	POSTHandler() http.Handler
 	PUTHandler() http.Handler
 	GETEmailConfirmationPageHandler(route string) http.Handler
+	GETEmailConfirmationResendPageHandler(route string) http.Handler
+	POSTEmailConfirmationResendHandler() http.Handler
 	GETChangePasswordStep1PageHandler(route string) http.Handler
 	GETChangePasswordStep2PageHandler(route string) http.Handler
 	POSTChangePasswordPageHandler(route string) http.Handler
 	PUTChangePasswordPageHandler(route string) http.Handler
 	GETRegistrationPageHandler(route string) http.Handler
 	GETUpdatePageHandler(route string) http.Handler
+	PUTProfileHandler() http.Handler
 }
 
 // DefaultUserCredentialsAPI holds the default implementation of the User API interface
 type DefaultUserCredentialsAPI struct {
 	*config.WebBuilder
-	UserCredentialsDAO db.UserCredentialsDAO
+	UserCredentialsDAO     db.UserCredentialsDAO
+	WebAuthnCredentialsDAO db.WebAuthnCredentialsDAO
+	RecoveryCodesDAO       db.RecoveryCodesDAO
+	UserIdentitiesDAO      db.UserIdentitiesDAO
+	UsedTokensDAO          db.UsedTokensDAO
+	UserAttributesDAO      db.UserAttributesDAO
+	LoginThrottler         *loginThrottler
 }
 
 // InitFromWebBuilder initializes the default user credentials API from a WebBuilder
 func (dapi *DefaultUserCredentialsAPI) InitFromWebBuilder(w *config.WebBuilder) *DefaultUserCredentialsAPI {
 	dapi.WebBuilder = w
-	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.SecretKey, w.BaseUIPath, w.PublicURL, w.Outbox, w.DB)
+	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.Keyring, w.Hasher, w.HardenedMode, w.BaseUIPath, w.PublicURL, w.Realm, w.Outbox, w.IdentityStore, w.DB)
+	dapi.WebAuthnCredentialsDAO = new(db.DefaultWebAuthnCredentialsDAO).Init(w.DB)
+	dapi.RecoveryCodesDAO = new(db.DefaultRecoveryCodesDAO).Init(w.Keyring, w.Hasher, w.DB)
+	dapi.UserIdentitiesDAO = new(db.DefaultUserIdentitiesDAO).Init(w.Realm, w.DB)
+	dapi.UsedTokensDAO = new(db.DefaultUsedTokensDAO).Init(w.DB)
+	dapi.UserAttributesDAO = new(db.DefaultUserAttributesDAO).Init(w.DB)
+	dapi.LoginThrottler = new(loginThrottler).InitFromWebBuilder(w)
 
 	return dapi
 }
@@ -47,31 +68,75 @@ func (dapi *DefaultUserCredentialsAPI) InitFromWebBuilder(w *config.WebBuilder)
 // POSTHandler handles post requests to create user credentials
 func (dapi *DefaultUserCredentialsAPI) POSTHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db.RefuseIfReadOnly(dapi.UserCredentialsDAO)
+
 		var payload types.AddUserCredentialRequestPayload
 
 		err := misc.UnmarshalPayloadFromRequest(&payload, r)
 		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
 
+		err = dapi.PasswordPolicy.Validate(payload.Password, payload.Username, payload.Email)
+		gohtypes.PanicIfError("Invalid Password", http.StatusBadRequest, err)
+
+		attributes, err := dapi.ProfileSchema.CheckProfileAttributes(payload.Attributes, misc.ProfileByRegistration)
+		if err != nil {
+			gohtypes.Panic(err.Error(), http.StatusBadRequest)
+		}
+
+		if dapi.HardenedMode && dapi.reportRegistrationConflict(payload) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
 		userID, err := dapi.UserCredentialsDAO.CreateUserCredential(payload.Username, payload.Password, payload.Email)
 		gohtypes.PanicIfError("Not possible to create user", http.StatusInternalServerError, err)
 		logrus.Infof("User created: %v", userID)
 
-		dapi.Outbox <- mail.GetEmailConfirmationMail(dapi.BaseUIPath, dapi.SecretKey, dapi.PublicURL, payload.Username, payload.Email, payload.Challenge)
+		err = dapi.UserAttributesDAO.SetUserAttributes(userID, attributes)
+		gohtypes.PanicIfError("Unable to save the profile attributes", http.StatusInternalServerError, err)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByID(userID)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		dapi.Outbox <- mail.GetEmailConfirmationMail(dapi.BaseUIPath, dapi.Keyring, dapi.PublicURL, userCredential.Username, userCredential.Email, userCredential.SecurityStamp, payload.Challenge)
 
 		w.WriteHeader(http.StatusOK)
 	})
 }
 
+// reportRegistrationConflict mails a registration conflict to the owner of the existing account instead of answering
+// it, telling whether there was one. The registrant is told nothing, as it may not own the email it informed. The
+// password is hashed anyway so conflicts take as long to be answered as the successful registrations
+func (dapi *DefaultUserCredentialsAPI) reportRegistrationConflict(payload types.AddUserCredentialRequestPayload) bool {
+	owner, err := dapi.UserCredentialsDAO.GetUserCredentialByEmail(payload.Email)
+	emailTaken := err == nil
+	if !emailTaken {
+		owner, err = dapi.UserCredentialsDAO.GetUserCredential(payload.Username)
+	}
+
+	if err != nil {
+		return false
+	}
+
+	_, _ = dapi.Hasher.Hash(payload.Password)
+	dapi.Outbox <- mail.GetRegistrationConflictMail(dapi.BaseUIPath, dapi.PublicURL, owner.Username, owner.Email, emailTaken)
+	logrus.Infof("Registration conflict with the account of '%v' reported by email", owner.Username)
+
+	return true
+}
+
 // PUTHandler handles put requests to update user credentials
 func (dapi *DefaultUserCredentialsAPI) PUTHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db.RefuseIfReadOnly(dapi.UserCredentialsDAO)
+
 		var payload types.UpdateUserCredentialRequestPayload
 
 		err := misc.UnmarshalPayloadFromRequest(&payload, r)
 		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
 
 		if token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token); ok {
-			err := misc.ValidatePassword(payload.NewPassword, token.Subject, payload.Email)
+			err := dapi.PasswordPolicy.Validate(payload.NewPassword, token.Subject, payload.Email)
 			gohtypes.PanicIfError("Invalid Password", http.StatusBadRequest, err)
 
 			dapi.UserCredentialsDAO.CheckCredentials(token.Subject, payload.OldPassword)
@@ -87,15 +152,18 @@ func (dapi *DefaultUserCredentialsAPI) PUTHandler() http.Handler {
 // GETRegistrationPageHandler builds the page where new credentials will be inserted
 func (dapi *DefaultUserCredentialsAPI) GETRegistrationPageHandler(route string) http.Handler {
 	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db.RefuseIfReadOnly(dapi.UserCredentialsDAO)
+
 		challenge, err := url.QueryUnescape(r.URL.Query().Get("login_challenge"))
 		gohtypes.PanicIfError("Unable to parse the login_challenge parameter", http.StatusBadRequest, err)
 
 		page := types.RegistrationPage{
 			LoginChallenge:        challenge,
-			PasswordTooltip:       misc.PasswordTooltip,
-			PasswordMinChar:       misc.PasswordMinChar,
-			PasswordMaxChar:       misc.PasswordMaxChar,
-			PasswordMinUniqueChar: misc.PasswordMinUniqueChar,
+			PasswordTooltip:       dapi.PasswordPolicy.Tooltip(),
+			PasswordMinChar:       dapi.PasswordPolicy.MinChar,
+			PasswordMaxChar:       dapi.PasswordPolicy.MaxChar,
+			PasswordMinUniqueChar: dapi.PasswordPolicy.MinUniqueChar,
+			Attributes:            types.GetProfileAttributeItems(dapi.ProfileSchema, nil, misc.ProfileByRegistration),
 		}
 		ui.WritePage(w, dapi.BaseUIPath, ui.Registration, &page)
 	}))
@@ -103,7 +171,16 @@ func (dapi *DefaultUserCredentialsAPI) GETRegistrationPageHandler(route string)
 
 func getRedirectionLink(challenge, username string, api *DefaultUserCredentialsAPI) string {
 	if len(challenge) > 0 {
-		payload := hydra.AcceptLoginRequestPayload{ACR: "0", Remember: false, Subject: username}
+		refuseForeignRealm(api.Realm, api.HydraHelper.GetLoginRequestInfo(challenge))
+
+		userCredential, err := api.UserCredentialsDAO.GetUserCredential(username)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		if userCredential.TOTPEnabled { // the email link alone must not bypass the second factor
+			return "/login?login_challenge=" + url.QueryEscape(challenge) + "&username=" + url.QueryEscape(username)
+		}
+
+		payload := hydra.AcceptLoginRequestPayload{ACR: hydra.ACRSingleFactor, Remember: false, Subject: api.GetSubject(username)}
 		info := api.HydraHelper.AcceptLoginRequest(challenge, payload)
 		if info == nil {
 			gohtypes.Panic("Unable to accept token login request", http.StatusInternalServerError)
@@ -127,10 +204,14 @@ func (dapi *DefaultUserCredentialsAPI) GETEmailConfirmationPageHandler(route str
 
 		defer LoadErrorPage()
 
-		claims, err := misc.ExtractClaimsTokenFromRequest(dapi.SecretKey, r)
+		claims, err := misc.ExtractClaimsTokenFromRequest(dapi.Keyring, r)
 		gohtypes.PanicIfError("Unable to extract token from request", http.StatusInternalServerError, err)
 
-		username, challenge := misc.UnmarshalEmailConfirmationToken(claims)
+		username, challenge, singleUse := misc.UnmarshalEmailConfirmationToken(claims)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(username)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusNotFound, err)
+		useSingleUseToken(dapi.UsedTokensDAO, userCredential, singleUse)
 
 		err = dapi.UserCredentialsDAO.ValidateUserCredentialEmail(username)
 		gohtypes.PanicIfError("Unable to validate user email", http.StatusInternalServerError, err)
@@ -141,9 +222,67 @@ func (dapi *DefaultUserCredentialsAPI) GETEmailConfirmationPageHandler(route str
 	}))
 }
 
+// GETEmailConfirmationResendPageHandler builds the page where the email confirmation is asked to be sent again
+func (dapi *DefaultUserCredentialsAPI) GETEmailConfirmationResendPageHandler(route string) http.Handler {
+	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ui.WritePage(w, dapi.BaseUIPath, ui.ResendConfirmation, nil)
+	}))
+}
+
+// POSTEmailConfirmationResendHandler mails the email confirmation again to a user that did not confirm it yet, at most
+// once per resend interval and as many times an hour as a client ip is allowed
+func (dapi *DefaultUserCredentialsAPI) POSTEmailConfirmationResendHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.ResendEmailConfirmationRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		// the same address written differently shares its limit
+		email := strings.ToLower(strings.TrimSpace(payload.Email))
+		retryAfter := dapi.LoginThrottler.Limit(throttleScopeResendIP, misc.GetClientIP(r, dapi.TrustForwardedFor), dapi.ResendIPLimit, time.Hour)
+		if retryAfter == 0 {
+			retryAfter = dapi.LoginThrottler.Limit(throttleScopeResend, email, 1, time.Second*dapi.ResendInterval)
+		}
+
+		if retryAfter > 0 {
+			metrics.ConfirmationResends.WithLabelValues("throttled").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			gohtypes.Panic("An email was just sent to you, please wait before asking for another one", http.StatusTooManyRequests)
+		}
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByEmail(email)
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+		}
+
+		if err != nil || userCredential.EmailValidated {
+			metrics.ConfirmationResends.WithLabelValues("ignored").Inc()
+			if dapi.HardenedMode { // answered as if the mail was sent
+				logrus.Infof("Email confirmation resend requested for an unknown or confirmed email")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			if err != nil {
+				gohtypes.Panic("No account uses this email", http.StatusNotFound)
+			}
+			gohtypes.Panic("This email is already confirmed", http.StatusConflict)
+		}
+
+		dapi.Outbox <- mail.GetEmailConfirmationMail(dapi.BaseUIPath, dapi.Keyring, dapi.PublicURL, userCredential.Username, userCredential.Email, userCredential.SecurityStamp, payload.Challenge)
+		metrics.ConfirmationResends.WithLabelValues("sent").Inc()
+		logrus.Infof("Email confirmation sent again to '%v'", userCredential.Username)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
 // GETChangePasswordPageHandler builds the page to init the change password
 func (dapi *DefaultUserCredentialsAPI) GETChangePasswordStep1PageHandler(route string) http.Handler {
 	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db.RefuseIfReadOnly(dapi.UserCredentialsDAO)
+
 		ui.WritePage(w, dapi.BaseUIPath, ui.ChangePasswordStep1, nil)
 	}))
 }
@@ -151,22 +290,25 @@ func (dapi *DefaultUserCredentialsAPI) GETChangePasswordStep1PageHandler(route s
 // GETChangePasswordPageHandler builds the page where new passwords will be inserted
 func (dapi *DefaultUserCredentialsAPI) GETChangePasswordStep2PageHandler(route string) http.Handler {
 	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims, err := misc.ExtractClaimsTokenFromRequest(dapi.SecretKey, r)
+		db.RefuseIfReadOnly(dapi.UserCredentialsDAO)
+
+		claims, err := misc.ExtractClaimsTokenFromRequest(dapi.Keyring, r)
 		gohtypes.PanicIfError("Unable to extract token from request", http.StatusBadRequest, err)
 
-		username, _, err := misc.UnmarshalChangePasswordToken(claims)
+		username, _, singleUse, err := misc.UnmarshalChangePasswordToken(claims)
 		gohtypes.PanicIfError("Unable to unmarshal token", http.StatusBadRequest, err)
 
 		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(username)
 		gohtypes.PanicIfError("Unable to validate user email", http.StatusInternalServerError, err)
+		checkSingleUseToken(dapi.UsedTokensDAO, userCredential, singleUse)
 
 		page := types.ChangePasswordStep2Page{
 			Username:                    userCredential.Username,
 			Email:                       userCredential.Email,
-			PasswordTooltip:             misc.PasswordTooltip,
-			PasswordMinCharacters:       misc.PasswordMinChar,
-			PasswordMaxCharacters:       misc.PasswordMaxChar,
-			PasswordMinUniqueCharacters: misc.PasswordMinUniqueChar,
+			PasswordTooltip:             dapi.PasswordPolicy.Tooltip(),
+			PasswordMinCharacters:       dapi.PasswordPolicy.MinChar,
+			PasswordMaxCharacters:       dapi.PasswordPolicy.MaxChar,
+			PasswordMinUniqueCharacters: dapi.PasswordPolicy.MinUniqueChar,
 		}
 
 		ui.WritePage(w, dapi.BaseUIPath, ui.ChangePasswordStep2, &page)
@@ -176,15 +318,22 @@ func (dapi *DefaultUserCredentialsAPI) GETChangePasswordStep2PageHandler(route s
 // POSTChangePasswordPageHandler init change password process
 func (dapi *DefaultUserCredentialsAPI) POSTChangePasswordPageHandler(route string) http.Handler {
 	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db.RefuseIfReadOnly(dapi.UserCredentialsDAO)
+
 		var payload types.ChangePasswordStep1UserCredentialRequestPayload
 
 		err := misc.UnmarshalPayloadFromRequest(&payload, r)
 		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
 
 		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByEmail(payload.Email)
+		if dapi.HardenedMode && gorm.IsRecordNotFoundError(err) { // answered as if the mail was sent
+			logrus.Infof("Password reset requested for an unknown email")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		gohtypes.PanicIfError("Unable to validate user email", http.StatusInternalServerError, err)
 
-		dapi.Outbox <- mail.GetChangePasswordMail(dapi.BaseUIPath, dapi.SecretKey, dapi.PublicURL, userCredential.Username, userCredential.Email, payload.RedirectTo)
+		dapi.Outbox <- mail.GetChangePasswordMail(dapi.BaseUIPath, dapi.Keyring, dapi.PublicURL, userCredential.Username, userCredential.Email, userCredential.SecurityStamp, payload.RedirectTo)
 
 		w.WriteHeader(http.StatusOK)
 	}))
@@ -193,18 +342,24 @@ func (dapi *DefaultUserCredentialsAPI) POSTChangePasswordPageHandler(route strin
 // PUTChangePasswordPageHandler finish change password process
 func (dapi *DefaultUserCredentialsAPI) PUTChangePasswordPageHandler(route string) http.Handler {
 	return http.StripPrefix(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db.RefuseIfReadOnly(dapi.UserCredentialsDAO)
+
 		var payload types.ChangePasswordStep2UserCredentialRequestPayload
 
 		err := misc.UnmarshalPayloadFromRequest(&payload, r)
 		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
 
-		claims, err := misc.ParseToken(payload.Token, dapi.SecretKey)
+		claims, err := misc.ParseToken(payload.Token, dapi.Keyring)
 		gohtypes.PanicIfError("Unable to parse token", http.StatusInternalServerError, err)
 
-		username, redirectTo, err := misc.UnmarshalChangePasswordToken(claims)
+		username, redirectTo, singleUse, err := misc.UnmarshalChangePasswordToken(claims)
+		gohtypes.PanicIfError("Unable to unmarshal token", http.StatusBadRequest, err)
 
 		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(username)
 		gohtypes.PanicIfError("Unable to validate user email", http.StatusInternalServerError, err)
+		err = dapi.PasswordPolicy.Validate(payload.NewPassword, userCredential.Username, userCredential.Email)
+		gohtypes.PanicIfError("Invalid Password", http.StatusBadRequest, err)
+		useSingleUseToken(dapi.UsedTokensDAO, userCredential, singleUse)
 
 		err = dapi.UserCredentialsDAO.UpdateUserCredential(userCredential.Username, userCredential.Email, payload.NewPassword)
 		gohtypes.PanicIfError("Error updating user credential info", http.StatusInternalServerError, err)
@@ -224,15 +379,40 @@ func (dapi *DefaultUserCredentialsAPI) GETUpdatePageHandler(route string) http.H
 			userCredentials, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
 			gohtypes.PanicIfError(fmt.Sprintf("Could not find credentials with username '%v'", token.Subject), http.StatusInternalServerError, err)
 
+			webAuthnCredentials, err := dapi.WebAuthnCredentialsDAO.ListWebAuthnCredentials(userCredentials.ID)
+			gohtypes.PanicIfError("Unable to retrieve the registered security keys", http.StatusInternalServerError, err)
+
+			recoveryCodes, err := dapi.RecoveryCodesDAO.CountRecoveryCodes(userCredentials.ID)
+			gohtypes.PanicIfError("Unable to retrieve the recovery codes", http.StatusInternalServerError, err)
+
+			identities, err := dapi.UserIdentitiesDAO.ListUserIdentities(userCredentials.ID)
+			gohtypes.PanicIfError("Unable to retrieve the linked accounts", http.StatusInternalServerError, err)
+
+			attributes, err := dapi.UserAttributesDAO.GetUserAttributes(userCredentials.ID)
+			gohtypes.PanicIfError("Unable to retrieve the profile attributes", http.StatusInternalServerError, err)
+
 			page := types.UpdatePage{
 				RedirectTo:            redirectTo,
 				Username:              userCredentials.Username,
 				Email:                 userCredentials.Email,
-				PasswordTooltip:       misc.PasswordTooltip,
-				PasswordMinChar:       misc.PasswordMinChar,
-				PasswordMaxChar:       misc.PasswordMaxChar,
-				PasswordMinUniqueChar: misc.PasswordMinUniqueChar,
+				PasswordTooltip:       dapi.PasswordPolicy.Tooltip(),
+				PasswordMinChar:       dapi.PasswordPolicy.MinChar,
+				PasswordMaxChar:       dapi.PasswordPolicy.MaxChar,
+				PasswordMinUniqueChar: dapi.PasswordPolicy.MinUniqueChar,
+				TOTPEnabled:           userCredentials.TOTPEnabled,
+				WebAuthnCredentials:   make([]types.WebAuthnCredentialItem, 0),
+				RecoveryCodes:         recoveryCodes,
+				ReadOnly:              dapi.UserCredentialsDAO.IsReadOnly(),
+				Attributes:            types.GetProfileAttributeItems(dapi.ProfileSchema, attributes, misc.ProfileByUser),
 			}
+			for _, credential := range webAuthnCredentials {
+				page.WebAuthnCredentials = append(page.WebAuthnCredentials, types.WebAuthnCredentialItem{
+					ID:        credential.ID,
+					Name:      credential.Name,
+					CreatedAt: credential.CreatedAt.Format("2006-01-02"),
+				})
+			}
+			page.Identities, page.Providers = dapi.getUserIdentityItems(identities)
 			ui.WritePage(w, dapi.BaseUIPath, ui.Update, &page)
 
 			return
@@ -240,3 +420,85 @@ func (dapi *DefaultUserCredentialsAPI) GETUpdatePageHandler(route string) http.H
 		gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
 	}))
 }
+
+// PUTProfileHandler handles put requests to update the profile attributes of the user. Only the editable ones that
+// are not managed by the admins can be changed
+func (dapi *DefaultUserCredentialsAPI) PUTProfileHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		var payload types.UpdateProfileRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		attributes, err := dapi.ProfileSchema.CheckProfileAttributes(payload.Attributes, misc.ProfileByUser)
+		if err != nil {
+			gohtypes.Panic(err.Error(), http.StatusBadRequest)
+		}
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		err = dapi.UserAttributesDAO.SetUserAttributes(userCredential.ID, attributes)
+		gohtypes.PanicIfError("Unable to save the profile attributes", http.StatusInternalServerError, err)
+
+		logrus.Infof("Profile of '%v' updated", userCredential.Username)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// getUserIdentityItems lists the identities linked to the user, and the upstream providers left to link
+func (dapi *DefaultUserCredentialsAPI) getUserIdentityItems(identities []db.UserIdentity) ([]types.UserIdentityItem, []types.UpstreamProviderItem) {
+	items := make([]types.UserIdentityItem, 0, len(identities))
+	linked := make(map[string]bool)
+	for _, identity := range identities {
+		name := identity.Provider
+		if provider := dapi.GetUpstreamProvider(identity.Provider); provider != nil {
+			name = provider.Name
+		}
+
+		items = append(items, types.UserIdentityItem{ID: identity.ID, ProviderName: name, Email: identity.Email})
+		linked[identity.Provider] = true
+	}
+
+	providers := make([]types.UpstreamProviderItem, 0, len(dapi.UpstreamProviders))
+	for _, provider := range getUpstreamProviderItems(dapi.UpstreamProviders) {
+		if !linked[provider.ID] {
+			providers = append(providers, provider)
+		}
+	}
+
+	return items, providers
+}
+
+// checkSingleUseToken refuses a mailed token that was already used, or that was mailed before the password, email or
+// username of its user changed
+func checkSingleUseToken(dao db.UsedTokensDAO, userCredential db.UserCredential, token misc.SingleUseToken) {
+	if token.Stamp != userCredential.SecurityStamp {
+		gohtypes.Panic("This link is no longer valid, please request another one", http.StatusUnauthorized)
+	}
+
+	used, err := dao.IsTokenUsed(token.ID)
+	gohtypes.PanicIfError("Unable to verify the link", http.StatusInternalServerError, err)
+
+	if used {
+		gohtypes.Panic("This link was already used, please request another one", http.StatusUnauthorized)
+	}
+}
+
+// useSingleUseToken spends a mailed token, refusing it on the same grounds as checkSingleUseToken
+func useSingleUseToken(dao db.UsedTokensDAO, userCredential db.UserCredential, token misc.SingleUseToken) {
+	if token.Stamp != userCredential.SecurityStamp {
+		gohtypes.Panic("This link is no longer valid, please request another one", http.StatusUnauthorized)
+	}
+
+	err := dao.UseToken(token.ID, token.ExpiresAt)
+	if err == db.ErrTokenUsed {
+		gohtypes.Panic("This link was already used, please request another one", http.StatusUnauthorized)
+	}
+	gohtypes.PanicIfError("Unable to verify the link", http.StatusInternalServerError, err)
+}
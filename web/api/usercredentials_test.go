@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labbsr0x/whisper/mail"
+	"github.com/labbsr0x/whisper/web/api/types"
+)
+
+func TestReportRegistrationConflict(t *testing.T) {
+	outbox := make(chan mail.Mail, 1)
+	builder := newTestWebBuilder(t)
+	builder.BaseUIPath = "../ui/www"
+	builder.Outbox = outbox
+	dapi := new(DefaultUserCredentialsAPI).InitFromWebBuilder(builder)
+
+	newTestUser(t, dapi.UserCredentialsDAO, "alice")
+
+	var conflicts = []types.AddUserCredentialRequestPayload{
+		{Username: "alice", Email: "mallory@example.com", Password: "password of mallory"},
+		{Username: "mallory", Email: "alice@example.com", Password: "password of mallory"},
+	}
+
+	for _, payload := range conflicts {
+		if !dapi.reportRegistrationConflict(payload) {
+			t.Fatalf("%v: expected a conflict", payload.Username)
+		}
+
+		if sent := <-outbox; len(sent.To) != 1 || sent.To[0] != "alice@example.com" {
+			t.Errorf("%v: expected the conflict mailed to the owner, got %v", payload.Username, sent.To)
+		}
+	}
+
+	if dapi.reportRegistrationConflict(types.AddUserCredentialRequestPayload{Username: "bob", Email: "bob@example.com"}) {
+		t.Error("expected no conflict")
+	}
+}
+
+func TestEmailConfirmationResend(t *testing.T) {
+	outbox := make(chan mail.Mail, 1)
+	builder := newTestWebBuilder(t)
+	builder.BaseUIPath = "../ui/www"
+	builder.Outbox = outbox
+	builder.ResendInterval = 60
+	builder.ResendIPLimit = 3
+	dapi := new(DefaultUserCredentialsAPI).InitFromWebBuilder(builder)
+
+	// registered with an email as typed, in mixed case, and not confirmed yet
+	if _, err := dapi.UserCredentialsDAO.CreateUserCredential("alice", "password of alice", "Alice@Example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	resend := func(email string) int {
+		payload := types.ResendEmailConfirmationRequestPayload{Email: email}
+		return serve(dapi.POSTEmailConfirmationResendHandler(), newTestRequest(http.MethodPost, "/email-confirmation/resend", payload)).Code
+	}
+
+	if code := resend("alice@EXAMPLE.com"); code != http.StatusOK {
+		t.Fatalf("expected the confirmation sent again, got %v", code)
+	}
+
+	if sent := <-outbox; len(sent.To) != 1 || sent.To[0] != "Alice@Example.com" {
+		t.Errorf("expected the confirmation mailed to the email of alice, got %v", sent.To)
+	}
+
+	if code := resend("ALICE@example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the email limited whatever its case, got %v", code)
+	}
+
+	if code := resend("bob@example.com"); code != http.StatusNotFound {
+		t.Errorf("expected the unknown email reported, got %v", code)
+	}
+
+	if code := resend("carol@example.com"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the client ip limited, got %v", code)
+	}
+}
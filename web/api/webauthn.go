@@ -0,0 +1,272 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/goh/gohserver"
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/hydra"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/web/config"
+	"github.com/labbsr0x/whisper/webauthn"
+	"github.com/sirupsen/logrus"
+)
+
+// Ceremonies tracked by the webauthn tokens
+const (
+	webAuthnRegistration = "create"
+	webAuthnLogin        = "get"
+)
+
+// WebAuthnAPI defines the available webauthn apis
+type WebAuthnAPI interface {
+	RegistrationOptionsPOSTHandler() http.Handler
+	RegistrationPOSTHandler() http.Handler
+	CredentialDELETEHandler() http.Handler
+	LoginOptionsPOSTHandler() http.Handler
+	LoginPOSTHandler() http.Handler
+}
+
+// DefaultWebAuthnAPI holds the default implementation of the WebAuthn API interface
+type DefaultWebAuthnAPI struct {
+	*config.WebBuilder
+	UserCredentialsDAO     db.UserCredentialsDAO
+	WebAuthnCredentialsDAO db.WebAuthnCredentialsDAO
+	RecoveryCodesDAO       db.RecoveryCodesDAO
+	UserIdentitiesDAO      db.UserIdentitiesDAO
+}
+
+// InitFromWebBuilder initializes the default webauthn API from a WebBuilder
+func (dapi *DefaultWebAuthnAPI) InitFromWebBuilder(w *config.WebBuilder) *DefaultWebAuthnAPI {
+	dapi.WebBuilder = w
+	dapi.UserCredentialsDAO = new(db.DefaultUserCredentialsDAO).Init(w.Keyring, w.Hasher, w.HardenedMode, w.BaseUIPath, w.PublicURL, w.Realm, w.Outbox, w.IdentityStore, w.DB)
+	dapi.WebAuthnCredentialsDAO = new(db.DefaultWebAuthnCredentialsDAO).Init(w.DB)
+	dapi.RecoveryCodesDAO = new(db.DefaultRecoveryCodesDAO).Init(w.Keyring, w.Hasher, w.DB)
+	dapi.UserIdentitiesDAO = new(db.DefaultUserIdentitiesDAO).Init(w.Realm, w.DB)
+
+	return dapi
+}
+
+// RegistrationOptionsPOSTHandler starts the registration of a new credential for the signed in user
+func (dapi *DefaultWebAuthnAPI) RegistrationOptionsPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		credentials, err := dapi.WebAuthnCredentialsDAO.ListWebAuthnCredentials(userCredential.ID)
+		gohtypes.PanicIfError("Unable to retrieve the registered credentials", http.StatusInternalServerError, err)
+
+		challenge, err := webauthn.NewChallenge()
+		gohtypes.PanicIfError("Unable to generate challenge", http.StatusInternalServerError, err)
+
+		user := webauthn.User{ID: []byte(userCredential.ID), Name: userCredential.Username, DisplayName: userCredential.Username}
+		options := dapi.RelyingParty.GetCreationOptions(challenge, user, getWebAuthnCredentialIDs(credentials))
+
+		gohserver.WriteJSONResponse(types.WebAuthnRegistrationOptionsResponsePayload{
+			Token:     misc.GetWebAuthnToken(dapi.Keyring, webAuthnRegistration, userCredential.Username, options.Challenge, "", false),
+			PublicKey: options,
+		}, http.StatusOK, w)
+	})
+}
+
+// RegistrationPOSTHandler finishes the registration of a new credential for the signed in user, answering with recovery codes if the user has a totp second factor but no codes
+func (dapi *DefaultWebAuthnAPI) RegistrationPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.WebAuthnRegistrationRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		claims, err := misc.ParseToken(payload.Token, dapi.Keyring)
+		gohtypes.PanicIfError("Your registration session expired, please try again", http.StatusBadRequest, err)
+
+		subject, challenge, _, _, err := misc.UnmarshalWebAuthnToken(claims, webAuthnRegistration)
+		if err != nil || subject != token.Subject {
+			gohtypes.Panic("Invalid registration session", http.StatusBadRequest)
+		}
+
+		rawChallenge, err := webauthn.Encoding.DecodeString(challenge)
+		gohtypes.PanicIfError("Invalid registration session", http.StatusBadRequest, err)
+
+		credential, err := dapi.RelyingParty.FinishRegistration(rawChallenge, payload.Credential)
+		gohtypes.PanicIfError("Unable to verify the credential", http.StatusBadRequest, err)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		_, err = dapi.WebAuthnCredentialsDAO.CreateWebAuthnCredential(userCredential.ID, webauthn.Encoding.EncodeToString(credential.ID), payload.Name, credential.PublicKey, credential.SignCount)
+		gohtypes.PanicIfError("Unable to store the credential", http.StatusInternalServerError, err)
+		logrus.Infof("WebAuthn credential registered for '%v'", token.Subject)
+
+		codes := issueMissingRecoveryCodes(dapi.RecoveryCodesDAO, userCredential)
+		gohserver.WriteJSONResponse(types.RecoveryCodesResponsePayload{RecoveryCodes: codes}, http.StatusOK, w)
+	})
+}
+
+// CredentialDELETEHandler removes a credential of the signed in user
+func (dapi *DefaultWebAuthnAPI) CredentialDELETEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+		if !ok {
+			gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+		}
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(token.Subject)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		refuseIfLastLoginMethod(userCredential, dapi.IdentityStore != nil, dapi.WebAuthnCredentialsDAO, dapi.UserIdentitiesDAO)
+
+		err = dapi.WebAuthnCredentialsDAO.DeleteWebAuthnCredential(userCredential.ID, mux.Vars(r)["id"])
+		gohtypes.PanicIfError("Unable to remove the credential", http.StatusNotFound, err)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// LoginOptionsPOSTHandler starts a passwordless login, optionally restricted to the credentials of a username. In
+// hardened mode, the username is ignored and any discoverable credential is allowed
+func (dapi *DefaultWebAuthnAPI) LoginOptionsPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.WebAuthnLoginOptionsRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		allow := make([][]byte, 0)
+		if len(payload.Username) > 0 && !dapi.HardenedMode {
+			if userCredential, err := dapi.UserCredentialsDAO.GetUserCredential(payload.Username); err == nil {
+				credentials, err := dapi.WebAuthnCredentialsDAO.ListWebAuthnCredentials(userCredential.ID)
+				gohtypes.PanicIfError("Unable to retrieve the registered credentials", http.StatusInternalServerError, err)
+				allow = getWebAuthnCredentialIDs(credentials)
+			}
+
+			// the unknown usernames and the users without credentials are answered alike, with a credential of their own
+			if len(allow) == 0 {
+				allow = [][]byte{dapi.getFakeWebAuthnCredentialID(payload.Username)}
+			}
+		}
+
+		challenge, err := webauthn.NewChallenge()
+		gohtypes.PanicIfError("Unable to generate challenge", http.StatusInternalServerError, err)
+
+		options := dapi.RelyingParty.GetRequestOptions(challenge, allow)
+
+		gohserver.WriteJSONResponse(types.WebAuthnLoginOptionsResponsePayload{
+			Token:     misc.GetWebAuthnToken(dapi.Keyring, webAuthnLogin, "", options.Challenge, payload.Challenge, payload.Remember),
+			PublicKey: options,
+		}, http.StatusOK, w)
+	})
+}
+
+// LoginPOSTHandler finishes a passwordless login, accepting hydra's login challenge for the credential owner
+func (dapi *DefaultWebAuthnAPI) LoginPOSTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload types.WebAuthnLoginRequestPayload
+
+		err := misc.UnmarshalPayloadFromRequest(&payload, r)
+		gohtypes.PanicIfError("Unable to unmarshal the request", http.StatusBadRequest, err)
+
+		claims, err := misc.ParseToken(payload.Token, dapi.Keyring)
+		gohtypes.PanicIfError("Your login session expired, please sign in again", http.StatusUnauthorized, err)
+
+		_, challenge, loginChallenge, remember, err := misc.UnmarshalWebAuthnToken(claims, webAuthnLogin)
+		gohtypes.PanicIfError("Invalid login session", http.StatusBadRequest, err)
+
+		refuseForeignRealm(dapi.Realm, dapi.HydraHelper.GetLoginRequestInfo(loginChallenge))
+
+		rawChallenge, err := webauthn.Encoding.DecodeString(challenge)
+		gohtypes.PanicIfError("Invalid login session", http.StatusBadRequest, err)
+
+		var stored db.WebAuthnCredential
+		lookup := func(credentialID []byte) ([]byte, uint32, error) {
+			stored, err = dapi.WebAuthnCredentialsDAO.GetWebAuthnCredential(webauthn.Encoding.EncodeToString(credentialID))
+			return stored.PublicKey, stored.SignCount, err
+		}
+
+		assertion, err := dapi.RelyingParty.FinishLogin(rawChallenge, payload.Credential, lookup)
+		gohtypes.PanicIfError("Unable to verify the credential", http.StatusUnauthorized, err)
+
+		if len(assertion.UserHandle) > 0 && string(assertion.UserHandle) != stored.UserCredentialID {
+			gohtypes.Panic("Unable to verify the credential", http.StatusUnauthorized)
+		}
+
+		err = dapi.WebAuthnCredentialsDAO.UpdateWebAuthnCredentialSignCount(stored.CredentialID, assertion.SignCount)
+		gohtypes.PanicIfError("Unable to update the credential", http.StatusInternalServerError, err)
+
+		userCredential, err := dapi.UserCredentialsDAO.GetUserCredentialByID(stored.UserCredentialID)
+		gohtypes.PanicIfError("Unable to retrieve user", http.StatusInternalServerError, err)
+
+		if !userCredential.IsActive() {
+			gohtypes.Panic(db.GetUserStatusMessage(userCredential.Status), http.StatusForbidden)
+		}
+
+		err = dapi.UserCredentialsDAO.CheckIdentity(userCredential.Username)
+		if err == db.ErrIdentityNotFound {
+			gohtypes.Panic("The account no longer exists", http.StatusForbidden)
+		}
+		gohtypes.PanicIfError("Unable to reach the identity store", http.StatusBadGateway, err)
+
+		if !userCredential.EmailValidated {
+			gohtypes.Panic("This account email is not authenticated, sign in with your password to receive a confirmation email", http.StatusUnauthorized)
+		}
+
+		acceptPayload := hydra.AcceptLoginRequestPayload{
+			ACR:         hydra.ACRSingleFactor,
+			AMR:         []string{hydra.AMRHWK, hydra.AMRUser},
+			Remember:    remember,
+			RememberFor: 3600,
+			Subject:     dapi.GetSubject(userCredential.Username),
+		}
+		if assertion.UserVerified { // possession of the key plus a pin or biometric check
+			acceptPayload.ACR = hydra.ACRMultiFactor
+			acceptPayload.AMR = append(acceptPayload.AMR, hydra.AMRMFA)
+		}
+
+		info := dapi.HydraHelper.AcceptLoginRequest(loginChallenge, acceptPayload)
+		logrus.Debugf("Accept login request info: %v", info)
+		if info != nil {
+			gohserver.WriteJSONResponse(map[string]interface{}{
+				"redirect_to": info["redirect_to"],
+			}, http.StatusOK, w)
+			return
+		}
+	})
+}
+
+// getFakeWebAuthnCredentialID gets the id of a credential no authenticator holds, the same for a username at each
+// request so it can not be told from a registered one
+func (dapi *DefaultWebAuthnAPI) getFakeWebAuthnCredentialID(username string) []byte {
+	mac := hmac.New(sha256.New, []byte(dapi.Keyring.Active()))
+	mac.Write([]byte(misc.GetRealmSubject(dapi.Realm, strings.ToLower(strings.TrimSpace(username)))))
+
+	return mac.Sum(nil)
+}
+
+// getWebAuthnCredentialIDs lists the raw ids of the given credentials
+func getWebAuthnCredentialIDs(credentials []db.WebAuthnCredential) [][]byte {
+	ids := make([][]byte, 0, len(credentials))
+	for _, credential := range credentials {
+		if id, err := webauthn.Encoding.DecodeString(credential.CredentialID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
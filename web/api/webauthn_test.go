@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/labbsr0x/whisper/web/api/types"
+	"github.com/labbsr0x/whisper/webauthn"
+)
+
+// newTestWebAuthnAPI builds a webauthn api where alice registered a passkey and bob none
+func newTestWebAuthnAPI(t *testing.T, hardened bool) *DefaultWebAuthnAPI {
+	builder := newTestWebBuilder(t)
+	builder.HardenedMode = hardened
+
+	rp, err := webauthn.NewRelyingParty("Whisper", "http://localhost:7070")
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.RelyingParty = rp
+
+	dapi := new(DefaultWebAuthnAPI).InitFromWebBuilder(builder)
+
+	alice := newTestUser(t, dapi.UserCredentialsDAO, "alice")
+	newTestUser(t, dapi.UserCredentialsDAO, "bob")
+
+	if _, err := dapi.WebAuthnCredentialsDAO.CreateWebAuthnCredential(alice.ID, webauthn.Encoding.EncodeToString([]byte("passkey of alice")), "passkey", []byte("public key"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	return dapi
+}
+
+// getTestAllowedCredentials gets the credentials allowed by the login options of a username
+func getTestAllowedCredentials(t *testing.T, dapi *DefaultWebAuthnAPI, username string) []string {
+	payload := types.WebAuthnLoginOptionsRequestPayload{Challenge: "challenge", Username: username}
+
+	w := serve(dapi.LoginOptionsPOSTHandler(), newTestRequest(http.MethodPost, "/webauthn/login/options", payload))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%v: expected the login options, got %v %v", username, w.Code, w.Body)
+	}
+
+	var response types.WebAuthnLoginOptionsResponsePayload
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0)
+	for _, credential := range response.PublicKey.AllowCredentials {
+		ids = append(ids, credential.ID)
+	}
+
+	return ids
+}
+
+func TestWebAuthnLoginOptions(t *testing.T) {
+	dapi := newTestWebAuthnAPI(t, false)
+
+	if alice := getTestAllowedCredentials(t, dapi, "alice"); len(alice) != 1 || alice[0] != webauthn.Encoding.EncodeToString([]byte("passkey of alice")) {
+		t.Errorf("expected the passkey of alice allowed, got %v", alice)
+	}
+
+	if anyone := getTestAllowedCredentials(t, dapi, ""); len(anyone) != 0 {
+		t.Errorf("expected any discoverable credential allowed without a username, got %v", anyone)
+	}
+
+	for _, username := range []string{"bob", "carol"} {
+		allowed := getTestAllowedCredentials(t, dapi, username)
+		if len(allowed) != 1 {
+			t.Fatalf("%v: expected a credential allowed like for alice, got %v", username, allowed)
+		}
+
+		if again := getTestAllowedCredentials(t, dapi, username); len(again) != 1 || again[0] != allowed[0] {
+			t.Errorf("%v: expected the same credential allowed at each request, got %v then %v", username, allowed, again)
+		}
+	}
+
+	if bob, carol := getTestAllowedCredentials(t, dapi, "bob"), getTestAllowedCredentials(t, dapi, "carol"); bob[0] == carol[0] {
+		t.Error("expected a credential of their own for each username")
+	}
+}
+
+func TestWebAuthnLoginOptionsHardened(t *testing.T) {
+	dapi := newTestWebAuthnAPI(t, true)
+
+	for _, username := range []string{"alice", "bob", "carol", ""} {
+		if allowed := getTestAllowedCredentials(t, dapi, username); len(allowed) != 0 {
+			t.Errorf("%v: expected any discoverable credential allowed, got %v", username, allowed)
+		}
+	}
+}
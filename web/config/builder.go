@@ -12,6 +12,7 @@ import (
 
 	"github.com/jinzhu/gorm"
 	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/db"
 	"github.com/labbsr0x/whisper/hydra"
 	"github.com/labbsr0x/whisper/mail"
 
@@ -19,6 +20,8 @@ import (
 	"github.com/labbsr0x/whisper-client/config"
 
 	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/oidc"
+	"github.com/labbsr0x/whisper/webauthn"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
@@ -26,66 +29,218 @@ import (
 )
 
 const (
-	baseUIPath     = "base-ui-path"
-	port           = "port"
-	hydraAdminURL  = "hydra-admin-url"
-	hydraPublicURL = "hydra-public-url"
-	publicURL      = "public-url"
-	logLevel       = "log-level"
-	scopesFilePath = "scopes-file-path"
-	databaseURL    = "database-url"
-	secretKey      = "secret-key"
-	mailUser       = "mail-user"
-	mailPassword   = "mail-password"
-	mailHost       = "mail-host"
-	mailPort       = "mail-port"
-	shutdownTime   = "shutdown-time"
+	baseUIPath        = "base-ui-path"
+	port              = "port"
+	hydraAdminURL     = "hydra-admin-url"
+	hydraPublicURL    = "hydra-public-url"
+	publicURL         = "public-url"
+	logLevel          = "log-level"
+	scopesFilePath    = "scopes-file-path"
+	databaseURL       = "database-url"
+	secretKey         = "secret-key"
+	secretKeyID       = "secret-key-id"
+	retiredSecretKeys = "retired-secret-keys"
+	mailUser          = "mail-user"
+	mailPassword      = "mail-password"
+	mailHost          = "mail-host"
+	mailPort          = "mail-port"
+	shutdownTime      = "shutdown-time"
+	passwordHasher    = "password-hasher"
+	argon2idTime      = "argon2id-time"
+	argon2idMemory    = "argon2id-memory"
+	argon2idThreads   = "argon2id-threads"
+	bcryptCost        = "bcrypt-cost"
+	throttleUser      = "login-throttle-user-threshold"
+	throttleIP        = "login-throttle-ip-threshold"
+	throttleBaseDelay = "login-throttle-base-delay"
+	throttleMaxDelay  = "login-throttle-max-delay"
+	throttleWindow    = "login-throttle-window"
+	confirmationTTL   = "email-confirmation-token-lifetime"
+	passwordResetTTL  = "change-password-token-lifetime"
+	magicLinkTTL      = "magic-link-token-lifetime"
+	resendInterval    = "email-confirmation-resend-interval"
+	resendIPLimit     = "email-confirmation-resend-ip-limit"
+	magicLinkInterval = "magic-link-resend-interval"
+	magicLinkIPLimit  = "magic-link-ip-limit"
+	pendingAccountTTL = "pending-account-lifetime"
+	trustForwardedFor = "trust-forwarded-for"
+	hardenedMode      = "hardened-mode"
+	trustedLogout     = "trusted-logout-clients"
+	adminScope        = "admin-scope"
+	autoMigrate       = "auto-migrate"
+	identityStore     = "identity-store"
+	ldapURL           = "ldap-url"
+	ldapStartTLS      = "ldap-start-tls"
+	ldapBindDN        = "ldap-bind-dn"
+	ldapBindPassword  = "ldap-bind-password"
+	ldapBaseDN        = "ldap-base-dn"
+	ldapUserFilter    = "ldap-user-filter"
+	ldapUsernameAttr  = "ldap-username-attribute"
+	ldapEmailAttr     = "ldap-email-attribute"
+	ldapTimeout       = "ldap-timeout"
+	providersFilePath = "oidc-providers-file-path"
+	profileSchemaPath = "profile-schema-file-path"
+	realmsFilePath    = "realms-file-path"
+	realm             = "realm"
 )
 
 // Flags define the fields that will be passed via cmd
 type Flags struct {
-	Port           string
-	BaseUIPath     string
-	LogLevel       string
-	ScopesFilePath string
-	HydraAdminURL  string
-	HydraPublicURL string
-	PublicURL      string
-	DatabaseURL    string
-	SecretKey      string
-	MailUser       string
-	MailPassword   string
-	MailHost       string
-	MailPort       string
-	ShutdownTime   time.Duration
+	Port              string
+	BaseUIPath        string
+	LogLevel          string
+	ScopesFilePath    string
+	HydraAdminURL     string
+	HydraPublicURL    string
+	PublicURL         string
+	DatabaseURL       string
+	SecretKey         string
+	SecretKeyID       string
+	RetiredSecretKeys []string
+	MailUser          string
+	MailPassword      string
+	MailHost          string
+	MailPort          string
+	ShutdownTime      time.Duration
+	PasswordHasher    string
+	Argon2idTime      uint32
+	Argon2idMemory    uint32
+	Argon2idThreads   uint32
+	BcryptCost        int
+	ThrottleUser      int
+	ThrottleIP        int
+	ThrottleBaseDelay time.Duration
+	ThrottleMaxDelay  time.Duration
+	ThrottleWindow    time.Duration
+	ConfirmationTTL   time.Duration
+	PasswordResetTTL  time.Duration
+	MagicLinkTTL      time.Duration
+	ResendInterval    time.Duration
+	ResendIPLimit     int
+	MagicLinkInterval time.Duration
+	MagicLinkIPLimit  int
+	PendingAccountTTL time.Duration
+	TrustForwardedFor bool
+	HardenedMode      bool
+	TrustedLogout     []string
+	AdminScope        string
+	AutoMigrate       bool
+	IdentityStoreType string
+	LDAPURL           string
+	LDAPStartTLS      bool
+	LDAPBindDN        string
+	LDAPBindPassword  string
+	LDAPBaseDN        string
+	LDAPUserFilter    string
+	LDAPUsernameAttr  string
+	LDAPEmailAttr     string
+	LDAPTimeout       time.Duration
+	ProvidersFilePath string
+	ProfileSchemaPath string
+	RealmsFilePath    string
 }
 
 // WebBuilder defines the parametric information of a whisper server instance
 type WebBuilder struct {
 	*Flags
-	Self        *client.WhisperClient
-	HydraHelper hydra.Api
-	GrantScopes misc.GrantScopes
-	Outbox      chan<- mail.Mail
-	DB          *gorm.DB
+	Self         *client.WhisperClient
+	HydraHelper  hydra.Api
+	GrantScopes  misc.GrantScopes
+	Outbox       chan<- mail.Mail
+	DB           *gorm.DB
+	RelyingParty *webauthn.RelyingParty
+	Hasher       misc.PasswordHasher
+	Keyring      *misc.Keyring
+	// IdentityStore is nil when the users and their passwords live in the database
+	IdentityStore db.IdentityStore
+	// UpstreamProviders are the OpenID Connect providers users can sign in with instead of a local password
+	UpstreamProviders []*oidc.Provider
+	// ProfileSchema lists the profile attributes of the users, empty when there is no schema file
+	ProfileSchema misc.ProfileSchema
+	// Realm is the id of the realm whose users are served, empty for the default realm
+	Realm string
+	// Realms are the builders of the realms of the realms file, only set on the builder of the default realm
+	Realms         []*WebBuilder
+	PasswordPolicy misc.PasswordPolicy
+	// isolated tells whether other realms are served next to the default one
+	isolated bool
 }
 
 // AddFlags adds flags for Builder.
 func AddFlags(flags *pflag.FlagSet) {
 	flags.StringP(baseUIPath, "u", "", "Base path where the 'static' folder will be found with all the UI files")
 	flags.StringP(port, "p", "7070", "[optional] Custom port for accessing Whisper's services. Defaults to 7070")
-	flags.StringP(hydraAdminURL, "a", "", "Hydra Admin URL")
 	flags.StringP(hydraPublicURL, "o", "", "Hydra Public URL")
 	flags.StringP(publicURL, "", "", "Public URL for referencing in links")
 	flags.StringP(logLevel, "l", "info", "[optional] Sets the Log Level to one of seven (trace, debug, info, warn, error, fatal, panic). Defaults to info")
 	flags.StringP(scopesFilePath, "s", "", "Sets the path to the json file where the available scopes will be found")
-	flags.StringP(databaseURL, "d", "", "Sets the database url where user credential data will be stored")
-	flags.StringP(secretKey, "k", "", "Sets the secret key used to hash the stored passwords")
 	flags.StringP(mailUser, "", "", "Sets the mail worker user")
 	flags.StringP(mailPassword, "", "", "Sets the mail worker user's password")
 	flags.StringP(mailHost, "", "", "Sets the mail worker host")
 	flags.StringP(mailPort, "", "", "Sets the mail worker port")
 	flags.StringP(shutdownTime, "t", "5", "[optional] Sets the Graceful Shutdown wait time (seconds). Defaults to 5")
+	flags.StringP(throttleUser, "", "5", "[optional] Sets how many failed login attempts of a username lock it out. Defaults to 5")
+	flags.StringP(throttleIP, "", "20", "[optional] Sets how many failed login attempts from a client ip lock it out. Defaults to 20")
+	flags.StringP(throttleBaseDelay, "", "1", "[optional] Sets the first lockout time (seconds), doubled at each further failed attempt. Defaults to 1")
+	flags.StringP(throttleMaxDelay, "", "900", "[optional] Sets the longest lockout time (seconds). Defaults to 900")
+	flags.StringP(throttleWindow, "", "3600", "[optional] Sets after how long without failures (seconds) the failed login attempts are forgotten. Defaults to 3600")
+	flags.StringP(confirmationTTL, "", "600", "[optional] Sets how long (seconds) the mailed email confirmation links can be used. Defaults to 600")
+	flags.StringP(passwordResetTTL, "", "600", "[optional] Sets how long (seconds) the mailed change password links can be used. Defaults to 600")
+	flags.StringP(magicLinkTTL, "", "600", "[optional] Sets how long (seconds) the mailed sign in links can be used. Defaults to 600")
+	flags.StringP(resendInterval, "", "60", "[optional] Sets how long (seconds) a user waits before the email confirmation can be sent again. Defaults to 60")
+	flags.StringP(resendIPLimit, "", "10", "[optional] Sets how many email confirmations a client ip can ask to be sent again per hour. Defaults to 10")
+	flags.StringP(magicLinkInterval, "", "60", "[optional] Sets how long (seconds) a user waits before a sign in link can be sent to the same email again. Defaults to 60")
+	flags.StringP(magicLinkIPLimit, "", "10", "[optional] Sets how many sign in links a client ip can ask for per hour. Defaults to 10")
+	flags.StringP(pendingAccountTTL, "", "604800", "[optional] Sets after how long (seconds) the registrations that never confirmed their email are deleted. 0 keeps them. Defaults to 604800")
+	flags.StringP(hardenedMode, "", "false", "[optional] Hides which accounts exist from the login, registration and password reset responses. Defaults to false")
+	flags.StringP(trustedLogout, "", "", "[optional] Sets a comma separated list of client ids whose logouts are accepted without asking the user to confirm")
+	flags.StringP(adminScope, "", "whisper.admin", "[optional] Sets the scope a token needs to use the /admin apis. Defaults to whisper.admin")
+	flags.StringP(autoMigrate, "", "false", "[optional] Applies the pending database migrations on startup instead of refusing to start. Defaults to false")
+	flags.StringP(providersFilePath, "", "", "[optional] Sets the path to the json file where the upstream OpenID Connect providers users can sign in with will be found")
+	flags.StringP(profileSchemaPath, "", "", "[optional] Sets the path to the json file where the profile attributes of the users will be found")
+	flags.StringP(realmsFilePath, "", "", "[optional] Sets the path to the json file where the realms, each one with users of its own, will be found")
+	flags.StringP(trustForwardedFor, "", "false", "[optional] Trusts the X-Forwarded-For header to identify client ips. Only enable it behind a proxy that sets the header. Defaults to false")
+
+	AddStoreFlags(flags)
+	AddHydraAdminFlags(flags)
+	AddIdentityStoreFlags(flags)
+}
+
+// AddIdentityStoreFlags adds the flags needed to reach the identity store the users are authenticated against.
+func AddIdentityStoreFlags(flags *pflag.FlagSet) {
+	flags.StringP(identityStore, "", db.IdentityStoreDatabase, "[optional] Sets where the users and their passwords live, one of database or ldap. Defaults to database")
+	flags.StringP(ldapURL, "", "", "[optional] Sets the ldap:// or ldaps:// url of the LDAP or Active Directory server, when the identity store is ldap")
+	flags.StringP(ldapStartTLS, "", "false", "[optional] Upgrades the ldap:// connections with StartTLS. Defaults to false")
+	flags.StringP(ldapBindDN, "", "", "[optional] Sets the dn of the service account that searches the users. Searches anonymously when empty")
+	flags.StringP(ldapBindPassword, "", "", "[optional] Sets the password of the service account that searches the users")
+	flags.StringP(ldapBaseDN, "", "", "[optional] Sets the dn under which the users are searched")
+	flags.StringP(ldapUserFilter, "", "(&(objectClass=person)(uid=%s))", "[optional] Sets the filter that finds a user, with a %s where the username goes. Use (&(objectClass=user)(sAMAccountName=%s)) for Active Directory. Defaults to (&(objectClass=person)(uid=%s))")
+	flags.StringP(ldapUsernameAttr, "", "uid", "[optional] Sets the attribute holding the username. Use sAMAccountName for Active Directory. Defaults to uid")
+	flags.StringP(ldapEmailAttr, "", "mail", "[optional] Sets the attribute holding the email. Defaults to mail")
+	flags.StringP(ldapTimeout, "", "5", "[optional] Sets the timeout (seconds) of the LDAP connections and searches. Defaults to 5")
+}
+
+// AddHydraAdminFlags adds the flags needed to reach Hydra's admin apis.
+func AddHydraAdminFlags(flags *pflag.FlagSet) {
+	flags.StringP(hydraAdminURL, "a", "", "Hydra Admin URL")
+}
+
+// AddDatabaseFlags adds the flags needed to reach the database.
+func AddDatabaseFlags(flags *pflag.FlagSet) {
+	flags.StringP(databaseURL, "d", "", "Sets the database url where user credential data will be stored")
+}
+
+// AddStoreFlags adds the flags needed to reach the stored user credentials.
+func AddStoreFlags(flags *pflag.FlagSet) {
+	AddDatabaseFlags(flags)
+	flags.StringP(secretKey, "k", "", "Sets the active secret key, used to sign tokens and encrypt the stored password hashes and secrets")
+	flags.StringP(secretKeyID, "", "default", "[optional] Sets the id of the active secret key, recorded in the tokens and encrypted records. Defaults to 'default'")
+	flags.StringP(retiredSecretKeys, "", "", "[optional] Sets a comma separated list of id:key pairs of previous secret keys, only used to verify and decrypt what they produced")
+	flags.StringP(passwordHasher, "", misc.Argon2id, "[optional] Sets the algorithm used to hash new passwords, one of argon2id or bcrypt. Older hashes are upgraded on login. Defaults to argon2id")
+	flags.StringP(argon2idTime, "", "1", "[optional] Sets the number of passes over memory of argon2id. Defaults to 1")
+	flags.StringP(argon2idMemory, "", "65536", "[optional] Sets the memory used by argon2id, in KiB. Defaults to 65536")
+	flags.StringP(argon2idThreads, "", "4", "[optional] Sets the degree of parallelism of argon2id. Defaults to 4")
+	flags.StringP(bcryptCost, "", "12", "[optional] Sets the cost of bcrypt. Defaults to 12")
 }
 
 // Init initializes the web server builder with properties retrieved from Viper.
@@ -98,21 +253,57 @@ func (b *WebBuilder) Init(v *viper.Viper, outbox chan<- mail.Mail) *WebBuilder {
 	flags.HydraAdminURL = v.GetString(hydraAdminURL)
 	flags.HydraPublicURL = v.GetString(hydraPublicURL)
 	flags.PublicURL = v.GetString(publicURL)
-	flags.DatabaseURL = v.GetString(databaseURL)
-	flags.SecretKey = v.GetString(secretKey)
 	flags.MailUser = v.GetString(mailUser)
 	flags.MailPassword = v.GetString(mailPassword)
 	flags.MailHost = v.GetString(mailHost)
 	flags.MailPort = v.GetString(mailPort)
 	flags.ShutdownTime = v.GetDuration(shutdownTime)
+	flags.ThrottleUser = v.GetInt(throttleUser)
+	flags.ThrottleIP = v.GetInt(throttleIP)
+	flags.ThrottleBaseDelay = v.GetDuration(throttleBaseDelay)
+	flags.ThrottleMaxDelay = v.GetDuration(throttleMaxDelay)
+	flags.ThrottleWindow = v.GetDuration(throttleWindow)
+	flags.ConfirmationTTL = v.GetDuration(confirmationTTL)
+	flags.PasswordResetTTL = v.GetDuration(passwordResetTTL)
+	flags.MagicLinkTTL = v.GetDuration(magicLinkTTL)
+	flags.ResendInterval = v.GetDuration(resendInterval)
+	flags.ResendIPLimit = v.GetInt(resendIPLimit)
+	flags.MagicLinkInterval = v.GetDuration(magicLinkInterval)
+	flags.MagicLinkIPLimit = v.GetInt(magicLinkIPLimit)
+	flags.PendingAccountTTL = v.GetDuration(pendingAccountTTL)
+	flags.TrustForwardedFor = v.GetBool(trustForwardedFor)
+	flags.HardenedMode = v.GetBool(hardenedMode)
+	flags.TrustedLogout = strings.Split(v.GetString(trustedLogout), ",")
+	flags.AdminScope = v.GetString(adminScope)
+	flags.AutoMigrate = v.GetBool(autoMigrate)
+	flags.ProvidersFilePath = v.GetString(providersFilePath)
+	flags.ProfileSchemaPath = v.GetString(profileSchemaPath)
+	flags.RealmsFilePath = v.GetString(realmsFilePath)
+	flags.readStoreFlags(v)
 
 	flags.check()
 
 	b.Flags = flags
+	b.InitIdentityStore(v)
 	b.Outbox = outbox
-	b.GrantScopes = b.getGrantScopesFromFile(flags.ScopesFilePath)
+	b.ProfileSchema = b.getProfileSchemaFromFile(flags.ProfileSchemaPath)
+	b.GrantScopes = b.getGrantScopesFromFile(flags.ScopesFilePath, b.ProfileSchema)
+	b.UpstreamProviders = b.getUpstreamProvidersFromFile(flags.ProvidersFilePath)
 	b.HydraHelper = new(hydra.DefaultHydraHelper).Init(b.HydraAdminURL)
 	b.DB = b.initDB()
+	b.checkSchema(flags.AutoMigrate)
+	b.Keyring = b.initKeyring()
+	b.Keyring.Lifetimes = misc.TokenLifetimes{
+		EmailConfirmation: time.Second * flags.ConfirmationTTL,
+		ChangePassword:    time.Second * flags.PasswordResetTTL,
+		MagicLink:         time.Second * flags.MagicLinkTTL,
+	}
+	b.Hasher = b.initPasswordHasher()
+	b.PasswordPolicy = misc.DefaultPasswordPolicy
+
+	rp, err := webauthn.NewRelyingParty("Whisper", flags.PublicURL)
+	gohtypes.PanicIfError("Invalid public url", 500, err)
+	b.RelyingParty = rp
 
 	hydraAdminURI, err := url.Parse(flags.HydraAdminURL)
 	gohtypes.PanicIfError("Invalid hydra admin url", 500, err)
@@ -128,10 +319,118 @@ func (b *WebBuilder) Init(v *viper.Viper, outbox chan<- mail.Mail) *WebBuilder {
 		Scopes:         b.GrantScopes.GetScopeListFromGrantScopeMap(),
 	})
 
+	b.Realms = b.initRealms(flags.RealmsFilePath)
+
 	logrus.Infof("GrantScopes: '%v'", b.GrantScopes)
 	return b
 }
 
+// InitStore initializes only what is needed to reach the stored user credentials, with properties retrieved from Viper.
+func (b *WebBuilder) InitStore(v *viper.Viper) *WebBuilder {
+	flags := new(Flags)
+	flags.readStoreFlags(v)
+
+	checkRequiredFlags([]requiredFlag{
+		{flags.DatabaseURL, databaseURL},
+		{flags.SecretKey, secretKey},
+	})
+
+	b.Flags = flags
+	b.DB = b.initDB()
+	b.checkSchema(false)
+	b.Keyring = b.initKeyring()
+	b.Hasher = b.initPasswordHasher()
+
+	return b
+}
+
+// InitDatabase initializes only the connection with the database, whatever its schema, with properties retrieved from Viper.
+func (b *WebBuilder) InitDatabase(v *viper.Viper) *WebBuilder {
+	flags := new(Flags)
+	flags.DatabaseURL = v.GetString(databaseURL)
+
+	checkRequiredFlags([]requiredFlag{
+		{flags.DatabaseURL, databaseURL},
+	})
+
+	b.Flags = flags
+	b.DB = b.initDB()
+
+	return b
+}
+
+// InitHydraAdmin initializes the helper to Hydra's admin apis when its url is set, with properties retrieved from Viper.
+func (b *WebBuilder) InitHydraAdmin(v *viper.Viper) *WebBuilder {
+	if b.Flags == nil {
+		b.Flags = new(Flags)
+	}
+
+	b.HydraAdminURL = v.GetString(hydraAdminURL)
+	if b.HydraAdminURL != "" {
+		b.HydraHelper = new(hydra.DefaultHydraHelper).Init(b.HydraAdminURL)
+	}
+
+	return b
+}
+
+// InitIdentityStore initializes the identity store the users are authenticated against, with properties retrieved from Viper.
+func (b *WebBuilder) InitIdentityStore(v *viper.Viper) *WebBuilder {
+	if b.Flags == nil {
+		b.Flags = new(Flags)
+	}
+
+	b.IdentityStoreType = v.GetString(identityStore)
+	b.LDAPURL = v.GetString(ldapURL)
+	b.LDAPStartTLS = v.GetBool(ldapStartTLS)
+	b.LDAPBindDN = v.GetString(ldapBindDN)
+	b.LDAPBindPassword = v.GetString(ldapBindPassword)
+	b.LDAPBaseDN = v.GetString(ldapBaseDN)
+	b.LDAPUserFilter = v.GetString(ldapUserFilter)
+	b.LDAPUsernameAttr = v.GetString(ldapUsernameAttr)
+	b.LDAPEmailAttr = v.GetString(ldapEmailAttr)
+	b.LDAPTimeout = v.GetDuration(ldapTimeout)
+
+	switch b.IdentityStoreType {
+	case db.IdentityStoreDatabase, "":
+		b.IdentityStore = nil
+	case db.IdentityStoreLDAP:
+		store, err := new(db.LDAPIdentityStore).Init(db.LDAPConfig{
+			URL:               b.LDAPURL,
+			StartTLS:          b.LDAPStartTLS,
+			BindDN:            b.LDAPBindDN,
+			BindPassword:      b.LDAPBindPassword,
+			BaseDN:            b.LDAPBaseDN,
+			UserFilter:        b.LDAPUserFilter,
+			UsernameAttribute: b.LDAPUsernameAttr,
+			EmailAttribute:    b.LDAPEmailAttr,
+			Timeout:           time.Second * b.LDAPTimeout,
+		})
+		gohtypes.PanicIfError("Invalid ldap identity store", http.StatusInternalServerError, err)
+		b.IdentityStore = store
+	default:
+		gohtypes.Panic(fmt.Sprintf("Unknown identity store '%v', it should be %v or %v", b.IdentityStoreType, db.IdentityStoreDatabase, db.IdentityStoreLDAP), http.StatusInternalServerError)
+	}
+
+	return b
+}
+
+func (flags *Flags) readStoreFlags(v *viper.Viper) {
+	flags.DatabaseURL = v.GetString(databaseURL)
+	flags.SecretKey = v.GetString(secretKey)
+	flags.SecretKeyID = v.GetString(secretKeyID)
+	flags.RetiredSecretKeys = strings.Split(v.GetString(retiredSecretKeys), ",")
+	flags.PasswordHasher = v.GetString(passwordHasher)
+	flags.Argon2idTime = v.GetUint32(argon2idTime)
+	flags.Argon2idMemory = v.GetUint32(argon2idMemory)
+	flags.Argon2idThreads = v.GetUint32(argon2idThreads)
+	flags.BcryptCost = v.GetInt(bcryptCost)
+}
+
+type requiredFlag = struct {
+	value string
+	name  string
+}
+
 func (flags *Flags) check() {
 	logrus.Infof("Flags: '%v'", flags)
 
@@ -147,8 +446,24 @@ func (flags *Flags) check() {
 		{flags.MailPassword, mailPassword},
 		{flags.MailHost, mailHost},
 		{flags.MailPort, mailPort},
+		{flags.AdminScope, adminScope},
 	}
+	checkRequiredFlags(requiredFlags)
 
+	if flags.ConfirmationTTL <= 0 || flags.PasswordResetTTL <= 0 || flags.MagicLinkTTL <= 0 {
+		panic("The token lifetimes should be positive")
+	}
+
+	if flags.MagicLinkIPLimit <= 0 {
+		panic("The sign in links a client ip can ask for should be positive")
+	}
+
+	if flags.ResendIPLimit <= 0 {
+		panic("The email confirmations a client ip can ask for should be positive")
+	}
+}
+
+func checkRequiredFlags(requiredFlags []requiredFlag) {
 	var errMsg string
 
 	for _, flag := range requiredFlags {
@@ -163,8 +478,9 @@ func (flags *Flags) check() {
 	}
 }
 
-// getGrantScopesFromFile reads into memory the json scopes file
-func (b *WebBuilder) getGrantScopesFromFile(scopesFilePath string) misc.GrantScopes {
+// getGrantScopesFromFile reads into memory the json scopes file, resolving the claims of the scopes against the
+// profile schema
+func (b *WebBuilder) getGrantScopesFromFile(scopesFilePath string, schema misc.ProfileSchema) misc.GrantScopes {
 	jsonFile, err := os.Open(scopesFilePath)
 	if err != nil {
 		panic(err)
@@ -184,18 +500,133 @@ func (b *WebBuilder) getGrantScopesFromFile(scopesFilePath string) misc.GrantSco
 		panic(err.Error())
 	}
 
+	grantScopes, err = misc.NewGrantScopes(grantScopes, schema)
+	if err != nil {
+		gohtypes.Panic(err.Error(), http.StatusInternalServerError)
+	}
+
 	return grantScopes
 }
 
+// getUpstreamProvidersFromFile reads into memory the json upstream providers file, when there is one
+func (b *WebBuilder) getUpstreamProvidersFromFile(providersFilePath string) []*oidc.Provider {
+	if providersFilePath == "" {
+		return nil
+	}
+
+	bytes, err := ioutil.ReadFile(providersFilePath)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var configs []oidc.ProviderConfig
+	err = json.Unmarshal(bytes, &configs)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	providers := make([]*oidc.Provider, 0, len(configs))
+	for _, providerConfig := range configs {
+		provider, err := oidc.NewProvider(providerConfig)
+		if err != nil {
+			gohtypes.Panic(err.Error(), http.StatusInternalServerError)
+		}
+
+		if provider.ID == "link" { // taken by the route confirming links
+			gohtypes.Panic("The upstream provider id 'link' is reserved", http.StatusInternalServerError)
+		}
+
+		for _, p := range providers {
+			if p.ID == provider.ID {
+				gohtypes.Panic(fmt.Sprintf("The upstream provider '%v' is configured twice", provider.ID), http.StatusInternalServerError)
+			}
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers
+}
+
+// getProfileSchemaFromFile reads into memory the json profile schema file, when there is one
+func (b *WebBuilder) getProfileSchemaFromFile(profileSchemaPath string) misc.ProfileSchema {
+	if profileSchemaPath == "" {
+		return misc.ProfileSchema{}
+	}
+
+	bytes, err := ioutil.ReadFile(profileSchemaPath)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var attributes []misc.ProfileAttribute
+	err = json.Unmarshal(bytes, &attributes)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	schema, err := misc.NewProfileSchema(attributes)
+	if err != nil {
+		gohtypes.Panic(err.Error(), http.StatusInternalServerError)
+	}
+
+	return schema
+}
+
+// GetUpstreamProvider gets a configured upstream provider by its id, or nil when there is none
+func (b *WebBuilder) GetUpstreamProvider(id string) *oidc.Provider {
+	for _, provider := range b.UpstreamProviders {
+		if provider.ID == id {
+			return provider
+		}
+	}
+
+	return nil
+}
+
+// GetUpstreamRedirectURI builds the url an upstream provider sends the browser back to after signing in
+func (b *WebBuilder) GetUpstreamRedirectURI(id string) string {
+	return strings.TrimSuffix(b.PublicURL, "/") + "/federation/" + url.PathEscape(id) + "/callback"
+}
+
+// initKeyring builds the keyring from the active and retired secret keys
+func (b *WebBuilder) initKeyring() *misc.Keyring {
+	keyring, err := new(misc.Keyring).Init(b.SecretKeyID, b.SecretKey, b.RetiredSecretKeys)
+	gohtypes.PanicIfError("Invalid secret keys", http.StatusInternalServerError, err)
+
+	return keyring
+}
+
+// initPasswordHasher builds the password hasher from the tuning flags
+func (b *WebBuilder) initPasswordHasher() misc.PasswordHasher {
+	hasher, err := new(misc.DefaultPasswordHasher).Init(
+		b.PasswordHasher,
+		b.Keyring,
+		misc.Argon2idHasher{Time: b.Argon2idTime, Memory: b.Argon2idMemory, Threads: b.Argon2idThreads},
+		misc.BcryptHasher{Cost: b.BcryptCost},
+	)
+	gohtypes.PanicIfError("Invalid password hasher", http.StatusInternalServerError, err)
+
+	return hasher
+}
+
 // initDB opens a connection with the database
 func (b *WebBuilder) initDB() *gorm.DB {
-	dbURL := strings.Replace(b.DatabaseURL, "mysql://", "", 1)
-	dbc, err := gorm.Open("mysql", dbURL)
+	dbc, err := db.Open(b.DatabaseURL)
 	gohtypes.PanicIfError("Unable to open db", http.StatusInternalServerError, err)
 
-	dbc.DB().SetMaxIdleConns(4)
-	dbc.DB().SetMaxOpenConns(20)
-
 	return dbc.LogMode(true)
 
 }
+
+// checkSchema refuses a database schema other than the expected one, first applying the pending migrations when allowed
+func (b *WebBuilder) checkSchema(migrate bool) {
+	if migrate {
+		_, err := db.MigrateUp(b.DB, 0)
+		gohtypes.PanicIfError("Unable to migrate db", http.StatusInternalServerError, err)
+	}
+
+	if err := db.CheckSchemaVersion(b.DB); err != nil {
+		gohtypes.Panic(err.Error(), http.StatusInternalServerError)
+	}
+}
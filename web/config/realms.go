@@ -0,0 +1,221 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/misc"
+	"github.com/labbsr0x/whisper/webauthn"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// RealmConfig defines a realm of the realms file: a user base of its own, served from its public url. What it
+// leaves empty is taken from the flags of the default realm
+type RealmConfig struct {
+	ID             string               `json:"id"`
+	PublicURL      string               `json:"public_url"`
+	BaseUIPath     string               `json:"base_ui_path"`
+	ScopesFilePath string               `json:"scopes_file_path"`
+	AdminScope     string               `json:"admin_scope"`
+	Mail           *RealmMailConfig     `json:"mail"`
+	PasswordPolicy *misc.PasswordPolicy `json:"password_policy"`
+}
+
+// RealmMailConfig defines the mail sender of a realm
+type RealmMailConfig struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+}
+
+// AddRealmFlags adds the flags picking the realm whose users are managed.
+func AddRealmFlags(flags *pflag.FlagSet) {
+	flags.StringP(realm, "", "", "[optional] Sets the id of the realm whose users are managed. Defaults to the default realm")
+	flags.StringP(realmsFilePath, "", "", "[optional] Sets the path to the json file where the realms will be found, so the password policy of the realm is followed")
+}
+
+// InitRealm picks the realm whose users are reached, with properties retrieved from Viper.
+func (b *WebBuilder) InitRealm(v *viper.Viper) *WebBuilder {
+	if b.Flags == nil {
+		b.Flags = new(Flags)
+	}
+
+	b.RealmsFilePath = v.GetString(realmsFilePath)
+	b.Realm = v.GetString(realm)
+	b.PasswordPolicy = misc.DefaultPasswordPolicy
+
+	if b.Realm == "" {
+		return b
+	}
+
+	gohtypes.PanicIfError("Invalid realm", http.StatusBadRequest, misc.CheckRealmID(b.Realm))
+	if b.RealmsFilePath == "" {
+		return b
+	}
+
+	for _, realmConfig := range b.getRealmConfigsFromFile(b.RealmsFilePath) {
+		if realmConfig.ID != b.Realm {
+			continue
+		}
+
+		if realmConfig.PasswordPolicy != nil {
+			b.PasswordPolicy = *realmConfig.PasswordPolicy
+		}
+		return b
+	}
+
+	gohtypes.Panic(fmt.Sprintf("The realm '%v' is not in the realms file", b.Realm), http.StatusBadRequest)
+	return b
+}
+
+// GetSubject gets the hydra subject of a user of the realm
+func (b *WebBuilder) GetSubject(username string) string {
+	return misc.GetRealmSubject(b.Realm, username)
+}
+
+// GetUsername gets the username of a hydra subject, telling whether the subject belongs to the realm
+func (b *WebBuilder) GetUsername(subject string) (string, bool) {
+	return misc.GetRealmUsername(b.Realm, subject, b.isolated)
+}
+
+// GetRealmURLs gets the public urls of the served realms by their ids, the default one by an empty id
+func (b *WebBuilder) GetRealmURLs() map[string]string {
+	urls := map[string]string{"": b.PublicURL}
+	for _, realmBuilder := range b.Realms {
+		urls[realmBuilder.Realm] = realmBuilder.PublicURL
+	}
+
+	return urls
+}
+
+// GetPublicHost gets the host of the public url of the realm, the requests to it being served by the realm
+func (b *WebBuilder) GetPublicHost() string {
+	return getURLHost(b.PublicURL)
+}
+
+// initRealms builds the realms of the realms file, when there is one, from the builder of the default realm
+func (b *WebBuilder) initRealms(realmsFilePath string) []*WebBuilder {
+	if realmsFilePath == "" {
+		return nil
+	}
+
+	if b.IdentityStore != nil {
+		gohtypes.Panic("Realms can not be used along with an identity store, whose users they would share", http.StatusInternalServerError)
+	}
+
+	hosts := map[string]string{b.GetPublicHost(): ""}
+	realms := make([]*WebBuilder, 0)
+
+	for _, realmConfig := range b.getRealmConfigsFromFile(realmsFilePath) {
+		if err := misc.CheckRealmID(realmConfig.ID); err != nil {
+			gohtypes.Panic(err.Error(), http.StatusInternalServerError)
+		}
+
+		host := getURLHost(realmConfig.PublicURL)
+		if host == "" {
+			gohtypes.Panic(fmt.Sprintf("The realm '%v' should have a public url", realmConfig.ID), http.StatusInternalServerError)
+		}
+
+		if other, ok := hosts[host]; ok {
+			gohtypes.Panic(fmt.Sprintf("The realm '%v' has the host of the realm '%v'", realmConfig.ID, other), http.StatusInternalServerError)
+		}
+
+		for _, realmBuilder := range realms {
+			if realmBuilder.Realm == realmConfig.ID {
+				gohtypes.Panic(fmt.Sprintf("The realm '%v' is configured twice", realmConfig.ID), http.StatusInternalServerError)
+			}
+		}
+
+		hosts[host] = realmConfig.ID
+		realms = append(realms, b.newRealm(realmConfig))
+	}
+
+	b.isolated = true
+	return realms
+}
+
+// newRealm builds a realm from a copy of the builder of the default realm
+func (b *WebBuilder) newRealm(realmConfig RealmConfig) *WebBuilder {
+	flags := *b.Flags
+	realmBuilder := *b
+	realmBuilder.Flags = &flags
+	realmBuilder.Realm = realmConfig.ID
+	realmBuilder.PublicURL = realmConfig.PublicURL
+	realmBuilder.AdminScope = b.AdminScope + "." + realmConfig.ID
+	realmBuilder.isolated = true
+
+	if realmConfig.BaseUIPath != "" {
+		realmBuilder.BaseUIPath = realmConfig.BaseUIPath
+	}
+
+	if realmConfig.ScopesFilePath != "" {
+		realmBuilder.ScopesFilePath = realmConfig.ScopesFilePath
+		realmBuilder.GrantScopes = b.getGrantScopesFromFile(realmConfig.ScopesFilePath, b.ProfileSchema)
+	}
+
+	if realmConfig.AdminScope != "" {
+		realmBuilder.AdminScope = realmConfig.AdminScope
+	}
+
+	if realmConfig.Mail != nil {
+		if realmConfig.Mail.User == "" || realmConfig.Mail.Password == "" || realmConfig.Mail.Host == "" || realmConfig.Mail.Port == "" {
+			gohtypes.Panic(fmt.Sprintf("The mail of the realm '%v' should have a user, password, host and port", realmConfig.ID), http.StatusInternalServerError)
+		}
+
+		realmBuilder.MailUser = realmConfig.Mail.User
+		realmBuilder.MailPassword = realmConfig.Mail.Password
+		realmBuilder.MailHost = realmConfig.Mail.Host
+		realmBuilder.MailPort = realmConfig.Mail.Port
+		realmBuilder.Outbox = nil // set once its mail sender runs
+	}
+
+	if realmConfig.PasswordPolicy != nil {
+		if err := realmConfig.PasswordPolicy.Check(); err != nil {
+			gohtypes.Panic(fmt.Sprintf("Invalid password policy of the realm '%v': %v", realmConfig.ID, err), http.StatusInternalServerError)
+		}
+		realmBuilder.PasswordPolicy = *realmConfig.PasswordPolicy
+	}
+
+	rp, err := webauthn.NewRelyingParty("Whisper", realmConfig.PublicURL)
+	gohtypes.PanicIfError(fmt.Sprintf("Invalid public url of the realm '%v'", realmConfig.ID), http.StatusInternalServerError, err)
+	realmBuilder.RelyingParty = rp
+
+	return &realmBuilder
+}
+
+// getRealmConfigsFromFile reads into memory the json realms file
+func (b *WebBuilder) getRealmConfigsFromFile(realmsFilePath string) []RealmConfig {
+	if realmsFilePath == "" {
+		return nil
+	}
+
+	bytes, err := ioutil.ReadFile(realmsFilePath)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	var realmConfigs []RealmConfig
+	err = json.Unmarshal(bytes, &realmConfigs)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	return realmConfigs
+}
+
+// getURLHost gets the host of a url, lower cased, or an empty string when it has none
+func getURLHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Host)
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"time"
+
+	"github.com/labbsr0x/whisper/db"
+	"github.com/labbsr0x/whisper/web/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// pendingAccountsCheckInterval is how often the registrations that never confirmed their email are looked for
+const pendingAccountsCheckInterval = time.Hour
+
+// runPendingAccountsExpiry deletes, from time to time, the registrations that did not confirm their email within the
+// pending account lifetime. Every replica runs it, deleting a user twice is harmless
+func (s *Server) runPendingAccountsExpiry() {
+	dao := new(db.DefaultUserCredentialsDAO).Init(s.Keyring, s.Hasher, s.HardenedMode, s.BaseUIPath, s.PublicURL, s.Realm, s.Outbox, s.IdentityStore, s.DB)
+	if s.PendingAccountTTL <= 0 || dao.IsReadOnly() {
+		return
+	}
+
+	ticker := time.NewTicker(pendingAccountsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.expirePendingAccounts(dao)
+		<-ticker.C
+	}
+}
+
+// expirePendingAccounts deletes the registrations older than the pending account lifetime that never confirmed their email
+func (s *Server) expirePendingAccounts(dao db.UserCredentialsDAO) {
+	expired, err := dao.ExpirePendingUserCredentials(time.Now().Add(-time.Second * s.PendingAccountTTL))
+	metrics.PendingAccountsExpired.Add(float64(len(expired)))
+
+	for _, userCredential := range expired {
+		logrus.Infof("User '%v' deleted for never confirming its email", userCredential.Username)
+	}
+
+	if err != nil {
+		logrus.Errorf("Unable to expire the pending accounts: %v", err)
+	}
+}
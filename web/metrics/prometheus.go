@@ -7,6 +7,18 @@ import (
 // Latency is a prometheus register for latency histogram data
 var Latency *prometheus.HistogramVec
 
+// LoginLockouts is a prometheus register for the login throttle lockouts, partitioned by what was locked
+var LoginLockouts *prometheus.CounterVec
+
+// LoginUnlocks is a prometheus register for the login throttle unlocks, partitioned by what was unlocked
+var LoginUnlocks *prometheus.CounterVec
+
+// ConfirmationResends is a prometheus register for the requests to resend the email confirmation, partitioned by result
+var ConfirmationResends *prometheus.CounterVec
+
+// PendingAccountsExpired is a prometheus register for the registrations deleted for never confirming their email
+var PendingAccountsExpired prometheus.Counter
+
 func init() {
 	Latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:        "http_request_duration_seconds",
@@ -16,4 +28,38 @@ func init() {
 		[]string{"code", "method", "path"},
 	)
 	prometheus.MustRegister(Latency)
+
+	LoginLockouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "login_lockouts_total",
+		Help:        "How many times a username or client ip was locked out after failed login attempts, partitioned by scope",
+		ConstLabels: prometheus.Labels{"service": "whisper"},
+	},
+		[]string{"scope"},
+	)
+	prometheus.MustRegister(LoginLockouts)
+
+	LoginUnlocks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "login_unlocks_total",
+		Help:        "How many times a locked out username or client ip was unlocked, partitioned by scope and reason",
+		ConstLabels: prometheus.Labels{"service": "whisper"},
+	},
+		[]string{"scope", "reason"},
+	)
+	prometheus.MustRegister(LoginUnlocks)
+
+	ConfirmationResends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "email_confirmation_resends_total",
+		Help:        "How many times a user asked for the email confirmation to be sent again, partitioned by result",
+		ConstLabels: prometheus.Labels{"service": "whisper"},
+	},
+		[]string{"result"},
+	)
+	prometheus.MustRegister(ConfirmationResends)
+
+	PendingAccountsExpired = prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "pending_accounts_expired_total",
+		Help:        "How many registrations were deleted for never confirming their email",
+		ConstLabels: prometheus.Labels{"service": "whisper"},
+	})
+	prometheus.MustRegister(PendingAccountsExpired)
 }
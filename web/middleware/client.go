@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+)
+
+// GetClientMiddleware gets the middleware that only lets through the requests whose token was issued to a client for
+// itself, through the client credentials flow, and not to a user that consented to the client. Such tokens have the
+// client as their subject. It must run after the security middleware, which introspects the token
+func GetClientMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+			if !ok {
+				gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+			}
+
+			if token.ClientID == "" || token.Subject != token.ClientID {
+				gohtypes.Panic("Forbidden: the token should be issued to the client through the client credentials flow", http.StatusForbidden)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,2 +1,53 @@
 package middleware
 
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+)
+
+// serveWithToken serves a request carrying the introspected token through the middleware, returning the status code
+// the middleware panicked with, or 200 when it let the request through
+func serveWithToken(middleware func(http.Handler) http.Handler, token whisper.Token) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(gohtypes.Error)
+			if !ok {
+				panic(r)
+			}
+			code = e.Code
+		}
+	}()
+
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r.WithContext(context.WithValue(r.Context(), whisper.TokenKey, token)))
+
+	return http.StatusOK
+}
+
+func TestAdminMiddlewares(t *testing.T) {
+	admin := func(next http.Handler) http.Handler {
+		return GetClientMiddleware()(GetScopeMiddleware("whisper.admin")(next))
+	}
+
+	var tests = []struct {
+		token whisper.Token
+		code  int
+	}{
+		{whisper.Token{ClientID: "backoffice", Subject: "backoffice", Scope: "whisper.admin"}, http.StatusOK},
+		{whisper.Token{ClientID: "backoffice", Subject: "backoffice", Scope: "openid"}, http.StatusForbidden},
+		{whisper.Token{ClientID: "backoffice", Subject: "alice", Scope: "openid whisper.admin"}, http.StatusForbidden},
+		{whisper.Token{ClientID: "backoffice", Subject: "sales/alice", Scope: "whisper.admin"}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		if code := serveWithToken(admin, test.token); code != test.code {
+			t.Errorf("%+v: expected %v, got %v", test.token, test.code, code)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+)
+
+// GetRealmMiddleware gets the middleware that only lets through the requests whose token subject belongs to the realm,
+// given by getUsername, replacing the subject by the username the handlers look the user up by.
+// It must run after the security middleware, which introspects the token
+func GetRealmMiddleware(getUsername func(subject string) (string, bool)) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+			if !ok {
+				gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+			}
+
+			username, ok := getUsername(token.Subject)
+			if !ok {
+				gohtypes.Panic("Forbidden: the token was issued to a user of another realm", http.StatusForbidden)
+			}
+
+			token.Subject = username
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), whisper.TokenKey, token)))
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/goh/gohtypes"
+	whisper "github.com/labbsr0x/whisper-client/client"
+)
+
+// GetScopeMiddleware gets the middleware that only lets through the requests whose token was granted the scope.
+// It must run after the security middleware, which introspects the token
+func GetScopeMiddleware(scope string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(whisper.TokenKey).(whisper.Token)
+			if !ok {
+				gohtypes.Panic("Unauthorized: token not found", http.StatusUnauthorized)
+			}
+
+			for _, granted := range strings.Fields(token.Scope) {
+				if granted == scope {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			gohtypes.Panic("Forbidden: the token was not granted the '"+scope+"' scope", http.StatusForbidden)
+		})
+	}
+}
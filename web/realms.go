@@ -0,0 +1,100 @@
+package web
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/labbsr0x/goh/gohtypes"
+	"github.com/labbsr0x/whisper/misc"
+)
+
+// getRealmsHandler dispatches the requests to the realm whose public url has their host, the default realm serving
+// the requests to any other host
+func (s *Server) getRealmsHandler(router *mux.Router) http.Handler {
+	realmURLs := s.GetRealmURLs()
+	router.Use(s.getRealmRedirectMiddleware(realmURLs))
+
+	routers := map[string]http.Handler{}
+	for _, realm := range s.Realms {
+		realmRouter := realm.getRouter()
+		realmRouter.Use(realm.getRealmRedirectMiddleware(realmURLs))
+		routers[realm.GetPublicHost()] = realmRouter
+
+		go realm.runPendingAccountsExpiry()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		if realmRouter, ok := routers[host]; ok {
+			realmRouter.ServeHTTP(w, r)
+			return
+		}
+
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			if realmRouter, ok := routers[hostname]; ok {
+				realmRouter.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		router.ServeHTTP(w, r)
+	})
+}
+
+// getRealmRedirectMiddleware sends the browser to the public url of the realm a login, consent or logout request
+// belongs to, when another realm got it: hydra redirects every realm to the same login, consent and logout urls
+func (s *Server) getRealmRedirectMiddleware(realmURLs map[string]string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet {
+				if realm, ok := s.getRequestRealm(r); ok && realm != s.Realm {
+					publicURL, known := realmURLs[realm]
+					if !known {
+						gohtypes.Panic(fmt.Sprintf("The realm '%v' is not served", realm), http.StatusBadRequest)
+					}
+
+					http.Redirect(w, r, strings.TrimSuffix(publicURL, "/")+r.URL.RequestURI(), http.StatusFound)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// getRequestRealm gets the realm of the hydra request a page is shown for, telling whether the page names one.
+// A login belongs to the realm of the client metadata, a consent or a logout to the realm of the subject
+func (s *Server) getRequestRealm(r *http.Request) (string, bool) {
+	query := r.URL.Query()
+
+	switch r.URL.Path {
+	case "/login", "/registration":
+		if challenge := query.Get("login_challenge"); challenge != "" {
+			return misc.GetClientRealm(s.HydraHelper.GetLoginRequestInfo(challenge))
+		}
+	case "/consent":
+		if challenge := query.Get("consent_challenge"); challenge != "" {
+			return getSubjectRealm(s.HydraHelper.GetConsentRequestInfo(challenge))
+		}
+	case "/logout":
+		if challenge := query.Get("logout_challenge"); challenge != "" {
+			return getSubjectRealm(s.HydraHelper.GetLogoutRequestInfo(challenge))
+		}
+	}
+
+	return "", false
+}
+
+// getSubjectRealm gets the realm of the subject of a hydra request
+func getSubjectRealm(info map[string]interface{}) (string, bool) {
+	subject, _ := info["subject"].(string)
+	if subject == "" {
+		return "", false
+	}
+
+	return misc.GetSubjectRealm(subject), true
+}
@@ -16,9 +16,14 @@ const (
 	ChangePasswordStep2 = "change_password_step_2.html"
 	Consent             = "consent.html"
 	EmailConfirmation   = "email_confirmation.html"
+	FederationLink      = "federation_link.html"
 	Layout              = "index.html"
 	Login               = "login.html"
+	Logout              = "logout.html"
+	MagicLink           = "magic_link.html"
 	Registration        = "registration.html"
+	ResendConfirmation  = "resend_confirmation.html"
+	Sessions            = "sessions.html"
 	Update              = "update.html"
 )
 
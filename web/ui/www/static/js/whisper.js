@@ -7,10 +7,15 @@ var params = new URLSearchParams(window.location.search);
 window.onload = function() {
     var action = window.location.pathname.replace("/", "");
     setupConsentForm(action);
+    setupLogoutForm(action);
     setupLoginPage(action);
     setupUpdatePage(action);
+    setupSessionsPage(action);
     setupRegistrationPage(action);
     setupEmailConfirmationPage(action);
+    setupResendConfirmationPage(action);
+    setupFederationLinkPage(action);
+    setupMagicLinkPage(action);
     setupChangePasswordStep1Page(action);
     setupChangePasswordStep2Page(action);
 };
@@ -148,6 +153,12 @@ function setupLoginPage(action) {
             contentType: "application/json",
             success: function(data) {
                 finishSubmitting($this);
+
+                if (data.second_factor) {
+                    showSecondFactorForm(data.token);
+                    return;
+                }
+
                 window.location = data.redirect_to;
             },
             error: function(xhr) {
@@ -155,9 +166,304 @@ function setupLoginPage(action) {
                 notifyError(xhr.responseText);
             }
         })
+    });
+
+    $('#second-factor-submit').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        var useRecoveryCode = !$("#recovery-code-group").attr("hidden");
+        var request = {
+            token: $("#second-factor-token").val(),
+            code: useRecoveryCode ? "" : $("#second-factor-code").val(),
+            recoveryCode: useRecoveryCode ? $("#second-factor-recovery-code").val() : ""
+        };
+
+        if (!request.code && !request.recoveryCode) {
+            notifyError(useRecoveryCode ? "Recovery code is missing" : "Authentication code is missing");
+            return;
+        }
+
+        startSubmitting($this);
+
+        $.ajax({
+            url: "/login/second-factor",
+            type: "POST",
+            data: JSON.stringify(request),
+            contentType: "application/json",
+            success: function(data) {
+                finishSubmitting($this, "Verify");
+                window.location = data.redirect_to;
+            },
+            error: function(xhr) {
+                finishSubmitting($this, "Verify");
+                notifyError(xhr.responseText);
+            }
+        })
+    });
+
+    $('#second-factor-use-recovery-code').on('click', function(event) {
+        event.preventDefault();
+
+        $("#second-factor-code").closest(".form-group").attr("hidden", true);
+        $("#recovery-code-group").attr("hidden", false);
+        $(this).attr("hidden", true);
+        $("#second-factor-recovery-code").focus();
+    });
+
+    setupWebAuthnLogin();
+    setupFederationLogin();
+    setupMagicLinkLogin();
+}
+
+function setupMagicLinkLogin() {
+    var secondFactorToken = params.get("second_factor_token");
+
+    if (secondFactorToken) {
+        // keeps cancel, which reloads the page, from showing the second factor again
+        params.delete("second_factor_token");
+        window.history.replaceState(null, "", window.location.pathname + "?" + params.toString());
+        showSecondFactorForm(secondFactorToken);
+    }
+
+    $('#magic-link-submit').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        var buttonText = $this.html();
+        var request = {
+            email: $("#magic-link-email").val(),
+            remember: $("#login-remember").is(":checked"),
+            challenge: params.get("login_challenge")
+        };
+
+        if (!request.email) {
+            notifyError("Email is missing");
+            return;
+        }
+
+        if (!request.challenge) {
+            notifyError("Challenge is missing");
+            return;
+        }
+
+        startSubmitting($this);
+
+        $.ajax({
+            url: "/login/magic-link",
+            type: "POST",
+            data: JSON.stringify(request),
+            contentType: "application/json",
+            success: function(data) {
+                finishSubmitting($this, buttonText);
+                notifySuccess(data.message);
+            },
+            error: function(xhr) {
+                finishSubmitting($this, buttonText);
+                notifyError(xhr.responseText);
+            }
+        })
+    });
+}
+
+function bufferToBase64URL(buffer) {
+    var bytes = new Uint8Array(buffer);
+    var binary = "";
+    for (var i = 0; i < bytes.length; i++) {
+        binary += String.fromCharCode(bytes[i]);
+    }
+    return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function base64URLToBuffer(value) {
+    var base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    var binary = atob(base64 + "===".slice((base64.length + 3) % 4));
+    var bytes = new Uint8Array(binary.length);
+    for (var i = 0; i < binary.length; i++) {
+        bytes[i] = binary.charCodeAt(i);
+    }
+    return bytes.buffer;
+}
+
+function decodeCredentialDescriptors(descriptors) {
+    return (descriptors || []).map(function (item) {
+        return { type: item.type, id: base64URLToBuffer(item.id) };
+    });
+}
+
+function setupFederationLogin() {
+    var federationError = params.get("federation_error");
+
+    if (federationError) {
+        notifyError(federationError);
+    }
+
+    $('.federation-login-submit').on('click', function(event) {
+        event.preventDefault();
+
+        var challenge = params.get("login_challenge");
+
+        if (!challenge) {
+            notifyError("Challenge is missing");
+            return;
+        }
+
+        startSubmitting($(this));
+        window.location = "/federation/" + encodeURIComponent($(this).data("provider")) +
+            "?login_challenge=" + encodeURIComponent(challenge) +
+            "&remember=" + $("#login-remember").is(":checked");
     })
 }
 
+function setupFederationLinkPage(action) {
+    if (action !== "federation/link") {
+        return;
+    }
+
+    $('#federation-link-submit').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        var request = {
+            password: $("#federation-link-password").val() || ""
+        };
+
+        startSubmitting($this);
+
+        $.ajax({
+            url: "/federation/link",
+            type: "POST",
+            data: JSON.stringify(request),
+            contentType: "application/json",
+            success: function(data) {
+                finishSubmitting($this, "Link");
+                window.location = data.redirect_to;
+            },
+            error: function(xhr) {
+                finishSubmitting($this, "Link");
+                notifyError(xhr.responseText);
+            }
+        })
+    });
+}
+
+function setupMagicLinkPage(action) {
+    if (action !== "login/magic-link") {
+        return;
+    }
+
+    $('#magic-link-confirm').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        var request = {
+            token: params.get("token")
+        };
+
+        startSubmitting($this);
+
+        $.ajax({
+            url: "/login/magic-link",
+            type: "PUT",
+            data: JSON.stringify(request),
+            contentType: "application/json",
+            success: function(data) {
+                finishSubmitting($this, "Sign in");
+                window.location = data.redirect_to;
+            },
+            error: function(xhr) {
+                finishSubmitting($this, "Sign in");
+                notifyError(xhr.responseText);
+            }
+        })
+    });
+}
+
+function setupWebAuthnLogin() {
+    if (!window.PublicKeyCredential) {
+        return;
+    }
+
+    $("#webauthn-login-content").attr("hidden", false);
+
+    $('#webauthn-login-submit').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        var buttonText = $this.html();
+        var request = {
+            username: $("#login-username").val(),
+            remember: $("#login-remember").is(":checked"),
+            challenge: params.get("login_challenge")
+        };
+
+        if (!request.challenge) {
+            notifyError("Challenge is missing");
+            return;
+        }
+
+        startSubmitting($this);
+
+        $.ajax({
+            url: "/login/webauthn/options",
+            type: "POST",
+            data: JSON.stringify(request),
+            contentType: "application/json",
+            success: function(data) {
+                var publicKey = data.publicKey;
+                publicKey.challenge = base64URLToBuffer(publicKey.challenge);
+                publicKey.allowCredentials = decodeCredentialDescriptors(publicKey.allowCredentials);
+
+                navigator.credentials.get({ publicKey: publicKey }).then(function (credential) {
+                    var response = {
+                        token: data.token,
+                        credential: {
+                            id: credential.id,
+                            rawId: bufferToBase64URL(credential.rawId),
+                            type: credential.type,
+                            response: {
+                                clientDataJSON: bufferToBase64URL(credential.response.clientDataJSON),
+                                authenticatorData: bufferToBase64URL(credential.response.authenticatorData),
+                                signature: bufferToBase64URL(credential.response.signature),
+                                userHandle: credential.response.userHandle ? bufferToBase64URL(credential.response.userHandle) : ""
+                            }
+                        }
+                    };
+
+                    $.ajax({
+                        url: "/login/webauthn",
+                        type: "POST",
+                        data: JSON.stringify(response),
+                        contentType: "application/json",
+                        success: function(data) {
+                            finishSubmitting($this, buttonText);
+                            window.location = data.redirect_to;
+                        },
+                        error: function(xhr) {
+                            finishSubmitting($this, buttonText);
+                            notifyError(xhr.responseText);
+                        }
+                    })
+                }).catch(function (err) {
+                    finishSubmitting($this, buttonText);
+                    notifyError(err.message);
+                });
+            },
+            error: function(xhr) {
+                finishSubmitting($this, buttonText);
+                notifyError(xhr.responseText);
+            }
+        })
+    })
+}
+
+function showSecondFactorForm(token) {
+    $("#second-factor-token").val(token);
+    $("#login-form").attr("hidden", true);
+    $("#second-factor-form").attr("hidden", false);
+    $("#second-factor-code").focus();
+}
+
 function setupConsentForm(action) {
     if (action !== "consent") {
         return;
@@ -209,6 +515,56 @@ function setupConsentForm(action) {
     $('#consent-deny').on('click', consent(false));
 }
 
+function setupLogoutForm(action) {
+    if (action !== "logout") {
+        return;
+    }
+
+    function logout (answer) {
+        return function (event) {
+            event.preventDefault();
+
+            var $this = $(this);
+            var buttonText = answer ? "Sign out" : "No";
+            var request = {
+                accept: answer,
+                challenge: $("#logout-challenge").val()
+            };
+
+            if (!request.challenge) {
+                notifyError("Challenge is missing");
+                return;
+            }
+
+            startSubmitting($this);
+
+            $.ajax({
+                url: "/logout",
+                type: "POST",
+                data: JSON.stringify(request),
+                contentType: "application/json",
+                success: function (data) {
+                    if (data.redirect_to) {
+                        window.location = data.redirect_to;
+                        return;
+                    }
+
+                    $("#logout-accept").prop("disabled", true);
+                    $this.remove();
+                    notifySuccess("You are still signed in.");
+                },
+                error: function (xhr) {
+                    finishSubmitting($this, buttonText);
+                    notifyError(xhr.responseText);
+                }
+            });
+        }
+    }
+
+    $('#logout-accept').on('click', logout(true));
+    $('#logout-deny').on('click', logout(false));
+}
+
 function setupUpdatePage(action) {
     if (action !== "secure/update") {
         return;
@@ -277,9 +633,183 @@ function setupUpdatePage(action) {
                 notifyError(xhr.responseText);
             }
         })
+    });
+
+    setupProfile();
+    setupTOTPEnrollment();
+    setupWebAuthnRegistration();
+    setupRecoveryCodes();
+    setupUserIdentities();
+
+    $("#sessions-link").attr("href", "/secure/sessions" + window.location.search);
+}
+
+function getProfileAttributes() {
+    var attributes = {};
+    $(".profile-attribute:enabled").each(function() {
+        attributes[$(this).data("name")] = $(this).val();
+    });
+
+    return attributes;
+}
+
+function setupProfile() {
+    $('#profile-submit').on('click', function(event) {
+        event.preventDefault();
+
+        var request = {
+            attributes: getProfileAttributes()
+        };
+
+        secureRequest("PUT", "/secure/profile", request, $(this), "Save profile", function() {
+            notifySuccess("Profile saved!");
+        });
+    });
+}
+
+function showRecoveryCodesOrReload(data) {
+    if (!data || !data.recovery_codes || data.recovery_codes.length === 0) {
+        window.location.reload();
+        return;
+    }
+
+    $("#recovery-codes").text(data.recovery_codes.join("\n"));
+    $("#recovery-codes-remaining").text(data.recovery_codes.length);
+    $("#recovery-codes-list").attr("hidden", false);
+    notifySuccess("Saved! Store your new recovery codes before leaving this page.");
+}
+
+function setupRecoveryCodes() {
+    $('#recovery-codes-generate').on('click', function(event) {
+        event.preventDefault();
+
+        secureRequest("POST", "/secure/recovery-codes", null, $(this), "Generate new codes", function(data) {
+            showRecoveryCodesOrReload(data);
+        });
+    });
+}
+
+function setupSessionsPage(action) {
+    if (action !== "secure/sessions") {
+        return;
+    }
+
+    $('.consent-revoke').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        secureRequest("DELETE", "/secure/sessions/consents/" + encodeURIComponent($this.data("client")), null, $this, "Revoke", function() {
+            window.location.reload();
+        });
+    });
+
+    $('#logins-revoke').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        secureRequest("DELETE", "/secure/sessions/logins", null, $this, "Sign out everywhere", function() {
+            notifySuccess("You were signed out of every session");
+        });
+    });
+}
+
+function secureRequest(method, url, request, $button, buttonText, success) {
+    startSubmitting($button);
+
+    $.ajax({
+        url: url,
+        type: method,
+        data: request ? JSON.stringify(request) : undefined,
+        contentType: "application/json",
+        headers: {
+            "Authorization": "Bearer " + params.get("token")
+        },
+        success: function(data) {
+            finishSubmitting($button, buttonText);
+            success(data);
+        },
+        error: function(xhr) {
+            finishSubmitting($button, buttonText);
+            notifyError(xhr.responseText);
+        }
     })
 }
 
+function setupUserIdentities() {
+    var federationError = params.get("federation_error");
+    var federationLinked = params.get("federation_linked");
+
+    if (federationError) {
+        notifyError(federationError);
+    } else if (federationLinked) {
+        notifySuccess(federationLinked + " linked to your account!");
+    }
+
+    $('.identity-link').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        var request = {
+            returnTo: window.location.pathname + window.location.search
+        };
+
+        secureRequest("POST", "/secure/identities/" + encodeURIComponent($this.data("provider")), request, $this, $this.html(), function(data) {
+            window.location = data.redirect_to;
+        });
+    });
+
+    $('.identity-unlink').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        secureRequest("DELETE", "/secure/identities/" + $this.data("id"), null, $this, "Unlink", function() {
+            window.location.reload();
+        });
+    });
+}
+
+function setupTOTPEnrollment() {
+    $('#totp-enable').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        secureRequest("POST", "/secure/totp", null, $this, "Enable", function(data) {
+            $("#totp-qrcode").attr("src", data.qrcode);
+            $("#totp-secret").text(data.secret);
+            $("#totp-enrollment").attr("hidden", false);
+            $this.attr("hidden", true);
+        });
+    });
+
+    $('#totp-confirm').on('click', function(event) {
+        event.preventDefault();
+
+        var request = { code: $("#totp-confirm-code").val() };
+        if (!request.code) {
+            notifyError("Authentication code is missing");
+            return;
+        }
+
+        secureRequest("PUT", "/secure/totp", request, $(this), "Confirm", function(data) {
+            showRecoveryCodesOrReload(data);
+        });
+    });
+
+    $('#totp-disable').on('click', function(event) {
+        event.preventDefault();
+
+        var request = { code: $("#totp-disable-code").val() };
+        if (!request.code) {
+            notifyError("Authentication code is missing");
+            return;
+        }
+
+        secureRequest("DELETE", "/secure/totp", request, $(this), "Disable", function() {
+            window.location.reload();
+        });
+    });
+}
+
 function setupChangePasswordStep1Page(action) {
     if (action !== "change-password/step-1") {
         return;
@@ -392,7 +922,8 @@ function setupRegistrationPage(action) {
             email: $("#registration-email").val(),
             password: $("#registration-password").val(),
             passwordConfirmation: $("#registration-password-confirmation").val(),
-            challenge: params.get("login_challenge")
+            challenge: params.get("login_challenge"),
+            attributes: getProfileAttributes()
         };
 
         if (!request.username) {
@@ -441,6 +972,44 @@ function setupRegistrationPage(action) {
     })
 }
 
+function setupResendConfirmationPage(action) {
+    if (action !== "email-confirmation/resend") {
+        return;
+    }
+
+    $('#submit').on('click', function (event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        var request = {
+            email: $("#email").val(),
+            challenge: params.get("login_challenge")
+        };
+
+        if (!request.email) {
+            notifyError("Email is missing");
+            return;
+        }
+
+        startSubmitting($this);
+
+        $.ajax({
+            url: "/email-confirmation/resend",
+            type: "POST",
+            data: JSON.stringify(request),
+            contentType: "application/json",
+            success: function() {
+                finishSubmitting($this);
+                notifySuccess("Check the inbox of your email.");
+            },
+            error: function(xhr) {
+                finishSubmitting($this);
+                notifyError(xhr.responseText);
+            }
+        })
+    })
+}
+
 function setupEmailConfirmationPage(action) {
     if (action !== "email-confirmation") {
         return;
@@ -455,4 +1024,54 @@ function setupEmailConfirmationPage(action) {
     if (link) {
         setTimeout(redirect, waitTime)
     }
-}
\ No newline at end of file
+}
+function setupWebAuthnRegistration() {
+    if (!window.PublicKeyCredential) {
+        $("#webauthn-register").attr("disabled", true);
+    }
+
+    $('#webauthn-register').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        var buttonText = "Add security key";
+
+        secureRequest("POST", "/secure/webauthn/options", null, $this, buttonText, function(data) {
+            var publicKey = data.publicKey;
+            publicKey.challenge = base64URLToBuffer(publicKey.challenge);
+            publicKey.user.id = base64URLToBuffer(publicKey.user.id);
+            publicKey.excludeCredentials = decodeCredentialDescriptors(publicKey.excludeCredentials);
+
+            navigator.credentials.create({ publicKey: publicKey }).then(function (credential) {
+                var request = {
+                    token: data.token,
+                    name: $("#webauthn-name").val(),
+                    credential: {
+                        id: credential.id,
+                        rawId: bufferToBase64URL(credential.rawId),
+                        type: credential.type,
+                        response: {
+                            clientDataJSON: bufferToBase64URL(credential.response.clientDataJSON),
+                            attestationObject: bufferToBase64URL(credential.response.attestationObject)
+                        }
+                    }
+                };
+
+                secureRequest("POST", "/secure/webauthn/credentials", request, $this, buttonText, function(data) {
+                    showRecoveryCodesOrReload(data);
+                });
+            }).catch(function (err) {
+                notifyError(err.message);
+            });
+        });
+    });
+
+    $('.webauthn-remove').on('click', function(event) {
+        event.preventDefault();
+
+        var $this = $(this);
+        secureRequest("DELETE", "/secure/webauthn/credentials/" + $this.data("id"), null, $this, "Remove", function() {
+            window.location.reload();
+        });
+    });
+}
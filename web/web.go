@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"time"
 
+	"github.com/labbsr0x/whisper/db"
 	"github.com/labbsr0x/whisper/web/api"
 
 	"github.com/labbsr0x/whisper/web/config"
@@ -25,7 +26,15 @@ type Server struct {
 	UserCredentialsAPIs api.UserCredentialsAPI
 	LoginAPIs           api.LoginAPI
 	ConsentAPIs         api.ConsentAPI
+	LogoutAPIs          api.LogoutAPI
 	HydraAPIs           api.HydraAPI
+	TOTPAPIs            api.TOTPAPI
+	WebAuthnAPIs        api.WebAuthnAPI
+	RecoveryCodesAPIs   api.RecoveryCodesAPI
+	SessionsAPIs        api.SessionsAPI
+	AdminAPIs           api.AdminAPI
+	FederationAPIs      api.FederationAPI
+	Realms              []*Server
 }
 
 // InitFromWebBuilder builds a Server instance
@@ -34,7 +43,18 @@ func (s *Server) InitFromWebBuilder(webBuilder *config.WebBuilder) *Server {
 	s.UserCredentialsAPIs = new(api.DefaultUserCredentialsAPI).InitFromWebBuilder(webBuilder)
 	s.LoginAPIs = new(api.DefaultLoginAPI).InitFromWebBuilder(webBuilder)
 	s.ConsentAPIs = new(api.DefaultConsentAPI).InitFromWebBuilder(webBuilder)
+	s.LogoutAPIs = new(api.DefaultLogoutAPI).InitFromWebBuilder(webBuilder)
 	s.HydraAPIs = new(api.DefaultHydraAPI).InitFromWebBuilder(webBuilder)
+	s.TOTPAPIs = new(api.DefaultTOTPAPI).InitFromWebBuilder(webBuilder)
+	s.WebAuthnAPIs = new(api.DefaultWebAuthnAPI).InitFromWebBuilder(webBuilder)
+	s.RecoveryCodesAPIs = new(api.DefaultRecoveryCodesAPI).InitFromWebBuilder(webBuilder)
+	s.SessionsAPIs = new(api.DefaultSessionsAPI).InitFromWebBuilder(webBuilder)
+	s.AdminAPIs = new(api.DefaultAdminAPI).InitFromWebBuilder(webBuilder)
+	s.FederationAPIs = new(api.DefaultFederationAPI).InitFromWebBuilder(webBuilder)
+
+	for _, realmBuilder := range webBuilder.Realms {
+		s.Realms = append(s.Realms, new(Server).InitFromWebBuilder(realmBuilder))
+	}
 
 	logLevel, err := logrus.ParseLevel(s.LogLevel)
 	if err != nil {
@@ -48,22 +68,51 @@ func (s *Server) InitFromWebBuilder(webBuilder *config.WebBuilder) *Server {
 
 // Run initializes the web server and its apis
 func (s *Server) Run() error {
+	router := s.getRouter()
+	go s.runPendingAccountsExpiry()
+
+	if len(s.Realms) == 0 {
+		return s.ListenAndServe(router)
+	}
+
+	return s.ListenAndServe(s.getRealmsHandler(router))
+}
+
+// getRouter routes the requests to the apis of the realm of the server
+func (s *Server) getRouter() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
 	secureRouter := router.PathPrefix("/secure").Subrouter()
+	adminRouter := router.PathPrefix("/admin").Subrouter()
 
 	router.PathPrefix("/static").Handler(ui.Handler(s.BaseUIPath)).Methods("GET")
 	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	router.Handle("/login", s.LoginAPIs.LoginGETHandler("/login")).Methods("GET")
 	router.Handle("/login", s.LoginAPIs.LoginPOSTHandler()).Methods("POST")
+	router.Handle("/login/magic-link", s.LoginAPIs.MagicLinkGETHandler("/login/magic-link")).Methods("GET")
+	router.Handle("/login/magic-link", s.LoginAPIs.MagicLinkPOSTHandler()).Methods("POST")
+	router.Handle("/login/magic-link", s.LoginAPIs.MagicLinkPUTHandler()).Methods("PUT")
+	router.Handle("/login/second-factor", s.LoginAPIs.LoginSecondFactorPOSTHandler()).Methods("POST")
+	router.Handle("/login/webauthn/options", s.WebAuthnAPIs.LoginOptionsPOSTHandler()).Methods("POST")
+	router.Handle("/login/webauthn", s.WebAuthnAPIs.LoginPOSTHandler()).Methods("POST")
+
+	router.Handle("/federation/link", s.FederationAPIs.LinkGETHandler("/federation/link")).Methods("GET")
+	router.Handle("/federation/link", s.FederationAPIs.LinkPOSTHandler()).Methods("POST")
+	router.Handle("/federation/{provider}", s.FederationAPIs.LoginGETHandler()).Methods("GET")
+	router.Handle("/federation/{provider}/callback", s.FederationAPIs.CallbackGETHandler()).Methods("GET")
 
 	router.Handle("/consent", s.ConsentAPIs.ConsentGETHandler("/consent")).Methods("GET")
 	router.Handle("/consent", s.ConsentAPIs.ConsentPOSTHandler()).Methods("POST")
 
+	router.Handle("/logout", s.LogoutAPIs.LogoutGETHandler("/logout")).Methods("GET")
+	router.Handle("/logout", s.LogoutAPIs.LogoutPOSTHandler()).Methods("POST")
+
 	router.Handle("/registration", s.UserCredentialsAPIs.GETRegistrationPageHandler("/registration")).Methods("GET")
 	router.Handle("/registration", s.UserCredentialsAPIs.POSTHandler()).Methods("POST")
 
 	router.Handle("/email-confirmation", s.UserCredentialsAPIs.GETEmailConfirmationPageHandler("/email-confirmation")).Methods("GET")
+	router.Handle("/email-confirmation/resend", s.UserCredentialsAPIs.GETEmailConfirmationResendPageHandler("/email-confirmation")).Methods("GET")
+	router.Handle("/email-confirmation/resend", s.UserCredentialsAPIs.POSTEmailConfirmationResendHandler()).Methods("POST")
 
 	router.Handle("/change-password/step-1", s.UserCredentialsAPIs.GETChangePasswordStep1PageHandler("/change-password")).Methods("GET")
 	router.Handle("/change-password/step-2", s.UserCredentialsAPIs.GETChangePasswordStep2PageHandler("/change-password")).Methods("GET")
@@ -74,18 +123,60 @@ func (s *Server) Run() error {
 
 	secureRouter.Handle("/update", s.UserCredentialsAPIs.GETUpdatePageHandler("/secure/update")).Methods("GET")
 	secureRouter.Handle("/update", s.UserCredentialsAPIs.PUTHandler()).Methods("PUT")
+	secureRouter.Handle("/profile", s.UserCredentialsAPIs.PUTProfileHandler()).Methods("PUT")
+
+	secureRouter.Handle("/totp", s.TOTPAPIs.POSTHandler()).Methods("POST")
+	secureRouter.Handle("/totp", s.TOTPAPIs.PUTHandler()).Methods("PUT")
+	secureRouter.Handle("/totp", s.TOTPAPIs.DELETEHandler()).Methods("DELETE")
+
+	secureRouter.Handle("/webauthn/options", s.WebAuthnAPIs.RegistrationOptionsPOSTHandler()).Methods("POST")
+	secureRouter.Handle("/webauthn/credentials", s.WebAuthnAPIs.RegistrationPOSTHandler()).Methods("POST")
+	secureRouter.Handle("/webauthn/credentials/{id}", s.WebAuthnAPIs.CredentialDELETEHandler()).Methods("DELETE")
+
+	secureRouter.Handle("/identities/{provider}", s.FederationAPIs.IdentityPOSTHandler()).Methods("POST")
+	secureRouter.Handle("/identities/{id}", s.FederationAPIs.IdentityDELETEHandler()).Methods("DELETE")
+
+	secureRouter.Handle("/recovery-codes", s.RecoveryCodesAPIs.POSTHandler()).Methods("POST")
+
+	secureRouter.Handle("/sessions", s.SessionsAPIs.GETPageHandler("/secure/sessions")).Methods("GET")
+	secureRouter.Handle("/sessions/consents/{client}", s.SessionsAPIs.ConsentDELETEHandler()).Methods("DELETE")
+	secureRouter.Handle("/sessions/logins", s.SessionsAPIs.LoginDELETEHandler()).Methods("DELETE")
+
+	adminRouter.Handle("/users", s.AdminAPIs.ListUsersGETHandler()).Methods("GET")
+	adminRouter.Handle("/users", s.AdminAPIs.UserPOSTHandler()).Methods("POST")
+	adminRouter.Handle("/users/{id}", s.AdminAPIs.UserGETHandler()).Methods("GET")
+	adminRouter.Handle("/users/{id}", s.AdminAPIs.UserPUTHandler()).Methods("PUT")
+	adminRouter.Handle("/users/{id}", s.AdminAPIs.UserDELETEHandler()).Methods("DELETE")
+	adminRouter.Handle("/users/{id}/status", s.AdminAPIs.StatusPUTHandler()).Methods("PUT")
+	adminRouter.Handle("/users/{id}/disable", s.AdminAPIs.DisablePOSTHandler()).Methods("POST")
+	adminRouter.Handle("/users/{id}/enable", s.AdminAPIs.EnablePOSTHandler()).Methods("POST")
+	adminRouter.Handle("/users/{id}/password-reset", s.AdminAPIs.PasswordResetPOSTHandler()).Methods("POST")
+	adminRouter.Handle("/users/{id}/attributes", s.AdminAPIs.AttributesGETHandler()).Methods("GET")
+	adminRouter.Handle("/users/{id}/attributes", s.AdminAPIs.AttributesPUTHandler()).Methods("PUT")
+	adminRouter.Handle("/users/{id}/groups", s.AdminAPIs.UserGroupsGETHandler()).Methods("GET")
+
+	for _, kind := range []string{db.GroupKindGroup, db.GroupKindRole} {
+		path := "/" + kind + "s"
+		adminRouter.Handle(path, s.AdminAPIs.GroupsGETHandler(kind)).Methods("GET")
+		adminRouter.Handle(path, s.AdminAPIs.GroupPOSTHandler(kind)).Methods("POST")
+		adminRouter.Handle(path+"/{name}", s.AdminAPIs.GroupDELETEHandler(kind)).Methods("DELETE")
+		adminRouter.Handle(path+"/{name}/members", s.AdminAPIs.GroupMembersGETHandler(kind)).Methods("GET")
+		adminRouter.Handle(path+"/{name}/members/{id}", s.AdminAPIs.GroupMemberPUTHandler(kind)).Methods("PUT")
+		adminRouter.Handle(path+"/{name}/members/{id}", s.AdminAPIs.GroupMemberDELETEHandler(kind)).Methods("DELETE")
+	}
 
 	router.Use(middleware.GetPrometheusMiddleware())
 	router.Use(middleware.GetErrorMiddleware())
-	secureRouter.Use(s.Self.GetMuxSecurityMiddleware())
+	secureRouter.Use(s.Self.GetMuxSecurityMiddleware(), middleware.GetRealmMiddleware(s.GetUsername))
+	adminRouter.Use(s.Self.GetMuxSecurityMiddleware(), middleware.GetClientMiddleware(), middleware.GetScopeMiddleware(s.AdminScope))
 
-	return s.ListenAndServe(router)
+	return router
 }
 
-func (s *Server) ListenAndServe(router *mux.Router) error {
+func (s *Server) ListenAndServe(handler http.Handler) error {
 
 	srv := &http.Server{
-		Handler:      router,
+		Handler:      handler,
 		Addr:         "0.0.0.0:" + s.Port,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
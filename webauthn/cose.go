@@ -0,0 +1,109 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers supported by the relying party
+const (
+	AlgES256 = -7
+	AlgRS256 = -257
+)
+
+// COSE key parameters (RFC 8152)
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1
+	coseX      = -2
+	coseY      = -3
+	coseN      = -1
+	coseE      = -2
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+	coseP256   = 1
+)
+
+// publicKey holds a parsed COSE credential public key
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey parses a CBOR encoded COSE_Key
+func parsePublicKey(data []byte) (*publicKey, error) {
+	item, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cose: key is not a map")
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, xok := m[int64(coseX)].([]byte)
+		y, yok := m[int64(coseY)].([]byte)
+		if crv != coseP256 || !xok || !yok || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("cose: invalid EC2 key")
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("cose: point is not on curve")
+		}
+
+		return &publicKey{alg: alg, key: key}, nil
+
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, nok := m[int64(coseN)].([]byte)
+		e, eok := m[int64(coseE)].([]byte)
+		if !nok || !eok || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("cose: invalid RSA key")
+		}
+
+		exponent := 0
+		for _, b := range e {
+			exponent = exponent<<8 | int(b)
+		}
+
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+
+	return nil, fmt.Errorf("cose: unsupported key type %v with algorithm %v", kty, alg)
+}
+
+// verify checks the signature of data against the key
+func (pk *publicKey) verify(data, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch key := pk.key.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 {
+			return fmt.Errorf("malformed signature")
+		}
+		if !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	}
+
+	return fmt.Errorf("unsupported key")
+}
@@ -0,0 +1,382 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Authenticator data flags (WebAuthn §6.1)
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+const (
+	challengeSize = 32
+	timeout       = 60000 // ms
+)
+
+// Encoding is the encoding used to exchange binary values with the browser
+var Encoding = base64.RawURLEncoding
+
+// RelyingParty holds the information that identifies Whisper to WebAuthn authenticators
+type RelyingParty struct {
+	ID     string
+	Name   string
+	Origin string
+}
+
+// User defines the user account a credential is registered to
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// Credential holds the data of a registered credential that must be stored to verify later assertions
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+// Assertion holds the result of a successfully verified assertion
+type Assertion struct {
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// CredentialLookup retrieves the public key and last known signature counter of a registered credential
+type CredentialLookup func(credentialID []byte) (publicKey []byte, signCount uint32, err error)
+
+// CredentialDescriptor identifies a credential in the options sent to the browser
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// CreationOptions holds the PublicKeyCredentialCreationOptions sent to navigator.credentials.create
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions holds the PublicKeyCredentialRequestOptions sent to navigator.credentials.get
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse holds the browser encoded result of navigator.credentials.create
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse holds the browser encoded result of navigator.credentials.get
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// NewRelyingParty builds a relying party from the public url Whisper is served at
+func NewRelyingParty(name, publicURL string) (*RelyingParty, error) {
+	u, err := url.Parse(publicURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid public url '%v'", publicURL)
+	}
+
+	return &RelyingParty{ID: u.Hostname(), Name: name, Origin: u.Scheme + "://" + u.Host}, nil
+}
+
+// NewChallenge generates a random challenge
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+// GetCreationOptions builds the options to register a new credential for a user
+func (rp *RelyingParty) GetCreationOptions(challenge []byte, user User, exclude [][]byte) CreationOptions {
+	var options CreationOptions
+
+	options.Challenge = Encoding.EncodeToString(challenge)
+	options.RP.ID = rp.ID
+	options.RP.Name = rp.Name
+	options.User.ID = Encoding.EncodeToString(user.ID)
+	options.User.Name = user.Name
+	options.User.DisplayName = user.DisplayName
+	options.Timeout = timeout
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = "preferred"
+	options.Attestation = "none"
+	options.ExcludeCredentials = getCredentialDescriptors(exclude)
+
+	for _, alg := range []int{AlgES256, AlgRS256} {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{Type: "public-key", Alg: alg})
+	}
+
+	return options
+}
+
+// GetRequestOptions builds the options to request an assertion. An empty allow list lets the authenticator pick a discoverable credential
+func (rp *RelyingParty) GetRequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        Encoding.EncodeToString(challenge),
+		Timeout:          timeout,
+		RPID:             rp.ID,
+		AllowCredentials: getCredentialDescriptors(allow),
+		UserVerification: "preferred",
+	}
+}
+
+// FinishRegistration verifies the response of a credential creation and extracts the credential to be stored.
+// Since attestation "none" is requested, the attestation statement itself is not verified
+func (rp *RelyingParty) FinishRegistration(challenge []byte, response AttestationResponse) (Credential, error) {
+	rawClientData, err := Encoding.DecodeString(response.Response.ClientDataJSON)
+	if err != nil {
+		return Credential{}, fmt.Errorf("malformed client data")
+	}
+
+	if err := rp.verifyClientData(rawClientData, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	rawAttestation, err := Encoding.DecodeString(response.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("malformed attestation object")
+	}
+
+	item, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	attestation, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, fmt.Errorf("malformed attestation object")
+	}
+
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("attestation object without authenticator data")
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if authData.flags&flagAttestedData == 0 {
+		return Credential{}, fmt.Errorf("authenticator data without attested credential")
+	}
+
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return Credential{}, err
+	}
+
+	if rawID, err := Encoding.DecodeString(response.RawID); err != nil || !bytes.Equal(rawID, authData.credentialID) {
+		return Credential{}, fmt.Errorf("credential id mismatch")
+	}
+
+	return Credential{ID: authData.credentialID, PublicKey: authData.publicKey, SignCount: authData.signCount}, nil
+}
+
+// FinishLogin verifies the response of an assertion request against the stored credential
+func (rp *RelyingParty) FinishLogin(challenge []byte, response AssertionResponse, lookup CredentialLookup) (Assertion, error) {
+	credentialID, err := Encoding.DecodeString(response.RawID)
+	if err != nil || len(credentialID) == 0 {
+		return Assertion{}, fmt.Errorf("malformed credential id")
+	}
+
+	rawClientData, err := Encoding.DecodeString(response.Response.ClientDataJSON)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("malformed client data")
+	}
+
+	if err := rp.verifyClientData(rawClientData, "webauthn.get", challenge); err != nil {
+		return Assertion{}, err
+	}
+
+	rawAuthData, err := Encoding.DecodeString(response.Response.AuthenticatorData)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("malformed authenticator data")
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	signature, err := Encoding.DecodeString(response.Response.Signature)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("malformed signature")
+	}
+
+	rawPublicKey, storedSignCount, err := lookup(credentialID)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	key, err := parsePublicKey(rawPublicKey)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	if err := key.verify(append(append([]byte(nil), rawAuthData...), clientDataHash[:]...), signature); err != nil {
+		return Assertion{}, err
+	}
+
+	if (authData.signCount != 0 || storedSignCount != 0) && authData.signCount <= storedSignCount {
+		return Assertion{}, fmt.Errorf("signature counter did not increase, the authenticator may have been cloned")
+	}
+
+	userHandle, err := Encoding.DecodeString(response.Response.UserHandle)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("malformed user handle")
+	}
+
+	return Assertion{
+		CredentialID: credentialID,
+		UserHandle:   userHandle,
+		SignCount:    authData.signCount,
+		UserVerified: authData.flags&flagUserVerified != 0,
+	}, nil
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("malformed client data")
+	}
+
+	if data.Type != ceremony {
+		return fmt.Errorf("unexpected ceremony type '%v'", data.Type)
+	}
+
+	if data.Challenge != Encoding.EncodeToString(challenge) {
+		return fmt.Errorf("challenge mismatch")
+	}
+
+	if data.Origin != rp.Origin {
+		return fmt.Errorf("unexpected origin '%v'", data.Origin)
+	}
+
+	return nil
+}
+
+func (rp *RelyingParty) parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	var data authenticatorData
+
+	if len(raw) < 37 {
+		return data, fmt.Errorf("authenticator data too short")
+	}
+
+	data.rpIDHash = raw[:32]
+	data.flags = raw[32]
+	data.signCount = binary.BigEndian.Uint32(raw[33:37])
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return data, fmt.Errorf("relying party id mismatch")
+	}
+
+	if data.flags&flagUserPresent == 0 {
+		return data, fmt.Errorf("user presence was not verified")
+	}
+
+	if data.flags&flagAttestedData != 0 {
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return data, fmt.Errorf("attested credential data too short")
+		}
+
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLength {
+			return data, fmt.Errorf("attested credential data too short")
+		}
+
+		data.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return data, err
+		}
+
+		data.publicKey = rest[:n]
+	}
+
+	return data, nil
+}
+
+func getCredentialDescriptors(ids [][]byte) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		descriptors = append(descriptors, CredentialDescriptor{Type: "public-key", ID: Encoding.EncodeToString(id)})
+	}
+
+	return descriptors
+}
@@ -0,0 +1,220 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// softwareAuthenticator emulates a platform authenticator holding a single ES256 credential
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T, userHandle []byte) *softwareAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialID := make([]byte, 16)
+	_, _ = rand.Read(credentialID)
+
+	return &softwareAuthenticator{key: key, credentialID: credentialID, userHandle: userHandle}
+}
+
+func (a *softwareAuthenticator) authData(rpID string, flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, a.signCount)
+	data = append(data, counter...)
+
+	if attested {
+		data = append(data, make([]byte, 16)...) // aaguid
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(a.credentialID)))
+		data = append(data, length...)
+		data = append(data, a.credentialID...)
+		data = append(data, encodeCBOR(map[interface{}]interface{}{
+			int64(coseKty): int64(coseKtyEC2),
+			int64(coseAlg): int64(AlgES256),
+			int64(coseCrv): int64(coseP256),
+			int64(coseX):   padTo32(a.key.X.Bytes()),
+			int64(coseY):   padTo32(a.key.Y.Bytes()),
+		})...)
+	}
+
+	return data
+}
+
+func (a *softwareAuthenticator) create(t *testing.T, rpID, origin string, options CreationOptions) AttestationResponse {
+	clientDataJSON, _ := json.Marshal(clientData{Type: "webauthn.create", Challenge: options.Challenge, Origin: origin})
+	attestation := encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(rpID, flagUserPresent|flagUserVerified|flagAttestedData, true),
+	})
+
+	var response AttestationResponse
+	response.ID = Encoding.EncodeToString(a.credentialID)
+	response.RawID = response.ID
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = Encoding.EncodeToString(clientDataJSON)
+	response.Response.AttestationObject = Encoding.EncodeToString(attestation)
+	return response
+}
+
+func (a *softwareAuthenticator) get(t *testing.T, rpID, origin string, options RequestOptions) AssertionResponse {
+	a.signCount++
+
+	clientDataJSON, _ := json.Marshal(clientData{Type: "webauthn.get", Challenge: options.Challenge, Origin: origin})
+	authData := a.authData(rpID, flagUserPresent|flagUserVerified, false)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response AssertionResponse
+	response.ID = Encoding.EncodeToString(a.credentialID)
+	response.RawID = response.ID
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = Encoding.EncodeToString(clientDataJSON)
+	response.Response.AuthenticatorData = Encoding.EncodeToString(authData)
+	response.Response.Signature = Encoding.EncodeToString(encodeDERSignature(r.Bytes(), s.Bytes()))
+	response.Response.UserHandle = Encoding.EncodeToString(a.userHandle)
+	return response
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	rp, err := NewRelyingParty("Whisper", "https://whisper.example.com:7070")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{ID: []byte("user-id"), Name: "username", DisplayName: "username"}
+	authenticator := newSoftwareAuthenticator(t, user.ID)
+
+	challenge, _ := NewChallenge()
+	attestation := authenticator.create(t, rp.ID, rp.Origin, rp.GetCreationOptions(challenge, user, nil))
+
+	credential, err := rp.FinishRegistration(challenge, attestation)
+	if err != nil {
+		t.Fatalf("registration should succeed: %v", err)
+	}
+
+	otherChallenge, _ := NewChallenge()
+	if _, err := rp.FinishRegistration(otherChallenge, attestation); err == nil {
+		t.Error("registration with another challenge should fail")
+	}
+
+	lookup := func(id []byte) ([]byte, uint32, error) {
+		return credential.PublicKey, credential.SignCount, nil
+	}
+
+	challenge, _ = NewChallenge()
+	assertion, err := rp.FinishLogin(challenge, authenticator.get(t, rp.ID, rp.Origin, rp.GetRequestOptions(challenge, nil)), lookup)
+	if err != nil {
+		t.Fatalf("login should succeed: %v", err)
+	}
+
+	if string(assertion.UserHandle) != string(user.ID) || !assertion.UserVerified || assertion.SignCount != 1 {
+		t.Errorf("unexpected assertion %+v", assertion)
+	}
+
+	credential.SignCount = assertion.SignCount
+
+	challenge, _ = NewChallenge()
+	if _, err := rp.FinishLogin(challenge, authenticator.get(t, rp.ID, "https://evil.example.com", rp.GetRequestOptions(challenge, nil)), lookup); err == nil {
+		t.Error("login from another origin should fail")
+	}
+
+	challenge, _ = NewChallenge()
+	authenticator.signCount = 0
+	if _, err := rp.FinishLogin(challenge, authenticator.get(t, rp.ID, rp.Origin, rp.GetRequestOptions(challenge, nil)), lookup); err == nil {
+		t.Error("login with a non increasing counter should fail")
+	}
+
+	challenge, _ = NewChallenge()
+	forged := authenticator.get(t, rp.ID, rp.Origin, rp.GetRequestOptions(challenge, nil))
+	impostor := newSoftwareAuthenticator(t, user.ID)
+	impostor.credentialID = authenticator.credentialID
+	impostor.signCount = 10
+	forged.Response.Signature = impostor.get(t, rp.ID, rp.Origin, rp.GetRequestOptions(challenge, nil)).Response.Signature
+	if _, err := rp.FinishLogin(challenge, forged, lookup); err == nil {
+		t.Error("login signed by another key should fail")
+	}
+}
+
+func padTo32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func encodeDERSignature(r, s []byte) []byte {
+	integer := func(b []byte) []byte {
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return append([]byte{0x02, byte(len(b))}, b...)
+	}
+
+	body := append(integer(r), integer(s)...)
+	return append([]byte{0x30, byte(len(body))}, body...)
+}
+
+// encodeCBOR encodes the subset of CBOR understood by the decoder, sorting map keys for determinism
+func encodeCBOR(item interface{}) []byte {
+	header := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 256:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			b := make([]byte, 3)
+			b[0] = major<<5 | 25
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		}
+	}
+
+	switch v := item.(type) {
+	case int64:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		keys := make([]interface{}, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return string(encodeCBOR(keys[i])) < string(encodeCBOR(keys[j])) })
+
+		out := header(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(out, encodeCBOR(k)...)
+			out = append(out, encodeCBOR(v[k])...)
+		}
+		return out
+	}
+
+	panic("unsupported type")
+}